@@ -5,8 +5,10 @@ package client
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
 )
 
@@ -51,6 +53,22 @@ func (c HTTPClient) Reload() error {
 	return nil
 }
 
+// SoftReload makes a request to the soft reload endpoint of a ContainerPilot
+// process, which replaces its watches without restarting any jobs.
+func (c HTTPClient) SoftReload() error {
+	resp, err := c.Post("http://control/v3/reload/soft", "application/json", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("soft reload refused: %s", strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
 // SetMaintenance makes a request to either the enable or disable maintenance
 // endpoint of a ContainerPilot process.
 func (c HTTPClient) SetMaintenance(isEnabled bool) error {
@@ -84,9 +102,14 @@ func (c HTTPClient) PutEnv(body string) error {
 }
 
 // PutMetric makes a request to the metric endpoint of a ContainerPilot process
-// for setting custom metrics.
-func (c HTTPClient) PutMetric(body string) error {
-	resp, err := c.Post("http://control/v3/metric", "application/json",
+// for setting custom metrics. If job is set, the metrics are recorded for
+// that job.
+func (c HTTPClient) PutMetric(body, job string) error {
+	endpoint := "http://control/v3/metric"
+	if job != "" {
+		endpoint += "?job=" + url.QueryEscape(job)
+	}
+	resp, err := c.Post(endpoint, "application/json",
 		strings.NewReader(body))
 	if err != nil {
 		return err
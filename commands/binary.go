@@ -0,0 +1,88 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"sync"
+	"unicode/utf8"
+)
+
+// Encodings for lines of logged output that aren't valid UTF-8 text
+const (
+	BinaryHex    = "hex"
+	BinaryBase64 = "base64"
+)
+
+// maxBinaryLine is the longest line we'll hold while waiting for its
+// newline, so that binary output without newlines can't grow without
+// bound
+const maxBinaryLine = 64 * 1024
+
+// binaryWriter is an io.Writer that splits its input into lines and
+// encodes each line that isn't valid UTF-8 text before passing it to the
+// sink, so that binary output can't break terminals or log parsers.
+// Encoded lines are prefixed with the name of their encoding.
+type binaryWriter struct {
+	encoding string
+	sink     io.Writer
+	partial  []byte
+	lock     sync.Mutex
+}
+
+func newBinaryWriter(encoding string, sink io.Writer) *binaryWriter {
+	return &binaryWriter{encoding: encoding, sink: sink}
+}
+
+// Write implements io.Writer
+func (w *binaryWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	buf := append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := w.writeLine(buf[:i]); err != nil {
+			return len(p), err
+		}
+		buf = buf[i+1:]
+	}
+	if len(buf) > maxBinaryLine {
+		if err := w.writeLine(buf); err != nil {
+			return len(p), err
+		}
+		buf = nil
+	}
+	w.partial = append([]byte{}, buf...)
+	return len(p), nil
+}
+
+func (w *binaryWriter) writeLine(line []byte) error {
+	if !utf8.Valid(line) {
+		var encoded string
+		switch w.encoding {
+		case BinaryHex:
+			encoded = hex.EncodeToString(line)
+		default:
+			encoded = base64.StdEncoding.EncodeToString(line)
+		}
+		line = []byte(w.encoding + ":" + encoded)
+	}
+	_, err := w.sink.Write(append(line, '\n'))
+	return err
+}
+
+// Close writes any partial line to the sink. It doesn't close the sink.
+func (w *binaryWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if len(w.partial) == 0 {
+		return nil
+	}
+	err := w.writeLine(w.partial)
+	w.partial = nil
+	return err
+}
@@ -0,0 +1,79 @@
+package commands
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBinaryWriter(t *testing.T) {
+	var sink bytes.Buffer
+	w := newBinaryWriter(BinaryBase64, &sink)
+	w.Write([]byte("text ü\n\xff"))
+	w.Write([]byte("\xfe\nunterminated"))
+	w.Close()
+	assert.Equal(t, "text ü\nbase64://4=\nunterminated\n", sink.String())
+
+	sink.Reset()
+	w = newBinaryWriter(BinaryHex, &sink)
+	w.Write([]byte("\x00ok\xff\n"))
+	assert.Equal(t, "hex:006f6bff\n", sink.String())
+}
+
+// lockedBuffer is a bytes.Buffer that's safe to read while the logger
+// is writing to it
+type lockedBuffer struct {
+	buf  bytes.Buffer
+	lock sync.Mutex
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+// Binary output is encoded when it's logged, but a job's output file
+// receives the original bytes
+func TestBinaryEncodingLoggedOnly(t *testing.T) {
+	printBinary := []interface{}{"printf", `ok\377\376\n`}
+
+	logged := &lockedBuffer{}
+	logger := log.New()
+	logger.Out = logged
+	logger.Formatter = &log.TextFormatter{DisableTimestamp: true}
+	cmd, _ := NewCommand(printBinary, time.Duration(0), nil)
+	cmd.Name = t.Name()
+	cmd.logger = *log.NewEntry(logger)
+	cmd.BinaryEncoding = BinaryHex
+	if _, ok := runtestCommandUntilExit(cmd, time.Second); !ok {
+		t.Fatal("expected command to exit")
+	}
+	// the logger writes lines from its own goroutine
+	for i := 0; i < 20 && !strings.Contains(logged.String(), "msg="); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Contains(t, logged.String(), "hex:6f6bfffe")
+	assert.NotContains(t, logged.String(), "\xff")
+
+	var captured bytes.Buffer
+	cmd, _ = NewCommand(printBinary, time.Duration(0), nil)
+	cmd.Name = t.Name()
+	cmd.Output = &captured
+	cmd.BinaryEncoding = BinaryHex
+	if _, ok := runtestCommandUntilExit(cmd, time.Second); !ok {
+		t.Fatal("expected command to exit")
+	}
+	assert.Equal(t, []byte("ok\xff\xfe\n"), captured.Bytes())
+}
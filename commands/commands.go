@@ -4,8 +4,10 @@
 package commands
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -13,6 +15,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -30,6 +33,79 @@ type Command struct {
 	logger  log.Entry
 	lock    *sync.Mutex
 	fields  log.Fields
+
+	cmdLock sync.Mutex // guards Cmd against Term and Kill while it starts
+	logLock sync.Mutex // guards logger, which gets the pid once it starts
+
+	// Hardening, if set, restricts the process before it's exec'd
+	Hardening *Hardening
+
+	// Output, if set, receives the process' stdout and stderr unmodified
+	Output io.Writer
+
+	// Env, if set, is added to ContainerPilot's environment for the
+	// process; later entries override earlier ones
+	Env []string
+
+	// LogBuffer, if set, buffers the process' output lines on their way
+	// to the logger or Output
+	LogBuffer *LogBuffer
+
+	// BinaryEncoding, if set to BinaryHex or BinaryBase64, encodes lines
+	// of logged output that aren't valid UTF-8 text. Output is never
+	// encoded.
+	BinaryEncoding string
+
+	// PostStop, if set, is run to completion each time the process exits,
+	// before the exit is published
+	PostStop *Command
+
+	// Steps, if set, are run to completion in order each time before the
+	// process starts. If a step fails, the remaining steps and the
+	// process aren't run and the Command exits as failed.
+	Steps []*Command
+
+	// CPUTimeout, if set, limits the CPU time the process may use,
+	// rounded down to whole seconds
+	CPUTimeout time.Duration
+
+	// Nice, if set, is the scheduling priority of the process, from -20
+	// (highest) to 19 (lowest)
+	Nice int
+
+	// Namespaces, if set, runs the process in the namespaces of another
+	// Command's process
+	Namespaces *Namespaces
+
+	// Success, if set, treats some non-zero exits as successful
+	Success *Success
+
+	// TTY, if set, attaches the process to a pseudo-terminal, so that it
+	// behaves as it would interactively. Its stderr is combined with its
+	// stdout.
+	TTY bool
+
+	// ExecRetry, if set, bounds the retries of transient errors starting
+	// the process, in place of the DefaultExecRetry
+	ExecRetry *ExecRetry
+
+	// Title, if set, replaces argv[0] of the process, which is what ps
+	// and top show in place of the executable's path. It's not applied
+	// with Namespaces. Multi-call binaries such as busybox choose what
+	// to run by argv[0], so they can't be given a title.
+	Title string
+
+	// Limit, if set, is shared with other Commands to cap how many of
+	// them run at once
+	Limit  *Limit
+	queued int32 // non-zero while waiting on the Limit
+
+	exitCode int
+	pid      int32 // of the running process, or zero
+
+	// clock, if set, replaces time.Now for measuring clock skew, so
+	// that tests can simulate it
+	clock func() time.Time
 }
 
 // NewCommand parses JSON config into a Command
@@ -88,21 +164,80 @@ func (c *Command) Run(pctx context.Context, bus *events.EventBus) {
 		log.Debugf("nothing to run for %s", c.Name)
 		return
 	}
+	if c.Limit == nil {
+		c.run(pctx, bus)
+		return
+	}
+	// if we're still waiting for our turn from the last time we were
+	// run, we don't need to queue up a second time
+	if !atomic.CompareAndSwapInt32(&c.queued, 0, 1) {
+		log.Debugf("%s is already waiting to run", c.Name)
+		return
+	}
+	go func() {
+		acquired := c.Limit.acquire(pctx)
+		atomic.StoreInt32(&c.queued, 0)
+		if acquired {
+			c.run(pctx, bus)
+		}
+	}()
+}
+
+func (c *Command) run(pctx context.Context, bus *events.EventBus) {
 	// we should never have more than one instance running for any
 	// realistic configuration but this ensures that's the case
 	c.lock.Lock()
 	log.Debugf("%s.Run start", c.Name)
 
-	cmd := exec.Command(c.Exec, c.Args...)
-	if c.logger.Logger != nil {
-		cmd.Stdout = c.logger.Writer()
-		cmd.Stderr = c.logger.Writer()
-	} else {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+	execPath, args := c.Exec, c.Args
+	argv0 := execPath
+	if c.Title != "" && c.Namespaces == nil {
+		// nsenter execs the process itself, so the title can't be
+		// applied in namespaces; the jobs config rejects the two
+		// together
+		argv0 = c.Title
+	}
+	var wrapErr, hardenErr error
+	if c.Namespaces != nil {
+		execPath, args, wrapErr = c.Namespaces.wrap(c.Exec, c.Args)
+		argv0 = execPath
+	}
+	if c.Hardening != nil && wrapErr == nil {
+		// the wrapper, if any, runs hardened and its process inherits
+		// the restrictions
+		execPath, args, hardenErr = c.Hardening.wrap(
+			execPath, append([]string{argv0}, args...))
+		argv0 = execPath
+	}
+	cmd := exec.Command(execPath, args...)
+	cmd.Args[0] = argv0
+	cmd.Stdout, cmd.Stderr = c.outputWriters()
+	var buffered *bufferedWriter
+	if c.LogBuffer != nil && c.LogBuffer.Size > 0 {
+		buffered = newBufferedWriter(c.Name, c.LogBuffer, cmd.Stdout)
+		cmd.Stdout = buffered
+		cmd.Stderr = buffered
+	}
+	var encoders []*binaryWriter
+	if c.Output == nil && c.BinaryEncoding != "" {
+		stdout := newBinaryWriter(c.BinaryEncoding, cmd.Stdout)
+		stderr := newBinaryWriter(c.BinaryEncoding, cmd.Stderr)
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+		encoders = append(encoders, stdout, stderr)
+	}
+	var matchers []*lineMatcher
+	cmd.Stdout, cmd.Stderr, matchers = c.Success.wrap(cmd.Stdout, cmd.Stderr)
+	var stderr *stderrTail
+	if dl := getDeadLetter(); dl != nil {
+		stderr = newStderrTail(dl.StderrLines)
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, stderr)
+	}
+	if c.Env != nil {
+		cmd.Env = append(os.Environ(), c.Env...)
 	}
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-	c.Cmd = cmd
+	c.setCmd(nil) // don't signal the last run's process group
+	timerStart := c.now()
 	ctx, cancel := getContext(pctx, c.Timeout)
 
 	go func() {
@@ -115,6 +250,7 @@ func (c *Command) Run(pctx context.Context, bus *events.EventBus) {
 		defer c.lock.Unlock()
 		if ctx.Err() == context.DeadlineExceeded {
 			log.Warnf("%s timeout after %s: '%s'", c.Name, c.Timeout, c.Args)
+			checkClockSkew(c.Name, c.now().Sub(timerStart), c.Timeout)
 			c.Kill()
 			return
 		}
@@ -122,44 +258,354 @@ func (c *Command) Run(pctx context.Context, bus *events.EventBus) {
 	}()
 
 	go func() {
+		if c.Limit != nil {
+			defer c.Limit.release()
+		}
 		defer cancel()
 		defer log.Debugf("%s.Run end", c.Name)
-		if err := c.Cmd.Start(); err != nil {
+		if buffered != nil {
+			defer buffered.Close()
+		}
+		for _, encoder := range encoders {
+			// flushed before the buffer is closed
+			defer encoder.Close()
+		}
+		if hardenErr != nil {
+			log.Errorf("unable to harden %s: %v", c.Name, hardenErr)
+			c.exitCode = 1
+			bus.Publish(events.Event{events.ExitFailed, c.Name})
+			bus.Publish(events.Event{events.Error, hardenErr.Error()})
+			return
+		}
+		if wrapErr != nil {
+			log.Errorf("unable to enter namespaces for %s: %v", c.Name, wrapErr)
+			c.exitCode = 1
+			bus.Publish(events.Event{events.ExitFailed, c.Name})
+			bus.Publish(events.Event{events.Error, wrapErr.Error()})
+			return
+		}
+		if err := c.runSteps(ctx); err != nil {
+			c.exitCode = 1
+			bus.Publish(events.Event{events.ExitFailed, c.Name})
+			bus.Publish(events.Event{events.Error, err.Error()})
+			return
+		}
+		var tty *ttyOutput
+		if c.TTY {
+			var err error
+			if tty, err = attachTTY(cmd); err != nil {
+				log.Errorf("unable to attach %s to a tty: %v", c.Name, err)
+				c.exitCode = 1
+				bus.Publish(events.Event{events.ExitFailed, c.Name})
+				bus.Publish(events.Event{events.Error, err.Error()})
+				return
+			}
+		}
+		start := time.Now()
+		cmd, err := c.start(cmd)
+		if err != nil {
+			if tty != nil {
+				tty.close(false)
+			}
 			log.Errorf("unable to start %s: %v", c.Name, err)
+			c.exitCode = startErrorCode(err)
+			c.recordExit(c.exitCode)
+			c.recordRun("failed", time.Since(start))
+			c.recordStreak(false)
+			c.recordFailure(c.exitCode, err, stderr, time.Since(start))
 			bus.Publish(events.Event{events.ExitFailed, c.Name})
 			bus.Publish(events.Event{events.Error, err.Error()})
 			return
 		}
 
+		if tty != nil {
+			tty.started()
+		}
+
 		// if we're able to, log the PID of our Command's exec process through
 		// our logger fields
-		if c.Cmd != nil && c.Cmd.Process != nil {
-			pid := c.Cmd.Process.Pid
+		if cmd.Process != nil {
+			pid := cmd.Process.Pid
+			atomic.StoreInt32(&c.pid, int32(pid))
+			defer atomic.StoreInt32(&c.pid, 0)
+			if c.CPUTimeout > 0 {
+				if err := setCPULimit(pid, c.CPUTimeout); err != nil {
+					log.Errorf("unable to set CPU time limit for %s: %v",
+						c.Name, err)
+				}
+			}
+			if c.Nice != 0 {
+				if err := setNice(pid, c.Nice); err != nil {
+					log.Errorf("unable to set priority for %s: %v", c.Name, err)
+				}
+			}
 
-			envName := fmt.Sprintf("CONTAINERPILOT_%s_PID", c.EnvName())
+			envName := EnvVar(c.EnvName() + "_PID")
 			os.Setenv(envName, strconv.Itoa(pid))
 			defer os.Unsetenv(envName)
 
 			if len(c.fields) > 0 {
+				c.logLock.Lock()
 				c.fields["pid"] = pid
 				c.logger = *log.WithFields(c.fields)
+				c.logLock.Unlock()
 			}
 		}
 
 		// blocks this goroutine here; if the context gets cancelled
 		// we'll return from Wait() and publish events
-		if err := c.Cmd.Wait(); err != nil {
+		err = cmd.Wait()
+		duration := time.Since(start)
+		if tty != nil {
+			tty.close(true)
+		}
+		c.runPostStop()
+		if err != nil && ctx.Err() == nil &&
+			c.Success.accepts(waitErrorCode(err), matchers) {
+			log.Debugf("%s exited with error treated as success: %v", c.Name, err)
+			c.recordRun("success", duration)
+			c.recordStreak(true)
+			c.exitCode = waitErrorCode(err)
+			c.recordExit(c.exitCode)
+			bus.Publish(events.Event{events.ExitSuccess, c.Name})
+		} else if err != nil {
 			log.Errorf("%s exited with error: %v", c.Name, err)
+			c.recordRun("failed", duration)
+			c.exitCode = waitErrorCode(err)
+			c.recordExit(c.exitCode)
+			if ctx.Err() != context.Canceled {
+				// we don't record processes we stopped ourselves
+				c.recordStreak(false)
+				c.recordFailure(c.exitCode, err, stderr, duration)
+			}
 			bus.Publish(events.Event{events.ExitFailed, c.Name})
 			bus.Publish(events.Event{events.Error,
 				fmt.Errorf("%s: %s", c.Name, err).Error()})
 		} else {
 			log.Debugf("%s exited without error", c.Name)
+			c.recordRun("success", duration)
+			c.recordStreak(true)
+			c.exitCode = 0
+			c.recordExit(c.exitCode)
 			bus.Publish(events.Event{events.ExitSuccess, c.Name})
 		}
 	}()
 }
 
+func (c *Command) outputWriters() (stdout, stderr io.Writer) {
+	if c.Output != nil {
+		return c.Output, c.Output
+	}
+	if c.getLogger().Logger != nil {
+		return &logWriter{c: c}, &logWriter{c: c}
+	}
+	return os.Stdout, os.Stderr
+}
+
+// logWriter logs each line written to it with the Command's logger. The
+// logger is looked up for each line rather than when the output is
+// attached, because it only gets the pid field once the process starts.
+type logWriter struct {
+	c       *Command
+	partial []byte
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := bytes.TrimSuffix(w.partial[:i], []byte("\r"))
+		w.c.getLogger().Info(string(line))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (c *Command) getLogger() *log.Entry {
+	c.logLock.Lock()
+	defer c.logLock.Unlock()
+	logger := c.logger
+	return &logger
+}
+
+// runPostStop runs the PostStop hook, if any, and blocks until it exits
+// or times out. The hook's failure is logged but otherwise ignored. The
+// hook isn't cancelled along with the Command's context, so that it can
+// still clean up after the process when we're shutting down.
+func (c *Command) runPostStop() {
+	hook := c.PostStop
+	if hook == nil {
+		return
+	}
+	timerStart := hook.now()
+	ctx, cancel := getContext(context.Background(), hook.Timeout)
+	defer cancel()
+	cmd := exec.Command(hook.Exec, hook.Args...)
+	cmd.Stdout, cmd.Stderr = hook.outputWriters()
+	if c.Env != nil {
+		cmd.Env = append(os.Environ(), c.Env...)
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		log.Errorf("unable to start %s: %v", hook.Name, err)
+		return
+	}
+	hook.setCmd(cmd)
+	waitCh := make(chan error, 1)
+	go func() { waitCh <- cmd.Wait() }()
+	select {
+	case err := <-waitCh:
+		if err != nil {
+			log.Errorf("%s exited with error: %v", hook.Name, err)
+		}
+	case <-ctx.Done():
+		log.Warnf("%s timeout after %s: '%s'", hook.Name, hook.Timeout, hook.Args)
+		checkClockSkew(hook.Name, hook.now().Sub(timerStart), hook.Timeout)
+		hook.Kill()
+		<-waitCh
+	}
+}
+
+// runSteps runs each of the Steps to completion in order, stopping at
+// the first one that fails or if the Command is cancelled
+func (c *Command) runSteps(ctx context.Context) error {
+	for _, step := range c.Steps {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%s: cancelled before %s", c.Name, step.Name)
+		}
+		stdout, stderr := step.outputWriters()
+		if err := step.runAndWait(ctx, stdout, stderr, c.Env); err != nil {
+			log.Errorf("%s failed: %v", step.Name, err)
+			return fmt.Errorf("%s: %s failed: %s", c.Name, step.Name, err)
+		}
+	}
+	return nil
+}
+
+// Pid returns the PID of the Command's running process, or zero if it's
+// not running
+func (c *Command) Pid() int {
+	return int(atomic.LoadInt32(&c.pid))
+}
+
+// ExitCode returns the exit code of the last run of the Command. It's
+// only meaningful after the Command's ExitSuccess or ExitFailed event has
+// been received. If the process couldn't be started at all, this follows
+// the shell convention of 127 for a missing executable and 126 for one
+// that can't be executed.
+func (c *Command) ExitCode() int {
+	return c.exitCode
+}
+
+// ExitCodeOf returns the exit code of a run of a Command that returned
+// the error, following the same conventions as ExitCode: 0 if there's no
+// error, and the code of the process if it exited or 128 plus the signal
+// if it was killed.
+func ExitCodeOf(err error) int {
+	if err == nil {
+		return 0
+	}
+	if _, ok := err.(*exec.ExitError); ok {
+		return waitErrorCode(err)
+	}
+	return startErrorCode(err)
+}
+
+func startErrorCode(err error) int {
+	if execErr, ok := err.(*exec.Error); ok {
+		err = execErr.Err
+	}
+	switch {
+	case err == exec.ErrNotFound || os.IsNotExist(err):
+		return 127
+	case os.IsPermission(err):
+		return 126
+	}
+	return 1
+}
+
+func waitErrorCode(err error) int {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if status.Signaled() {
+				return 128 + int(status.Signal())
+			}
+			return status.ExitStatus()
+		}
+	}
+	return 1
+}
+
+// RunAndWait runs the Command in the foreground with its stdout/stderr
+// attached to ContainerPilot's, and blocks until it exits or times out.
+// This is only for one-off subcommands that don't use the event bus.
+func (c *Command) RunAndWait() error {
+	return c.runAndWait(context.Background(), os.Stdout, os.Stderr, c.Env)
+}
+
+// RunAndCapture runs the Command in the foreground and blocks until it
+// exits or times out, returning what it wrote to stdout. Its stderr goes
+// wherever the Command's output is logged.
+func (c *Command) RunAndCapture(ctx context.Context) ([]byte, error) {
+	stdout := &bytes.Buffer{}
+	_, stderr := c.outputWriters()
+	err := c.runAndWait(ctx, stdout, stderr, c.Env)
+	return stdout.Bytes(), err
+}
+
+func (c *Command) runAndWait(pctx context.Context, stdout, stderr io.Writer, env []string) error {
+	timerStart := c.now()
+	ctx, cancel := getContext(pctx, c.Timeout)
+	defer cancel()
+	cmd := exec.Command(c.Exec, c.Args...)
+	var matchers []*lineMatcher
+	stdout, stderr, matchers = c.Success.wrap(stdout, stderr)
+	pipes, err := attachOutput(cmd, stdout, stderr)
+	if err != nil {
+		return err
+	}
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd, err = c.start(cmd)
+	if err != nil {
+		pipes.close()
+		c.recordExit(startErrorCode(err))
+		return err
+	}
+	pipes.started()
+	waitCh := make(chan error, 1)
+	go func() { waitCh <- cmd.Wait() }()
+	select {
+	case err := <-waitCh:
+		// the matchers need all of the output before we check them
+		pipes.wait(ctx)
+		if err == nil {
+			c.recordExit(0)
+			return nil
+		}
+		c.recordExit(waitErrorCode(err))
+		if c.Success.accepts(waitErrorCode(err), matchers) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			checkClockSkew(c.Name, c.now().Sub(timerStart), c.Timeout)
+		}
+		c.Kill()
+		if err := <-waitCh; err != nil {
+			c.recordExit(waitErrorCode(err))
+		}
+		pipes.wait(ctx)
+		return fmt.Errorf("timeout after %s", c.Timeout)
+	}
+}
+
 func getContext(pctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
 	if timeout > 0 {
 		return context.WithTimeout(pctx, timeout)
@@ -167,13 +613,40 @@ func getContext(pctx context.Context, timeout time.Duration) (context.Context, c
 	return context.WithCancel(pctx)
 }
 
+// start starts the exec.Cmd, with retries, and publishes the one that
+// was started as c.Cmd. Term and Kill wait for it, so a process that's
+// stopped while it's being started is signalled once it has started.
+func (c *Command) start(cmd *exec.Cmd) (*exec.Cmd, error) {
+	c.cmdLock.Lock()
+	defer c.cmdLock.Unlock()
+	cmd, err := c.startCmd(cmd)
+	c.Cmd = cmd
+	return cmd, err
+}
+
+func (c *Command) setCmd(cmd *exec.Cmd) {
+	c.cmdLock.Lock()
+	defer c.cmdLock.Unlock()
+	c.Cmd = cmd
+}
+
+// process returns the started process, if any
+func (c *Command) process() *os.Process {
+	c.cmdLock.Lock()
+	defer c.cmdLock.Unlock()
+	if c.Cmd == nil {
+		return nil
+	}
+	return c.Cmd.Process
+}
+
 // Kill sends a kill signal to the underlying process if it still exists,
 // as well as all its children
 func (c *Command) Kill() {
 	log.Debugf("%s.kill", c.Name)
-	if c.Cmd != nil && c.Cmd.Process != nil {
-		log.Debugf("killing command '%v' at pid: %d", c.Name, c.Cmd.Process.Pid)
-		syscall.Kill(-c.Cmd.Process.Pid, syscall.SIGKILL)
+	if proc := c.process(); proc != nil {
+		log.Debugf("killing command '%v' at pid: %d", c.Name, proc.Pid)
+		syscall.Kill(-proc.Pid, syscall.SIGKILL)
 	}
 }
 
@@ -181,8 +654,8 @@ func (c *Command) Kill() {
 // as well as all its children
 func (c *Command) Term() {
 	log.Debugf("%s.term", c.Name)
-	if c.Cmd != nil && c.Cmd.Process != nil {
-		log.Debugf("terminating command '%v' at pid: %d", c.Name, c.Cmd.Process.Pid)
-		syscall.Kill(-c.Cmd.Process.Pid, syscall.SIGTERM)
+	if proc := c.process(); proc != nil {
+		log.Debugf("terminating command '%v' at pid: %d", c.Name, proc.Pid)
+		syscall.Kill(-proc.Pid, syscall.SIGTERM)
 	}
 }
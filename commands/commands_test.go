@@ -3,7 +3,9 @@ package commands
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -67,6 +69,24 @@ func TestCommandRunExecInvalid(t *testing.T) {
 	}
 }
 
+func TestCommandExitCode(t *testing.T) {
+	testCases := []struct {
+		exec     string
+		expected int
+	}{
+		{"true", 0},
+		{"./testdata/test.sh failStuff", 255},
+		{"./testdata/invalidCommand", 127},
+		{"invalidCommand", 127},
+		{"./testdata", 126},
+	}
+	for _, tc := range testCases {
+		cmd, _ := NewCommand(tc.exec, time.Duration(0), nil)
+		runtestCommandRun(cmd)
+		assert.Equal(t, tc.expected, cmd.ExitCode(), "exit code for %s", tc.exec)
+	}
+}
+
 func TestEmptyCommand(t *testing.T) {
 	if cmd, err := NewCommand("", time.Duration(0), nil); cmd != nil || err == nil {
 		t.Errorf("Expected exit (nil, err) but got %v, %s", cmd, err)
@@ -111,6 +131,48 @@ func TestEnvName(t *testing.T) {
 	}
 }
 
+func TestCommandRunSteps(t *testing.T) {
+	dir, _ := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "marker")
+
+	cmd, _ := NewCommand("touch "+marker, time.Duration(0), nil)
+	cmd.Name = t.Name()
+	for i, exec := range []string{"true", "false", "touch " + marker} {
+		step, _ := NewCommand(exec, time.Duration(0), nil)
+		step.Name = fmt.Sprintf("%s.step%d", t.Name(), i+1)
+		cmd.Steps = append(cmd.Steps, step)
+	}
+	exit, ok := runtestCommandUntilExit(cmd, time.Second)
+	if !ok {
+		t.Fatal("expected command to exit")
+	}
+	assert.Equal(t, events.Event{events.ExitFailed, t.Name()}, exit)
+	assert.Equal(t, 1, cmd.ExitCode())
+	_, err := os.Stat(marker)
+	assert.True(t, os.IsNotExist(err),
+		"expected neither the last step nor the exec to run")
+}
+
+func TestCommandPidEnvPrefix(t *testing.T) {
+	SetEnvPrefix("CP_SIDECAR")
+	defer SetEnvPrefix("")
+
+	cmd, _ := NewCommand("sleep 1", time.Duration(0), nil)
+	cmd.Name = "app"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cmd.Run(ctx, events.NewEventBus())
+	for i := 0; i < 50 && cmd.Pid() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if cmd.Pid() == 0 {
+		t.Fatal("expected command to start")
+	}
+	assert.Equal(t, fmt.Sprintf("%d", cmd.Pid()), os.Getenv("CP_SIDECAR_APP_PID"))
+	assert.Equal(t, "", os.Getenv("CONTAINERPILOT_APP_PID"))
+}
+
 // test helpers
 
 func runtestCommandRun(cmd *Command) map[events.Event]int {
@@ -127,3 +189,26 @@ func runtestCommandRun(cmd *Command) map[events.Event]int {
 	}
 	return got
 }
+
+// runtestCommandUntilExit runs the Command and returns its exit event,
+// or false if it doesn't exit before the timeout
+func runtestCommandUntilExit(cmd *Command, timeout time.Duration) (events.Event, bool) {
+	bus := events.NewEventBus()
+	sub := &events.Subscriber{Rx: make(chan events.Event, 100)}
+	sub.Subscribe(bus)
+	defer sub.Unsubscribe()
+	cmd.Run(context.Background(), bus)
+	deadline := time.After(timeout)
+	for {
+		select {
+		case event := <-sub.Rx:
+			if event.Source == cmd.Name &&
+				(event.Code == events.ExitSuccess || event.Code == events.ExitFailed) {
+				return event, true
+			}
+		case <-deadline:
+			cmd.Kill()
+			return events.Event{}, false
+		}
+	}
+}
@@ -0,0 +1,25 @@
+//go:build linux
+// +build linux
+
+package commands
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// setCPULimit sets RLIMIT_CPU for the process with the given pid so that
+// the kernel sends it SIGXCPU once it has used limit of CPU time. The
+// hard limit is a second later, at which point the kernel sends SIGKILL,
+// in case the process ignores SIGXCPU.
+func setCPULimit(pid int, limit time.Duration) error {
+	seconds := uint64(limit / time.Second)
+	rlimit := syscall.Rlimit{Cur: seconds, Max: seconds + 1}
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64,
+		uintptr(pid), syscall.RLIMIT_CPU,
+		uintptr(unsafe.Pointer(&rlimit)), 0, 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+//go:build linux
+// +build linux
+
+package commands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/joyent/containerpilot/events"
+	"github.com/stretchr/testify/assert"
+)
+
+// The kernel kills a process that spins past its CPU time limit, even
+// though it's well within its wall-clock timeout
+func TestCPUTimeout(t *testing.T) {
+	cmd, _ := NewCommand([]interface{}{"sh", "-c", "while :; do :; done"},
+		10*time.Second, nil)
+	cmd.Name = t.Name()
+	cmd.CPUTimeout = time.Second
+
+	exit, ok := runtestCommandUntilExit(cmd, 5*time.Second)
+	if !ok {
+		t.Fatal("expected process to be killed by CPU time limit")
+	}
+	assert.Equal(t, events.Event{events.ExitFailed, t.Name()}, exit)
+	assert.Equal(t, 128+24, cmd.ExitCode(), "expected SIGXCPU")
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package commands
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// setCPULimit is a no-op outside of Linux, where we can't set the
+// resource limits of another process.
+func setCPULimit(pid int, limit time.Duration) error {
+	log.Warn("cpuTimeout is only supported on Linux; running without a CPU time limit")
+	return nil
+}
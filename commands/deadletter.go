@@ -0,0 +1,251 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joyent/containerpilot/config/decode"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// number of lines of stderr kept for each dead letter by default
+	defaultDeadLetterLines = 20
+
+	// the most bytes of stderr we'll hold onto for a dead letter, however
+	// long its lines are
+	maxStderrTail = 64 * 1024
+
+	redacted = "[REDACTED]"
+)
+
+// the names of environment variables and flags whose values we redact
+// from dead letters, matched case-insensitively anywhere in the name
+var defaultRedactions = []string{
+	"PASSWORD", "PASSWD", "SECRET", "TOKEN", "KEY", "CREDENTIAL", "AUTH",
+}
+
+// dead letters are only written once SetDeadLetter is called with a
+// configured DeadLetter
+var (
+	deadLetter     *DeadLetter
+	deadLetterLock sync.RWMutex
+)
+
+// DeadLetter is a log of every failed run of a Command, written as one
+// JSON record per line so that failures can be examined after the fact
+type DeadLetter struct {
+	Path        string   `mapstructure:"path"`
+	StderrLines int      `mapstructure:"stderrLines"`
+	Redact      []string `mapstructure:"redact"`
+
+	redactions []string
+	lock       sync.Mutex
+}
+
+// NewDeadLetter parses json config into a validated DeadLetter. Returns
+// nil if the dead letter log isn't configured.
+func NewDeadLetter(raw interface{}) (*DeadLetter, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	dl := &DeadLetter{}
+	if err := decode.ToStruct(raw, dl); err != nil {
+		return nil, fmt.Errorf("deadLetter configuration error: %v", err)
+	}
+	if dl.Path == "" {
+		return nil, fmt.Errorf("deadLetter.path must be set")
+	}
+	if dl.StderrLines < 0 {
+		return nil, fmt.Errorf("deadLetter.stderrLines must be >= 0")
+	}
+	if dl.StderrLines == 0 {
+		dl.StderrLines = defaultDeadLetterLines
+	}
+	dl.redactions = append([]string{}, defaultRedactions...)
+	for _, name := range dl.Redact {
+		dl.redactions = append(dl.redactions, strings.ToUpper(name))
+	}
+	return dl, nil
+}
+
+// SetDeadLetter sets the log that failed runs of all Commands are
+// written to. A nil DeadLetter stops writing them, as on a reload that
+// removes it.
+func SetDeadLetter(dl *DeadLetter) {
+	deadLetterLock.Lock()
+	defer deadLetterLock.Unlock()
+	deadLetter = dl
+}
+
+func getDeadLetter() *DeadLetter {
+	deadLetterLock.RLock()
+	defer deadLetterLock.RUnlock()
+	return deadLetter
+}
+
+// deadLetterRecord is one failed run of a Command
+type deadLetterRecord struct {
+	Time        time.Time `json:"time"`
+	Command     string    `json:"command"`
+	Argv        []string  `json:"argv"`
+	Env         []string  `json:"env"`
+	ExitCode    int       `json:"exitCode"`
+	Error       string    `json:"error"`
+	Stderr      []string  `json:"stderr"`
+	Duration    string    `json:"duration"`
+	EventSource string    `json:"eventSource,omitempty"`
+	EventTime   string    `json:"eventTime,omitempty"`
+}
+
+// recordFailure writes a dead letter for the failed run of the Command,
+// if the dead letter log is configured
+func (c *Command) recordFailure(exitCode int, err error, stderr *stderrTail, duration time.Duration) {
+	dl := getDeadLetter()
+	if dl == nil {
+		return
+	}
+	env := append(os.Environ(), c.Env...)
+	rec := deadLetterRecord{
+		Time:        time.Now(),
+		Command:     c.Name,
+		Argv:        dl.redactArgs(append([]string{c.Exec}, c.Args...)),
+		Env:         dl.redactEnv(env),
+		ExitCode:    exitCode,
+		Error:       err.Error(),
+		Stderr:      stderr.lines(),
+		Duration:    duration.String(),
+		EventSource: c.getenv(EnvVar("EVENT_SOURCE")),
+		EventTime:   c.getenv(EnvVar("EVENT_TIME")),
+	}
+	if err := dl.write(rec); err != nil {
+		log.Errorf("unable to write dead letter for %s: %v", c.Name, err)
+	}
+}
+
+func (dl *DeadLetter) write(rec deadLetterRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+	f, err := os.OpenFile(dl.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (dl *DeadLetter) sensitive(name string) bool {
+	return isSensitive(name, dl.redactions)
+}
+
+func isSensitive(name string, redactions []string) bool {
+	name = strings.ToUpper(strings.TrimLeft(name, "-"))
+	for _, word := range redactions {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactValue returns the value of the named environment variable or
+// argument, or a placeholder if the name is sensitive. Names are redacted
+// the same way as in the dead letter log, whether or not it's configured.
+func RedactValue(name, value string) string {
+	redactions := defaultRedactions
+	if dl := getDeadLetter(); dl != nil {
+		redactions = dl.redactions
+	}
+	if isSensitive(name, redactions) {
+		return redacted
+	}
+	return value
+}
+
+// redactEnv replaces the values of sensitive environment variables
+func (dl *DeadLetter) redactEnv(env []string) []string {
+	result := make([]string, len(env))
+	for i, kv := range env {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 && dl.sensitive(parts[0]) {
+			kv = parts[0] + "=" + redacted
+		}
+		result[i] = kv
+	}
+	return result
+}
+
+// redactArgs replaces the values of sensitive arguments, whether they're
+// passed as "--name=value", "NAME=value", or "--name value"
+func (dl *DeadLetter) redactArgs(args []string) []string {
+	result := make([]string, len(args))
+	redactNext := false
+	for i, arg := range args {
+		switch {
+		case redactNext && !strings.HasPrefix(arg, "-"):
+			arg = redacted
+			redactNext = false
+		case strings.Contains(arg, "="):
+			parts := strings.SplitN(arg, "=", 2)
+			if dl.sensitive(parts[0]) {
+				arg = parts[0] + "=" + redacted
+			}
+			redactNext = false
+		default:
+			redactNext = i > 0 && strings.HasPrefix(arg, "-") && dl.sensitive(arg)
+		}
+		result[i] = arg
+	}
+	return result
+}
+
+// stderrTail is an io.Writer that keeps the last lines written to it
+type stderrTail struct {
+	max  int
+	buf  []byte
+	lock sync.Mutex
+}
+
+func newStderrTail(max int) *stderrTail {
+	return &stderrTail{max: max}
+}
+
+// Write implements io.Writer
+func (t *stderrTail) Write(p []byte) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > maxStderrTail {
+		t.buf = t.buf[len(t.buf)-maxStderrTail:]
+	}
+	// trim to the last lines, plus any partial line being written
+	for n := bytes.Count(t.buf, []byte("\n")); n > t.max; n-- {
+		t.buf = t.buf[bytes.IndexByte(t.buf, '\n')+1:]
+	}
+	return len(p), nil
+}
+
+func (t *stderrTail) lines() []string {
+	if t == nil {
+		return []string{}
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	text := strings.TrimRight(string(t.buf), "\n")
+	if text == "" {
+		return []string{}
+	}
+	return strings.Split(text, "\n")
+}
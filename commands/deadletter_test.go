@@ -0,0 +1,81 @@
+package commands
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetterFailedCommand(t *testing.T) {
+	dir, _ := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dead-letter.log")
+	dl, err := NewDeadLetter(map[string]interface{}{
+		"path": path, "stderrLines": 2, "redact": []string{"dsn"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetDeadLetter(dl)
+	defer SetDeadLetter(nil)
+
+	cmd, _ := NewCommand([]string{"sh", "-c",
+		"echo one >&2; echo two >&2; echo three >&2; exit 3",
+		"--password", "hunter2", "--db-dsn=postgres://u:p@db/app"},
+		time.Duration(0), nil)
+	cmd.Name = "failing"
+	cmd.Env = []string{"API_TOKEN=abc123", "CONTAINERPILOT_EVENT_SOURCE=upstream"}
+	runtestCommandRun(cmd)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if !assert.Len(t, lines, 1) {
+		return
+	}
+	var rec deadLetterRecord
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "failing", rec.Command)
+	assert.Equal(t, []string{"sh", "-c",
+		"echo one >&2; echo two >&2; echo three >&2; exit 3",
+		"--password", redacted, "--db-dsn=" + redacted}, rec.Argv)
+	assert.Contains(t, rec.Env, "API_TOKEN="+redacted)
+	assert.NotContains(t, strings.Join(rec.Env, " "), "abc123")
+	assert.Equal(t, 3, rec.ExitCode)
+	assert.Equal(t, "exit status 3", rec.Error)
+	assert.Equal(t, []string{"two", "three"}, rec.Stderr)
+	assert.NotEmpty(t, rec.Duration)
+	assert.Equal(t, "upstream", rec.EventSource)
+	assert.False(t, rec.Time.IsZero())
+
+	// successful runs aren't recorded
+	cmd, _ = NewCommand("true", time.Duration(0), nil)
+	runtestCommandRun(cmd)
+	data, _ = ioutil.ReadFile(path)
+	assert.Len(t, strings.Split(strings.TrimSpace(string(data)), "\n"), 1)
+}
+
+func TestDeadLetterConfig(t *testing.T) {
+	dl, err := NewDeadLetter(nil)
+	assert.Nil(t, dl)
+	assert.NoError(t, err)
+
+	dl, err = NewDeadLetter(map[string]interface{}{"path": "/tmp/dead"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, defaultDeadLetterLines, dl.StderrLines)
+	}
+
+	_, err = NewDeadLetter(map[string]interface{}{})
+	assert.EqualError(t, err, "deadLetter.path must be set")
+	_, err = NewDeadLetter(map[string]interface{}{"path": "/tmp/dead", "stderrLines": -1})
+	assert.EqualError(t, err, "deadLetter.stderrLines must be >= 0")
+}
@@ -0,0 +1,48 @@
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	f, _ := ioutil.TempFile("", "envfile")
+	f.WriteString(`# a comment
+PLAIN=value
+
+export EXPORTED=1
+DOUBLE="quoted value"
+SINGLE='single'
+EMPTY=
+WITH_EQUALS=a=b
+`)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	env, err := ParseEnvFile(f.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"PLAIN=value",
+		"EXPORTED=1",
+		"DOUBLE=quoted value",
+		"SINGLE=single",
+		"EMPTY=",
+		"WITH_EQUALS=a=b",
+	}, env)
+}
+
+func TestParseEnvFileInvalid(t *testing.T) {
+	f, _ := ioutil.TempFile("", "envfile")
+	f.WriteString("GOOD=1\nnot an assignment\n")
+	f.Close()
+	defer os.Remove(f.Name())
+
+	_, err := ParseEnvFile(f.Name())
+	assert.EqualError(t, err, f.Name()+":2: expected KEY=VALUE")
+
+	_, err = ParseEnvFile("./testdata/doesNotExist")
+	assert.Error(t, err)
+}
@@ -0,0 +1,47 @@
+package commands
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// DefaultEnvPrefix is the prefix of the environment variables that
+// ContainerPilot sets for its child processes, unless it's configured
+// otherwise (ex. CONTAINERPILOT_APP_PID)
+const DefaultEnvPrefix = "CONTAINERPILOT"
+
+var (
+	envPrefix     = DefaultEnvPrefix
+	envPrefixLock sync.RWMutex
+
+	validEnvPrefix = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// ValidateEnvPrefix returns an error if the prefix can't start the name
+// of an environment variable
+func ValidateEnvPrefix(prefix string) error {
+	if !validEnvPrefix.MatchString(prefix) {
+		return fmt.Errorf("'%s' is not a valid environment variable prefix", prefix)
+	}
+	return nil
+}
+
+// SetEnvPrefix sets the prefix of the environment variables we set for
+// child processes. An empty prefix resets it to the DefaultEnvPrefix.
+func SetEnvPrefix(prefix string) {
+	if prefix == "" {
+		prefix = DefaultEnvPrefix
+	}
+	envPrefixLock.Lock()
+	defer envPrefixLock.Unlock()
+	envPrefix = prefix
+}
+
+// EnvVar returns the name of the environment variable we set for child
+// processes with the given suffix (ex. "APP_PID" => CONTAINERPILOT_APP_PID)
+func EnvVar(name string) string {
+	envPrefixLock.RLock()
+	defer envPrefixLock.RUnlock()
+	return envPrefix + "_" + name
+}
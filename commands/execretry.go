@@ -0,0 +1,67 @@
+package commands
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ExecRetry bounds how often we retry starting a process after an error
+// that's expected to clear on its own, such as ETXTBSY when the
+// executable was written just before we ran it
+type ExecRetry struct {
+	Attempts int // retries after the first attempt; zero to not retry
+	Delay    time.Duration
+}
+
+// DefaultExecRetry is used by Commands that don't set their own ExecRetry
+var DefaultExecRetry = &ExecRetry{Attempts: 3, Delay: 100 * time.Millisecond}
+
+// isTransientExecError returns true if starting a process failed for a
+// reason worth retrying. A missing or non-executable file isn't.
+func isTransientExecError(err error) bool {
+	if pathErr, ok := err.(*os.PathError); ok {
+		err = pathErr.Err
+	}
+	return err == syscall.ETXTBSY || err == syscall.EAGAIN
+}
+
+// startCmd starts cmd, retrying after transient errors, and returns the
+// exec.Cmd that was started. An exec.Cmd can't be started twice, so each
+// retry starts a copy of it. The caller publishes the result to c.Cmd
+// under cmdLock so that Term and Kill never see a process mid-start.
+func (c *Command) startCmd(cmd *exec.Cmd) (*exec.Cmd, error) {
+	retry := c.ExecRetry
+	if retry == nil {
+		retry = DefaultExecRetry
+	}
+	err := cmd.Start()
+	for attempt := 1; err != nil && attempt <= retry.Attempts; attempt++ {
+		if !isTransientExecError(err) {
+			return cmd, err
+		}
+		log.Warnf("unable to start %s, retrying in %v (%d/%d): %v",
+			c.Name, retry.Delay, attempt, retry.Attempts, err)
+		time.Sleep(retry.Delay)
+		cmd = copyCmd(cmd)
+		err = cmd.Start()
+	}
+	return cmd, err
+}
+
+func copyCmd(cmd *exec.Cmd) *exec.Cmd {
+	return &exec.Cmd{
+		Path:        cmd.Path,
+		Args:        cmd.Args,
+		Env:         cmd.Env,
+		Dir:         cmd.Dir,
+		Stdin:       cmd.Stdin,
+		Stdout:      cmd.Stdout,
+		Stderr:      cmd.Stderr,
+		ExtraFiles:  cmd.ExtraFiles,
+		SysProcAttr: cmd.SysProcAttr,
+	}
+}
@@ -0,0 +1,88 @@
+//go:build linux
+// +build linux
+
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/joyent/containerpilot/events"
+	"github.com/stretchr/testify/assert"
+)
+
+// busyExecutable writes an executable and holds it open for writing, as
+// if it were still being written, so that exec'ing it fails with ETXTBSY
+// until the returned file is closed
+func busyExecutable(t *testing.T) (string, *os.File) {
+	dir, _ := ioutil.TempDir("", t.Name())
+	path := filepath.Join(dir, "busy.sh")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("#!/bin/sh\nexit 0\n")
+	return path, f
+}
+
+func TestExecRetryTransient(t *testing.T) {
+	path, f := busyExecutable(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	cmd, _ := NewCommand(path, time.Duration(0), nil)
+	cmd.Name = t.Name()
+	cmd.ExecRetry = &ExecRetry{Attempts: 5, Delay: 100 * time.Millisecond}
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		f.Close()
+	}()
+	exit, ok := runtestCommandUntilExit(cmd, 2*time.Second)
+	if !ok {
+		t.Fatal("expected command to exit")
+	}
+	assert.Equal(t, events.Event{events.ExitSuccess, t.Name()}, exit)
+}
+
+func TestExecRetryExhausted(t *testing.T) {
+	path, f := busyExecutable(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	defer f.Close()
+	cmd, _ := NewCommand(path, time.Duration(0), nil)
+	cmd.Name = t.Name()
+	cmd.ExecRetry = &ExecRetry{Attempts: 2, Delay: 10 * time.Millisecond}
+	exit, ok := runtestCommandUntilExit(cmd, 2*time.Second)
+	if !ok {
+		t.Fatal("expected command to exit")
+	}
+	assert.Equal(t, events.Event{events.ExitFailed, t.Name()}, exit)
+}
+
+func TestExecRetryNotFound(t *testing.T) {
+	cmd, _ := NewCommand("./testdata/invalidCommand", time.Duration(0), nil)
+	cmd.Name = t.Name()
+	cmd.ExecRetry = &ExecRetry{Attempts: 3, Delay: time.Second}
+	start := time.Now()
+	exit, ok := runtestCommandUntilExit(cmd, 2*time.Second)
+	if !ok {
+		t.Fatal("expected command to exit")
+	}
+	assert.Equal(t, events.Event{events.ExitFailed, t.Name()}, exit)
+	assert.Equal(t, 127, cmd.ExitCode())
+	assert.True(t, time.Since(start) < 500*time.Millisecond,
+		"expected a missing executable to fail without retrying")
+}
+
+func TestExecRetryRunAndWait(t *testing.T) {
+	path, f := busyExecutable(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	cmd, _ := NewCommand(path, time.Duration(0), nil)
+	cmd.Name = t.Name()
+	cmd.ExecRetry = &ExecRetry{Attempts: 5, Delay: 100 * time.Millisecond}
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		f.Close()
+	}()
+	assert.NoError(t, cmd.RunAndWait())
+}
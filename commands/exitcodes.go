@@ -0,0 +1,42 @@
+package commands
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// the exit codes that get their own label value on the exit counter.
+// Any other code is counted as "other", so that a process that exits
+// with arbitrary codes can't blow up the number of series.
+const (
+	maxCommonExitCode = 2   // 0 success, 1 general error, 2 misuse
+	minSignalExitCode = 124 // timeout(1), not executable, not found, ...
+	maxSignalExitCode = 128 + 31
+)
+
+var commandExits *prometheus.CounterVec
+
+func init() {
+	commandExits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "containerpilot_command_exit_total",
+		Help: "count of command exits, partitioned by command and exit code",
+	}, []string{"command", "code"})
+	prometheus.MustRegister(commandExits)
+}
+
+// exitCodeLabel returns the label value an exit code is counted under
+func exitCodeLabel(code int) string {
+	switch {
+	case code >= 0 && code <= maxCommonExitCode,
+		code >= minSignalExitCode && code <= maxSignalExitCode,
+		code == 255:
+		return strconv.Itoa(code)
+	}
+	return "other"
+}
+
+// recordExit counts an exit of the Command's process with the code
+func (c *Command) recordExit(code int) {
+	commandExits.WithLabelValues(c.Name, exitCodeLabel(code)).Inc()
+}
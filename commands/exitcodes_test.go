@@ -0,0 +1,46 @@
+package commands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandExitCodes(t *testing.T) {
+	exits := func(code string) float64 {
+		metric := &dto.Metric{}
+		commandExits.With(prometheus.Labels{
+			"command": t.Name(), "code": code}).Write(metric)
+		return metric.GetCounter().GetValue()
+	}
+	run := func(exec interface{}) {
+		cmd, _ := NewCommand(exec, time.Duration(0), nil)
+		cmd.Name = t.Name()
+		if _, ok := runtestCommandUntilExit(cmd, time.Second); !ok {
+			t.Fatal("expected command to exit")
+		}
+	}
+
+	run("true")
+	run([]string{"sh", "-c", "exit 2"})
+	run([]string{"sh", "-c", "exit 2"})
+	assert.Equal(t, 1.0, exits("0"))
+	assert.Equal(t, 2.0, exits("2"))
+	assert.Equal(t, 0.0, exits("1"))
+
+	run([]string{"sh", "-c", "exit 42"})
+	assert.Equal(t, 1.0, exits("other"), "expected uncommon codes to be bucketed")
+}
+
+func TestExitCodeLabel(t *testing.T) {
+	for code, label := range map[int]string{
+		0: "0", 1: "1", 2: "2", 3: "other", 42: "other", 123: "other",
+		124: "124", 126: "126", 127: "127", 137: "137", 143: "143",
+		159: "159", 160: "other", 255: "255",
+	} {
+		assert.Equal(t, label, exitCodeLabel(code), "code %d", code)
+	}
+}
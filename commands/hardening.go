@@ -0,0 +1,134 @@
+package commands
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// seccomp BPF programs are arrays of 8-byte 'struct sock_filter' and
+// the kernel refuses programs longer than BPF_MAXINSNS instructions
+const (
+	bpfInstructionSize = 8
+	bpfMaxInstructions = 4096
+)
+
+// Hardening configures restrictions that are applied to a Command's
+// process before it is exec'd. These are only supported on Linux.
+type Hardening struct {
+	NoNewPrivs     bool
+	SeccompProfile string
+	seccompProgram []byte
+}
+
+// NewHardening creates a Hardening and loads the compiled seccomp BPF
+// program found at seccompProfile, if any
+func NewHardening(noNewPrivs bool, seccompProfile string) (*Hardening, error) {
+	h := &Hardening{
+		NoNewPrivs:     noNewPrivs,
+		SeccompProfile: seccompProfile,
+	}
+	if seccompProfile == "" {
+		return h, nil
+	}
+	prog, err := ioutil.ReadFile(seccompProfile)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSeccompProgram(prog, seccompProfile); err != nil {
+		return nil, err
+	}
+	h.seccompProgram = prog
+	return h, nil
+}
+
+func checkSeccompProgram(prog []byte, name string) error {
+	if len(prog) == 0 || len(prog)%bpfInstructionSize != 0 {
+		return fmt.Errorf("%s is not a compiled seccomp BPF program", name)
+	}
+	if len(prog)/bpfInstructionSize > bpfMaxInstructions {
+		return fmt.Errorf("%s exceeds %d BPF instructions",
+			name, bpfMaxInstructions)
+	}
+	return nil
+}
+
+// HardeningShimArg is the first argument of a ContainerPilot process that
+// has been re-executed as the shim that hardens itself and then execs a
+// Command's process. The restrictions can only be applied to a thread of
+// the process that sets them and are never lifted, so we can't apply
+// them to one of our own threads without our code going on to run under
+// them. Instead the shim applies them after we fork, the same way that
+// nsenter enters namespaces for us.
+const HardeningShimArg = "__harden"
+
+// wrap returns the executable and arguments that run the shim, which
+// hardens itself and then execs path with argv
+func (h *Hardening) wrap(path string, argv []string) (string, []string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return "", nil, err
+	}
+	if resolved, err := exec.LookPath(path); err == nil {
+		// otherwise the shim fails to exec it and exits as we would
+		// have failed to start it
+		path = resolved
+	}
+	args := []string{HardeningShimArg}
+	if h.NoNewPrivs {
+		args = append(args, "-no-new-privs")
+	}
+	if len(h.seccompProgram) > 0 {
+		args = append(args, "-seccomp",
+			base64.StdEncoding.EncodeToString(h.seccompProgram))
+	}
+	args = append(args, "--", path)
+	args = append(args, argv...)
+	return self, args, nil
+}
+
+// RunHardeningShim applies the Hardening described by args, the arguments
+// that follow HardeningShimArg, to this process and then execs the
+// process that they name. It only returns if that fails, with the exit
+// code the shim should exit with.
+func RunHardeningShim(args []string) int {
+	flags := flag.NewFlagSet(HardeningShimArg, flag.ContinueOnError)
+	noNewPrivs := flags.Bool("no-new-privs", false, "set no_new_privs")
+	seccomp := flags.String("seccomp", "", "base64 seccomp BPF program")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	argv := flags.Args()
+	if len(argv) < 2 {
+		fmt.Fprintln(os.Stderr, "containerpilot: no process to harden")
+		return 1
+	}
+	h := &Hardening{NoNewPrivs: *noNewPrivs}
+	if *seccomp != "" {
+		prog, err := base64.StdEncoding.DecodeString(*seccomp)
+		if err == nil {
+			err = checkSeccompProgram(prog, "seccomp program")
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "containerpilot: %v\n", err)
+			return 1
+		}
+		h.seccompProgram = prog
+	}
+	// exec replaces the process with the thread that calls it, so the
+	// restrictions we apply to that thread are the ones the process keeps
+	runtime.LockOSThread()
+	if err := h.apply(); err != nil {
+		fmt.Fprintf(os.Stderr, "containerpilot: unable to harden %s: %v\n",
+			argv[0], err)
+		return 1
+	}
+	err := syscall.Exec(argv[0], argv[1:], os.Environ())
+	fmt.Fprintf(os.Stderr, "containerpilot: unable to exec %s: %v\n", argv[0], err)
+	return startErrorCode(err)
+}
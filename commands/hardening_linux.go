@@ -0,0 +1,52 @@
+//go:build linux
+// +build linux
+
+package commands
+
+import (
+	"encoding/binary"
+	"syscall"
+	"unsafe"
+)
+
+// not all of these are exported by the syscall package on every arch
+const (
+	prSetNoNewPrivs   = 38 // PR_SET_NO_NEW_PRIVS from linux/prctl.h
+	prSetSeccomp      = 22 // PR_SET_SECCOMP from linux/prctl.h
+	seccompModeFilter = 2  // SECCOMP_MODE_FILTER from linux/seccomp.h
+)
+
+// apply sets no_new_privs and installs the seccomp filter on the calling
+// OS thread. It's only called by the hardening shim, which locks itself
+// to the thread and then execs from it, so that the exec'd process keeps
+// these restrictions.
+func (h *Hardening) apply() error {
+	if h.NoNewPrivs {
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL,
+			prSetNoNewPrivs, 1, 0); errno != 0 {
+			return errno
+		}
+	}
+	if len(h.seccompProgram) > 0 {
+		filter := make([]syscall.SockFilter, len(h.seccompProgram)/bpfInstructionSize)
+		for i := range filter {
+			insn := h.seccompProgram[i*bpfInstructionSize:]
+			filter[i] = syscall.SockFilter{
+				Code: binary.LittleEndian.Uint16(insn[0:2]),
+				Jt:   insn[2],
+				Jf:   insn[3],
+				K:    binary.LittleEndian.Uint32(insn[4:8]),
+			}
+		}
+		prog := syscall.SockFprog{
+			Len:    uint16(len(filter)),
+			Filter: &filter[0],
+		}
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL,
+			prSetSeccomp, seccompModeFilter,
+			uintptr(unsafe.Pointer(&prog))); errno != 0 {
+			return errno
+		}
+	}
+	return nil
+}
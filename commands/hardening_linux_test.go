@@ -0,0 +1,71 @@
+//go:build linux
+// +build linux
+
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joyent/containerpilot/events"
+	"github.com/stretchr/testify/assert"
+)
+
+// The hardening shim re-executes ContainerPilot, which is the test binary
+// when we're under test.
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == HardeningShimArg {
+		os.Exit(RunHardeningShim(os.Args[2:]))
+	}
+	os.Exit(m.Run())
+}
+
+// With no_new_privs set, the kernel ignores the setuid/setgid bits and
+// file capabilities of anything the process execs, so we check the flag
+// the kernel reports for the child rather than needing a setuid binary.
+func TestHardeningNoNewPrivs(t *testing.T) {
+	check := `grep -q "NoNewPrivs:[[:space:]]*1" /proc/self/status`
+
+	cmd, _ := NewCommand([]interface{}{"sh", "-c", check}, time.Duration(0), nil)
+	cmd.Name = t.Name()
+	cmd.Hardening, _ = NewHardening(true, "")
+	got := runtestCommandRun(cmd)
+	assert.Equal(t, 1, got[events.Event{events.ExitSuccess, t.Name()}],
+		"expected child to have no_new_privs set, got events %v", got)
+
+	// none of our own threads are left with the restrictions
+	statuses, _ := filepath.Glob("/proc/self/task/*/status")
+	for _, status := range statuses {
+		buf, err := ioutil.ReadFile(status)
+		if err != nil {
+			continue // the thread has exited
+		}
+		assert.False(t, strings.Contains(string(buf), "NoNewPrivs:\t1"),
+			"expected %s not to have no_new_privs set", status)
+	}
+}
+
+func TestHardeningMissingExec(t *testing.T) {
+	cmd, _ := NewCommand("./testdata/doesNotExist", time.Duration(0), nil)
+	cmd.Name = t.Name()
+	cmd.Hardening, _ = NewHardening(true, "")
+	got := runtestCommandRun(cmd)
+	assert.Equal(t, 1, got[events.Event{events.ExitFailed, t.Name()}])
+	assert.Equal(t, 127, cmd.ExitCode())
+}
+
+func TestHardeningBadProfile(t *testing.T) {
+	_, err := NewHardening(false, "./testdata/doesNotExist")
+	assert.Error(t, err)
+
+	f, _ := ioutil.TempFile("", "seccomp")
+	f.Write([]byte("not bpf"))
+	f.Close()
+	defer os.Remove(f.Name())
+	_, err = NewHardening(false, f.Name())
+	assert.Error(t, err)
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package commands
+
+import log "github.com/sirupsen/logrus"
+
+// apply is a no-op outside of Linux, where neither no_new_privs nor
+// seccomp are available.
+func (h *Hardening) apply() error {
+	log.Warn("no_new_privs and seccomp are only supported on Linux; " +
+		"running without process hardening")
+	return nil
+}
@@ -0,0 +1,30 @@
+package commands
+
+import "context"
+
+// Limit caps the number of Commands sharing it whose processes run at
+// once. A Command that's over the limit waits its turn before starting,
+// and its timeout doesn't start until it does.
+type Limit struct {
+	slots chan struct{}
+}
+
+// NewLimit creates a Limit allowing max Commands to run at once
+func NewLimit(max int) *Limit {
+	return &Limit{slots: make(chan struct{}, max)}
+}
+
+// acquire blocks until there's a free slot, returning false if the
+// context is cancelled first
+func (l *Limit) acquire(ctx context.Context) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (l *Limit) release() {
+	<-l.slots
+}
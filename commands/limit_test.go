@@ -0,0 +1,87 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joyent/containerpilot/events"
+)
+
+func TestCommandLimit(t *testing.T) {
+	limit := NewLimit(2)
+	bus := events.NewEventBus()
+	sub := &events.Subscriber{Rx: make(chan events.Event, 100)}
+	sub.Subscribe(bus)
+	defer sub.Unsubscribe()
+
+	cmds := []*Command{}
+	for i := 0; i < 8; i++ {
+		cmd, _ := NewCommand("sleep 0.1", time.Second, nil)
+		cmd.Name = fmt.Sprintf("%s.%d", t.Name(), i)
+		cmd.Limit = limit
+		cmds = append(cmds, cmd)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, cmd := range cmds {
+		cmd.Run(ctx, bus)
+	}
+
+	maxRunning, exits := 0, 0
+	deadline := time.After(2 * time.Second)
+	for exits < len(cmds) {
+		select {
+		case event := <-sub.Rx:
+			if event.Code == events.ExitSuccess {
+				exits++
+			}
+		case <-deadline:
+			t.Fatalf("expected all commands to exit but only %d did", exits)
+		case <-time.After(5 * time.Millisecond):
+			running := 0
+			for _, cmd := range cmds {
+				if cmd.Pid() != 0 {
+					running++
+				}
+			}
+			if running > maxRunning {
+				maxRunning = running
+			}
+		}
+	}
+	assert.Equal(t, 2, maxRunning, "expected no more than 2 commands at once")
+}
+
+func TestCommandLimitQueuedOnce(t *testing.T) {
+	limit := NewLimit(1)
+	blocker, _ := NewCommand("sleep 0.2", time.Second, nil)
+	blocker.Name = t.Name() + ".blocker"
+	blocker.Limit = limit
+	cmd, _ := NewCommand("true", time.Second, nil)
+	cmd.Name = t.Name()
+	cmd.Limit = limit
+
+	bus := events.NewEventBus()
+	sub := &events.Subscriber{Rx: make(chan events.Event, 100)}
+	sub.Subscribe(bus)
+	defer sub.Unsubscribe()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	blocker.Run(ctx, bus)
+	time.Sleep(50 * time.Millisecond)
+	cmd.Run(ctx, bus)
+	cmd.Run(ctx, bus) // dropped because the first run is still queued
+	time.Sleep(400 * time.Millisecond)
+
+	runs := 0
+	for len(sub.Rx) > 0 {
+		if <-sub.Rx == (events.Event{events.ExitSuccess, t.Name()}) {
+			runs++
+		}
+	}
+	assert.Equal(t, 1, runs)
+}
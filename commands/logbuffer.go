@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var droppedLines *prometheus.CounterVec
+
+func init() {
+	droppedLines = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "containerpilot_log_lines_dropped",
+		Help: "count of output lines dropped because the log sink was too slow, partitioned by command",
+	}, []string{"command"})
+	prometheus.MustRegister(droppedLines)
+}
+
+// LogBuffer configures a bounded buffer of output lines between a
+// Command's process and its log sink, so that a slow sink doesn't block
+// the process when the pipe between them fills up. When the buffer is
+// full we either block (the default) or drop the line.
+type LogBuffer struct {
+	Size int  // number of lines
+	Drop bool // drop lines rather than block when the buffer is full
+}
+
+// bufferedWriter is an io.Writer that splits its input into lines and
+// passes them to the sink from a separate goroutine
+type bufferedWriter struct {
+	name    string
+	drop    bool
+	lines   chan []byte
+	partial []byte
+	done    chan struct{}
+	lock    sync.Mutex
+}
+
+func newBufferedWriter(name string, cfg *LogBuffer, sink io.Writer) *bufferedWriter {
+	w := &bufferedWriter{
+		name:  name,
+		drop:  cfg.Drop,
+		lines: make(chan []byte, cfg.Size),
+		done:  make(chan struct{}),
+	}
+	go func() {
+		defer close(w.done)
+		for line := range w.lines {
+			sink.Write(line)
+		}
+	}()
+	return w
+}
+
+// Write implements io.Writer. It only blocks if the buffer is full and
+// we're not dropping lines.
+func (w *bufferedWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	buf := append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.send(buf[:i+1])
+		buf = buf[i+1:]
+	}
+	w.partial = append([]byte{}, buf...)
+	return len(p), nil
+}
+
+func (w *bufferedWriter) send(line []byte) {
+	line = append([]byte{}, line...)
+	if !w.drop {
+		w.lines <- line
+		return
+	}
+	select {
+	case w.lines <- line:
+	default:
+		droppedLines.WithLabelValues(w.name).Inc()
+	}
+}
+
+// Close flushes any partial line and stops the goroutine writing to the
+// sink once it has written everything buffered. It doesn't wait for that.
+func (w *bufferedWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if len(w.partial) > 0 {
+		w.send(append(w.partial, '\n'))
+		w.partial = nil
+	}
+	close(w.lines)
+	return nil
+}
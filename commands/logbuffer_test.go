@@ -0,0 +1,59 @@
+package commands
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joyent/containerpilot/events"
+)
+
+// slowWriter is a log sink that takes a while to write each line
+type slowWriter struct {
+	delay time.Duration
+	lines int
+	lock  sync.Mutex
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(w.delay)
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.lines++
+	return len(p), nil
+}
+
+func TestLogBufferDropsWhenFull(t *testing.T) {
+	sink := &slowWriter{delay: 50 * time.Millisecond}
+	cmd, _ := NewCommand("seq 1 1000", time.Duration(0), nil)
+	cmd.Name = t.Name()
+	cmd.Output = sink
+	cmd.LogBuffer = &LogBuffer{Size: 10, Drop: true}
+
+	// blocking on the sink would take 50s
+	exit, ok := runtestCommandUntilExit(cmd, time.Second)
+	if !ok {
+		t.Fatal("expected process not to be blocked by log sink")
+	}
+	assert.Equal(t, events.Event{events.ExitSuccess, t.Name()}, exit)
+
+	metric := &dto.Metric{}
+	droppedLines.WithLabelValues(t.Name()).Write(metric)
+	dropped := int(metric.GetCounter().GetValue())
+	assert.True(t, dropped > 0, "expected dropped lines to be counted")
+	assert.True(t, dropped < 1000, "expected buffered lines to be kept")
+}
+
+func TestLogBufferBlocksWhenFull(t *testing.T) {
+	var sink bytes.Buffer
+	w := newBufferedWriter(t.Name(), &LogBuffer{Size: 1}, &sink)
+	w.Write([]byte("one\ntw"))
+	w.Write([]byte("o\nthree"))
+	w.Close()
+	<-w.done
+	assert.Equal(t, "one\ntwo\nthree\n", sink.String())
+}
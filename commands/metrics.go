@@ -0,0 +1,75 @@
+package commands
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// the per-command metrics are only collected once EnableMetrics is
+// called, because their labels depend on the configuration
+var (
+	metricsLock     sync.RWMutex
+	metricEnvLabels []string
+	commandRuns     *prometheus.CounterVec
+	commandDuration *prometheus.HistogramVec
+)
+
+// EnableMetrics registers the metrics recorded for each run of a
+// Command. They're labeled with the command name and its exit status,
+// and with the value of each of the allowlisted environment variables in
+// the command's environment; the label is the variable name in lower
+// case. Calling EnableMetrics again replaces the metrics, as on a reload.
+func EnableMetrics(envLabels []string) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	if commandRuns != nil {
+		prometheus.Unregister(commandRuns)
+		prometheus.Unregister(commandDuration)
+	}
+	labels := []string{"command", "status"}
+	for _, name := range envLabels {
+		labels = append(labels, strings.ToLower(name))
+	}
+	commandRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "containerpilot_command_runs",
+		Help: "count of command runs, partitioned by command and exit status",
+	}, labels)
+	commandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "containerpilot_command_duration_seconds",
+		Help: "duration of command runs, partitioned by command and exit status",
+	}, labels)
+	prometheus.MustRegister(commandRuns)
+	prometheus.MustRegister(commandDuration)
+	metricEnvLabels = envLabels
+}
+
+// recordRun records a run of the Command in the per-command metrics, if
+// they've been enabled
+func (c *Command) recordRun(status string, duration time.Duration) {
+	metricsLock.RLock()
+	defer metricsLock.RUnlock()
+	if commandRuns == nil {
+		return
+	}
+	values := []string{c.Name, status}
+	for _, name := range metricEnvLabels {
+		values = append(values, c.getenv(name))
+	}
+	commandRuns.WithLabelValues(values...).Inc()
+	commandDuration.WithLabelValues(values...).Observe(duration.Seconds())
+}
+
+// getenv returns the value of the environment variable as the process
+// sees it, where the Command's Env overrides our own environment
+func (c *Command) getenv(name string) string {
+	for i := len(c.Env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(c.Env[i], name+"=") {
+			return strings.TrimPrefix(c.Env[i], name+"=")
+		}
+	}
+	return os.Getenv(name)
+}
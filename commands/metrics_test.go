@@ -0,0 +1,37 @@
+package commands
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandMetricsEnvLabels(t *testing.T) {
+	EnableMetrics([]string{"TEST_DEPLOY_ENV", "TEST_REGION"})
+	os.Setenv("TEST_DEPLOY_ENV", "prod")
+	defer os.Unsetenv("TEST_DEPLOY_ENV")
+
+	cmd, _ := NewCommand("true", time.Duration(0), nil)
+	cmd.Name = t.Name()
+	cmd.Env = []string{"TEST_REGION=us-east-1"}
+	if _, ok := runtestCommandUntilExit(cmd, time.Second); !ok {
+		t.Fatal("expected command to exit")
+	}
+
+	metric := &dto.Metric{}
+	commandRuns.WithLabelValues(t.Name(), "success", "prod", "us-east-1").Write(metric)
+	assert.Equal(t, 1.0, metric.GetCounter().GetValue())
+	labels := map[string]string{}
+	for _, label := range metric.GetLabel() {
+		labels[label.GetName()] = label.GetValue()
+	}
+	assert.Equal(t, map[string]string{
+		"command":         t.Name(),
+		"status":          "success",
+		"test_deploy_env": "prod",
+		"test_region":     "us-east-1",
+	}, labels)
+}
@@ -0,0 +1,51 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// defaultNamespaceWrapper is the command we use to enter namespaces. We
+// can't enter a mount namespace from a multithreaded process like
+// ContainerPilot, so we have nsenter(1) do it after we fork.
+const defaultNamespaceWrapper = "nsenter"
+
+// Namespaces configures a Command to run inside the namespaces of another
+// Command's process, so that it sees the same mounts (for example) as that
+// process rather than ContainerPilot's.
+type Namespaces struct {
+	Target  *Command // the Command whose process' namespaces we enter
+	Types   []string // long flags for nsenter without the '--' (ex. "mount")
+	Wrapper string   // defaults to nsenter
+}
+
+// ValidNamespaceTypes are the namespaces that can be entered
+var ValidNamespaceTypes = map[string]bool{
+	"mount":  true,
+	"uts":    true,
+	"ipc":    true,
+	"net":    true,
+	"pid":    true,
+	"user":   true,
+	"cgroup": true,
+}
+
+// wrap returns the executable and arguments that run exec and args in the
+// namespaces of the target process, or an error if it's not running
+func (ns *Namespaces) wrap(exec string, args []string) (string, []string, error) {
+	pid := ns.Target.Pid()
+	if pid == 0 {
+		return "", nil, fmt.Errorf("%s is not running", ns.Target.Name)
+	}
+	wrapper := ns.Wrapper
+	if wrapper == "" {
+		wrapper = defaultNamespaceWrapper
+	}
+	wrapped := []string{"--target", strconv.Itoa(pid)}
+	for _, nsType := range ns.Types {
+		wrapped = append(wrapped, "--"+nsType)
+	}
+	wrapped = append(wrapped, "--", exec)
+	wrapped = append(wrapped, args...)
+	return wrapper, wrapped, nil
+}
@@ -0,0 +1,74 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joyent/containerpilot/events"
+)
+
+func TestNamespacesTargetNotRunning(t *testing.T) {
+	target, _ := NewCommand("sleep 5", time.Duration(0), nil)
+	target.Name = "myjob"
+	cmd, _ := NewCommand("true", time.Duration(0), nil)
+	cmd.Name = t.Name()
+	cmd.Namespaces = &Namespaces{Target: target, Types: []string{"mount"}}
+	exit, ok := runtestCommandUntilExit(cmd, time.Second)
+	if !ok {
+		t.Fatal("expected command to fail promptly")
+	}
+	assert.Equal(t, events.Event{events.ExitFailed, t.Name()}, exit)
+}
+
+func TestNamespacesWrapper(t *testing.T) {
+	dir, _ := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "args")
+
+	target, _ := NewCommand("sleep 5", time.Duration(0), nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	target.Run(ctx, events.NewEventBus())
+	time.Sleep(100 * time.Millisecond)
+	pid := target.Pid()
+	if pid == 0 {
+		t.Fatal("expected target to be running")
+	}
+
+	namespaces := &Namespaces{
+		Target:  target,
+		Types:   []string{"mount", "pid"},
+		Wrapper: "./testdata/nswrap.sh",
+	}
+	cmd, _ := NewCommand([]interface{}{"sh", "-c", "exit 0"}, time.Second, nil)
+	cmd.Name = t.Name()
+	cmd.Env = []string{"NSWRAP_OUT=" + out}
+	cmd.Namespaces = namespaces
+	exit, ok := runtestCommandUntilExit(cmd, time.Second)
+	if !ok {
+		t.Fatal("expected wrapped command to exit")
+	}
+	assert.Equal(t, events.Event{events.ExitSuccess, t.Name()}, exit)
+	args, _ := ioutil.ReadFile(out)
+	assert.Equal(t,
+		fmt.Sprintf("--target %d --mount --pid -- sh -c exit 0\n", pid),
+		string(args))
+
+	// the timeout applies to the wrapped command, not just the wrapper
+	cmd, _ = NewCommand("sleep 5", 100*time.Millisecond, nil)
+	cmd.Name = t.Name() + "Timeout"
+	cmd.Env = []string{"NSWRAP_OUT=" + out}
+	cmd.Namespaces = namespaces
+	exit, ok = runtestCommandUntilExit(cmd, time.Second)
+	if !ok {
+		t.Fatal("expected wrapped command to be killed by its timeout")
+	}
+	assert.Equal(t, events.Event{events.ExitFailed, t.Name() + "Timeout"}, exit)
+}
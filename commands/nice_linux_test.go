@@ -0,0 +1,49 @@
+//go:build linux
+// +build linux
+
+package commands
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNice(t *testing.T) {
+	cmd, _ := NewCommand("sleep 2", time.Duration(0), nil)
+	cmd.Name = t.Name()
+	cmd.Nice = 10
+	go runtestCommandUntilExit(cmd, 5*time.Second)
+
+	var nice int
+	var err error
+	for i := 0; i < 20; i++ {
+		time.Sleep(50 * time.Millisecond)
+		if pid := cmd.Pid(); pid != 0 {
+			if nice, err = procNice(pid); err == nil && nice != 0 {
+				break
+			}
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 10, nice)
+}
+
+// procNice reads the nice value of a process from /proc/<pid>/stat
+func procNice(pid int) (int, error) {
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	// the command name in field 2 may contain spaces, so we count
+	// fields from the end of it. nice is field 19.
+	fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+	return strconv.Atoi(fields[16])
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package commands
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// setNice is a no-op on platforms without setpriority(2).
+func setNice(pid, nice int) error {
+	log.Warn("nice is not supported on this platform; running at normal priority")
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package commands
+
+import "syscall"
+
+// setNice sets the scheduling priority of the process with the given
+// pid. Processes it forks afterwards inherit the priority.
+func setNice(pid, nice int) error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, pid, nice)
+}
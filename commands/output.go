@@ -0,0 +1,209 @@
+package commands
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// rotatedTimeFormat is used to suffix rotated segments so that they sort
+// from oldest to newest
+const rotatedTimeFormat = "20060102T150405.000000000"
+
+// OutputFile is an io.Writer for a Command's stdout/stderr that appends to
+// a file on disk. If MaxSize or MaxAge are set the file is rotated once
+// it exceeds either of them, and the rotated segment is optionally
+// compressed. Only the Keep most recent segments are retained.
+// Compression and removal of old segments happen in the background so
+// that they don't hold up the command's writes.
+type OutputFile struct {
+	Path     string
+	MaxSize  int64         // bytes; zero for no size-based rotation
+	MaxAge   time.Duration // zero for no age-based rotation
+	Compress bool
+	Keep     int // zero to retain all segments
+
+	file   *os.File
+	size   int64
+	opened time.Time
+	lock   sync.Mutex
+
+	segmentLock sync.Mutex     // serializes work on rotated segments
+	pending     sync.WaitGroup // rotated segments not yet processed
+}
+
+// Write implements io.Writer. The file is opened on the first write so
+// that we don't hold open files for commands that never run.
+func (out *OutputFile) Write(p []byte) (int, error) {
+	out.lock.Lock()
+	defer out.lock.Unlock()
+	if out.file == nil {
+		if err := out.open(); err != nil {
+			return 0, err
+		}
+	}
+	if out.needsRotate(int64(len(p))) {
+		if err := out.rotate(); err != nil {
+			// keep writing to the current file rather than losing output
+			log.Errorf("unable to rotate %s: %v", out.Path, err)
+		}
+	}
+	n, err := out.file.Write(p)
+	out.size += int64(n)
+	return n, err
+}
+
+// Close closes the underlying file, if it's open, and waits for any
+// rotated segments to finish being compressed and pruned
+func (out *OutputFile) Close() error {
+	defer out.pending.Wait()
+	out.lock.Lock()
+	defer out.lock.Unlock()
+	if out.file == nil {
+		return nil
+	}
+	err := out.file.Close()
+	out.file = nil
+	return err
+}
+
+func (out *OutputFile) open() error {
+	f, err := os.OpenFile(out.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	out.file = f
+	out.size = info.Size()
+	out.opened = time.Now()
+	return nil
+}
+
+func (out *OutputFile) needsRotate(n int64) bool {
+	if out.size == 0 {
+		return false // never rotate an empty file
+	}
+	if out.MaxSize > 0 && out.size+n > out.MaxSize {
+		return true
+	}
+	if out.MaxAge > 0 && time.Since(out.opened) > out.MaxAge {
+		return true
+	}
+	return false
+}
+
+// rotate moves the active file aside and opens a new active file. The
+// rotated segment is compressed, if configured, and any segments beyond
+// the retention count are removed in the background.
+func (out *OutputFile) rotate() error {
+	if err := out.file.Close(); err != nil {
+		return err
+	}
+	out.file = nil
+	segment := out.Path + "." + time.Now().UTC().Format(rotatedTimeFormat)
+	if err := os.Rename(out.Path, segment); err != nil {
+		return out.open()
+	}
+	out.pending.Add(1)
+	go out.processSegment(segment)
+	return out.open()
+}
+
+func (out *OutputFile) processSegment(segment string) {
+	defer out.pending.Done()
+	out.segmentLock.Lock()
+	defer out.segmentLock.Unlock()
+	if out.Compress {
+		if err := compressFile(segment); err != nil {
+			log.Errorf("unable to compress %s: %v", segment, err)
+		}
+	}
+	out.removeOldSegments()
+}
+
+func (out *OutputFile) removeOldSegments() {
+	if out.Keep < 1 {
+		return
+	}
+	segments, err := out.segments()
+	if err != nil {
+		log.Errorf("unable to list segments of %s: %v", out.Path, err)
+		return
+	}
+	for len(segments) > out.Keep {
+		if err := os.Remove(segments[0]); err != nil {
+			log.Errorf("unable to remove %s: %v", segments[0], err)
+		}
+		segments = segments[1:]
+	}
+}
+
+// segments returns the rotated segments of the file from oldest to
+// newest. Only files named for the rotation timestamp are included so
+// that we never touch other files that happen to share the prefix.
+func (out *OutputFile) segments() ([]string, error) {
+	dir, base := filepath.Split(out.Path)
+	if dir == "" {
+		dir = "."
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	segments := []string{}
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasPrefix(name, base+".") {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, base+"."), ".gz")
+		if _, err := time.Parse(rotatedTimeFormat, stamp); err != nil {
+			continue
+		}
+		segments = append(segments, filepath.Join(dir, name))
+	}
+	sort.Strings(segments)
+	return segments, nil
+}
+
+// compressFile replaces the file at path with a gzipped copy at path.gz
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		gz.Close()
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,70 @@
+package commands
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputFileRotate(t *testing.T) {
+	dir, _ := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out.log")
+
+	out := &OutputFile{Path: path, MaxSize: 10, Compress: true}
+	out.Write([]byte("0123456789"))
+	out.Write([]byte("abc")) // exceeds MaxSize
+	out.Close()
+
+	active, _ := ioutil.ReadFile(path)
+	assert.Equal(t, "abc", string(active), "expected active file to be rolled")
+
+	segments, _ := filepath.Glob(path + ".*.gz")
+	if len(segments) != 1 {
+		t.Fatalf("expected 1 compressed segment, got %v", segments)
+	}
+	f, _ := os.Open(segments[0])
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("expected gzipped segment: %v", err)
+	}
+	rotated, _ := ioutil.ReadAll(gz)
+	assert.Equal(t, "0123456789", string(rotated))
+}
+
+func TestOutputFileKeep(t *testing.T) {
+	dir, _ := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out.log")
+
+	// files that only share the prefix aren't segments
+	for _, other := range []string{path + ".bak", path + ".1.gz"} {
+		ioutil.WriteFile(other, []byte("other"), 0644)
+		defer func(other string) {
+			_, err := os.Stat(other)
+			assert.NoError(t, err, "expected %s to be kept", other)
+		}(other)
+	}
+
+	out := &OutputFile{Path: path, MaxSize: 1, Keep: 2}
+	for _, line := range []string{"a", "b", "c", "d"} {
+		out.Write([]byte(line))
+	}
+	out.Close()
+
+	segments, _ := out.segments()
+	assert.Equal(t, 2, len(segments), "expected only 2 segments retained")
+	if len(segments) == 2 {
+		oldest, _ := ioutil.ReadFile(segments[0])
+		newest, _ := ioutil.ReadFile(segments[1])
+		assert.Equal(t, "b", string(oldest))
+		assert.Equal(t, "c", string(newest))
+	}
+	active, _ := ioutil.ReadFile(path)
+	assert.Equal(t, "d", string(active))
+}
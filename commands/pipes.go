@@ -0,0 +1,112 @@
+package commands
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// how long we wait for a killed process' output to finish being copied
+// before we close its pipes, and again for the copying to stop after
+const outputDrainTimeout = time.Second
+
+// outputPipes copies a process' stdout and stderr to writers through
+// pipes that we own, rather than letting exec.Cmd do it. Cmd.Wait doesn't
+// return until its copying is done, so a writer that blocks (or a child
+// that escaped the kill and holds the pipe open) would keep a killed
+// process from being reaped and our caller from ever returning.
+type outputPipes struct {
+	readers []*os.File
+	writers []*os.File
+	done    chan struct{}
+}
+
+// attachOutput connects the Cmd's stdout and stderr to the writers. The
+// two share a pipe if they're the same writer, as they do in exec.Cmd, so
+// that the writer isn't written to concurrently.
+func attachOutput(cmd *exec.Cmd, stdout, stderr io.Writer) (*outputPipes, error) {
+	p := &outputPipes{}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	p.readers, p.writers = append(p.readers, outR), append(p.writers, outW)
+	cmd.Stdout, cmd.Stderr = outW, outW
+	if !sameWriter(stdout, stderr) {
+		errR, errW, err := os.Pipe()
+		if err != nil {
+			p.close()
+			return nil, err
+		}
+		p.readers, p.writers = append(p.readers, errR), append(p.writers, errW)
+		cmd.Stderr = errW
+	}
+	dests := []io.Writer{stdout, stderr}
+	copied := make(chan struct{}, len(p.readers))
+	for i, r := range p.readers {
+		go func(dest io.Writer, r *os.File) {
+			io.Copy(dest, r)
+			copied <- struct{}{}
+		}(dests[i], r)
+	}
+	p.done = make(chan struct{})
+	go func(n int) {
+		for i := 0; i < n; i++ {
+			<-copied
+		}
+		close(p.done)
+	}(len(p.readers))
+	return p, nil
+}
+
+// sameWriter compares the writers without panicking on types that can't
+// be compared
+func sameWriter(a, b io.Writer) (same bool) {
+	defer func() {
+		if recover() != nil {
+			same = false
+		}
+	}()
+	return a == b
+}
+
+// started closes our copies of the write ends once the process has them,
+// so that we see EOF when it exits
+func (p *outputPipes) started() {
+	for _, w := range p.writers {
+		w.Close()
+	}
+}
+
+// close closes both ends of the pipes
+func (p *outputPipes) close() {
+	p.started()
+	for _, r := range p.readers {
+		r.Close()
+	}
+}
+
+// wait waits for the output to be copied. Once the context is done it
+// waits at most outputDrainTimeout longer, and then closes the pipes and
+// gives up on whatever output is left.
+func (p *outputPipes) wait(ctx context.Context) {
+	select {
+	case <-p.done:
+		return
+	case <-ctx.Done():
+	}
+	select {
+	case <-p.done:
+		return
+	case <-time.After(outputDrainTimeout):
+	}
+	p.close()
+	// a copy that's blocked writing can't be interrupted, so we leave it
+	// behind rather than wait on it forever
+	select {
+	case <-p.done:
+	case <-time.After(outputDrainTimeout):
+	}
+}
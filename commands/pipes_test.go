@@ -0,0 +1,50 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockedWriter never returns from Write until it's released, like a
+// reader that's stopped draining a full pipe
+type blockedWriter struct {
+	release chan struct{}
+}
+
+func (w *blockedWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
+
+func TestRunAndWaitTimeoutWithBlockedOutput(t *testing.T) {
+	cmd, _ := NewCommand([]string{"sh", "-c",
+		"head -c 1000000 /dev/zero; sleep 10"}, 100*time.Millisecond, nil)
+	out := &blockedWriter{release: make(chan struct{})}
+	defer close(out.release)
+
+	result := make(chan error, 1)
+	go func() {
+		result <- cmd.runAndWait(context.Background(), out, out, nil)
+	}()
+	select {
+	case err := <-result:
+		assert.EqualError(t, err, "timeout after 100ms")
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the timed out command to return")
+	}
+}
+
+func TestRunAndCaptureOutput(t *testing.T) {
+	cmd, _ := NewCommand([]string{"sh", "-c",
+		"echo out; echo err >&2"}, time.Second, nil)
+	stderr := &bytes.Buffer{}
+	cmd.Output = stderr
+	out, err := cmd.RunAndCapture(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "out\n", string(out))
+	assert.Equal(t, "err\n", stderr.String())
+}
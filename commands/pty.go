@@ -0,0 +1,76 @@
+package commands
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// ttyDrainTimeout is how long we keep reading a process' terminal after
+// it exits, in case children it left behind still hold the terminal open
+const ttyDrainTimeout = time.Second
+
+// ttyOutput copies the output of a process attached to a pseudo-terminal
+// to the writer that would have received its stdout
+type ttyOutput struct {
+	master *os.File
+	slave  *os.File
+	out    io.Writer
+	copied chan struct{}
+}
+
+// attachTTY attaches the process to a new pseudo-terminal as its stdin,
+// stdout, and stderr, and in a new session so that the terminal becomes
+// its controlling terminal. The process' combined output is written to
+// the writer that was its stdout. cmd.SysProcAttr must already be set.
+func attachTTY(cmd *exec.Cmd) (*ttyOutput, error) {
+	master, slave, err := openPTY()
+	if err != nil {
+		return nil, err
+	}
+	tty := &ttyOutput{
+		master: master,
+		slave:  slave,
+		out:    cmd.Stdout,
+		copied: make(chan struct{}),
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	// a new session is also a new process group, so we can still signal
+	// the process and all its children at once
+	cmd.SysProcAttr.Setpgid = false
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0 // the child's stdin
+	return tty, nil
+}
+
+// started closes our copy of the terminal that the process now holds, and
+// starts copying its output
+func (tty *ttyOutput) started() {
+	tty.slave.Close()
+	go func() {
+		defer close(tty.copied)
+		// reading returns EIO once every holder of the terminal has
+		// closed it, which is how we know the output is finished
+		io.Copy(tty.out, tty.master)
+	}()
+}
+
+// close waits for the process' output to be copied, and closes the
+// terminal. If the process was never started, this only closes it.
+// Closing the master doesn't interrupt a read that's in progress, so if
+// children the process left behind still hold the terminal, we stop
+// waiting but their output is still copied until they close it.
+func (tty *ttyOutput) close(started bool) {
+	if !started {
+		tty.slave.Close()
+		tty.master.Close()
+		return
+	}
+	select {
+	case <-tty.copied:
+	case <-time.After(ttyDrainTimeout):
+	}
+	tty.master.Close()
+}
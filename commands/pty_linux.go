@@ -0,0 +1,64 @@
+//go:build linux
+// +build linux
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// openPTY opens a new pseudo-terminal pair. Output processing that turns
+// "\n" into "\r\n" is turned off so that the output logs cleanly.
+func openPTY() (master, slave *os.File, err error) {
+	// the master stays in blocking mode: a file made from an fd isn't
+	// added to the runtime's poller on every version of Go we support,
+	// so reading it in non-blocking mode could fail with EAGAIN
+	fd, err := syscall.Open("/dev/ptmx",
+		syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var unlock int32
+	if err = ioctl(uintptr(fd), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		syscall.Close(fd)
+		return nil, nil, fmt.Errorf("unable to unlock pty: %v", err)
+	}
+	var n uint32
+	if err = ioctl(uintptr(fd), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); err != nil {
+		syscall.Close(fd)
+		return nil, nil, fmt.Errorf("unable to get pty number: %v", err)
+	}
+	master = os.NewFile(uintptr(fd), "/dev/ptmx")
+	defer func() {
+		if err != nil {
+			master.Close()
+		}
+	}()
+
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n),
+		os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var termios syscall.Termios
+	if err = ioctl(slave.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&termios))); err == nil {
+		termios.Oflag &^= syscall.ONLCR
+		err = ioctl(slave.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&termios)))
+	}
+	if err != nil {
+		slave.Close()
+		return nil, nil, fmt.Errorf("unable to configure pty: %v", err)
+	}
+	return master, slave, nil
+}
+
+func ioctl(fd, req, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
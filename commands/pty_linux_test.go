@@ -0,0 +1,56 @@
+//go:build linux
+// +build linux
+
+package commands
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/joyent/containerpilot/events"
+	"github.com/stretchr/testify/assert"
+)
+
+// A Command with TTY set sees a terminal on its stdin and stdout, and its
+// output still reaches the Command's Output
+func TestCommandTTY(t *testing.T) {
+	runTTYCheck := func(tty bool) string {
+		cmd, _ := NewCommand([]interface{}{"sh", "-c",
+			"if [ -t 0 ] && [ -t 1 ]; then echo tty; else echo notty; fi"},
+			5*time.Second, nil)
+		cmd.Name = t.Name()
+		cmd.TTY = tty
+		var out bytes.Buffer
+		cmd.Output = &out
+		exit, ok := runtestCommandUntilExit(cmd, 5*time.Second)
+		if !ok {
+			t.Fatal("expected command to exit")
+		}
+		assert.Equal(t, events.Event{events.ExitSuccess, t.Name()}, exit)
+		return out.String()
+	}
+	assert.Equal(t, "notty\n", runTTYCheck(false))
+	assert.Equal(t, "tty\n", runTTYCheck(true))
+}
+
+// A child left holding the terminal doesn't hold up the exit, and the
+// output written before the process exited isn't lost
+func TestCommandTTYChildHoldsTerminal(t *testing.T) {
+	cmd, _ := NewCommand([]interface{}{"sh", "-c",
+		"trap '' HUP; sleep 3 & echo first"},
+		5*time.Second, nil)
+	cmd.Name = t.Name()
+	cmd.TTY = true
+	out := &lockedBuffer{}
+	cmd.Output = out
+	start := time.Now()
+	exit, ok := runtestCommandUntilExit(cmd, 5*time.Second)
+	if !ok {
+		t.Fatal("expected command to exit")
+	}
+	assert.Equal(t, events.Event{events.ExitSuccess, t.Name()}, exit)
+	assert.True(t, time.Since(start) < 3*time.Second,
+		"expected exit not to wait for the child holding the terminal")
+	assert.Equal(t, "first\n", out.String())
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package commands
+
+import (
+	"errors"
+	"os"
+)
+
+// openPTY isn't supported outside of Linux
+func openPTY() (master, slave *os.File, err error) {
+	return nil, nil, errors.New("tty is only supported on Linux")
+}
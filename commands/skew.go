@@ -0,0 +1,49 @@
+package commands
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// skewTolerance is how far the measured time before a timeout fires can
+// be from the timeout itself before we report it. Go's timers use the
+// monotonic clock, so anything this far off means the process wasn't
+// scheduled (e.g. it was heavily CPU throttled) or the clock misbehaved.
+const skewTolerance = time.Second
+
+var clockSkew *prometheus.CounterVec
+
+func init() {
+	clockSkew = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "containerpilot_clock_skew",
+		Help: "count of timeouts that fired well before or after they were due, partitioned by command",
+	}, []string{"command"})
+	prometheus.MustRegister(clockSkew)
+}
+
+// now returns the current time from the Command's clock
+func (c *Command) now() time.Time {
+	if c.clock != nil {
+		return c.clock()
+	}
+	return time.Now()
+}
+
+// checkClockSkew compares the time elapsed since the timer started
+// against the timeout that just fired, and warns if they're far enough
+// apart that the kill is more likely due to the clock than to the
+// command hanging. It returns the skew it found, or zero if it's within
+// tolerance.
+func checkClockSkew(name string, elapsed, timeout time.Duration) time.Duration {
+	skew := elapsed - timeout
+	if skew > -skewTolerance && skew < skewTolerance {
+		return 0
+	}
+	log.Warnf("%s timeout of %s fired after %s: clock skew of %s, "+
+		"the process may have been throttled rather than hung",
+		name, timeout, elapsed, skew)
+	clockSkew.WithLabelValues(name).Inc()
+	return skew
+}
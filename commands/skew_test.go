@@ -0,0 +1,65 @@
+package commands
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckClockSkew(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	skewCount := func() float64 {
+		metric := &dto.Metric{}
+		clockSkew.WithLabelValues(t.Name()).Write(metric)
+		return metric.GetCounter().GetValue()
+	}
+	before := skewCount()
+
+	elapsed := 10*time.Second + 100*time.Millisecond
+	assert.Equal(t, time.Duration(0), checkClockSkew(t.Name(), elapsed, 10*time.Second))
+	assert.Equal(t, "", logged.String(), "expected no warning within tolerance")
+
+	elapsed = 25 * time.Second
+	assert.Equal(t, 15*time.Second, checkClockSkew(t.Name(), elapsed, 10*time.Second))
+	assert.Contains(t, logged.String(), "clock skew of 15s")
+
+	logged.Reset()
+	elapsed = 2 * time.Second
+	assert.Equal(t, -8*time.Second, checkClockSkew(t.Name(), elapsed, 10*time.Second))
+	assert.Contains(t, logged.String(), "clock skew of -8s")
+
+	assert.Equal(t, float64(2), skewCount()-before)
+}
+
+func TestCommandRunClockSkew(t *testing.T) {
+	metric := &dto.Metric{}
+	clockSkew.WithLabelValues(t.Name()).Write(metric)
+	before := metric.GetCounter().GetValue()
+
+	cmd, _ := NewCommand("sleep 2", 100*time.Millisecond, nil)
+	cmd.Name = t.Name()
+	// every reading of the clock is 30s after the last, as if the
+	// process had been paused between starting the timer and it firing
+	var clock time.Time
+	var clockLock sync.Mutex
+	cmd.clock = func() time.Time {
+		clockLock.Lock()
+		defer clockLock.Unlock()
+		clock = clock.Add(30 * time.Second)
+		return clock
+	}
+	if _, ok := runtestCommandUntilExit(cmd, time.Second); !ok {
+		t.Fatal("expected command to time out")
+	}
+	clockSkew.WithLabelValues(t.Name()).Write(metric)
+	assert.Equal(t, float64(1), metric.GetCounter().GetValue()-before)
+}
@@ -0,0 +1,69 @@
+package commands
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	successStreak *prometheus.GaugeVec
+	failureStreak *prometheus.GaugeVec
+
+	streaks     = map[string]*Streak{}
+	streaksLock sync.RWMutex
+)
+
+func init() {
+	successStreak = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "containerpilot_command_success_streak",
+		Help: "gauge of consecutive successful runs of each command, partitioned by command",
+	}, []string{"command"})
+	failureStreak = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "containerpilot_command_failure_streak",
+		Help: "gauge of consecutive failed runs of each command, partitioned by command",
+	}, []string{"command"})
+	prometheus.MustRegister(successStreak)
+	prometheus.MustRegister(failureStreak)
+}
+
+// Streak is the number of consecutive runs of a command that succeeded
+// or failed, up to and including its last run. At most one of them is
+// non-zero.
+type Streak struct {
+	Successes int `json:"successes"`
+	Failures  int `json:"failures"`
+}
+
+// Streaks returns the current streak of each command that has run, by
+// the command's name
+func Streaks() map[string]Streak {
+	streaksLock.RLock()
+	defer streaksLock.RUnlock()
+	result := make(map[string]Streak, len(streaks))
+	for name, streak := range streaks {
+		result[name] = *streak
+	}
+	return result
+}
+
+// recordStreak extends the streak of the Command if the run had the same
+// outcome as the last, or starts a new one
+func (c *Command) recordStreak(success bool) {
+	streaksLock.Lock()
+	defer streaksLock.Unlock()
+	streak, ok := streaks[c.Name]
+	if !ok {
+		streak = &Streak{}
+		streaks[c.Name] = streak
+	}
+	if success {
+		streak.Successes++
+		streak.Failures = 0
+	} else {
+		streak.Failures++
+		streak.Successes = 0
+	}
+	successStreak.WithLabelValues(c.Name).Set(float64(streak.Successes))
+	failureStreak.WithLabelValues(c.Name).Set(float64(streak.Failures))
+}
@@ -0,0 +1,40 @@
+package commands
+
+import (
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandStreaks(t *testing.T) {
+	gauges := func() (float64, float64) {
+		success, failure := &dto.Metric{}, &dto.Metric{}
+		successStreak.WithLabelValues(t.Name()).Write(success)
+		failureStreak.WithLabelValues(t.Name()).Write(failure)
+		return success.GetGauge().GetValue(), failure.GetGauge().GetValue()
+	}
+	expected := []struct {
+		exec              string
+		successes, failed int
+	}{
+		{"true", 1, 0},
+		{"true", 2, 0},
+		{"false", 0, 1},
+		{"false", 0, 2},
+		{"true", 1, 0},
+	}
+	for i, run := range expected {
+		cmd, _ := NewCommand(run.exec, time.Duration(0), nil)
+		cmd.Name = t.Name()
+		if _, ok := runtestCommandUntilExit(cmd, time.Second); !ok {
+			t.Fatal("expected command to exit")
+		}
+		successes, failures := gauges()
+		assert.Equal(t, float64(run.successes), successes, "success streak after run %d", i)
+		assert.Equal(t, float64(run.failed), failures, "failure streak after run %d", i)
+		assert.Equal(t, Streak{Successes: run.successes, Failures: run.failed},
+			Streaks()[t.Name()], "streak after run %d", i)
+	}
+}
@@ -0,0 +1,91 @@
+package commands
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+)
+
+// maxMatchLine is the longest partial line we hold on to while waiting
+// for its end; anything longer is matched as-is and dropped
+const maxMatchLine = 64 * 1024
+
+// Success classifies some of a Command's failed exits as successful, for
+// tools that exit non-zero for expected reasons (ex. diff exits 1 when
+// the files differ). Exits caused by a timeout or by cancellation are
+// never treated as successful.
+type Success struct {
+	Codes  []int          // exit codes that count as success
+	Output *regexp.Regexp // a line of stdout or stderr matching this counts as success
+}
+
+// accepts returns true if a process that exited with this code should be
+// treated as successful
+func (s *Success) accepts(code int, matchers []*lineMatcher) bool {
+	if s == nil {
+		return false
+	}
+	for _, successCode := range s.Codes {
+		if code == successCode {
+			return true
+		}
+	}
+	for _, m := range matchers {
+		if m.isMatched() {
+			return true
+		}
+	}
+	return false
+}
+
+// wrap tees the stdout and stderr writers through line matchers for the
+// Output pattern, if there is one
+func (s *Success) wrap(stdout, stderr io.Writer) (io.Writer, io.Writer, []*lineMatcher) {
+	if s == nil || s.Output == nil {
+		return stdout, stderr, nil
+	}
+	outMatch := &lineMatcher{re: s.Output}
+	errMatch := &lineMatcher{re: s.Output}
+	return io.MultiWriter(outMatch, stdout), io.MultiWriter(errMatch, stderr),
+		[]*lineMatcher{outMatch, errMatch}
+}
+
+// lineMatcher is an io.Writer that records whether any line written to it
+// matches a pattern. It's only read after the process' output has been
+// copied, so it needs no locking.
+type lineMatcher struct {
+	re      *regexp.Regexp
+	partial []byte
+	matched bool
+}
+
+func (m *lineMatcher) Write(p []byte) (int, error) {
+	if m.matched {
+		return len(p), nil
+	}
+	buf := append(m.partial, p...)
+	for {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			break
+		}
+		if m.re.Match(buf[:i]) {
+			m.matched = true
+			m.partial = nil
+			return len(p), nil
+		}
+		buf = buf[i+1:]
+	}
+	if len(buf) > maxMatchLine {
+		m.matched = m.re.Match(buf)
+		buf = nil
+	}
+	m.partial = append([]byte{}, buf...)
+	return len(p), nil
+}
+
+// isMatched returns true if a line matched, including a final line that
+// didn't end with a newline
+func (m *lineMatcher) isMatched() bool {
+	return m.matched || (len(m.partial) > 0 && m.re.Match(m.partial))
+}
@@ -0,0 +1,52 @@
+package commands
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joyent/containerpilot/events"
+)
+
+func TestCommandSuccessCodes(t *testing.T) {
+	run := func(exec interface{}, timeout time.Duration, success *Success) events.Event {
+		cmd, _ := NewCommand(exec, timeout, nil)
+		cmd.Name = t.Name()
+		cmd.Success = success
+		exit, ok := runtestCommandUntilExit(cmd, time.Second)
+		if !ok {
+			t.Fatalf("expected %v to exit", exec)
+		}
+		return exit
+	}
+	passed := events.Event{events.ExitSuccess, t.Name()}
+	failed := events.Event{events.ExitFailed, t.Name()}
+
+	assert.Equal(t, failed, run("false", 0, nil))
+	assert.Equal(t, passed, run("false", 0, &Success{Codes: []int{1}}))
+	assert.Equal(t, failed, run("false", 0, &Success{Codes: []int{2}}))
+
+	matchOutput := &Success{Output: regexp.MustCompile("^files differ$")}
+	assert.Equal(t, passed,
+		run([]string{"sh", "-c", "echo start; echo files differ >&2; exit 1"},
+			0, matchOutput))
+	assert.Equal(t, passed,
+		run([]string{"sh", "-c", "printf 'files differ'; exit 1"},
+			0, matchOutput))
+	assert.Equal(t, failed,
+		run([]string{"sh", "-c", "echo files are the same; exit 1"},
+			0, matchOutput))
+
+	// a timeout is never a success
+	assert.Equal(t, failed, run("sleep 2", 100*time.Millisecond,
+		&Success{Codes: []int{137}}))
+}
+
+func TestCommandRunAndWaitSuccessCodes(t *testing.T) {
+	cmd, _ := NewCommand("false", time.Duration(0), nil)
+	assert.Error(t, cmd.RunAndWait())
+	cmd.Success = &Success{Codes: []int{1}}
+	assert.NoError(t, cmd.RunAndWait())
+}
@@ -0,0 +1,7 @@
+#!/bin/sh
+# stands in for nsenter in tests: records its arguments and then runs the
+# command that follows '--'
+echo "$@" > "$NSWRAP_OUT"
+while [ "$1" != "--" ]; do shift; done
+shift
+exec "$@"
@@ -0,0 +1,49 @@
+//go:build linux
+// +build linux
+
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTitle(t *testing.T) {
+	cmd, _ := NewCommand("sleep 2", time.Duration(0), nil)
+	cmd.Name = t.Name()
+	cmd.Title = "containerpilot-test-title"
+	go runtestCommandUntilExit(cmd, 5*time.Second)
+
+	var argv [][]byte
+	var err error
+	for i := 0; i < 20; i++ {
+		time.Sleep(50 * time.Millisecond)
+		if pid := cmd.Pid(); pid != 0 {
+			if argv, err = procCmdline(pid); err == nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "containerpilot-test-title", string(argv[0]))
+	assert.Equal(t, "2", string(argv[1]), "expected args to be unchanged")
+}
+
+// procCmdline reads the arguments of a process from /proc/<pid>/cmdline
+func procCmdline(pid int) ([][]byte, error) {
+	cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return nil, err
+	}
+	if len(cmdline) == 0 {
+		return nil, fmt.Errorf("process %d has no cmdline", pid)
+	}
+	return bytes.Split(bytes.TrimRight(cmdline, "\x00"), []byte{0}), nil
+}
@@ -7,28 +7,42 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/flynn/json5"
 
+	"github.com/joyent/containerpilot/commands"
 	"github.com/joyent/containerpilot/config/decode"
 	"github.com/joyent/containerpilot/config/logger"
 	"github.com/joyent/containerpilot/config/template"
+	"github.com/joyent/containerpilot/config/timing"
 	"github.com/joyent/containerpilot/control"
 	"github.com/joyent/containerpilot/discovery"
 	"github.com/joyent/containerpilot/jobs"
 	"github.com/joyent/containerpilot/telemetry"
 	"github.com/joyent/containerpilot/watches"
+	"github.com/joyent/containerpilot/webhooks"
 )
 
 type rawConfig struct {
-	consul      interface{}
-	logConfig   *logger.Config
-	stopTimeout int
-	jobs        []interface{}
-	watches     []interface{}
-	telemetry   interface{}
-	control     interface{}
+	consul       interface{}
+	logConfig    *logger.Config
+	stopTimeout  int
+	jobs         []interface{}
+	watches      []interface{}
+	webhooks     []interface{}
+	telemetry    interface{}
+	control      interface{}
+	breaker      interface{}
+	envPrefix    string
+	maxChecks    int
+	quietPeriod  string
+	reloadPolicy string
+	deadLetter   interface{}
+
+	reloadFailure string
 }
 
 // Config contains the parsed config elements
@@ -36,15 +50,59 @@ type Config struct {
 	Discovery   discovery.Backend
 	LogConfig   *logger.Config
 	StopTimeout int
+	StopGrace   time.Duration
 	Jobs        []*jobs.Config
 	Watches     []*watches.Config
+	Webhooks    []*webhooks.Config
 	Telemetry   *telemetry.Config
 	Control     *control.Config
+	Breaker     *jobs.BreakerConfig
+	EnvPrefix   string
+
+	// DeadLetter, if set, is the log of failed commands
+	DeadLetter *commands.DeadLetter
+
+	// ReloadPolicy is how concurrent requests to reload are handled,
+	// either ReloadWait or ReloadCoalesce
+	ReloadPolicy string
+
+	// ReloadFailure is what a reload does when part of the new
+	// configuration fails, either ReloadAllOrNothing or ReloadBestEffort
+	ReloadFailure string
+
+	// the configuration as it was decoded, so that we can tell what a
+	// new config changes and whether it can be applied without
+	// restarting jobs
+	decoded map[string]interface{}
 }
 
+const (
+	// ReloadWait applies each concurrent request to reload in turn
+	ReloadWait = "wait"
+
+	// ReloadCoalesce applies the requests to reload made while another
+	// reload is running together, once it's finished
+	ReloadCoalesce = "coalesce"
+)
+
+const (
+	// ReloadAllOrNothing keeps the running configuration if any part of
+	// the new configuration fails
+	ReloadAllOrNothing = "allOrNothing"
+
+	// ReloadBestEffort applies the jobs of the new configuration that
+	// are valid, and keeps the running configuration of those that aren't
+	ReloadBestEffort = "bestEffort"
+)
+
 const (
 	// Amount of time to wait before killing the application
 	defaultStopTimeout int = 5
+
+	// Environment variable with the grace period the container runtime
+	// gives us between SIGTERM and SIGKILL (ex. Kubernetes'
+	// terminationGracePeriodSeconds)
+	stopGraceEnv = "CONTAINERPILOT_STOP_GRACE"
 )
 
 // InitLogging configure logrus with the new log config if available
@@ -55,6 +113,18 @@ func (cfg *Config) InitLogging() error {
 	return nil
 }
 
+// OnlyWatchesChanged returns true if the other Config differs from this
+// one in its watches at most, so that it can be applied without
+// restarting any jobs
+func (cfg *Config) OnlyWatchesChanged(other *Config) bool {
+	for _, change := range cfg.Diff(other) {
+		if change.Section != "watches" {
+			return false
+		}
+	}
+	return true
+}
+
 // parseStopTimeout makes sure we have a safe default
 func (cfg *rawConfig) parseStopTimeout() (int, error) {
 	if cfg.stopTimeout == 0 {
@@ -63,6 +133,21 @@ func (cfg *rawConfig) parseStopTimeout() (int, error) {
 	return cfg.stopTimeout, nil
 }
 
+// parseStopGrace returns the grace period the container runtime gives us
+// to shut down after SIGTERM, or zero if it hasn't told us one
+func parseStopGrace() (time.Duration, error) {
+	grace := os.Getenv(stopGraceEnv)
+	if grace == "" {
+		return 0, nil
+	}
+	graceDuration, err := timing.ParseDuration(grace)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse %s '%s': %v",
+			stopGraceEnv, grace, err)
+	}
+	return graceDuration, nil
+}
+
 // RenderConfig renders the templated config in configFlag to renderFlag.
 func RenderConfig(configFlag, renderFlag string) error {
 	configData, err := loadConfigFile(configFlag)
@@ -104,6 +189,22 @@ func LoadConfig(configFlag string) (*Config, error) {
 	return config, nil
 }
 
+// LoadConfigBestEffort loads, parses, and validates the configuration
+// like LoadConfig, except that jobs that fail to validate don't fail the
+// whole configuration. Each keeps its configuration from prev, or is left
+// out if prev doesn't have it, and its error is returned by its name.
+func LoadConfigBestEffort(configFlag string, prev *Config) (*Config, map[string]error, error) {
+	configData, err := loadConfigFile(configFlag)
+	if err != nil {
+		return nil, nil, err
+	}
+	renderedConfig, err := renderConfigTemplate(configData)
+	if err != nil {
+		return nil, nil, err
+	}
+	return parseConfig(renderedConfig, prev)
+}
+
 func loadConfigFile(configFlag string) ([]byte, error) {
 	if configFlag == "" {
 		return nil, errors.New("-config flag is required")
@@ -126,59 +227,146 @@ func renderConfigTemplate(configData []byte) ([]byte, error) {
 // newConfig unmarshals the textual configuration data into the
 // validated Config struct that we'll use the run the application
 func newConfig(configData []byte) (*Config, error) {
+	cfg, _, err := parseConfig(configData, nil)
+	return cfg, err
+}
+
+// parseConfig does the work of newConfig. If prev is set, jobs that fail
+// to validate are replaced by those of prev as in LoadConfigBestEffort,
+// rather than failing the configuration.
+func parseConfig(configData []byte, prev *Config) (*Config, map[string]error, error) {
 	configMap, err := unmarshalConfig(configData)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	// decodeConfig consumes the map, so keep a copy
+	decoded := make(map[string]interface{}, len(configMap))
+	for key, val := range configMap {
+		decoded[key] = val
 	}
 
 	raw := &rawConfig{}
 	if err = decodeConfig(configMap, raw); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	cfg := &Config{}
+	cfg := &Config{decoded: decoded}
 
 	disc, err := discovery.NewConsul(raw.consul)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	cfg.Discovery = disc
 
 	cfg.LogConfig = raw.logConfig
 
+	if raw.envPrefix != "" {
+		if err := commands.ValidateEnvPrefix(raw.envPrefix); err != nil {
+			return nil, nil, fmt.Errorf("unable to parse envPrefix: %v", err)
+		}
+		cfg.EnvPrefix = raw.envPrefix
+	}
+
 	stopTimeout, err := raw.parseStopTimeout()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	cfg.StopTimeout = stopTimeout
 
+	stopGrace, err := parseStopGrace()
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg.StopGrace = stopGrace
+
+	deadLetter, err := commands.NewDeadLetter(raw.deadLetter)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg.DeadLetter = deadLetter
+
+	switch raw.reloadFailure {
+	case "":
+		cfg.ReloadFailure = ReloadAllOrNothing
+	case ReloadAllOrNothing, ReloadBestEffort:
+		cfg.ReloadFailure = raw.reloadFailure
+	default:
+		return nil, nil, fmt.Errorf("reloadFailure must be one of '%s' or '%s'",
+			ReloadAllOrNothing, ReloadBestEffort)
+	}
+
+	switch raw.reloadPolicy {
+	case "":
+		cfg.ReloadPolicy = ReloadWait
+	case ReloadWait, ReloadCoalesce:
+		cfg.ReloadPolicy = raw.reloadPolicy
+	default:
+		return nil, nil, fmt.Errorf("reloadPolicy must be one of '%s' or '%s'",
+			ReloadWait, ReloadCoalesce)
+	}
+
 	controlConfig, err := control.NewConfig(raw.control)
 	if err != nil {
-		return nil, fmt.Errorf("unable to parse control: %v", err)
+		return nil, nil, fmt.Errorf("unable to parse control: %v", err)
 	}
 	cfg.Control = controlConfig
 
-	jobConfigs, err := jobs.NewConfigs(raw.jobs, disc)
+	var jobConfigs []*jobs.Config
+	var failedJobs map[string]error
+	if prev != nil {
+		jobConfigs, failedJobs, err = jobs.NewConfigsBestEffort(raw.jobs, disc, prev.Jobs)
+	} else {
+		jobConfigs, err = jobs.NewConfigs(raw.jobs, disc)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("unable to parse jobs: %v", err)
+		return nil, nil, fmt.Errorf("unable to parse jobs: %v", err)
 	}
 	cfg.Jobs = jobConfigs
+	cfg.keepDecodedJobs(prev, failedJobs)
+	if raw.maxChecks < 0 {
+		return nil, nil, fmt.Errorf("maxConcurrentChecks must be >= 0")
+	}
+	jobs.LimitHealthChecks(cfg.Jobs, raw.maxChecks)
 
-	watches, err := watches.NewConfigs(raw.watches, disc)
+	breakerConfig, err := jobs.NewBreakerConfig(raw.breaker)
 	if err != nil {
-		return nil, fmt.Errorf("unable to parse watches: %v", err)
+		return nil, nil, err
+	}
+	cfg.Breaker = breakerConfig
+
+	watchConfigs, err := watches.NewConfigs(raw.watches, disc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to parse watches: %v", err)
+	}
+	cfg.Watches = watchConfigs
+	if err := jobs.UseWatchUpstreams(cfg.Jobs, watches.Upstreams(cfg.Watches)); err != nil {
+		return nil, nil, fmt.Errorf("unable to parse jobs: %v", err)
+	}
+
+	webhookConfigs, err := webhooks.NewConfigs(raw.webhooks)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to parse webhooks: %v", err)
+	}
+	cfg.Webhooks = webhookConfigs
+	if raw.quietPeriod != "" {
+		quietPeriod, err := timing.GetTimeout(raw.quietPeriod)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to parse quietPeriod '%s': %v",
+				raw.quietPeriod, err)
+		}
+		webhooks.SetQuietPeriod(cfg.Webhooks, quietPeriod)
 	}
-	cfg.Watches = watches
 
 	telemetry, err := telemetry.NewConfig(raw.telemetry, disc)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if telemetry != nil {
 		cfg.Telemetry = telemetry
 		cfg.Jobs = append(cfg.Jobs, telemetry.JobConfig)
 	}
 
-	return cfg, nil
+	return cfg, failedJobs, nil
 }
 
 func unmarshalConfig(data []byte) (map[string]interface{}, error) {
@@ -237,19 +425,47 @@ func highlightError(data []byte, pos int64) (int, int, string) {
 func decodeConfig(configMap map[string]interface{}, result *rawConfig) error {
 	var logConfig logger.Config
 	var stopTimeout int
+	var envPrefix string
+	var maxChecks int
+	var quietPeriod string
+	var reloadPolicy string
+	var reloadFailure string
 	if err := decode.ToStruct(configMap["logging"], &logConfig); err != nil {
 		return err
 	}
 	if err := decode.ToStruct(configMap["stopTimeout"], &stopTimeout); err != nil {
 		return err
 	}
+	if err := decode.ToStruct(configMap["envPrefix"], &envPrefix); err != nil {
+		return err
+	}
+	if err := decode.ToStruct(configMap["maxConcurrentChecks"], &maxChecks); err != nil {
+		return err
+	}
+	if err := decode.ToStruct(configMap["quietPeriod"], &quietPeriod); err != nil {
+		return err
+	}
+	if err := decode.ToStruct(configMap["reloadPolicy"], &reloadPolicy); err != nil {
+		return err
+	}
+	if err := decode.ToStruct(configMap["reloadFailure"], &reloadFailure); err != nil {
+		return err
+	}
 	result.consul = configMap["consul"]
 	result.stopTimeout = stopTimeout
 	result.logConfig = &logConfig
 	result.control = configMap["control"]
 	result.jobs = decode.ToSlice(configMap["jobs"])
 	result.watches = decode.ToSlice(configMap["watches"])
+	result.webhooks = decode.ToSlice(configMap["webhooks"])
 	result.telemetry = configMap["telemetry"]
+	result.breaker = configMap["restartBreaker"]
+	result.envPrefix = envPrefix
+	result.maxChecks = maxChecks
+	result.quietPeriod = quietPeriod
+	result.reloadPolicy = reloadPolicy
+	result.deadLetter = configMap["deadLetter"]
+	result.reloadFailure = reloadFailure
 
 	delete(configMap, "consul")
 	delete(configMap, "logging")
@@ -257,7 +473,15 @@ func decodeConfig(configMap map[string]interface{}, result *rawConfig) error {
 	delete(configMap, "stopTimeout")
 	delete(configMap, "jobs")
 	delete(configMap, "watches")
+	delete(configMap, "webhooks")
 	delete(configMap, "telemetry")
+	delete(configMap, "restartBreaker")
+	delete(configMap, "envPrefix")
+	delete(configMap, "maxConcurrentChecks")
+	delete(configMap, "quietPeriod")
+	delete(configMap, "reloadPolicy")
+	delete(configMap, "deadLetter")
+	delete(configMap, "reloadFailure")
 	var unused []string
 	for key := range configMap {
 		unused = append(unused, key)
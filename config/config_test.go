@@ -1,10 +1,12 @@
 package config
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -150,6 +152,146 @@ func TestCustomConfigControl(t *testing.T) {
 		"config for control.socket")
 }
 
+func TestConfigEnvPrefix(t *testing.T) {
+	cfg, err := newConfig([]byte(`{"consul": "consul:8500", "envPrefix": "CP_SIDECAR"}`))
+	if err != nil {
+		t.Fatalf("unexpected error in newConfig: %v", err)
+	}
+	assert.Equal(t, "CP_SIDECAR", cfg.EnvPrefix)
+
+	_, err = newConfig([]byte(`{"consul": "consul:8500", "envPrefix": "CP-SIDECAR"}`))
+	assert.EqualError(t, err,
+		"unable to parse envPrefix: 'CP-SIDECAR' is not a valid environment variable prefix")
+}
+
+func TestConfigMaxConcurrentChecks(t *testing.T) {
+	_, err := newConfig([]byte(`{"consul": "consul:8500", "maxConcurrentChecks": 2}`))
+	assert.NoError(t, err)
+	_, err = newConfig([]byte(`{"consul": "consul:8500", "maxConcurrentChecks": -1}`))
+	assert.EqualError(t, err, "maxConcurrentChecks must be >= 0")
+}
+
+func TestConfigQuietPeriod(t *testing.T) {
+	_, err := newConfig([]byte(`{"consul": "consul:8500", "quietPeriod": "30s",
+		"webhooks": [{"name": "hook", "url": "http://example.com", "events": ["exitFailed"]}]}`))
+	assert.NoError(t, err)
+	_, err = newConfig([]byte(`{"consul": "consul:8500", "quietPeriod": "xx"}`))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to parse quietPeriod 'xx'")
+	}
+}
+
+func TestConfigReloadPolicy(t *testing.T) {
+	cfg, err := newConfig([]byte(`{"consul": "consul:8500"}`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, ReloadWait, cfg.ReloadPolicy)
+	}
+	cfg, err = newConfig([]byte(`{"consul": "consul:8500", "reloadPolicy": "coalesce"}`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, ReloadCoalesce, cfg.ReloadPolicy)
+	}
+	_, err = newConfig([]byte(`{"consul": "consul:8500", "reloadPolicy": "xx"}`))
+	assert.EqualError(t, err, "reloadPolicy must be one of 'wait' or 'coalesce'")
+}
+
+func TestConfigReloadFailure(t *testing.T) {
+	cfg, err := newConfig([]byte(`{"consul": "consul:8500"}`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, ReloadAllOrNothing, cfg.ReloadFailure)
+	}
+	cfg, err = newConfig([]byte(`{"consul": "consul:8500", "reloadFailure": "bestEffort"}`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, ReloadBestEffort, cfg.ReloadFailure)
+	}
+	_, err = newConfig([]byte(`{"consul": "consul:8500", "reloadFailure": "xx"}`))
+	assert.EqualError(t, err,
+		"reloadFailure must be one of 'allOrNothing' or 'bestEffort'")
+}
+
+func TestConfigOnlyWatchesChanged(t *testing.T) {
+	load := func(jobExec, watchName string) *Config {
+		cfg, err := newConfig([]byte(fmt.Sprintf(`{
+			"consul": "consul:8500",
+			"jobs": [{"name": "app", "exec": "%s"}],
+			"watches": [{"name": "%s", "interval": 5}]}`, jobExec, watchName)))
+		if err != nil {
+			t.Fatalf("unexpected error in newConfig: %v", err)
+		}
+		return cfg
+	}
+	cfg := load("/bin/app", "upstreamA")
+	assert.True(t, cfg.OnlyWatchesChanged(load("/bin/app", "upstreamA")))
+	assert.True(t, cfg.OnlyWatchesChanged(load("/bin/app", "upstreamB")))
+	assert.False(t, cfg.OnlyWatchesChanged(load("/bin/app2", "upstreamA")))
+}
+
+func TestConfigDiff(t *testing.T) {
+	load := func(text string) *Config {
+		cfg, err := newConfig([]byte(text))
+		if err != nil {
+			t.Fatalf("unexpected error in newConfig: %v", err)
+		}
+		return cfg
+	}
+	cfg := load(`{"consul": "consul:8500",
+		"jobs": [{"name": "a", "exec": "/bin/a"}, {"name": "b", "exec": "/bin/b"}]}`)
+	other := load(`{"consul": "consul:8501", "stopTimeout": 10,
+		"jobs": [{"name": "a", "exec": "/bin/a2"}, {"name": "c", "exec": "/bin/c"}]}`)
+	job := func(name, exec string) map[string]interface{} {
+		return map[string]interface{}{"name": name, "exec": exec}
+	}
+	assert.Equal(t, []Change{
+		{Section: "consul", Action: "changed",
+			Before: "consul:8500", After: "consul:8501"},
+		{Section: "jobs", Name: "a", Action: "changed",
+			Fields: map[string]FieldChange{
+				"exec": {Before: "/bin/a", After: "/bin/a2"}}},
+		{Section: "jobs", Name: "b", Action: "removed",
+			Before: job("b", "/bin/b")},
+		{Section: "jobs", Name: "c", Action: "added",
+			After: job("c", "/bin/c")},
+		{Section: "stopTimeout", Action: "added", After: float64(10)},
+	}, cfg.Diff(other))
+	assert.Equal(t, []Change{}, cfg.Diff(cfg))
+
+	var empty *Config
+	assert.Equal(t, []Change{
+		{Section: "consul", Action: "added", After: "consul:8500"},
+		{Section: "jobs", Name: "a", Action: "added", After: job("a", "/bin/a")},
+		{Section: "jobs", Name: "b", Action: "added", After: job("b", "/bin/b")},
+	}, empty.Diff(cfg))
+}
+
+func TestStopGrace(t *testing.T) {
+	defer os.Unsetenv(stopGraceEnv)
+	testCases := []struct {
+		grace    string
+		expected time.Duration
+	}{
+		{"", 0},
+		{"30", 30 * time.Second},
+		{"500ms", 500 * time.Millisecond},
+	}
+	for _, tc := range testCases {
+		os.Setenv(stopGraceEnv, tc.grace)
+		cfg, err := newConfig([]byte(`{"consul": "consul:8500"}`))
+		if err != nil {
+			t.Fatalf("unexpected error in newConfig: %v", err)
+		}
+		assert.Equal(t, tc.expected, cfg.StopGrace,
+			"grace period '%s'", tc.grace)
+		assert.Equal(t, defaultStopTimeout, cfg.StopTimeout,
+			"expected grace period '%s' not to change the stop timeout", tc.grace)
+	}
+
+	os.Setenv(stopGraceEnv, "xx")
+	_, err := newConfig([]byte(`{"consul": "consul:8500"}`))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(),
+			"unable to parse CONTAINERPILOT_STOP_GRACE 'xx'")
+	}
+}
+
 func TestInvalidRenderConfigFileMissing(t *testing.T) {
 	err := RenderConfig("/xxxx", "-")
 	assert.Error(t, err,
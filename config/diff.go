@@ -0,0 +1,174 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+)
+
+// the sections of the configuration that are lists of named items, and
+// that we diff item by item
+var namedSections = map[string]bool{
+	"jobs":     true,
+	"watches":  true,
+	"webhooks": true,
+}
+
+// Change is a difference between two configurations. Changes to jobs,
+// watches and webhooks are reported for each item by its name, and
+// changes to other sections for the whole section. When an item or
+// section with fields is changed, the Fields that differ are reported
+// with their values before and after; otherwise its whole value is.
+type Change struct {
+	Section string                 `json:"section"`
+	Name    string                 `json:"name,omitempty"`
+	Action  string                 `json:"action"` // one of "added", "removed", "changed"
+	Fields  map[string]FieldChange `json:"fields,omitempty"`
+	Before  interface{}            `json:"before,omitempty"`
+	After   interface{}            `json:"after,omitempty"`
+}
+
+// FieldChange is the value of a field before and after a Change. A
+// value is nil if the field wasn't set.
+type FieldChange struct {
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// Diff returns the changes that the other Config makes to this one,
+// sorted by section and name. A nil Config is treated as empty.
+func (cfg *Config) Diff(other *Config) []Change {
+	var prev, next map[string]interface{}
+	if cfg != nil {
+		prev = cfg.decoded
+	}
+	if other != nil {
+		next = other.decoded
+	}
+	changes := []Change{}
+	for _, section := range sortedKeys(prev, next) {
+		prevVal, nextVal := prev[section], next[section]
+		if reflect.DeepEqual(prevVal, nextVal) {
+			continue
+		}
+		if namedSections[section] {
+			prevItems, prevOk := byName(prevVal)
+			nextItems, nextOk := byName(nextVal)
+			if prevOk && nextOk {
+				changes = append(changes, diffItems(section, prevItems, nextItems)...)
+				continue
+			}
+		}
+		changes = append(changes, newChange(section, "", prevVal, nextVal))
+	}
+	return changes
+}
+
+// keepDecodedJobs replaces the decoded jobs that failed with those of
+// the same name from prev, or leaves them out if prev doesn't have them,
+// so that the decoded config matches the jobs that are applied
+func (cfg *Config) keepDecodedJobs(prev *Config, failed map[string]error) {
+	if len(failed) == 0 {
+		return
+	}
+	list, ok := cfg.decoded["jobs"].([]interface{})
+	if !ok {
+		return
+	}
+	prevItems, _ := byName(prev.decoded["jobs"])
+	kept := []interface{}{}
+	for _, item := range list {
+		fields, _ := item.(map[string]interface{})
+		name, _ := fields["name"].(string)
+		if _, ok := failed[name]; !ok {
+			kept = append(kept, item)
+		} else if prevItem, ok := prevItems[name]; ok {
+			kept = append(kept, prevItem)
+		}
+	}
+	cfg.decoded["jobs"] = kept
+}
+
+func diffItems(section string, prev, next map[string]interface{}) []Change {
+	changes := []Change{}
+	for _, name := range sortedKeys(prev, next) {
+		prevVal, nextVal := prev[name], next[name]
+		if !reflect.DeepEqual(prevVal, nextVal) {
+			changes = append(changes, newChange(section, name, prevVal, nextVal))
+		}
+	}
+	return changes
+}
+
+// newChange records the difference between the prev and next values of
+// an item or section, field by field if both have fields
+func newChange(section, name string, prev, next interface{}) Change {
+	change := Change{Section: section, Name: name, Action: action(prev, next)}
+	prevFields, prevOk := prev.(map[string]interface{})
+	nextFields, nextOk := next.(map[string]interface{})
+	if !prevOk || !nextOk {
+		change.Before, change.After = prev, next
+		return change
+	}
+	change.Fields = map[string]FieldChange{}
+	for _, field := range sortedKeys(prevFields, nextFields) {
+		prevVal, nextVal := prevFields[field], nextFields[field]
+		if !reflect.DeepEqual(prevVal, nextVal) {
+			change.Fields[field] = FieldChange{Before: prevVal, After: nextVal}
+		}
+	}
+	return change
+}
+
+func action(prev, next interface{}) string {
+	switch {
+	case prev == nil:
+		return "added"
+	case next == nil:
+		return "removed"
+	default:
+		return "changed"
+	}
+}
+
+// byName maps the items of a named section by their names, or returns
+// false if any item is unnamed or the names aren't unique
+func byName(section interface{}) (map[string]interface{}, bool) {
+	items := map[string]interface{}{}
+	if section == nil {
+		return items, true
+	}
+	list, ok := section.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	for _, item := range list {
+		fields, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		name, ok := fields["name"].(string)
+		if !ok || name == "" {
+			return nil, false
+		}
+		if _, dupe := items[name]; dupe {
+			return nil, false
+		}
+		items[name] = item
+	}
+	return items, true
+}
+
+func sortedKeys(maps ...map[string]interface{}) []string {
+	seen := map[string]bool{}
+	keys := []string{}
+	for _, m := range maps {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -3,11 +3,18 @@
 package timing
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"time"
 )
 
+// errInvalidDuration replaces the errors from time.ParseDuration, which
+// don't tell an operator editing their config what we're looking for.
+// Callers include the name of the config field and the value.
+var errInvalidDuration = errors.New(
+	`must be a number of seconds or a duration with units, such as "30s", "5m" or "1h"`)
+
 // GetTimeout converts a properly formatted string to a Duration,
 // returning an error if the Duration can't be parsed
 func GetTimeout(timeoutFmt string) (time.Duration, error) {
@@ -51,8 +58,12 @@ func ParseDuration(duration interface{}) (time.Duration, error) {
 		return time.Duration(t) * time.Second, nil
 	case string:
 		if i, err := strconv.Atoi(t); err == nil {
-			return time.ParseDuration(fmt.Sprintf("%ds", i))
+			return time.Duration(i) * time.Second, nil
+		}
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			return time.Duration(0), errInvalidDuration
 		}
-		return time.ParseDuration(t)
+		return d, nil
 	}
 }
@@ -1,7 +1,6 @@
 package timing
 
 import (
-	"errors"
 	"strings"
 	"testing"
 	"time"
@@ -19,8 +18,7 @@ func TestGetTimeout(t *testing.T) {
 	expectDurationCompare(t, dur, time.Duration(0), err, nil)
 
 	dur, err = GetTimeout("x")
-	expectDurationCompare(t, dur, time.Duration(0),
-		err, errors.New("time: invalid duration x"))
+	expectDurationCompare(t, dur, time.Duration(0), err, errInvalidDuration)
 
 	dur, err = GetTimeout("0")
 	expectDurationCompare(t, dur, time.Duration(0), err, nil)
@@ -66,8 +64,9 @@ func TestParseDuration(t *testing.T) {
 	expectDuration(t, "10h", 10*time.Hour)
 
 	// Some parse errors
-	expectError(t, "asf", "invalid duration")
-	expectError(t, "20yy", "unknown unit yy")
+	expectError(t, "asf", `such as "30s", "5m" or "1h"`)
+	expectError(t, "20yy", `such as "30s", "5m" or "1h"`)
+	expectError(t, "30 seconds", `such as "30s", "5m" or "1h"`)
 
 	// Fractional
 	expectError(t, 10.10, "unexpected duration of type float")
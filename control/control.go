@@ -9,6 +9,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/joyent/containerpilot/events"
@@ -34,25 +35,58 @@ func init() {
 
 // HTTPServer contains the state of the HTTP Server used by ContainerPilot's
 // HTTP transport control plane. Currently this is listening via a UNIX socket
-// file.
+// file. The server keeps listening across reloads of the configuration;
+// only the EventBus and jobs that its endpoints use are swapped out.
 type HTTPServer struct {
 	Addr string
 	Bus  *events.EventBus
 
+	// Primaries are the jobs that must be healthy for /v3/ready to
+	// report that ContainerPilot is ready
+	Primaries []HealthReporter
+
+	// Services are the names of the jobs that advertise a service, which
+	// /v3/services/{name} can deregister and register
+	Services []string
+
+	// SoftReload replaces the watches with those of the config on disk
+	// without restarting any jobs, for /v3/reload/soft
+	SoftReload func() error
+
+	// ReloadHistory returns the recent reloads for /v3/reload/history
+	ReloadHistory func() interface{}
+
+	// JobEnv returns the environment each job's exec was last started
+	// with, for /v3/jobs/env
+	JobEnv func() interface{}
+
+	// Health returns an error if ContainerPilot itself isn't working,
+	// for /v3/health
+	Health func() error
+
+	// CollectMetrics runs the telemetry sensors and returns their values,
+	// or an error if there's no telemetry, for /v3/metrics/collect
+	CollectMetrics func() (map[string]float64, error)
+
+	endpoints *Endpoints
+	started   bool
+	lock      sync.RWMutex
+
 	http.Server
 	events.Publisher
 }
 
 // NewHTTPServer initializes a new control server for manipulating
-// ContainerPilot's runtime configuration.
+// ContainerPilot's runtime configuration. The socket isn't touched until
+// the server is started, so that a reload doesn't unlink the socket of
+// the server that's still running.
 func NewHTTPServer(cfg *Config) (*HTTPServer, error) {
 	srv := &HTTPServer{
 		Addr: cfg.SocketPath,
 	}
-	if err := srv.Validate(); err != nil {
-		return nil, fmt.Errorf("control: validate failed with %s", err)
+	if srv.Addr == "" {
+		return nil, fmt.Errorf("control: validate failed with %s", ErrMissingAddr)
 	}
-
 	return srv, nil
 }
 
@@ -72,45 +106,113 @@ func (srv *HTTPServer) Validate() error {
 	return nil
 }
 
-// Run executes the event loop for the control server
+// Run executes the event loop for the control server. The first time
+// it's called it starts the server; after a reload it only points the
+// running server at the new EventBus. The server stays registered with
+// the EventBus until the context is cancelled, but isn't stopped then.
 func (srv *HTTPServer) Run(pctx context.Context, bus *events.EventBus) {
 	ctx, cancel := context.WithCancel(pctx)
 	srv.Register(bus)
-	srv.Start(cancel)
+	if srv.isStarted() {
+		srv.setEndpoints(cancel)
+	} else {
+		srv.Start(cancel)
+	}
 
 	go func() {
-		defer srv.Stop()
 		<-ctx.Done()
-		return
+		srv.Unregister()
 	}()
 }
 
+func (srv *HTTPServer) isStarted() bool {
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+	return srv.started
+}
+
+// setEndpoints swaps in the endpoints for the current EventBus and jobs
+func (srv *HTTPServer) setEndpoints(cancel context.CancelFunc) {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	srv.endpoints = &Endpoints{
+		bus:            srv.Publisher.Bus,
+		cancel:         cancel,
+		primaries:      srv.Primaries,
+		services:       srv.Services,
+		softReload:     srv.SoftReload,
+		reloadHistory:  srv.ReloadHistory,
+		jobEnv:         srv.JobEnv,
+		health:         srv.Health,
+		collectMetrics: srv.CollectMetrics,
+	}
+}
+
+func (srv *HTTPServer) getEndpoints() Endpoints {
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+	return *srv.endpoints
+}
+
+// route adapts an Endpoints method expression into a handler that calls
+// it on the endpoints current at the time of the request
+func (srv *HTTPServer) route(handler func(Endpoints, *http.Request) (interface{}, int)) func(*http.Request) (interface{}, int) {
+	return func(r *http.Request) (interface{}, int) {
+		return handler(srv.getEndpoints(), r)
+	}
+}
+
 // Start sets up API routes with the event bus, listens on the control socket,
 // and serves the HTTP server.
 func (srv *HTTPServer) Start(cancel context.CancelFunc) {
-	endpoints := &Endpoints{
-		bus:    srv.Publisher.Bus,
-		cancel: cancel,
-	}
+	srv.setEndpoints(cancel)
 
 	router := http.NewServeMux()
 	router.Handle("/v3/environ",
-		PostHandler(endpoints.PutEnviron))
+		PostHandler(srv.route(Endpoints.PutEnviron)))
 	router.Handle("/v3/reload",
-		PostHandler(endpoints.PostReload))
+		PostHandler(srv.route(Endpoints.PostReload)))
+	router.Handle("/v3/reload/soft",
+		PostHandler(srv.route(Endpoints.PostSoftReload)))
+	router.Handle("/v3/reload/history", MethodHandler{
+		http.MethodGet: srv.route(Endpoints.GetReloadHistory),
+	})
+	router.Handle("/v3/jobs/env", MethodHandler{
+		http.MethodGet: srv.route(Endpoints.GetJobEnv),
+	})
+	router.Handle("/v3/health", MethodHandler{
+		http.MethodGet: srv.route(Endpoints.GetHealth),
+	})
 	router.Handle("/v3/metric",
-		PostHandler(endpoints.PostMetric))
+		PostHandler(srv.route(Endpoints.PostMetric)))
+	router.Handle("/v3/metrics/collect",
+		PostHandler(srv.route(Endpoints.PostCollectMetrics)))
 	router.Handle("/v3/maintenance/enable",
-		PostHandler(endpoints.PostEnableMaintenanceMode))
+		PostHandler(srv.route(Endpoints.PostEnableMaintenanceMode)))
 	router.Handle("/v3/maintenance/disable",
-		PostHandler(endpoints.PostDisableMaintenanceMode))
+		PostHandler(srv.route(Endpoints.PostDisableMaintenanceMode)))
+	router.Handle("/v3/services/",
+		PostHandler(srv.route(Endpoints.PostService)))
+	router.Handle("/v3/loglevel", MethodHandler{
+		http.MethodGet: srv.route(Endpoints.GetLogLevel),
+		http.MethodPut: srv.route(Endpoints.PutLogLevel),
+	})
 	router.HandleFunc("/v3/ping", GetPing)
+	router.HandleFunc("/v3/ready", func(w http.ResponseWriter, r *http.Request) {
+		srv.getEndpoints().GetReady(w, r)
+	})
 
 	srv.Handler = router
 	srv.SetKeepAlivesEnabled(false)
 	log.Debug("control: initialized router for control server")
 
+	if err := srv.Validate(); err != nil {
+		log.Fatalf("control: validate failed with %s", err)
+	}
 	ln := srv.listenWithRetry()
+	srv.lock.Lock()
+	srv.started = true
+	srv.lock.Unlock()
 
 	go func() {
 		log.Infof("control: serving at %s", srv.Addr)
@@ -141,10 +243,11 @@ func (srv *HTTPServer) listenWithRetry() net.Listener {
 
 // Stop shuts down the control server gracefully
 func (srv *HTTPServer) Stop() error {
-	// This timeout won't stop the configuration reload process, since that
-	// happens async, but timing out can pre-emptively close the HTTP connection
-	// that fired the reload in the first place. If pre-emptive timeout occurs
-	// than CP only throws a warning in its logs.
+	// The server keeps running across reloads, so we only get here when
+	// ContainerPilot is exiting or the socket path has changed. Timing out
+	// can pre-emptively close HTTP connections that are still in flight.
+	// If pre-emptive timeout occurs than CP only throws a warning in its
+	// logs.
 	//
 	// Also, 600 seemed to be the magic number... I'm sure it'll vary.
 	log.Debug("control: stopping control server")
@@ -155,8 +258,6 @@ func (srv *HTTPServer) Stop() error {
 		log.Warnf("control: failed to gracefully shutdown control server: %v", err)
 		return err
 	}
-
-	srv.Unregister()
 	log.Debug("control: completed graceful shutdown of control server")
 	return nil
 }
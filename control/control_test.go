@@ -108,3 +108,71 @@ func TestServerSmokeTest(t *testing.T) {
 		t.Fatalf("expected 404 but got %v\n%+v", resp.StatusCode, resp)
 	}
 }
+
+// The control server keeps serving while ContainerPilot reloads, so a
+// request made during the reload doesn't fail
+func TestServerPersistsAcrossReload(t *testing.T) {
+	tempSocketPath := tempSocketPath()
+	defer os.Remove(tempSocketPath)
+	cfg, _ := NewConfig(tests.DecodeRaw(fmt.Sprintf(`{"socket": %q}`, tempSocketPath)))
+	s, err := NewHTTPServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: socketDialer(tempSocketPath),
+		},
+	}
+
+	bus1 := events.NewEventBus()
+	s.Run(context.Background(), bus1)
+
+	done := make(chan struct{})
+	errs := make(chan error, 1000)
+	go func() {
+		defer close(errs)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			resp, err := client.Get("http://control/v3/ready")
+			if err != nil {
+				errs <- err
+				continue
+			}
+			resp.Body.Close()
+		}
+	}()
+
+	resp, err := client.Post("http://control/v3/reload", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	if !bus1.Wait() {
+		t.Fatal("expected reload flag to be set")
+	}
+
+	// the App reloads its config and runs everything on a new bus
+	bus2 := events.NewEventBus()
+	s.Run(context.Background(), bus2)
+	time.Sleep(50 * time.Millisecond)
+	close(done)
+	for err := range errs {
+		t.Errorf("request failed during reload: %v", err)
+	}
+
+	resp, err = client.Post("http://control/v3/maintenance/enable", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Contains(t, bus2.DebugEvents(), events.GlobalEnterMaintenance,
+		"expected requests after reload to use the new bus")
+	assert.NotContains(t, bus1.DebugEvents(), events.GlobalEnterMaintenance)
+}
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joyent/containerpilot/events"
 	log "github.com/sirupsen/logrus"
@@ -17,8 +18,21 @@ import (
 // Endpoints wraps the EventBus so we can bridge data across the App and
 // HTTPServer API boundary
 type Endpoints struct {
-	bus    *events.EventBus
-	cancel context.CancelFunc
+	bus            *events.EventBus
+	cancel         context.CancelFunc
+	primaries      []HealthReporter
+	services       []string
+	softReload     func() error
+	reloadHistory  func() interface{}
+	jobEnv         func() interface{}
+	health         func() error
+	collectMetrics func() (map[string]float64, error)
+}
+
+// HealthReporter is a job whose health we can check without going
+// through the EventBus
+type HealthReporter interface {
+	IsHealthy() bool
 }
 
 // PostHandler is an adapter which allows a normal function to serve itself and
@@ -35,6 +49,30 @@ func (pw PostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	resp, status := pw(r)
+	writeResponse(w, r, resp, status)
+}
+
+// MethodHandler is an adapter like PostHandler for endpoints that handle
+// more than one HTTP method, keyed by the method
+type MethodHandler map[string]func(*http.Request) (interface{}, int)
+
+func (mh MethodHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler, ok := mh[r.Method]
+	if !ok {
+		failedStatus := http.StatusMethodNotAllowed
+		http.Error(w, http.StatusText(failedStatus), failedStatus)
+		collector.WithLabelValues(
+			strconv.Itoa(http.StatusMethodNotAllowed), r.URL.Path).Inc()
+		return
+	}
+	resp, status := handler(r)
+	writeResponse(w, r, resp, status)
+}
+
+// writeResponse writes the response of a PostHandler or MethodHandler
+// as JSON, or writes an empty response if there's nothing to encode.
+// An error response that's a string is written as the error message.
+func writeResponse(w http.ResponseWriter, r *http.Request, resp interface{}, status int) {
 	switch status {
 	case http.StatusOK:
 		if resp != nil {
@@ -46,7 +84,11 @@ func (pw PostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			io.WriteString(w, "\n")
 		}
 	default:
-		http.Error(w, http.StatusText(status), status)
+		msg, ok := resp.(string)
+		if !ok {
+			msg = http.StatusText(status)
+		}
+		http.Error(w, msg, status)
 	}
 	collector.WithLabelValues(strconv.Itoa(status), r.URL.Path).Inc()
 }
@@ -85,6 +127,61 @@ func (e Endpoints) PostReload(r *http.Request) (interface{}, int) {
 	return nil, http.StatusOK
 }
 
+// PostSoftReload handles incoming HTTP POST requests and replaces the
+// watches with those of the configuration on disk, without restarting
+// any jobs. Returns empty response, or HTTP409 with the reason if the
+// configuration has changed in ways that need a full reload.
+func (e Endpoints) PostSoftReload(r *http.Request) (interface{}, int) {
+	if r.Body != nil {
+		defer r.Body.Close()
+	}
+	if e.softReload == nil {
+		return nil, http.StatusNotFound
+	}
+	log.Debug("control: soft reloading watches via control plane")
+	if err := e.softReload(); err != nil {
+		log.Warnf("control: soft reload refused: %v", err)
+		return err.Error(), http.StatusConflict
+	}
+	return nil, http.StatusOK
+}
+
+// GetReloadHistory handles incoming HTTP GET requests and returns the
+// most recent reloads of the configuration as JSON. Returns HTTP404 if
+// there's no history to report.
+func (e Endpoints) GetReloadHistory(r *http.Request) (interface{}, int) {
+	if e.reloadHistory == nil {
+		return nil, http.StatusNotFound
+	}
+	return e.reloadHistory(), http.StatusOK
+}
+
+// GetJobEnv handles incoming HTTP GET requests and returns the environment
+// that each job's exec was last started with as JSON. It's only served on
+// the control socket because the values that aren't redacted may still
+// be sensitive. Returns HTTP404 if there's no environment to report.
+func (e Endpoints) GetJobEnv(r *http.Request) (interface{}, int) {
+	if e.jobEnv == nil {
+		return nil, http.StatusNotFound
+	}
+	return e.jobEnv(), http.StatusOK
+}
+
+// GetHealth handles incoming HTTP GET requests and reports whether
+// ContainerPilot itself is working, regardless of the health of its jobs.
+// Returns HTTP503 with the reason if it isn't, or HTTP404 if there's
+// nothing to check.
+func (e Endpoints) GetHealth(r *http.Request) (interface{}, int) {
+	if e.health == nil {
+		return nil, http.StatusNotFound
+	}
+	if err := e.health(); err != nil {
+		log.Warnf("control: unhealthy: %v", err)
+		return err.Error(), http.StatusServiceUnavailable
+	}
+	return nil, http.StatusOK
+}
+
 // PostEnableMaintenanceMode handles incoming HTTP POST requests and toggles
 // ContainerPilot maintenance mode on. Returns empty response or HTTP422.
 func (e Endpoints) PostEnableMaintenanceMode(r *http.Request) (interface{}, int) {
@@ -106,7 +203,8 @@ func (e Endpoints) PostDisableMaintenanceMode(r *http.Request) (interface{}, int
 }
 
 // PostMetric handles incoming HTTP POST requests, serializes the metrics
-// into Events, and publishes them for sensors to record their values.
+// into Events, and publishes them for sensors to record their values. The
+// optional "job" query parameter names the job the metrics are for.
 // Returns empty response or HTTP422.
 func (e Endpoints) PostMetric(r *http.Request) (interface{}, int) {
 	var postMetrics map[string]interface{}
@@ -121,13 +219,106 @@ func (e Endpoints) PostMetric(r *http.Request) (interface{}, int) {
 		log.Debug(err)
 		return nil, http.StatusUnprocessableEntity
 	}
+	job := r.URL.Query().Get("job")
 	for metricKey, metricValue := range postMetrics {
 		eventVal := fmt.Sprintf("%v|%v", metricKey, metricValue)
+		if job != "" {
+			eventVal += "|" + job
+		}
 		e.bus.Publish(events.Event{events.Metric, eventVal})
 	}
 	return nil, http.StatusOK
 }
 
+// PostCollectMetrics handles incoming HTTP POST requests, runs the
+// telemetry sensors now, and returns the values they recorded as JSON
+// once they're done. Returns HTTP404 if there's no telemetry.
+func (e Endpoints) PostCollectMetrics(r *http.Request) (interface{}, int) {
+	if r.Body != nil {
+		defer r.Body.Close()
+	}
+	if e.collectMetrics == nil {
+		return nil, http.StatusNotFound
+	}
+	log.Debug("control: collecting metrics via control plane")
+	values, err := e.collectMetrics()
+	if err != nil {
+		return err.Error(), http.StatusNotFound
+	}
+	return values, http.StatusOK
+}
+
+// PostService handles incoming HTTP POST requests to
+// '/v3/services/{name}/{deregister|register}' and publishes the
+// matching event so that the job advertising that service can remove
+// itself from (or return itself to) the discovery backend. Returns
+// empty response, or HTTP404 if no job advertises the service.
+func (e Endpoints) PostService(r *http.Request) (interface{}, int) {
+	if r.Body != nil {
+		defer r.Body.Close()
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/v3/services/")
+	parts := strings.Split(path, "/")
+	if len(parts) != 2 || parts[0] == "" {
+		return nil, http.StatusNotFound
+	}
+	name, action := parts[0], parts[1]
+	if !e.hasService(name) {
+		return nil, http.StatusNotFound
+	}
+	switch action {
+	case "deregister":
+		log.Debugf("control: deregistering service %s via control plane", name)
+		e.bus.Publish(events.Event{Code: events.Deregister, Source: name})
+	case "register":
+		log.Debugf("control: re-registering service %s via control plane", name)
+		e.bus.Publish(events.Event{Code: events.Register, Source: name})
+	default:
+		return nil, http.StatusNotFound
+	}
+	return nil, http.StatusOK
+}
+
+func (e Endpoints) hasService(name string) bool {
+	for _, service := range e.services {
+		if service == name {
+			return true
+		}
+	}
+	return false
+}
+
+// GetLogLevel handles incoming HTTP GET requests and returns the current
+// log level as JSON.
+func (e Endpoints) GetLogLevel(r *http.Request) (interface{}, int) {
+	return map[string]string{"level": log.GetLevel().String()}, http.StatusOK
+}
+
+// PutLogLevel handles incoming HTTP PUT requests containing a JSON log
+// level and sets the level of ContainerPilot's logs and the logs of its
+// jobs. Returns empty response or HTTP422.
+func (e Endpoints) PutLogLevel(r *http.Request) (interface{}, int) {
+	var putLevel struct {
+		Level string `json:"level"`
+	}
+	jsonBlob, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		return nil, http.StatusUnprocessableEntity
+	}
+	if err := json.Unmarshal(jsonBlob, &putLevel); err != nil {
+		return nil, http.StatusUnprocessableEntity
+	}
+	level, err := log.ParseLevel(putLevel.Level)
+	if err != nil {
+		log.Debug(err)
+		return nil, http.StatusUnprocessableEntity
+	}
+	log.SetLevel(level)
+	log.Infof("control: log level set to %s via control plane", level)
+	return nil, http.StatusOK
+}
+
 // GetPing allows us to check if the control socket is up without
 // making a mutation of ContainerPilot's state
 func GetPing(w http.ResponseWriter, r *http.Request) {
@@ -136,3 +327,20 @@ func GetPing(w http.ResponseWriter, r *http.Request) {
 	collector.WithLabelValues("200", r.URL.Path).Inc()
 	io.WriteString(w, "\n")
 }
+
+// GetReady reports whether ContainerPilot is ready to serve traffic,
+// which is when all of its primary jobs are healthy. Returns empty
+// response or HTTP503.
+func (e Endpoints) GetReady(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	status := http.StatusOK
+	for _, job := range e.primaries {
+		if !job.IsHealthy() {
+			status = http.StatusServiceUnavailable
+			break
+		}
+	}
+	w.WriteHeader(status)
+	collector.WithLabelValues(strconv.Itoa(status), r.URL.Path).Inc()
+	io.WriteString(w, "\n")
+}
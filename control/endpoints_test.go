@@ -1,7 +1,9 @@
 package control
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -10,6 +12,7 @@ import (
 	"strings"
 	"testing"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/joyent/containerpilot/events"
@@ -102,14 +105,14 @@ func TestPostHandler(t *testing.T) {
 }
 
 func TestPostMetric(t *testing.T) {
-	testFunc := func(t *testing.T, expected map[events.Event]int, body string) int {
+	testFunc := func(t *testing.T, expected map[events.Event]int, target, body string) int {
 		_, cancel := context.WithCancel(context.Background())
 		bus := events.NewEventBus()
 		endpoints := &Endpoints{
 			bus:    bus,
 			cancel: cancel,
 		}
-		req, _ := http.NewRequest("POST", "/v3/metric", strings.NewReader(body))
+		req, _ := http.NewRequest("POST", target, strings.NewReader(body))
 		_, status := endpoints.PostMetric(req)
 		got := map[events.Event]int{}
 		results := bus.DebugEvents()
@@ -125,13 +128,13 @@ func TestPostMetric(t *testing.T) {
 	t.Run("POST bad JSON", func(t *testing.T) {
 		body := "{{\n"
 		expected := map[events.Event]int{}
-		status := testFunc(t, expected, body)
+		status := testFunc(t, expected, "/v3/metric", body)
 		assert.Equal(t, http.StatusUnprocessableEntity, status, "status was not 422")
 	})
 	t.Run("POST value", func(t *testing.T) {
 		body := "{\"mymetric\": 1.0}"
 		expected := map[events.Event]int{{events.Metric, "mymetric|1"}: 1}
-		status := testFunc(t, expected, body)
+		status := testFunc(t, expected, "/v3/metric", body)
 		assert.Equal(t, http.StatusOK, status, "status was not 200OK")
 	})
 	t.Run("POST multi-metric", func(t *testing.T) {
@@ -139,7 +142,13 @@ func TestPostMetric(t *testing.T) {
 		status := testFunc(t, map[events.Event]int{
 			{events.Metric, "mymetric|1.5"}:    1,
 			{events.Metric, "myothermetric|2"}: 1,
-		}, body)
+		}, "/v3/metric", body)
+		assert.Equal(t, http.StatusOK, status, "status was not 200OK")
+	})
+	t.Run("POST value for job", func(t *testing.T) {
+		body := "{\"mymetric\": 1.0}"
+		expected := map[events.Event]int{{events.Metric, "mymetric|1|worker-a"}: 1}
+		status := testFunc(t, expected, "/v3/metric?job=worker-a", body)
 		assert.Equal(t, http.StatusOK, status, "status was not 200OK")
 	})
 }
@@ -217,6 +226,145 @@ func TestPostDisableMaintenanceMode(t *testing.T) {
 	})
 }
 
+func TestPostService(t *testing.T) {
+	testFunc := func(t *testing.T, expected map[events.Event]int, path string) int {
+		_, cancel := context.WithCancel(context.Background())
+		bus := events.NewEventBus()
+		bus.Publish(events.GlobalStartup)
+		endpoints := &Endpoints{
+			bus:      bus,
+			cancel:   cancel,
+			services: []string{"myservice"},
+		}
+		req, _ := http.NewRequest("POST", path, nil)
+		_, status := endpoints.PostService(req)
+		results := bus.DebugEvents()
+		got := map[events.Event]int{}
+		for _, result := range results {
+			if result != events.GlobalStartup {
+				got[result]++
+			}
+		}
+		assert.Equal(t, expected, got)
+		return status
+	}
+
+	t.Run("POST deregister", func(t *testing.T) {
+		expected := map[events.Event]int{{events.Deregister, "myservice"}: 1}
+		status := testFunc(t, expected, "/v3/services/myservice/deregister")
+		assert.Equal(t, http.StatusOK, status, "status was not 200OK")
+	})
+	t.Run("POST register", func(t *testing.T) {
+		expected := map[events.Event]int{{events.Register, "myservice"}: 1}
+		status := testFunc(t, expected, "/v3/services/myservice/register")
+		assert.Equal(t, http.StatusOK, status, "status was not 200OK")
+	})
+	t.Run("POST unknown action", func(t *testing.T) {
+		status := testFunc(t, map[events.Event]int{}, "/v3/services/myservice/xxxx")
+		assert.Equal(t, http.StatusNotFound, status, "status was not 404")
+	})
+	t.Run("POST missing name", func(t *testing.T) {
+		status := testFunc(t, map[events.Event]int{}, "/v3/services//deregister")
+		assert.Equal(t, http.StatusNotFound, status, "status was not 404")
+	})
+	t.Run("POST unknown service", func(t *testing.T) {
+		status := testFunc(t, map[events.Event]int{}, "/v3/services/myservce/deregister")
+		assert.Equal(t, http.StatusNotFound, status, "status was not 404")
+	})
+}
+
+func TestPostSoftReload(t *testing.T) {
+	testFunc := func(softReload func() error) (int, string) {
+		endpoints := Endpoints{softReload: softReload}
+		ph := PostHandler(endpoints.PostSoftReload)
+		w := httptest.NewRecorder()
+		ph.ServeHTTP(w, httptest.NewRequest("POST", "/v3/reload/soft", nil))
+		resp := w.Result()
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	status, _ := testFunc(func() error { return nil })
+	assert.Equal(t, http.StatusOK, status)
+
+	status, body := testFunc(func() error { return fmt.Errorf("jobs changed") })
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Equal(t, "jobs changed\n", body)
+
+	status, _ = testFunc(nil)
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestGetReloadHistory(t *testing.T) {
+	testFunc := func(history func() interface{}) (int, string) {
+		endpoints := Endpoints{reloadHistory: history}
+		mh := MethodHandler{http.MethodGet: endpoints.GetReloadHistory}
+		w := httptest.NewRecorder()
+		mh.ServeHTTP(w, httptest.NewRequest("GET", "/v3/reload/history", nil))
+		resp := w.Result()
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := testFunc(func() interface{} {
+		return []map[string]string{{"trigger": "reload"}}
+	})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "[{\"trigger\":\"reload\"}]\n", body)
+
+	status, _ = testFunc(nil)
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestGetJobEnv(t *testing.T) {
+	testFunc := func(env func() interface{}) (int, string) {
+		endpoints := Endpoints{jobEnv: env}
+		mh := MethodHandler{http.MethodGet: endpoints.GetJobEnv}
+		w := httptest.NewRecorder()
+		mh.ServeHTTP(w, httptest.NewRequest("GET", "/v3/jobs/env", nil))
+		resp := w.Result()
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := testFunc(func() interface{} {
+		return map[string]map[string]string{"app": {"DB_PORT": "5432"}}
+	})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "{\"app\":{\"DB_PORT\":\"5432\"}}\n", body)
+
+	status, _ = testFunc(nil)
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestGetHealth(t *testing.T) {
+	testFunc := func(health func() error) (int, string) {
+		endpoints := Endpoints{health: health}
+		mh := MethodHandler{http.MethodGet: endpoints.GetHealth}
+		w := httptest.NewRecorder()
+		mh.ServeHTTP(w, httptest.NewRequest("GET", "/v3/health", nil))
+		resp := w.Result()
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	status, _ := testFunc(func() error { return nil })
+	assert.Equal(t, http.StatusOK, status)
+
+	status, body := testFunc(func() error {
+		return errors.New("unable to reach discovery backend: connection refused")
+	})
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, "unable to reach discovery backend: connection refused\n", body)
+
+	status, _ = testFunc(nil)
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
 func TestGetPing(t *testing.T) {
 	req := httptest.NewRequest("GET", "/v3/ping", nil)
 	w := httptest.NewRecorder()
@@ -226,3 +374,100 @@ func TestGetPing(t *testing.T) {
 	status := resp.StatusCode
 	assert.Equal(t, 200, status, "expected HTTP 200 OK")
 }
+
+type testHealthReporter struct {
+	healthy bool
+}
+
+func (r *testHealthReporter) IsHealthy() bool {
+	return r.healthy
+}
+
+func TestGetReady(t *testing.T) {
+	jobA := &testHealthReporter{healthy: true}
+	jobB := &testHealthReporter{healthy: false}
+	endpoints := &Endpoints{primaries: []HealthReporter{jobA, jobB}}
+	getReady := func() int {
+		req := httptest.NewRequest("GET", "/v3/ready", nil)
+		w := httptest.NewRecorder()
+		endpoints.GetReady(w, req)
+		resp := w.Result()
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, 503, getReady(), "expected HTTP 503 with one job unhealthy")
+	jobB.healthy = true
+	assert.Equal(t, 200, getReady(), "expected HTTP 200 with all jobs healthy")
+	jobA.healthy = false
+	assert.Equal(t, 503, getReady(), "expected HTTP 503 with one job unhealthy")
+
+	endpoints = &Endpoints{}
+	assert.Equal(t, 200, getReady(), "expected HTTP 200 without primary jobs")
+}
+
+func TestLogLevel(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	defer log.SetLevel(log.GetLevel())
+
+	handler := MethodHandler{
+		http.MethodGet: Endpoints{}.GetLogLevel,
+		http.MethodPut: Endpoints{}.PutLogLevel,
+	}
+	request := func(method, body string) (int, string) {
+		req := httptest.NewRequest(method, "/v3/loglevel", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		resp := w.Result()
+		defer resp.Body.Close()
+		result, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(result)
+	}
+
+	status, _ := request("PUT", `{"level": "debug"}`)
+	assert.Equal(t, http.StatusOK, status)
+	status, result := request("GET", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "{\"level\":\"debug\"}\n", result)
+	log.Debug("debug line one")
+	assert.Contains(t, logged.String(), "debug line one")
+
+	status, _ = request("PUT", `{"level": "info"}`)
+	assert.Equal(t, http.StatusOK, status)
+	log.Debug("debug line two")
+	assert.NotContains(t, logged.String(), "debug line two")
+
+	status, _ = request("PUT", `{"level": "loud"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+	status, _ = request("POST", `{"level": "debug"}`)
+	assert.Equal(t, http.StatusMethodNotAllowed, status)
+}
+
+func TestPostCollectMetrics(t *testing.T) {
+	testFunc := func(collect func() (map[string]float64, error)) (int, string) {
+		endpoints := Endpoints{collectMetrics: collect}
+		ph := PostHandler(endpoints.PostCollectMetrics)
+		w := httptest.NewRecorder()
+		ph.ServeHTTP(w, httptest.NewRequest("POST", "/v3/metrics/collect", nil))
+		resp := w.Result()
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := testFunc(func() (map[string]float64, error) {
+		return map[string]float64{"app_queue_depth": 12}, nil
+	})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "{\"app_queue_depth\":12}\n", body)
+
+	status, body = testFunc(func() (map[string]float64, error) {
+		return nil, errors.New("telemetry is not configured")
+	})
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, "telemetry is not configured\n", body)
+
+	status, _ = testFunc(nil)
+	assert.Equal(t, http.StatusNotFound, status)
+}
@@ -6,10 +6,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/joyent/containerpilot/commands"
 	"github.com/joyent/containerpilot/config"
 	"github.com/joyent/containerpilot/control"
 	"github.com/joyent/containerpilot/discovery"
@@ -17,38 +20,87 @@ import (
 	"github.com/joyent/containerpilot/jobs"
 	"github.com/joyent/containerpilot/telemetry"
 	"github.com/joyent/containerpilot/watches"
+	"github.com/joyent/containerpilot/webhooks"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// processStart is when ContainerPilot started. The webhooks' quiet period
+// is measured from here rather than from the last reload.
+var processStart = time.Now()
+
+// Amount of the stop grace period we reserve so that we can kill our
+// processes before the container runtime kills us
+const stopGraceMargin = time.Second
+
 // App encapsulates the state of ContainerPilot after the initial setup.
 type App struct {
 	ControlServer *control.HTTPServer
 	Discovery     discovery.Backend
 	Jobs          []*jobs.Job
 	Watches       []*watches.Watch
+	Webhooks      []*webhooks.Webhook
 	Telemetry     *telemetry.Telemetry
+	Breaker       *jobs.RestartBreaker
 	StopTimeout   int
+	StopGrace     time.Duration
 	signalLock    *sync.RWMutex
 	ConfigFlag    string
 	Bus           *events.EventBus
+
+	config      *config.Config
+	tasksCtx    context.Context
+	watchCancel context.CancelFunc
+	reloads     *reloadHistory
+	reloadQueue *reloadQueue
+	pings       *pingProbe
+
+	// when we have to have killed our processes by, once we've been
+	// told to terminate with a grace period, and a channel closed then
+	stopBy    time.Time
+	graceOver chan struct{}
 }
 
 // EmptyApp creates an empty application
 func EmptyApp() *App {
 	app := &App{}
 	app.signalLock = &sync.RWMutex{}
+	app.reloads = &reloadHistory{}
+	app.reloadQueue = &reloadQueue{policy: config.ReloadWait}
+	app.pings = &pingProbe{}
+	app.graceOver = make(chan struct{})
 	return app
 }
 
 // NewApp creates a new App from the config
 func NewApp(configFlag string) (*App, error) {
-	os.Setenv("CONTAINERPILOT_PID", fmt.Sprintf("%v", os.Getpid()))
-	a := EmptyApp()
+	// the config can refer to our PID before we know its envPrefix, so we
+	// set it with the default prefix while we load it. If the config sets
+	// another prefix we put back whatever was there, so that we don't
+	// clobber the PID of a ContainerPilot we're running under.
+	defaultPID := commands.DefaultEnvPrefix + "_PID"
+	inheritedPID, inherited := os.LookupEnv(defaultPID)
+	os.Setenv(defaultPID, fmt.Sprintf("%v", os.Getpid()))
 	cfg, err := config.LoadConfig(configFlag)
 	if err != nil {
 		return nil, err
 	}
+	if cfg.EnvPrefix != "" && cfg.EnvPrefix != commands.DefaultEnvPrefix {
+		if inherited {
+			os.Setenv(defaultPID, inheritedPID)
+		} else {
+			os.Unsetenv(defaultPID)
+		}
+	}
+	return newAppFromConfig(configFlag, cfg)
+}
+
+// newAppFromConfig creates a new App from the loaded config
+func newAppFromConfig(configFlag string, cfg *config.Config) (*App, error) {
+	a := EmptyApp()
+	commands.SetEnvPrefix(cfg.EnvPrefix)
+	commands.SetDeadLetter(cfg.DeadLetter)
+	os.Setenv(commands.EnvVar("PID"), fmt.Sprintf("%v", os.Getpid()))
 
 	if err := cfg.InitLogging(); err != nil {
 		return nil, err
@@ -68,20 +120,40 @@ func NewApp(configFlag string) (*App, error) {
 	a.ControlServer = cs
 
 	a.StopTimeout = cfg.StopTimeout
+	a.StopGrace = cfg.StopGrace
 	a.Discovery = cfg.Discovery
 	a.Jobs = jobs.FromConfigs(cfg.Jobs)
+	for _, job := range a.Jobs {
+		if job.Primary {
+			a.ControlServer.Primaries = append(a.ControlServer.Primaries, job)
+		}
+		if job.Service != nil {
+			a.ControlServer.Services = append(a.ControlServer.Services, job.Name)
+		}
+	}
 	a.Watches = watches.FromConfigs(cfg.Watches)
+	a.Webhooks = webhooks.FromConfigs(cfg.Webhooks, processStart)
+	a.Breaker = jobs.NewRestartBreaker(cfg.Breaker)
 	a.Telemetry = telemetry.NewTelemetry(cfg.Telemetry)
 	a.Telemetry.MonitorJobs(a.Jobs)
 	a.Telemetry.MonitorWatches(a.Watches)
 	a.ConfigFlag = configFlag // stash the old config
+	a.config = cfg
+	a.reloadQueue.setPolicy(cfg.ReloadPolicy)
+	a.ControlServer.SoftReload = a.SoftReload
+	a.ControlServer.ReloadHistory = a.ReloadHistory
+	a.ControlServer.JobEnv = a.JobEnv
+	a.ControlServer.Health = a.Health
+	a.ControlServer.CollectMetrics = a.CollectMetrics
 
-	// set an environment variable for each job IP address so that
-	// forked processes have access to this information
+	// set an environment variable for each job IP address and listen
+	// port so that forked processes have access to this information
 	for _, job := range a.Jobs {
 		if job.Service != nil {
 			envKey := getEnvVarNameFromService(job.Name)
 			os.Setenv(envKey, job.Service.IPAddress)
+			os.Setenv(getPortEnvVarNameFromService(job.Name),
+				strconv.Itoa(job.Service.ListenPort))
 		}
 	}
 
@@ -92,8 +164,14 @@ func NewApp(configFlag string) (*App, error) {
 func getEnvVarNameFromService(service string) string {
 	envKey := strings.ToUpper(service)
 	envKey = strings.Replace(envKey, "-", "_", -1)
-	envKey = fmt.Sprintf("CONTAINERPILOT_%v_IP", envKey)
-	return envKey
+	return commands.EnvVar(envKey + "_IP")
+}
+
+// Normalize the validated service name as a port environment variable
+func getPortEnvVarNameFromService(service string) string {
+	envKey := strings.ToUpper(service)
+	envKey = strings.Replace(envKey, "-", "_", -1)
+	return commands.EnvVar(envKey + "_PORT")
 }
 
 // Run starts the application and blocks until finished
@@ -139,34 +217,97 @@ func (a *App) Run() {
 			}
 		}()
 
+		a.signalLock.Lock()
 		a.Bus = events.NewEventBus()
+		a.signalLock.Unlock()
 		a.ControlServer.Run(ctx, a.Bus)
 		a.runTasks(ctx, completedCh)
 
-		if !a.Bus.Wait() {
-			if a.StopTimeout > 0 {
-				log.Debugf("killing all processes in %v seconds", a.StopTimeout)
-				tick := time.NewTimer(time.Duration(a.StopTimeout) * time.Second)
+		reloading, ok := a.waitForJobs()
+		if !ok {
+			log.Warnf("stop grace period is nearly over, killing processes " +
+				"of jobs that haven't stopped")
+			a.killJobs()
+			break
+		}
+		if !reloading {
+			if wait := a.stopWait(); wait > 0 {
+				log.Debugf("killing all processes in %v", wait)
+				tick := time.NewTimer(wait)
 				<-tick.C
 			}
-			for _, job := range a.Jobs {
-				log.Infof("killing processes for job %#v", job.Name)
-				job.Kill()
-			}
+			a.killJobs()
 			break
 		}
-		if err := a.reload(); err != nil {
+		if err := a.reloadQueue.do("reload", a.reload); err != nil {
 			log.Error(err)
 			break
 		}
 		close(completedCh)
 	}
+	a.ControlServer.Stop()
+}
+
+// waitForJobs waits for the EventBus to shut down and returns whether
+// it's for a reload, or false if the stop grace period runs out first
+func (a *App) waitForJobs() (reloading bool, ok bool) {
+	waited := make(chan bool, 1)
+	go func() {
+		waited <- a.Bus.Wait()
+	}()
+	select {
+	case reloading := <-waited:
+		return reloading, true
+	case <-a.graceOver:
+		return false, false
+	}
 }
 
-// Terminate kills the application
+// stopWait returns how long to wait after the jobs have stopped before
+// killing their processes: the stop timeout, but no longer than what's
+// left of the stop grace period
+func (a *App) stopWait() time.Duration {
+	wait := time.Duration(a.StopTimeout) * time.Second
+	a.signalLock.RLock()
+	stopBy := a.stopBy
+	a.signalLock.RUnlock()
+	if !stopBy.IsZero() {
+		if left := time.Until(stopBy); left < wait {
+			wait = left
+		}
+	}
+	return wait
+}
+
+func (a *App) killJobs() {
+	for _, job := range a.Jobs {
+		log.Infof("killing processes for job %#v", job.Name)
+		job.Kill()
+	}
+}
+
+// StartFailureCode returns the exit code of the first job that failed on
+// its first start and is configured to exit for that, or zero otherwise
+func (a *App) StartFailureCode() int {
+	for _, job := range a.Jobs {
+		if code := job.StartFailureCode(); code != 0 {
+			return code
+		}
+	}
+	return 0
+}
+
+// Terminate kills the application. If we've been told the grace period
+// the container runtime gives us to shut down, any processes still
+// running when it's nearly over are killed so that we can exit first.
 func (a *App) Terminate() {
 	a.signalLock.Lock()
 	defer a.signalLock.Unlock()
+	if a.StopGrace > 0 && a.stopBy.IsZero() {
+		budget := a.StopGrace - stopGraceMargin
+		a.stopBy = time.Now().Add(budget)
+		time.AfterFunc(budget, func() { close(a.graceOver) })
+	}
 	a.Bus.Shutdown()
 }
 
@@ -181,26 +322,220 @@ func (a *App) SignalEvent(sig string) {
 // updating the App with those changes. The EventBus should be
 // already shut down before we call this.
 func (a *App) reload() error {
-	newApp, err := NewApp(a.ConfigFlag)
-	if err != nil {
+	newApp, failedJobs, err := a.loadReload()
+	switch {
+	case err != nil && a.config == nil:
 		log.Errorf("error initializing config: %v", err)
+		a.reloads.add("reload", nil, err)
+		deregisterRemoved(a.Jobs, nil) // we're exiting
 		return err
+	case err != nil:
+		// roll back to the configuration that was running
+		log.Errorf("error initializing config, keeping the running "+
+			"configuration: %v", err)
+		a.reloads.add("reload", nil, err)
+		newApp, err = newAppFromConfig(a.ConfigFlag, a.config)
+		if err != nil {
+			log.Errorf("error restoring config: %v", err)
+			deregisterRemoved(a.Jobs, nil) // we're exiting
+			return err
+		}
+	default:
+		a.reloads.add("reload", a.config.Diff(newApp.config),
+			failedJobsError(failedJobs))
 	}
+	deregisterRemoved(a.Jobs, newApp.Jobs)
+	a.signalLock.Lock()
 	a.Discovery = newApp.Discovery
 	a.Jobs = newApp.Jobs
 	a.Watches = newApp.Watches
+	a.Webhooks = newApp.Webhooks
 	a.StopTimeout = newApp.StopTimeout
+	a.StopGrace = newApp.StopGrace
 	a.Telemetry = newApp.Telemetry
-	a.ControlServer = newApp.ControlServer
+	a.Breaker = newApp.Breaker
+	a.config = newApp.config
+	a.signalLock.Unlock()
+	a.reloadQueue.setPolicy(newApp.config.ReloadPolicy)
+	switch {
+	case a.ControlServer == nil:
+		a.ControlServer = newApp.ControlServer
+	case a.ControlServer.Addr == newApp.ControlServer.Addr:
+		// keep the running control server so that requests in flight
+		// during the reload aren't dropped
+		a.ControlServer.Primaries = newApp.ControlServer.Primaries
+		a.ControlServer.Services = newApp.ControlServer.Services
+	default:
+		a.ControlServer.Stop()
+		a.ControlServer = newApp.ControlServer
+		a.ControlServer.SoftReload = a.SoftReload
+		a.ControlServer.ReloadHistory = a.ReloadHistory
+		a.ControlServer.JobEnv = a.JobEnv
+		a.ControlServer.Health = a.Health
+		a.ControlServer.CollectMetrics = a.CollectMetrics
+	}
 	return nil
 }
 
+// loadReload creates the App for a reload. Under the bestEffort
+// reloadFailure policy of the running configuration, jobs that fail keep
+// their running configuration and their errors are returned by name.
+// Otherwise any failure fails the whole reload.
+func (a *App) loadReload() (*App, map[string]error, error) {
+	var cfg *config.Config
+	var failedJobs map[string]error
+	var err error
+	if a.config != nil && a.config.ReloadFailure == config.ReloadBestEffort {
+		cfg, failedJobs, err = config.LoadConfigBestEffort(a.ConfigFlag, a.config)
+	} else {
+		cfg, err = config.LoadConfig(a.ConfigFlag)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	newApp, err := newAppFromConfig(a.ConfigFlag, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newApp, failedJobs, nil
+}
+
+// failedJobsError reports the jobs that failed to apply in a reload, or
+// returns nil if there weren't any
+func failedJobsError(failedJobs map[string]error) error {
+	if len(failedJobs) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(failedJobs))
+	for name := range failedJobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		msgs[i] = failedJobs[name].Error()
+		log.Errorf("job %s failed to reload, keeping its running "+
+			"configuration: %v", name, failedJobs[name])
+	}
+	return fmt.Errorf("jobs failed to reload and kept their running "+
+		"configuration: %s", strings.Join(msgs, "; "))
+}
+
+// deregisterRemoved deregisters the services that the previous jobs left
+// registered across a reload but that none of the next jobs will update
+func deregisterRemoved(prev, next []*jobs.Job) {
+	ids := map[string]bool{}
+	for _, job := range next {
+		if job.Service != nil {
+			ids[job.Service.ID] = true
+		}
+	}
+	for _, job := range prev {
+		if job.KeepsRegistration() && !ids[job.Service.ID] {
+			job.Service.Deregister()
+		}
+	}
+}
+
+// SoftReload reloads the configuration and replaces the running watches
+// with the new ones, without touching the jobs. It refuses to if anything
+// but the watches has changed, because that needs a full reload. Soft
+// reloads are queued behind any other reload that's running.
+func (a *App) SoftReload() error {
+	return a.reloadQueue.do("softReload", a.softReload)
+}
+
+func (a *App) softReload() error {
+	a.signalLock.Lock()
+	defer a.signalLock.Unlock()
+	cfg, err := config.LoadConfig(a.ConfigFlag)
+	if err != nil {
+		err = fmt.Errorf("error initializing config: %v", err)
+		a.reloads.add("softReload", nil, err)
+		return err
+	}
+	changes := a.config.Diff(cfg)
+	if !a.config.OnlyWatchesChanged(cfg) {
+		err = fmt.Errorf("configuration changed outside of watches, " +
+			"a full reload is required")
+		a.reloads.add("softReload", changes, err)
+		return err
+	}
+	if a.watchCancel == nil || a.tasksCtx.Err() != nil {
+		err = fmt.Errorf("watches are not running")
+		a.reloads.add("softReload", changes, err)
+		return err
+	}
+	// the running jobs started by watches need the upstreams of the new
+	// ones, because those of the old ones stop finding instances
+	upstreams := watches.Upstreams(cfg.Watches)
+	for _, job := range a.Jobs {
+		if err := job.UseWatchUpstreams(upstreams); err != nil {
+			a.reloads.add("softReload", changes, err)
+			return err
+		}
+	}
+	newWatches := watches.FromConfigs(cfg.Watches)
+	a.watchCancel()
+	ctx, cancel := context.WithCancel(a.tasksCtx)
+	for _, watch := range newWatches {
+		watch.Run(ctx, a.Bus)
+	}
+	a.Watches = newWatches
+	a.watchCancel = cancel
+	a.config = cfg
+	a.Telemetry.MonitorWatches(a.Watches)
+	log.Infof("soft reloaded %d watches", len(newWatches))
+	a.reloads.add("softReload", changes, nil)
+	return nil
+}
+
+// ReloadHistory returns the most recent reloads, oldest first, with
+// the changes each made to the configuration
+func (a *App) ReloadHistory() interface{} {
+	return a.reloads.list()
+}
+
+// JobEnv returns the environment that each job's exec was last started
+// with, by job name, leaving out the jobs that haven't started yet
+func (a *App) JobEnv() interface{} {
+	a.signalLock.RLock()
+	defer a.signalLock.RUnlock()
+	env := map[string]map[string]jobs.EnvValue{}
+	for _, job := range a.Jobs {
+		if jobEnv := job.Env(); jobEnv != nil {
+			env[job.Name] = jobEnv
+		}
+	}
+	return env
+}
+
+// CollectMetrics runs the sensors of the telemetry metrics now and
+// returns the values they recorded, or an error if there's no telemetry.
+func (a *App) CollectMetrics() (map[string]float64, error) {
+	// a reload replaces the telemetry, so we take it under the lock but
+	// run the sensors after releasing it
+	a.signalLock.RLock()
+	telem := a.Telemetry
+	a.signalLock.RUnlock()
+	if telem == nil {
+		return nil, fmt.Errorf("telemetry is not configured")
+	}
+	return telem.Collect(), nil
+}
+
 // HandlePolling sets up polling functions and write their quit channels
 // back to our config
 func (a *App) runTasks(ctx context.Context, completedCh chan struct{}) {
 	// we need to subscribe to events before we Run all the jobs
 	// to avoid races where a job finishes and fires events before
 	// other jobs are even subscribed to listen for them.
+	if a.Breaker != nil {
+		a.Breaker.Run(ctx, a.Bus)
+	}
+	for _, webhook := range a.Webhooks {
+		webhook.Run(ctx, a.Bus)
+	}
 	for _, job := range a.Jobs {
 		job.Subscribe(a.Bus)
 		job.Register(a.Bus)
@@ -208,9 +543,15 @@ func (a *App) runTasks(ctx context.Context, completedCh chan struct{}) {
 	for _, job := range a.Jobs {
 		job.Run(ctx, completedCh)
 	}
+	// watches get their own context so that SoftReload can replace them
+	a.signalLock.Lock()
+	a.tasksCtx = ctx
+	watchCtx, watchCancel := context.WithCancel(ctx)
+	a.watchCancel = watchCancel
 	for _, watch := range a.Watches {
-		watch.Run(ctx, a.Bus)
+		watch.Run(watchCtx, a.Bus)
 	}
+	a.signalLock.Unlock()
 	if a.Telemetry != nil {
 		for _, metric := range a.Telemetry.Metrics {
 			metric.Run(ctx, a.Bus)
@@ -1,17 +1,27 @@
 package core
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/joyent/containerpilot/commands"
+	"github.com/joyent/containerpilot/config"
 	"github.com/joyent/containerpilot/discovery"
 	"github.com/joyent/containerpilot/events"
 	"github.com/joyent/containerpilot/jobs"
+	"github.com/joyent/containerpilot/tests"
 	"github.com/joyent/containerpilot/tests/mocks"
 )
 
@@ -86,6 +96,9 @@ func TestMetricServiceCreation(t *testing.T) {
 		if service.Name != "containerpilot" {
 			t.Errorf("got incorrect service back: %v", service)
 		}
+		if port := os.Getenv("CONTAINERPILOT_CONTAINERPILOT_PORT"); port != "9090" {
+			t.Errorf("expected CONTAINERPILOT_CONTAINERPILOT_PORT=9090 but got %q", port)
+		}
 		for _, envVar := range os.Environ() {
 			if strings.HasPrefix(envVar, "CONTAINERPILOT_CONTAINERPILOT_IP") {
 				return
@@ -95,6 +108,25 @@ func TestMetricServiceCreation(t *testing.T) {
 	}
 }
 
+func TestPrimaryJobs(t *testing.T) {
+	f := testCfgToTempFile(t, `{
+	"consul": "consul:8500",
+	"jobs": [
+		{"name": "app", "exec": "true", "primary": true,
+		 "health": {"exec": "true", "interval": 1, "ttl": 5}},
+		{"name": "logship", "exec": "true"}
+	]
+  }`)
+	defer os.Remove(f.Name())
+	app, err := NewApp(f.Name())
+	if err != nil {
+		t.Fatalf("got error while initializing config: %v", err)
+	}
+	if assert.Len(t, app.ControlServer.Primaries, 1) {
+		assert.Equal(t, app.Jobs[0], app.ControlServer.Primaries[0])
+	}
+}
+
 // Test configuration reload
 func TestReloadConfig(t *testing.T) {
 	cfg := &jobs.Config{
@@ -146,6 +178,439 @@ func TestReloadConfig(t *testing.T) {
 	}
 }
 
+func TestSoftReload(t *testing.T) {
+	cfgText := `{"consul": "consul:8500",
+	"jobs": [{"name": "app", "exec": "%s"}],
+	"watches": [{"name": "%s", "interval": 100}]}`
+	f := testCfgToTempFile(t, fmt.Sprintf(cfgText, "sleep 10", "upstreamA"))
+	defer os.Remove(f.Name())
+	app, err := NewApp(f.Name())
+	if err != nil {
+		t.Fatalf("got error while initializing config: %v", err)
+	}
+	app.Bus = events.NewEventBus()
+	sub := &events.Subscriber{Rx: make(chan events.Event, 100)}
+	sub.Subscribe(app.Bus)
+	ctx, cancel := context.WithCancel(context.Background())
+	app.runTasks(ctx, make(chan struct{}, 1))
+	job := app.Jobs[0]
+	time.Sleep(100 * time.Millisecond) // let the job start
+	defer func() {
+		sub.Unsubscribe()
+		job.Kill()
+		app.Bus.Shutdown()
+		cancel()
+		app.Bus.Wait()
+	}()
+
+	rewrite := func(exec, watch string) {
+		err := ioutil.WriteFile(f.Name(),
+			[]byte(fmt.Sprintf(cfgText, exec, watch)), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rewrite("sleep 10", "upstreamB")
+	if err := app.SoftReload(); err != nil {
+		t.Fatalf("unexpected error in SoftReload: %v", err)
+	}
+	if assert.Len(t, app.Watches, 1) {
+		assert.Equal(t, "watch.upstreamB", app.Watches[0].Name)
+		assert.Equal(t, "CONTAINERPILOT_UPSTREAMB_EVENT", app.Watches[0].EnvName())
+	}
+
+	rewrite("sleep 20", "upstreamC")
+	assert.EqualError(t, app.SoftReload(),
+		"configuration changed outside of watches, a full reload is required")
+	assert.Equal(t, "watch.upstreamB", app.Watches[0].Name)
+
+	assert.Equal(t, job, app.Jobs[0])
+	for {
+		select {
+		case event := <-sub.Rx:
+			if event.Source == "app" {
+				t.Fatalf("job should not have been restarted, got %v", event)
+			}
+		default:
+			return
+		}
+	}
+}
+
+func TestReloadHistory(t *testing.T) {
+	cfgText := `{"consul": "consul:8500",
+	"jobs": [{"name": "app", "exec": "%s"}],
+	"watches": [{"name": "%s", "interval": 100}]}`
+	f := testCfgToTempFile(t, fmt.Sprintf(cfgText, "sleep 10", "upstreamA"))
+	defer os.Remove(f.Name())
+	app, err := NewApp(f.Name())
+	if err != nil {
+		t.Fatalf("got error while initializing config: %v", err)
+	}
+	app.Bus = events.NewEventBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	app.runTasks(ctx, make(chan struct{}, 1))
+	job := app.Jobs[0]
+	time.Sleep(100 * time.Millisecond) // let the job start
+	defer func() {
+		job.Kill()
+		app.Bus.Shutdown()
+		cancel()
+		app.Bus.Wait()
+	}()
+
+	rewrite := func(exec, watch string) {
+		err := ioutil.WriteFile(f.Name(),
+			[]byte(fmt.Sprintf(cfgText, exec, watch)), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rewrite("sleep 10", "upstreamB")
+	assert.NoError(t, app.SoftReload())
+	rewrite("sleep 20", "upstreamB")
+	assert.Error(t, app.SoftReload())
+
+	history := app.ReloadHistory().([]reloadRecord)
+	if !assert.Len(t, history, 2) {
+		return
+	}
+	assert.Equal(t, "softReload", history[0].Trigger)
+	assert.True(t, history[0].Success)
+	watch := func(name string) map[string]interface{} {
+		return map[string]interface{}{"name": name, "interval": float64(100)}
+	}
+	assert.Equal(t, []config.Change{
+		{Section: "watches", Name: "upstreamA", Action: "removed",
+			Before: watch("upstreamA")},
+		{Section: "watches", Name: "upstreamB", Action: "added",
+			After: watch("upstreamB")},
+	}, history[0].Changes)
+
+	assert.Equal(t, "softReload", history[1].Trigger)
+	assert.False(t, history[1].Success)
+	assert.Equal(t, "configuration changed outside of watches, "+
+		"a full reload is required", history[1].Error)
+	assert.Equal(t, []config.Change{
+		{Section: "jobs", Name: "app", Action: "changed",
+			Fields: map[string]config.FieldChange{
+				"exec": {Before: "sleep 10", After: "sleep 20"}}},
+	}, history[1].Changes)
+	assert.False(t, history[1].Time.Before(history[0].Time))
+}
+
+func TestReloadHistoryLimit(t *testing.T) {
+	h := &reloadHistory{}
+	for i := 0; i < reloadHistorySize+2; i++ {
+		h.add("reload", nil, fmt.Errorf("reload %d", i))
+	}
+	history := h.list()
+	assert.Len(t, history, reloadHistorySize)
+	assert.Equal(t, "reload 2", history[0].Error)
+	assert.Equal(t, []config.Change{}, history[0].Changes)
+}
+
+func TestReloadFailure(t *testing.T) {
+	cfgText := `{"consul": "consul:8500", "reloadFailure": "%s",
+	"jobs": [{"name": "a", "exec": "%s"},
+	         {"name": "b", "exec": "sleep 10", "restarts": "%s"}]}`
+
+	// reloads with a valid change to job "a" and a failing one to job "b"
+	reload := func(policy string) *App {
+		f := testCfgToTempFile(t, fmt.Sprintf(cfgText, policy, "sleep 10", "never"))
+		defer os.Remove(f.Name())
+		app, err := NewApp(f.Name())
+		if err != nil {
+			t.Fatalf("got error while initializing config: %v", err)
+		}
+		err = ioutil.WriteFile(f.Name(), []byte(
+			fmt.Sprintf(cfgText, policy, "sleep 20", "bogus")), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.NoError(t, app.reload())
+		return app
+	}
+	execs := func(app *App) map[string]interface{} {
+		result := map[string]interface{}{}
+		for _, job := range app.config.Jobs {
+			result[job.Name] = job.Exec
+		}
+		return result
+	}
+	restartsErr := `job[b].restarts field 'bogus' invalid: ` +
+		`accepts positive integers, "unlimited", or "never"`
+
+	app := reload(config.ReloadAllOrNothing)
+	assert.Equal(t, map[string]interface{}{"a": "sleep 10", "b": "sleep 10"},
+		execs(app), "expected every job to keep its running configuration")
+	assert.Len(t, app.Jobs, 2)
+	history := app.ReloadHistory().([]reloadRecord)
+	if assert.Len(t, history, 1) {
+		assert.False(t, history[0].Success)
+		assert.Equal(t, "unable to parse jobs: "+restartsErr, history[0].Error)
+	}
+
+	app = reload(config.ReloadBestEffort)
+	assert.Equal(t, map[string]interface{}{"a": "sleep 20", "b": "sleep 10"},
+		execs(app), "expected only the valid job change to be applied")
+	assert.Len(t, app.Jobs, 2)
+	history = app.ReloadHistory().([]reloadRecord)
+	if assert.Len(t, history, 1) {
+		assert.False(t, history[0].Success)
+		assert.Equal(t, "jobs failed to reload and kept their running "+
+			"configuration: "+restartsErr, history[0].Error)
+		assert.Equal(t, []config.Change{
+			{Section: "jobs", Name: "a", Action: "changed",
+				Fields: map[string]config.FieldChange{
+					"exec": {Before: "sleep 10", After: "sleep 20"}}},
+		}, history[0].Changes)
+	}
+}
+
+func TestReloadQueue(t *testing.T) {
+	// each reload records when it starts and finishes, so that we can
+	// tell if any of them interleaved
+	run := func(policy string, kinds ...string) (runs []string, errs []error) {
+		q := &reloadQueue{policy: policy}
+		lock := &sync.Mutex{}
+		record := func(s string) {
+			lock.Lock()
+			defer lock.Unlock()
+			runs = append(runs, s)
+		}
+		reload := func(kind string) func() error {
+			return func() error {
+				record("start " + kind)
+				time.Sleep(50 * time.Millisecond)
+				record("end " + kind)
+				return nil
+			}
+		}
+		wg := &sync.WaitGroup{}
+		errCh := make(chan error, len(kinds))
+		for _, kind := range kinds {
+			wg.Add(1)
+			go func(kind string) {
+				defer wg.Done()
+				errCh <- q.do(kind, reload(kind))
+			}(kind)
+			time.Sleep(10 * time.Millisecond)
+		}
+		wg.Wait()
+		close(errCh)
+		for err := range errCh {
+			errs = append(errs, err)
+		}
+		return runs, errs
+	}
+
+	runs, errs := run(config.ReloadWait, "reload", "reload", "reload")
+	assert.Equal(t, []string{"start reload", "end reload", "start reload",
+		"end reload", "start reload", "end reload"}, runs)
+	assert.Equal(t, []error{nil, nil, nil}, errs)
+
+	// the 2nd and 3rd requests arrive while the 1st is running, so
+	// they're applied together
+	runs, errs = run(config.ReloadCoalesce, "reload", "reload", "reload")
+	assert.Equal(t, []string{"start reload", "end reload",
+		"start reload", "end reload"}, runs)
+	assert.Equal(t, []error{nil, nil, nil}, errs)
+
+	// a reload never joins a pending soft reload, or it would be dropped
+	runs, errs = run(config.ReloadCoalesce, "reload", "softReload", "reload")
+	assert.Equal(t, 3, strings.Count(strings.Join(runs, ","), "start"),
+		"expected the soft reload and both reloads to run")
+	assert.Equal(t, []error{nil, nil, nil}, errs)
+}
+
+func TestHealth(t *testing.T) {
+	disc := &mocks.PingingDiscoveryBackend{}
+	disc.SetPingError(fmt.Errorf("connection refused"))
+	app := EmptyApp()
+	app.Discovery = disc
+	app.Bus = events.NewEventBus()
+
+	// nothing needs the discovery backend
+	app.Jobs = []*jobs.Job{{Name: "setup"}}
+	assert.NoError(t, app.Health())
+
+	app.Jobs = append(app.Jobs, &jobs.Job{
+		Name: "app", Service: &discovery.ServiceDefinition{Name: "app"}})
+	assert.EqualError(t, app.Health(),
+		"unable to reach discovery backend: connection refused")
+	disc.SetPingError(nil)
+	assert.NoError(t, app.Health())
+
+	app.reloadQueue.started = time.Now().Add(-10 * time.Minute)
+	assert.EqualError(t, app.Health(), "reload has been running for 10m0s")
+	app.reloadQueue.started = time.Time{}
+
+	// a subscriber that never reads leaves the bus stuck publishing
+	stuck := &events.Subscriber{Rx: make(chan events.Event)}
+	stuck.Subscribe(app.Bus)
+	go app.Bus.Publish(events.GlobalStartup)
+	time.Sleep(10 * time.Millisecond)
+	assert.EqualError(t, app.Health(), "event bus is not accepting events")
+	<-stuck.Rx
+	assert.NoError(t, app.Health())
+}
+
+// hangingPinger is a discovery.Pinger whose pings don't return until
+// it's released
+type hangingPinger struct {
+	release chan struct{}
+	pings   int32
+}
+
+func (h *hangingPinger) Ping() error {
+	atomic.AddInt32(&h.pings, 1)
+	<-h.release
+	return nil
+}
+
+func TestHealthPingHung(t *testing.T) {
+	pinger := &hangingPinger{release: make(chan struct{})}
+	probe := &pingProbe{}
+	for i := 0; i < 5; i++ {
+		assert.EqualError(t, probe.ping(pinger, 10*time.Millisecond),
+			"timed out after 10ms")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&pinger.pings),
+		"expected health checks to share the hung ping")
+
+	close(pinger.release)
+	for i := 0; i < 100; i++ {
+		probe.lock.Lock()
+		idle := probe.pending == nil
+		probe.lock.Unlock()
+		if idle {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NoError(t, probe.ping(pinger, time.Second))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&pinger.pings),
+		"expected a new ping once the hung one returned")
+}
+
+func TestDeregisterRemoved(t *testing.T) {
+	registry := &mocks.RegistryDiscoveryBackend{}
+	newJobs := func(raw string) []*jobs.Job {
+		cfgs, err := jobs.NewConfigs(tests.DecodeRawToSlice(raw), registry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return jobs.FromConfigs(cfgs)
+	}
+	prev := newJobs(`[
+		{name: "svc-a", id: "a-id", exec: "true", port: 80, interfaces: ["inet", "lo0"],
+		 health: {exec: "true", interval: 10, ttl: 30}},
+		{name: "svc-b", id: "b-id", exec: "true", port: 80, interfaces: ["inet", "lo0"],
+		 health: {exec: "true", interval: 10, ttl: 30}},
+		{name: "svc-c", id: "c-id", exec: "true", port: 80, interfaces: ["inet", "lo0"],
+		 health: {exec: "true", interval: 10, ttl: 30}, consul: {onReload: "deregister"}}
+	]`)
+	next := newJobs(`[
+		{name: "svc-a", id: "a-id", exec: "true", port: 80, interfaces: ["inet", "lo0"],
+		 health: {exec: "true", interval: 10, ttl: 30}}
+	]`)
+
+	// the job with a stable ID is updated in place, and the job that
+	// deregisters on reload has already done so
+	deregisterRemoved(prev, next)
+	assert.Equal(t, []string{"b-id"}, registry.Deregistrations())
+}
+
+// Shutting down after a SIGTERM fits within the stop grace period
+func TestStopGrace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containerpilot-stop-grace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pidFile := filepath.Join(dir, "pid")
+	cfgText := `{"consul": "consul:8500", "stopTimeout": 1,
+	"control": {"socket": "%s"},
+	"jobs": [{"name": "app", "exec": ["sh", "-c", "echo $$ > %s; exec sleep 30"]
+	          %s}]}`
+
+	// runs the app until it has started its job, then terminates it and
+	// returns how long it took to shut down
+	terminate := func(grace time.Duration, jobExtra string) time.Duration {
+		f := testCfgToTempFile(t, fmt.Sprintf(cfgText,
+			filepath.Join(dir, "cp.sock"), pidFile, jobExtra))
+		defer os.Remove(f.Name())
+		os.Remove(pidFile)
+		app, err := NewApp(f.Name())
+		if err != nil {
+			t.Fatalf("got error while initializing config: %v", err)
+		}
+		app.StopGrace = grace
+		done := make(chan struct{})
+		go func() {
+			app.Run()
+			close(done)
+		}()
+		time.Sleep(500 * time.Millisecond) // let the job start
+		start := time.Now()
+		app.Terminate()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for shutdown")
+		}
+		return time.Since(start)
+	}
+	processExited := func() bool {
+		data, err := ioutil.ReadFile(pidFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+		for i := 0; i < 20; i++ {
+			if syscall.Kill(pid, 0) != nil {
+				return true
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		return false
+	}
+
+	// a long grace period doesn't stretch the wait after the jobs stop
+	elapsed := terminate(30*time.Second, "")
+	assert.True(t, elapsed < 3*time.Second,
+		"expected shutdown within the stop timeout, took %v", elapsed)
+	assert.True(t, processExited())
+
+	// a job that won't stop is killed before the grace period is over
+	elapsed = terminate(2*time.Second,
+		`, "drainGate": {"exec": "false", "interval": "100ms", "timeout": "60s"}`)
+	assert.True(t, elapsed >= time.Second && elapsed < 2*time.Second,
+		"expected shutdown within the grace period less its margin, took %v", elapsed)
+	assert.True(t, processExited(), "expected the job's process to be killed")
+}
+
+// A configured envPrefix doesn't clobber the PID of a ContainerPilot
+// we're running under
+func TestEnvPrefixPID(t *testing.T) {
+	os.Setenv("CONTAINERPILOT_PID", "1")
+	defer os.Unsetenv("CONTAINERPILOT_PID")
+	defer os.Unsetenv("CP_SIDECAR_PID")
+	defer commands.SetEnvPrefix("")
+
+	f := testCfgToTempFile(t, `{"consul": "consul:8500", envPrefix: "CP_SIDECAR",
+		jobs: [{name: "app", exec: "true"}]}`)
+	defer os.Remove(f.Name())
+	if _, err := NewApp(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "1", os.Getenv("CONTAINERPILOT_PID"))
+	assert.Equal(t, fmt.Sprintf("%v", os.Getpid()), os.Getenv("CP_SIDECAR_PID"))
+}
+
 // ----------------------------------------------------
 // test helpers
 
@@ -48,11 +48,13 @@ func GetArgs() (subcommands.Handler, subcommands.Params) {
 	var versionFlag bool
 	var templateFlag bool
 	var reloadFlag bool
+	var softReloadFlag bool
 	var pingFlag bool
 
 	var configPath string
 	var renderFlag string
 	var maintFlag string
+	var checkFlag string
 
 	var putMetricFlags MultiFlag
 	var putEnvFlags MultiFlag
@@ -67,6 +69,10 @@ func GetArgs() (subcommands.Handler, subcommands.Params) {
 		flag.BoolVar(&reloadFlag, "reload", false,
 			"Reload a ContainerPilot process through its control socket.")
 
+		flag.BoolVar(&softReloadFlag, "softreload", false,
+			`Reload only the watches of a ContainerPilot process through its control
+	socket, without restarting any jobs.`)
+
 		flag.StringVar(&configPath, "config", "",
 			"File path to JSON5 configuration file. Defaults to CONTAINERPILOT env var.")
 
@@ -89,6 +95,10 @@ func GetArgs() (subcommands.Handler, subcommands.Params) {
 		flag.BoolVar(&pingFlag, "ping", false,
 			"Check that the ContainerPilot control socket is up.")
 
+		flag.StringVar(&checkFlag, "check", "",
+			`Run the health check of the named job once and exit with its result.
+	Intended for use as a container HEALTHCHECK.`)
+
 		flag.Parse()
 	}
 
@@ -112,6 +122,11 @@ func GetArgs() (subcommands.Handler, subcommands.Params) {
 			ConfigPath: configPath,
 		}
 	}
+	if softReloadFlag {
+		return subcommands.SoftReloadHandler, subcommands.Params{
+			ConfigPath: configPath,
+		}
+	}
 	if maintFlag != "" {
 		return subcommands.MaintenanceHandler, subcommands.Params{
 			ConfigPath:      configPath,
@@ -130,6 +145,12 @@ func GetArgs() (subcommands.Handler, subcommands.Params) {
 			Metrics:    putMetricFlags.Values,
 		}
 	}
+	if checkFlag != "" {
+		return subcommands.CheckHandler, subcommands.Params{
+			ConfigPath: configPath,
+			CheckName:  checkFlag,
+		}
+	}
 	if pingFlag {
 		return subcommands.GetPingHandler, subcommands.Params{
 			ConfigPath: configPath,
@@ -7,6 +7,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/joyent/containerpilot/subcommands"
 )
 
 func TestInvalidConfigNoConfigFlag(t *testing.T) {
@@ -91,6 +93,29 @@ func TestSetEqual(t *testing.T) {
 	}
 }
 
+func TestCheckFlag(t *testing.T) {
+	f1 := testCfgToTempFile(t, `{"consul": "consul:8500", jobs: [
+	{name: "passing", exec: "sleep 10", health: {exec: "true", interval: 1, ttl: 5}},
+	{name: "failing", exec: "sleep 10", health: {exec: "false", interval: 1, ttl: 5}},
+	{name: "warning", exec: "sleep 10", health: {exec: ["sh", "-c", "exit 3"], interval: 1, ttl: 5}}]}`)
+	defer os.Remove(f1.Name())
+
+	// the exit code main exits with after running the subcommand
+	runCheck := func(name string) int {
+		defer argTestCleanup(argTestSetup())
+		os.Args = []string{"this", "-config", f1.Name(), "-check", name}
+		cmd, p := GetArgs()
+		if cmd == nil {
+			t.Fatal("expected -check to return a subcommand")
+		}
+		return subcommands.ExitCode(cmd(p))
+	}
+	assert.Equal(t, 0, runCheck("passing"))
+	assert.Equal(t, 1, runCheck("failing"))
+	assert.Equal(t, 3, runCheck("warning"))
+	assert.Equal(t, 1, runCheck("missing"))
+}
+
 // ----------------------------------------------------
 // test helpers
 
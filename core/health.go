@@ -0,0 +1,110 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/joyent/containerpilot/discovery"
+)
+
+const (
+	// how long the event bus may take to accept an event before we
+	// consider it stuck
+	busHealthTimeout = time.Second
+
+	// how long we wait for the discovery backend to answer
+	discoveryHealthTimeout = 5 * time.Second
+
+	// how long a reload may run before we consider it stuck
+	reloadHealthTimeout = 5 * time.Minute
+)
+
+// Health returns an error if ContainerPilot itself isn't working: its
+// event bus is stuck, a reload has been running for too long, or the
+// discovery backend can't be reached while any job or watch needs it.
+// This is independent of the health of the jobs.
+func (a *App) Health() error {
+	// a reload replaces these, so we take them under the lock but check
+	// them after releasing it so a slow check doesn't hold up the reload
+	a.signalLock.RLock()
+	bus, backend := a.Bus, a.Discovery
+	requiresDiscovery := a.requiresDiscovery()
+	a.signalLock.RUnlock()
+
+	if bus != nil && !bus.Responsive(busHealthTimeout) {
+		return fmt.Errorf("event bus is not accepting events")
+	}
+	if running := a.reloadQueue.runningFor(); running > reloadHealthTimeout {
+		return fmt.Errorf("reload has been running for %v",
+			running.Truncate(time.Second))
+	}
+	if pinger, ok := backend.(discovery.Pinger); ok && requiresDiscovery {
+		if err := a.pings.ping(pinger, discoveryHealthTimeout); err != nil {
+			return fmt.Errorf("unable to reach discovery backend: %v", err)
+		}
+	}
+	return nil
+}
+
+// requiresDiscovery returns true if any job registers a service or any
+// watch checks the discovery backend. The caller holds the signalLock.
+func (a *App) requiresDiscovery() bool {
+	for _, job := range a.Jobs {
+		if job.Service != nil {
+			return true
+		}
+	}
+	for _, watch := range a.Watches {
+		if watch.UsesDiscovery() {
+			return true
+		}
+	}
+	return false
+}
+
+// pingProbe shares a single outstanding ping of the discovery backend
+// between health checks. The backend's client may never time out, so a
+// hung backend would otherwise accumulate a goroutine for every check.
+type pingProbe struct {
+	lock    sync.Mutex
+	pinger  discovery.Pinger
+	pending *pendingPing
+}
+
+// pendingPing is a ping that's running; err is set before done is closed
+type pendingPing struct {
+	done chan struct{}
+	err  error
+}
+
+// ping returns the result of pinging the backend, joining the ping that's
+// running if there is one, or an error if it takes longer than the timeout
+func (p *pingProbe) ping(pinger discovery.Pinger, timeout time.Duration) error {
+	p.lock.Lock()
+	pending := p.pending
+	if pending == nil || p.pinger != pinger {
+		// a reload replaced the backend, so we don't wait on the old one
+		pending = &pendingPing{done: make(chan struct{})}
+		p.pinger, p.pending = pinger, pending
+		go func() {
+			pending.err = pinger.Ping()
+			p.lock.Lock()
+			if p.pending == pending {
+				p.pinger, p.pending = nil, nil
+			}
+			p.lock.Unlock()
+			close(pending.done)
+		}()
+	}
+	p.lock.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-pending.done:
+		return pending.err
+	case <-timer.C:
+		return fmt.Errorf("timed out after %v", timeout)
+	}
+}
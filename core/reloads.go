@@ -0,0 +1,140 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/joyent/containerpilot/config"
+)
+
+// Number of reloads we keep in the history
+const reloadHistorySize = 10
+
+// reloadRecord is an entry in the history of reloads. The changes are
+// empty if the new configuration couldn't be loaded.
+type reloadRecord struct {
+	Time    time.Time       `json:"time"`
+	Trigger string          `json:"trigger"` // "reload" or "softReload"
+	Changes []config.Change `json:"changes"`
+	Success bool            `json:"success"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// reloadHistory keeps the most recent reloads, oldest first
+type reloadHistory struct {
+	records []reloadRecord
+	lock    sync.RWMutex
+}
+
+func (h *reloadHistory) add(trigger string, changes []config.Change, err error) {
+	rec := reloadRecord{
+		Time:    time.Now(),
+		Trigger: trigger,
+		Changes: changes,
+		Success: err == nil,
+	}
+	if rec.Changes == nil {
+		rec.Changes = []config.Change{}
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.records = append(h.records, rec)
+	if len(h.records) > reloadHistorySize {
+		h.records = h.records[len(h.records)-reloadHistorySize:]
+	}
+}
+
+func (h *reloadHistory) list() []reloadRecord {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	records := make([]reloadRecord, len(h.records))
+	copy(records, h.records)
+	return records
+}
+
+// reloadQueue runs reloads one at a time. Under the "wait" policy each
+// request waits for its turn and is applied separately. Under the
+// "coalesce" policy all the requests of the same kind made while a reload
+// is running are applied together by a single reload once it finishes,
+// and each of them gets its result. Requests of different kinds, such as
+// a reload and a soft reload, are never applied by the same reload.
+type reloadQueue struct {
+	running sync.Mutex // held while a reload runs
+
+	lock    sync.Mutex
+	policy  string
+	pending map[string]*pendingReload // by kind
+	started time.Time                 // of the running reload, or zero
+}
+
+// pendingReload is a reload that's waiting for the running one to finish
+type pendingReload struct {
+	done chan struct{}
+	err  error
+}
+
+func (q *reloadQueue) setPolicy(policy string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.policy = policy
+}
+
+// do runs the reload of the given kind once the one running, if any, has
+// finished, and returns its error
+func (q *reloadQueue) do(kind string, reload func() error) error {
+	q.lock.Lock()
+	if q.policy != config.ReloadCoalesce {
+		q.lock.Unlock()
+		q.running.Lock()
+		defer q.running.Unlock()
+		return q.run(reload)
+	}
+	if p := q.pending[kind]; p != nil {
+		q.lock.Unlock()
+		<-p.done
+		return p.err
+	}
+	p := &pendingReload{done: make(chan struct{})}
+	if q.pending == nil {
+		q.pending = map[string]*pendingReload{}
+	}
+	q.pending[kind] = p
+	q.lock.Unlock()
+
+	q.running.Lock()
+	// requests made from here on need another reload to be applied
+	q.lock.Lock()
+	delete(q.pending, kind)
+	q.lock.Unlock()
+	p.err = q.run(reload)
+	q.running.Unlock()
+	close(p.done)
+	return p.err
+}
+
+// run runs the reload, recording how long it's been running
+func (q *reloadQueue) run(reload func() error) error {
+	q.lock.Lock()
+	q.started = time.Now()
+	q.lock.Unlock()
+	defer func() {
+		q.lock.Lock()
+		q.started = time.Time{}
+		q.lock.Unlock()
+	}()
+	return reload()
+}
+
+// runningFor returns how long the running reload has been running, or
+// zero if there isn't one
+func (q *reloadQueue) runningFor() time.Duration {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.started.IsZero() {
+		return 0
+	}
+	return time.Since(q.started)
+}
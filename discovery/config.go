@@ -1,18 +1,81 @@
 package discovery
 
 import (
+	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/joyent/containerpilot/config/decode"
+	"github.com/joyent/containerpilot/config/timing"
+)
+
+// defaults for the pool of connections to the Consul agent. All our
+// requests go to the same agent, so the pool is sized for that one host
+// rather than the usual small per-host limit.
+const (
+	defaultMaxIdleConns = 32
+	defaultIdleTimeout  = 90 * time.Second
+	defaultKeepAlive    = 30 * time.Second
 )
 
 type parsedConfig struct {
-	Address string          `mapstructure:"address"`
-	Scheme  string          `mapstructure:"scheme"`
-	Token   string          `mapstructure:"token"`
-	TLS     parsedTLSConfig `mapstructure:"tls"` // optional TLS settings
+	Address string           `mapstructure:"address"`
+	Scheme  string           `mapstructure:"scheme"`
+	Token   string           `mapstructure:"token"`
+	TLS     parsedTLSConfig  `mapstructure:"tls"`  // optional TLS settings
+	Pool    parsedPoolConfig `mapstructure:"pool"` // optional connection pooling
+}
+
+type parsedPoolConfig struct {
+	MaxIdleConns int    `mapstructure:"maxIdleConns"`
+	IdleTimeout  string `mapstructure:"idleTimeout"`
+	KeepAlive    string `mapstructure:"keepAlive"`
+}
+
+// poolConfig tunes the reuse of connections to the Consul agent
+type poolConfig struct {
+	maxIdleConns int
+	idleTimeout  time.Duration
+	keepAlive    time.Duration
+}
+
+func defaultPoolConfig() poolConfig {
+	return poolConfig{
+		maxIdleConns: defaultMaxIdleConns,
+		idleTimeout:  defaultIdleTimeout,
+		keepAlive:    defaultKeepAlive,
+	}
+}
+
+// getPoolConfig fills in the defaults for any pool settings that
+// weren't set
+func getPoolConfig(parsed parsedPoolConfig) (poolConfig, error) {
+	pool := defaultPoolConfig()
+	if parsed.MaxIdleConns < 0 {
+		return pool, fmt.Errorf("consul.pool.maxIdleConns must be >= 0")
+	}
+	if parsed.MaxIdleConns > 0 {
+		pool.maxIdleConns = parsed.MaxIdleConns
+	}
+	if parsed.IdleTimeout != "" {
+		timeout, err := timing.GetTimeout(parsed.IdleTimeout)
+		if err != nil {
+			return pool, fmt.Errorf("unable to parse consul.pool.idleTimeout '%s': %v",
+				parsed.IdleTimeout, err)
+		}
+		pool.idleTimeout = timeout
+	}
+	if parsed.KeepAlive != "" {
+		keepAlive, err := timing.GetTimeout(parsed.KeepAlive)
+		if err != nil {
+			return pool, fmt.Errorf("unable to parse consul.pool.keepAlive '%s': %v",
+				parsed.KeepAlive, err)
+		}
+		pool.keepAlive = keepAlive
+	}
+	return pool, nil
 }
 
 type parsedTLSConfig struct {
@@ -60,10 +123,14 @@ func getTLSConfig(parsed *parsedConfig) api.TLSConfig {
 	return tlsConfig
 }
 
-func configFromMap(raw map[string]interface{}) (*api.Config, error) {
+func configFromMap(raw map[string]interface{}) (*api.Config, poolConfig, error) {
 	parsed := &parsedConfig{}
 	if err := decode.ToStruct(raw, parsed); err != nil {
-		return nil, err
+		return nil, poolConfig{}, err
+	}
+	pool, err := getPoolConfig(parsed.Pool)
+	if err != nil {
+		return nil, pool, err
 	}
 	config := &api.Config{
 		Address:   parsed.Address,
@@ -71,10 +138,10 @@ func configFromMap(raw map[string]interface{}) (*api.Config, error) {
 		Token:     parsed.Token,
 		TLSConfig: getTLSConfig(parsed),
 	}
-	return config, nil
+	return config, pool, nil
 }
 
-func configFromURI(uri string) (*api.Config, error) {
+func configFromURI(uri string) (*api.Config, poolConfig, error) {
 	address, scheme := parseRawURI(uri)
 	parsed := &parsedConfig{Address: address, Scheme: scheme}
 	config := &api.Config{
@@ -83,7 +150,7 @@ func configFromURI(uri string) (*api.Config, error) {
 		Token:     parsed.Token,
 		TLSConfig: getTLSConfig(parsed),
 	}
-	return config, nil
+	return config, defaultPoolConfig(), nil
 }
 
 // Returns the uri broken into an address and scheme portion
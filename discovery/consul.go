@@ -32,12 +32,13 @@ type Consul struct {
 // NewConsul creates a new service discovery backend for Consul
 func NewConsul(config interface{}) (*Consul, error) {
 	var consulConfig *api.Config
+	var pool poolConfig
 	var err error
 	switch t := config.(type) {
 	case string:
-		consulConfig, err = configFromURI(t)
+		consulConfig, pool, err = configFromURI(t)
 	case map[string]interface{}:
-		consulConfig, err = configFromMap(t)
+		consulConfig, pool, err = configFromMap(t)
 	default:
 		return nil, fmt.Errorf("no discovery backend defined")
 	}
@@ -48,6 +49,14 @@ func NewConsul(config interface{}) (*Consul, error) {
 	if token := os.Getenv("CONSUL_HTTP_TOKEN"); token != "" {
 		consulConfig.Token = token
 	}
+	transport := newTransport(pool)
+	consulConfig.Transport = transport
+	httpClient, err := api.NewHttpClient(transport, consulConfig.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	httpClient.Transport = reconnectingTransport{transport}
+	consulConfig.HttpClient = httpClient
 	client, err := api.NewClient(consulConfig)
 	if err != nil {
 		return nil, err
@@ -81,47 +90,98 @@ func (c *Consul) ServiceDeregister(serviceID string) error {
 	return c.Agent().ServiceDeregister(serviceID)
 }
 
+// Ping asks Consul for the address of its cluster leader, to check that
+// the agent is reachable and part of a cluster that has a leader
+func (c *Consul) Ping() error {
+	leader, err := c.Status().Leader()
+	if err != nil {
+		return err
+	}
+	if leader == "" {
+		return fmt.Errorf("consul has no cluster leader")
+	}
+	return nil
+}
+
 // CheckForUpstreamChanges requests the set of healthy instances of a
 // service from Consul and checks whether there has been a change since
 // the last check.
-func (c *Consul) CheckForUpstreamChanges(backendName, backendTag, dc string) (didChange, isHealthy bool) {
+func (c *Consul) CheckForUpstreamChanges(backendName, backendTag, dc string) (change UpstreamChange, isHealthy bool) {
 	opts := &api.QueryOptions{Datacenter: dc}
 	instances, meta, err := c.Health().Service(backendName, backendTag, true, opts)
 	if err != nil {
 		log.Warnf("failed to query %v: %s [%v]", backendName, err, meta)
-		return false, false
+		return NoChange, false
 	}
 	collector.WithLabelValues(backendName).Set(float64(len(instances)))
 	isHealthy = len(instances) > 0
-	didChange = c.compareAndSwap(backendName, instances)
-	return didChange, isHealthy
+	change = c.compareAndSwap(backendName, instances)
+	return change, isHealthy
 }
 
-// returns true if any addresses for the service changed and updates
-// the internal state
-func (c *Consul) compareAndSwap(service string, new []*api.ServiceEntry) bool {
+// InstanceCount returns the number of healthy instances of the service
+// found by the last CheckForUpstreamChanges
+func (c *Consul) InstanceCount(service string) int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return len(c.watchedServices[service])
+}
+
+// Instances returns the healthy instances of the service found by the
+// last CheckForUpstreamChanges, sorted by their IDs
+func (c *Consul) Instances(service string) []Instance {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	entries := append([]*api.ServiceEntry{}, c.watchedServices[service]...)
+	sort.Sort(ByServiceID(entries))
+	instances := make([]Instance, 0, len(entries))
+	for _, entry := range entries {
+		address := entry.Service.Address
+		if address == "" && entry.Node != nil {
+			// the service is registered at its node's address
+			address = entry.Node.Address
+		}
+		instances = append(instances, Instance{
+			ID:      entry.Service.ID,
+			Address: address,
+			Port:    entry.Service.Port,
+		})
+	}
+	return instances
+}
+
+// returns how the addresses for the service changed, if at all, and
+// updates the internal state
+func (c *Consul) compareAndSwap(service string, new []*api.ServiceEntry) UpstreamChange {
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	existing := c.watchedServices[service]
+	existing, seen := c.watchedServices[service]
 	c.watchedServices[service] = new
-	return compareForChange(existing, new)
+	change := compareForChange(existing, new)
+	if !seen && change != NoChange {
+		return InitialInstances
+	}
+	return change
 }
 
 // Compare the two arrays to see if the address or port has changed
 // or if we've added or removed entries.
-func compareForChange(existing, newEntries []*api.ServiceEntry) (changed bool) {
-	if len(existing) != len(newEntries) {
-		return true
+func compareForChange(existing, newEntries []*api.ServiceEntry) UpstreamChange {
+	if len(existing) < len(newEntries) {
+		return InstancesAdded
+	}
+	if len(existing) > len(newEntries) {
+		return InstancesRemoved
 	}
 	sort.Sort(ByServiceID(existing))
 	sort.Sort(ByServiceID(newEntries))
 	for i, ex := range existing {
 		if ex.Service.Address != newEntries[i].Service.Address ||
 			ex.Service.Port != newEntries[i].Service.Port {
-			return true
+			return InstancesChanged
 		}
 	}
-	return false
+	return NoChange
 }
 
 // ByServiceID implements the Sort interface because Go can't sort without it.
@@ -49,25 +49,37 @@ func TestCheckForChanges(t *testing.T) {
 
 	t0 := []*consul.ServiceEntry{}
 	didChange := c.compareAndSwap("test", t0)
-	assert.False(t, didChange, "value for 'didChange' after t0")
+	assert.Equal(t, NoChange, didChange, "value for 'didChange' after t0")
 
 	t1 := []*consul.ServiceEntry{
 		{Service: &consul.AgentService{Address: "1.2.3.4", Port: 80}},
 		{Service: &consul.AgentService{Address: "1.2.3.5", Port: 80}},
 	}
 	didChange = c.compareAndSwap("test", t1)
-	assert.True(t, didChange, "value for 'didChange' after t1")
+	assert.Equal(t, InstancesAdded, didChange, "value for 'didChange' after t1")
 
 	didChange = c.compareAndSwap("test", t0)
-	assert.True(t, didChange, "value for 'didChange' after t0 (again)")
+	assert.Equal(t, InstancesRemoved, didChange, "value for 'didChange' after t0 (again)")
 
 	didChange = c.compareAndSwap("test", t1)
-	assert.True(t, didChange, "value for 'didChange' after t1 (again)")
+	assert.Equal(t, InstancesAdded, didChange, "value for 'didChange' after t1 (again)")
 
 	t3 := []*consul.ServiceEntry{
 		{Service: &consul.AgentService{Address: "1.2.3.4", Port: 80}}}
 	didChange = c.compareAndSwap("test", t3)
-	assert.True(t, didChange, "value for 'didChange' after t3")
+	assert.Equal(t, InstancesRemoved, didChange, "value for 'didChange' after t3")
+
+	t4 := []*consul.ServiceEntry{
+		{Service: &consul.AgentService{Address: "1.2.3.6", Port: 80}}}
+	didChange = c.compareAndSwap("test", t4)
+	assert.Equal(t, InstancesChanged, didChange, "value for 'didChange' after t4")
+
+	didChange = c.compareAndSwap("other", t1)
+	assert.Equal(t, InitialInstances, didChange, "value for 'didChange' on first check")
+
+	assert.Equal(t, 1, c.InstanceCount("test"), "instances of 'test' after t4")
+	assert.Equal(t, 2, c.InstanceCount("other"), "instances of 'other' after t1")
+	assert.Equal(t, 0, c.InstanceCount("unseen"), "instances of unchecked service")
 }
 
 func TestWithConsul(t *testing.T) {
@@ -152,20 +164,20 @@ func testConsulCheckForChanges(testServer *TestServer) func(*testing.T) {
 		consul, _ := NewConsul(testServer.HTTPAddr)
 		service := generateServiceDefinition(backend, consul)
 		id := service.ID
-		if changed, _ := consul.CheckForUpstreamChanges(backend, "", ""); changed {
+		if changed, _ := consul.CheckForUpstreamChanges(backend, "", ""); changed != NoChange {
 			t.Fatalf("First read of %s should show `false` for change", id)
 		}
 		service.SendHeartbeat() // force registration and 1st heartbeat
 
-		if changed, _ := consul.CheckForUpstreamChanges(backend, "", ""); !changed {
+		if changed, _ := consul.CheckForUpstreamChanges(backend, "", ""); changed != InstancesAdded {
 			t.Errorf("%v should have changed after first health check TTL", id)
 		}
-		if changed, _ := consul.CheckForUpstreamChanges(backend, "", ""); changed {
+		if changed, _ := consul.CheckForUpstreamChanges(backend, "", ""); changed != NoChange {
 			t.Errorf("%v should not have changed without TTL expiring", id)
 		}
 		check := fmt.Sprintf("service:TestConsulCheckForChanges")
 		consul.Agent().UpdateTTL(check, "expired", "critical")
-		if changed, _ := consul.CheckForUpstreamChanges(backend, "", ""); !changed {
+		if changed, _ := consul.CheckForUpstreamChanges(backend, "", ""); changed != InstancesRemoved {
 			t.Errorf("%v should have changed after TTL expired.", id)
 		}
 	}
@@ -185,7 +197,7 @@ func testConsulEnableTagOverride(testServer *TestServer) func(*testing.T) {
 			Consul:            consul,
 		}
 		id := service.ID
-		if changed, _ := consul.CheckForUpstreamChanges(backend, "", ""); changed {
+		if changed, _ := consul.CheckForUpstreamChanges(backend, "", ""); changed != NoChange {
 			t.Fatalf("First read of %s should show `false` for change", id)
 		}
 		service.SendHeartbeat() // force registration
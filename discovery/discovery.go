@@ -6,9 +6,53 @@ import "github.com/hashicorp/consul/api"
 
 // Backend is an interface which all service discovery backends must implement
 type Backend interface {
-	CheckForUpstreamChanges(service, tag, dc string) (bool, bool)
+	Upstream
 	CheckRegister(check *api.AgentCheckRegistration) error
 	UpdateTTL(checkID, output, status string) error
 	ServiceDeregister(serviceID string) error
 	ServiceRegister(service *api.AgentServiceRegistration) error
 }
+
+// Upstream is implemented by anything that can be watched for changes to
+// the healthy instances of a service
+type Upstream interface {
+	CheckForUpstreamChanges(service, tag, dc string) (UpstreamChange, bool)
+}
+
+// InstanceCounter is implemented by Backends that can report how many
+// healthy instances of a service they found on its last check
+type InstanceCounter interface {
+	InstanceCount(service string) int
+}
+
+// InstanceLister is implemented by Backends that can list the healthy
+// instances of a service they found on its last check
+type InstanceLister interface {
+	Instances(service string) []Instance
+}
+
+// Pinger is implemented by Backends that can check that they're able to
+// reach the service discovery server
+type Pinger interface {
+	Ping() error
+}
+
+// Instance is the address of one healthy instance of a service
+type Instance struct {
+	ID      string
+	Address string
+	Port    int
+}
+
+// UpstreamChange describes how the healthy instances of an upstream
+// service changed since the last check
+type UpstreamChange string
+
+// UpstreamChange values
+const (
+	NoChange         UpstreamChange = ""
+	InitialInstances UpstreamChange = "initial" // first instances seen
+	InstancesAdded   UpstreamChange = "added"
+	InstancesRemoved UpstreamChange = "removed"
+	InstancesChanged UpstreamChange = "changed" // same count, new addresses
+)
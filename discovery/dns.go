@@ -0,0 +1,133 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SRVResolver looks up DNS SRV records. It's satisfied by *net.Resolver.
+type SRVResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// NewResolver returns an SRVResolver that queries the DNS server at the
+// address (ex. "10.0.0.10:53"), or the system's resolver if it's empty
+func NewResolver(address string) SRVResolver {
+	if address == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			dialer := net.Dialer{}
+			return dialer.DialContext(ctx, network, address)
+		},
+	}
+}
+
+// DNS is a read-only source of the instances of a service, found by
+// resolving the SRV records of a name, such as a Kubernetes headless
+// service. It can be watched but not registered with.
+type DNS struct {
+	name     string
+	resolver SRVResolver
+	timeout  time.Duration
+
+	lock  sync.RWMutex
+	found map[string][]Instance
+}
+
+// NewDNS creates a DNS source that resolves the SRV records of the name,
+// giving up on each lookup after the timeout
+func NewDNS(name string, resolver SRVResolver, timeout time.Duration) *DNS {
+	return &DNS{
+		name:     name,
+		resolver: resolver,
+		timeout:  timeout,
+		found:    map[string][]Instance{},
+	}
+}
+
+// CheckForUpstreamChanges resolves the SRV records and checks whether
+// the instances have changed since the last check. The tag and
+// datacenter don't apply to DNS and are ignored.
+func (d *DNS) CheckForUpstreamChanges(service, _, _ string) (change UpstreamChange, isHealthy bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	_, records, err := d.resolver.LookupSRV(ctx, "", "", d.name)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+			log.Warnf("failed to resolve %v: %s", d.name, err)
+			return NoChange, false
+		}
+		// a name with no records has no instances
+		records = nil
+	}
+	instances := make([]Instance, 0, len(records))
+	for _, record := range records {
+		address := strings.TrimSuffix(record.Target, ".")
+		instances = append(instances, Instance{
+			ID:      fmt.Sprintf("%s:%d", address, record.Port),
+			Address: address,
+			Port:    int(record.Port),
+		})
+	}
+	sort.Sort(byInstanceID(instances))
+	collector.WithLabelValues(service).Set(float64(len(instances)))
+	return d.compareAndSwap(service, instances), len(instances) > 0
+}
+
+// InstanceCount returns the number of instances of the service found by
+// the last CheckForUpstreamChanges
+func (d *DNS) InstanceCount(service string) int {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return len(d.found[service])
+}
+
+// Instances returns the instances of the service found by the last
+// CheckForUpstreamChanges, sorted by their IDs
+func (d *DNS) Instances(service string) []Instance {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return append([]Instance{}, d.found[service]...)
+}
+
+func (d *DNS) compareAndSwap(service string, instances []Instance) UpstreamChange {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	existing, seen := d.found[service]
+	d.found[service] = instances
+	var change UpstreamChange
+	switch {
+	case len(existing) < len(instances):
+		change = InstancesAdded
+	case len(existing) > len(instances):
+		change = InstancesRemoved
+	default:
+		for i, ex := range existing {
+			if ex != instances[i] {
+				change = InstancesChanged
+				break
+			}
+		}
+	}
+	if !seen && change != NoChange {
+		return InitialInstances
+	}
+	return change
+}
+
+// byInstanceID implements the Sort interface for Instances
+type byInstanceID []Instance
+
+func (in byInstanceID) Len() int           { return len(in) }
+func (in byInstanceID) Swap(i, j int)      { in[i], in[j] = in[j], in[i] }
+func (in byInstanceID) Less(i, j int) bool { return in[i].ID < in[j].ID }
@@ -12,9 +12,11 @@ import (
 type ServiceDefinition struct {
 	ID                             string
 	Name                           string
-	Port                           int
+	Port                           int // advertised to Consul
+	ListenPort                     int // may differ from Port behind NAT
 	TTL                            int
 	Tags                           []string
+	Meta                           map[string]string
 	InitialStatus                  string
 	IPAddress                      string
 	EnableTagOverride              bool
@@ -22,6 +24,7 @@ type ServiceDefinition struct {
 	Consul                         Backend
 
 	wasRegistered bool
+	isSuppressed  bool
 }
 
 // Deregister removes the service from Consul.
@@ -32,6 +35,20 @@ func (service *ServiceDefinition) Deregister() {
 	}
 }
 
+// ForceDeregister removes the service from Consul and prevents it from
+// being registered again until Reregister is called.
+func (service *ServiceDefinition) ForceDeregister() {
+	service.isSuppressed = true
+	service.wasRegistered = false
+	service.Deregister()
+}
+
+// Reregister lifts the suppression set by ForceDeregister so that the
+// service will be registered again on its next heartbeat.
+func (service *ServiceDefinition) Reregister() {
+	service.isSuppressed = false
+}
+
 // MarkForMaintenance removes the service from Consul.
 func (service *ServiceDefinition) MarkForMaintenance() {
 	service.Deregister()
@@ -39,6 +56,9 @@ func (service *ServiceDefinition) MarkForMaintenance() {
 
 // SendHeartbeat writes a TTL check status=ok to the Consul store.
 func (service *ServiceDefinition) SendHeartbeat() error {
+	if service.isSuppressed {
+		return nil
+	}
 	// Make sure the service is registered.
 	service.register(api.HealthPassing)
 
@@ -50,9 +70,40 @@ func (service *ServiceDefinition) SendHeartbeat() error {
 	return nil
 }
 
+// SendWarning writes a TTL check status=warning to the Consul store.
+func (service *ServiceDefinition) SendWarning() error {
+	if service.isSuppressed {
+		return nil
+	}
+	// Make sure the service is registered.
+	service.register(api.HealthWarning)
+
+	checkID := fmt.Sprintf("service:%s", service.ID)
+	if err := service.Consul.UpdateTTL(checkID, "warning", "warn"); err != nil {
+		log.Warnf("service update TTL failed: %s", err)
+	}
+
+	return nil
+}
+
+// SendCritical writes a TTL check status=critical to the Consul store so
+// that the service stops receiving traffic without waiting for its TTL to
+// expire. There's nothing to mark if the service was never registered.
+func (service *ServiceDefinition) SendCritical(output string) error {
+	if service.isSuppressed || !service.wasRegistered {
+		return nil
+	}
+	checkID := fmt.Sprintf("service:%s", service.ID)
+	if err := service.Consul.UpdateTTL(checkID, output, "fail"); err != nil {
+		log.Warnf("service update TTL failed: %s", err)
+		return err
+	}
+	return nil
+}
+
 // RegisterWithInitialStatus registers the service with its configured initial status.
 func (service *ServiceDefinition) RegisterWithInitialStatus() {
-	if service.wasRegistered {
+	if service.wasRegistered || service.isSuppressed {
 		return
 	}
 
@@ -79,7 +130,8 @@ func (service *ServiceDefinition) RegisterWithInitialStatus() {
 func (service *ServiceDefinition) register(status string) error {
 	if !service.wasRegistered {
 		if err := service.registerService(status); err != nil {
-			log.Warnf("service registration failed: %s", err)
+			log.Warnf("service registration failed for %s (id %s): %s",
+				service.Name, service.ID, err)
 			return err
 		}
 		log.Infof("Service registered: %v", service.Name)
@@ -96,6 +148,7 @@ func (service *ServiceDefinition) registerService(status string) error {
 			ID:                service.ID,
 			Name:              service.Name,
 			Tags:              service.Tags,
+			Meta:              service.Meta,
 			Port:              service.Port,
 			Address:           service.IPAddress,
 			EnableTagOverride: service.EnableTagOverride,
@@ -0,0 +1,64 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	cleanhttp "github.com/hashicorp/go-cleanhttp"
+)
+
+// lookupHost resolves the Consul agent's hostname. It's a variable so
+// that tests can stub out DNS.
+var lookupHost = net.DefaultResolver.LookupHost
+
+// newTransport returns the HTTP transport for the Consul client. The
+// agent's hostname is resolved each time we dial rather than once when
+// we start, so that we follow the agent if its address changes. Idle
+// connections are kept in the pool so that polling watches reuse them.
+func newTransport(pool poolConfig) *http.Transport {
+	transport := cleanhttp.DefaultPooledTransport()
+	transport.MaxIdleConns = pool.maxIdleConns
+	transport.MaxIdleConnsPerHost = pool.maxIdleConns
+	transport.IdleConnTimeout = pool.idleTimeout
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: pool.keepAlive,
+	}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		ips, err := lookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			var conn net.Conn
+			conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+	return transport
+}
+
+// reconnectingTransport drops its pooled connections whenever a request
+// fails, so that the next request dials the agent again rather than
+// reusing a connection to an address the agent has moved away from.
+type reconnectingTransport struct {
+	*http.Transport
+}
+
+// RoundTrip implements http.RoundTripper
+func (t reconnectingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		t.Transport.CloseIdleConnections()
+	}
+	return resp, err
+}
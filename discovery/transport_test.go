@@ -0,0 +1,106 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testAgent is a stand-in for a Consul agent listening on the given
+// address that reports the address as the cluster leader
+func testAgent(t *testing.T, addr string) *httptest.Server {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("unable to listen on %s: %v", addr, err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, fmt.Sprintf("%q", ln.Addr()))
+		}))
+	srv.Listener = ln
+	srv.Start()
+	return srv
+}
+
+// The agent's hostname is resolved again once the address it first
+// resolved to becomes unreachable
+func TestConsulReresolvesAgent(t *testing.T) {
+	first := testAgent(t, "127.0.0.1:0")
+	_, port, _ := net.SplitHostPort(first.Listener.Addr().String())
+
+	var lock sync.Mutex
+	resolved := "127.0.0.1"
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		assert.Equal(t, "consul.test", host)
+		return []string{resolved}, nil
+	}
+	defer func() { lookupHost = net.DefaultResolver.LookupHost }()
+
+	c, err := NewConsul("consul.test:" + port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leader, err := c.Status().Leader()
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:"+port, leader)
+
+	// the agent moves to a new address
+	first.Close()
+	second := testAgent(t, "127.0.0.2:"+port)
+	defer second.Close()
+	lock.Lock()
+	resolved = "127.0.0.2"
+	lock.Unlock()
+
+	// the first request may fail on the connection to the old address
+	if leader, err = c.Status().Leader(); err != nil {
+		leader, err = c.Status().Leader()
+	}
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.2:"+port, leader)
+}
+
+func TestConsulConnectionPool(t *testing.T) {
+	_, pool, err := configFromMap(map[string]interface{}{
+		"address": "consul:8500",
+		"pool": map[string]interface{}{
+			"maxIdleConns": 64,
+			"idleTimeout":  "5m",
+			"keepAlive":    "10s",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := newTransport(pool)
+	assert.Equal(t, 64, transport.MaxIdleConns)
+	assert.Equal(t, 64, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, 5*time.Minute, transport.IdleConnTimeout)
+	assert.Equal(t, 10*time.Second, pool.keepAlive)
+
+	_, pool, err = configFromURI("consul:8500")
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport = newTransport(pool)
+	assert.Equal(t, defaultMaxIdleConns, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, defaultIdleTimeout, transport.IdleConnTimeout)
+
+	_, err = NewConsul(map[string]interface{}{
+		"address": "consul:8500",
+		"pool":    map[string]interface{}{"idleTimeout": "xx"},
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to parse consul.pool.idleTimeout 'xx'")
+	}
+}
@@ -15,6 +15,11 @@ type EventBus struct {
 	reload   bool
 	done     sync.WaitGroup
 
+	// probe is closed once the lock is free, by the one goroutine
+	// Responsive leaves waiting on it while the bus is stuck
+	probe     chan struct{}
+	probeLock sync.Mutex
+
 	// circular buffer of events
 	head int
 	tail int
@@ -57,14 +62,21 @@ func (bus *EventBus) mod(p int) int {
 	return p % len(bus.buf)
 }
 
-var collector *prometheus.CounterVec
+var (
+	collector *prometheus.CounterVec
+	backlog   *prometheus.GaugeVec
+)
 
 func init() {
 	collector = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "containerpilot_events",
 		Help: "count of ContainerPilot events, partitioned by type and source",
 	}, []string{"code", "source"})
-	prometheus.MustRegister(collector)
+	backlog = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "containerpilot_events_backlog",
+		Help: "events waiting to be handled by a subscriber as of the last event published, partitioned by subscriber",
+	}, []string{"subscriber"})
+	prometheus.MustRegister(collector, backlog)
 }
 
 // NewEventBus initializes an EventBus. We need this rather than a struct
@@ -117,6 +129,7 @@ func (bus *EventBus) Unsubscribe(subscriber EventSubscriber) {
 	sub := subscriber.(*Subscriber)
 	if _, ok := bus.registry[sub]; ok {
 		delete(bus.registry, sub)
+		backlog.DeleteLabelValues(sub.Name)
 	}
 	bus.done.Done()
 }
@@ -135,6 +148,7 @@ func (bus *EventBus) Publish(event Event) {
 		// sending to an unsubscribed Subscriber shouldn't be a runtime
 		// error, so this is in intentionally allowed to panic here
 		subscriber.Receive(event)
+		backlog.WithLabelValues(subscriber.Name).Set(float64(len(subscriber.Rx)))
 	}
 	bus.enqueue(event)
 }
@@ -153,6 +167,46 @@ func (bus *EventBus) SetReloadFlag() {
 	bus.reload = true
 }
 
+// Reloading returns true if the reload flag has been set, so that
+// Subscribers can tell a shutdown for a reload from a final one
+func (bus *EventBus) Reloading() bool {
+	bus.lock.RLock()
+	defer bus.lock.RUnlock()
+	return bus.reload
+}
+
+// Responsive returns false if the EventBus can't take another Event
+// within the timeout, as when a Subscriber has stopped reading and its
+// backlog is full so that Publish is stuck waiting on it. Callers share
+// a single probe of the lock, so a stuck bus doesn't accumulate a
+// goroutine for every call.
+func (bus *EventBus) Responsive(timeout time.Duration) bool {
+	bus.probeLock.Lock()
+	probe := bus.probe
+	if probe == nil {
+		probe = make(chan struct{})
+		bus.probe = probe
+		go func() {
+			bus.lock.RLock()
+			bus.lock.RUnlock()
+			bus.probeLock.Lock()
+			bus.probe = nil
+			bus.probeLock.Unlock()
+			close(probe)
+		}()
+	}
+	bus.probeLock.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-probe:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
 // Shutdown asks all Subscribers to halt by sending the GlobalShutdown
 // message. Subscribers are responsible for handling this message.
 func (bus *EventBus) Shutdown() {
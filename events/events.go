@@ -33,9 +33,12 @@ const (
 	Error
 	Quit
 	Metric
-	Startup  // fired once after events are set up and event loop is started
-	Shutdown // fired once after all jobs exit or on receiving SIGTERM
-	Signal   // fired when a UNIX signal hits a CP process/supervisor
+	Startup    // fired once after events are set up and event loop is started
+	Shutdown   // fired once after all jobs exit or on receiving SIGTERM
+	Signal     // fired when a UNIX signal hits a CP process/supervisor
+	Deregister // fired when a service is forcibly deregistered via control plane
+	Register   // fired when a forcibly deregistered service is re-registered
+	Restarting // fired when a Job restarts its exec after it exits
 )
 
 // global events
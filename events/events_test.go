@@ -4,9 +4,12 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"runtime"
 	"sync"
 	"testing"
+	"time"
 
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -111,3 +114,48 @@ func TestPublishSignal(t *testing.T) {
 		assert.Equal(t, found, expected[n])
 	}
 }
+
+// The backlog of a subscriber that isn't keeping up is exported by name
+func TestEventsBacklogMetric(t *testing.T) {
+	bus := NewEventBus()
+	slow := &Subscriber{Rx: make(chan Event, 10), Name: t.Name()}
+	slow.Subscribe(bus)
+	depth := func() float64 {
+		metric := &dto.Metric{}
+		backlog.WithLabelValues(t.Name()).Write(metric)
+		return metric.GetGauge().GetValue()
+	}
+
+	for i := 0; i < 5; i++ {
+		bus.Publish(Event{Code: Metric, Source: "test"})
+	}
+	assert.Equal(t, float64(5), depth(), "expected unread events in backlog")
+
+	<-slow.Rx
+	<-slow.Rx
+	bus.Publish(Event{Code: Metric, Source: "test"})
+	assert.Equal(t, float64(4), depth(), "expected backlog to drain")
+	slow.Unsubscribe()
+}
+
+// Checking a stuck bus repeatedly shouldn't leave a goroutine behind for
+// each check
+func TestResponsiveSingleProbe(t *testing.T) {
+	bus := NewEventBus()
+	stuck := &Subscriber{Rx: make(chan Event)}
+	stuck.Subscribe(bus)
+	go bus.Publish(GlobalStartup)
+	time.Sleep(10 * time.Millisecond)
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 50; i++ {
+		assert.False(t, bus.Responsive(time.Millisecond))
+	}
+	assert.True(t, runtime.NumGoroutine()-before <= 1,
+		"expected one outstanding probe, got %d more goroutines",
+		runtime.NumGoroutine()-before)
+
+	<-stuck.Rx
+	assert.True(t, bus.Responsive(time.Second))
+	stuck.Unsubscribe()
+}
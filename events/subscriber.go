@@ -13,6 +13,9 @@ type EventSubscriber interface {
 type Subscriber struct {
 	Rx  chan Event
 	Bus *EventBus
+
+	// Name labels the subscriber's metrics
+	Name string
 }
 
 // Subscribe subscribes a subscriber to the EventBus
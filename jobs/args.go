@@ -0,0 +1,80 @@
+package jobs
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/joyent/containerpilot/discovery"
+)
+
+// watchResult is what the templates in a Job's exec arguments are
+// rendered against
+type watchResult struct {
+	Service   string
+	Instances []discovery.Instance
+}
+
+// argTemplates renders the arguments of a Job's exec from the instances
+// found by the watch that starts it. Each argument with a template may
+// render to any number of arguments, split on whitespace.
+type argTemplates struct {
+	service   string
+	args      []string
+	templates []*template.Template // nil for args without a template
+}
+
+// newArgTemplates parses the templates in the args, and returns nil if
+// there aren't any
+func newArgTemplates(args []string, service string) (*argTemplates, error) {
+	tmpls := make([]*template.Template, len(args))
+	found := false
+	for i, arg := range args {
+		if !strings.Contains(arg, "{{") {
+			continue
+		}
+		tmpl, err := template.New(arg).Option("missingkey=error").Parse(arg)
+		if err != nil {
+			return nil, err
+		}
+		tmpls[i] = tmpl
+		found = true
+	}
+	if !found {
+		return nil, nil
+	}
+	t := &argTemplates{service: service, args: args, templates: tmpls}
+	// catch references to fields that don't exist before we need them
+	if _, err := t.renderWith(watchResult{
+		Service: service, Instances: []discovery.Instance{{}},
+	}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// render returns the args for the instances the watch's upstream last
+// found
+func (t *argTemplates) render(lister discovery.InstanceLister) ([]string, error) {
+	return t.renderWith(watchResult{
+		Service:   t.service,
+		Instances: lister.Instances(t.service),
+	})
+}
+
+func (t *argTemplates) renderWith(result watchResult) ([]string, error) {
+	args := []string{}
+	for i, arg := range t.args {
+		if t.templates[i] == nil {
+			args = append(args, arg)
+			continue
+		}
+		var buf bytes.Buffer
+		if err := t.templates[i].Execute(&buf, result); err != nil {
+			return nil, fmt.Errorf("unable to render '%s': %v", arg, err)
+		}
+		args = append(args, strings.Fields(buf.String())...)
+	}
+	return args, nil
+}
@@ -0,0 +1,141 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/joyent/containerpilot/config/decode"
+	"github.com/joyent/containerpilot/config/timing"
+	"github.com/joyent/containerpilot/events"
+	log "github.com/sirupsen/logrus"
+)
+
+// BreakerConfig configures a RestartBreaker
+type BreakerConfig struct {
+	Restarts int    `mapstructure:"restarts"`
+	Window   string `mapstructure:"window"`
+	window   time.Duration
+}
+
+// NewBreakerConfig parses json config into a validated BreakerConfig.
+// Returns nil if the breaker isn't configured.
+func NewBreakerConfig(raw interface{}) (*BreakerConfig, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	cfg := &BreakerConfig{}
+	if err := decode.ToStruct(raw, cfg); err != nil {
+		return nil, fmt.Errorf("restartBreaker configuration error: %v", err)
+	}
+	if cfg.Restarts < 1 {
+		return nil, fmt.Errorf("restartBreaker.restarts must be > 0")
+	}
+	window, err := timing.GetTimeout(cfg.Window)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse restartBreaker.window '%s': %v",
+			cfg.Window, err)
+	}
+	if window <= 0 {
+		return nil, fmt.Errorf("restartBreaker.window must be set")
+	}
+	cfg.window = window
+	return cfg, nil
+}
+
+// RestartBreaker counts the restarts of all Jobs and shuts down
+// ContainerPilot if there are more than the configured number of
+// restarts within the window. This catches systemic failures that
+// restarting any one Job won't fix.
+type RestartBreaker struct {
+	limit    int
+	window   time.Duration
+	restarts []time.Time
+	tripped  bool
+	lock     sync.Mutex // guards tripped, which IsTripped reads
+
+	events.Subscriber
+}
+
+// NewRestartBreaker creates a RestartBreaker from a validated
+// BreakerConfig. Returns nil if the config is nil.
+func NewRestartBreaker(cfg *BreakerConfig) *RestartBreaker {
+	if cfg == nil {
+		return nil
+	}
+	breaker := &RestartBreaker{
+		limit:  cfg.Restarts,
+		window: cfg.window,
+	}
+	breaker.Rx = make(chan events.Event, eventBufferSize)
+	breaker.Subscriber.Name = "restartBreaker"
+	return breaker
+}
+
+// Run executes the event loop for the RestartBreaker
+func (breaker *RestartBreaker) Run(pctx context.Context, bus *events.EventBus) {
+	breaker.Subscribe(bus)
+	ctx, cancel := context.WithCancel(pctx)
+	go func() {
+		defer func() {
+			cancel()
+			breaker.Unsubscribe()
+			breaker.Wait()
+		}()
+		for {
+			select {
+			case event, ok := <-breaker.Rx:
+				if !ok {
+					return
+				}
+				switch event.Code {
+				case events.Restarting:
+					if breaker.record(time.Now()) {
+						log.Errorf("%d job restarts within %v exceeds restart breaker limit of %d, shutting down",
+							len(breaker.restarts), breaker.window, breaker.limit)
+						bus.Shutdown()
+					}
+				default:
+					switch event {
+					case events.GlobalShutdown, events.QuitByTest:
+						return
+					}
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// record adds a restart to the window and returns true if this trips
+// the breaker
+func (breaker *RestartBreaker) record(now time.Time) bool {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	if breaker.tripped {
+		return false
+	}
+	cutoff := now.Add(-breaker.window)
+	restarts := []time.Time{}
+	for _, t := range breaker.restarts {
+		if t.After(cutoff) {
+			restarts = append(restarts, t)
+		}
+	}
+	breaker.restarts = append(restarts, now)
+	breaker.tripped = len(breaker.restarts) > breaker.limit
+	return breaker.tripped
+}
+
+// IsTripped returns true if the RestartBreaker has shut down
+// ContainerPilot
+func (breaker *RestartBreaker) IsTripped() bool {
+	if breaker == nil {
+		return false
+	}
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	return breaker.tripped
+}
@@ -0,0 +1,86 @@
+package jobs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/tests"
+)
+
+func TestBreakerConfig(t *testing.T) {
+	cfg, err := NewBreakerConfig(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, cfg)
+
+	cfg, err = NewBreakerConfig(map[string]interface{}{
+		"restarts": 5, "window": "1m"})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, cfg.Restarts)
+	assert.Equal(t, time.Minute, cfg.window)
+
+	_, err = NewBreakerConfig(map[string]interface{}{"window": "1m"})
+	assert.EqualError(t, err, "restartBreaker.restarts must be > 0")
+
+	_, err = NewBreakerConfig(map[string]interface{}{"restarts": 5})
+	assert.EqualError(t, err, "restartBreaker.window must be set")
+}
+
+func TestBreakerRecord(t *testing.T) {
+	breaker := NewRestartBreaker(&BreakerConfig{Restarts: 2, window: time.Second})
+	now := time.Now()
+	assert.False(t, breaker.record(now))
+	assert.False(t, breaker.record(now.Add(100*time.Millisecond)))
+	// the first restart has aged out of the window
+	assert.False(t, breaker.record(now.Add(1050*time.Millisecond)))
+	assert.False(t, breaker.IsTripped())
+	assert.True(t, breaker.record(now.Add(1060*time.Millisecond)))
+	assert.True(t, breaker.IsTripped())
+}
+
+// Several jobs failing at once should trip the breaker and shut down
+// everything even though each job is permitted unlimited restarts
+func TestBreakerTripsOnRestarts(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[
+		{name: "jobA", exec: "false", restarts: "unlimited"},
+		{name: "jobB", exec: "false", restarts: "unlimited"},
+		{name: "jobC", exec: "false", restarts: "unlimited"}
+	]`)
+	cfgs, err := NewConfigs(testCfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	breakerCfg, _ := NewBreakerConfig(map[string]interface{}{
+		"restarts": 5, "window": "10s"})
+	breaker := NewRestartBreaker(breakerCfg)
+
+	bus := events.NewEventBus()
+	ctx := context.Background()
+	breaker.Run(ctx, bus)
+	jobs := FromConfigs(cfgs)
+	completedCh := make(chan struct{}, len(jobs))
+	for _, job := range jobs {
+		job.Subscribe(bus)
+		job.Register(bus)
+	}
+	for _, job := range jobs {
+		job.Run(ctx, completedCh)
+	}
+	bus.Publish(events.GlobalStartup)
+
+	done := make(chan bool)
+	go func() { done <- bus.Wait() }()
+	select {
+	case reload := <-done:
+		assert.False(t, reload)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected restart breaker to shut down the jobs")
+	}
+	assert.True(t, breaker.IsTripped())
+	for _, job := range jobs {
+		assert.True(t, job.IsComplete, "expected %s to be complete", job.Name)
+	}
+}
@@ -0,0 +1,98 @@
+package jobs
+
+import (
+	"fmt"
+
+	"github.com/joyent/containerpilot/commands"
+)
+
+// checkState is the combined result of a Job's health checks
+type checkState int
+
+const (
+	checkPassing checkState = iota
+	checkWarning
+	checkCritical
+)
+
+// subCheck is one of several health checks that together determine the
+// health of a Job
+type subCheck struct {
+	exec     *commands.Command
+	critical bool
+}
+
+// scoreSubChecks combines the results of a Job's sub-checks, keyed by
+// the name of each check's exec. The Job is critical if any critical
+// check failed, warning if only non-critical checks failed, and passing
+// otherwise.
+func scoreSubChecks(checks []*subCheck, passed map[string]bool) checkState {
+	state := checkPassing
+	for _, check := range checks {
+		if passed[check.exec.Name] {
+			continue
+		}
+		if check.critical {
+			return checkCritical
+		}
+		state = checkWarning
+	}
+	return state
+}
+
+// LimitHealthChecks caps the number of health checks across all the
+// jobs that run at once, so that many jobs' checks firing together don't
+// saturate the host. Checks over the limit wait their turn.
+func LimitHealthChecks(cfgs []*Config, max int) {
+	if max < 1 {
+		return
+	}
+	limit := commands.NewLimit(max)
+	for _, cfg := range cfgs {
+		if cfg.healthCheckExec != nil {
+			cfg.healthCheckExec.Limit = limit
+		}
+		for _, check := range cfg.healthChecks {
+			check.exec.Limit = limit
+		}
+	}
+}
+
+// RunHealthCheck runs the health check exec of the named job once in the
+// foreground, returning an error if the job or its check can't be found
+// or if the check fails, along with the check's exit code. If the job has
+// several sub-checks, only the failure of a critical check is an error,
+// and its exit code is 1.
+func RunHealthCheck(cfgs []*Config, name string) (int, error) {
+	for _, cfg := range cfgs {
+		if cfg.Name != name {
+			continue
+		}
+		if len(cfg.healthChecks) > 0 {
+			if err := runSubChecks(cfg.healthChecks, name); err != nil {
+				return 1, err
+			}
+			return 0, nil
+		}
+		if cfg.healthCheckExec == nil {
+			return 1, fmt.Errorf("job[%s] has no health check", name)
+		}
+		if err := cfg.healthCheckExec.RunAndWait(); err != nil {
+			return commands.ExitCodeOf(err),
+				fmt.Errorf("job[%s] health check failed: %v", name, err)
+		}
+		return 0, nil
+	}
+	return 1, fmt.Errorf("no job named '%s'", name)
+}
+
+func runSubChecks(checks []*subCheck, name string) error {
+	passed := make(map[string]bool)
+	for _, check := range checks {
+		passed[check.exec.Name] = check.exec.RunAndWait() == nil
+	}
+	if scoreSubChecks(checks, passed) == checkCritical {
+		return fmt.Errorf("job[%s] health check failed", name)
+	}
+	return nil
+}
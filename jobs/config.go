@@ -2,8 +2,13 @@ package jobs
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"text/template"
 	"time"
 
 	"github.com/joyent/containerpilot/commands"
@@ -17,35 +22,79 @@ import (
 
 const taskMinDuration = time.Millisecond
 
+// a postStop hook blocks the job from restarting, so we don't want it to
+// run forever if it isn't given a timeout
+const defaultPostStopTimeout = 10 * time.Second
+
 // Config holds the configuration for service discovery data
 type Config struct {
 	Name string      `mapstructure:"name"`
 	Exec interface{} `mapstructure:"exec"`
 
 	// service discovery
-	Port              int           `mapstructure:"port"`
-	InitialStatus     string        `mapstructure:"initial_status"`
-	Interfaces        interface{}   `mapstructure:"interfaces"`
-	Tags              []string      `mapstructure:"tags"`
-	ConsulExtras      *ConsulExtras `mapstructure:"consul"`
+	Port              int               `mapstructure:"port"`
+	AdvertisePort     int               `mapstructure:"advertisePort"`
+	ID                string            `mapstructure:"id"`
+	InitialStatus     string            `mapstructure:"initial_status"`
+	Interfaces        interface{}       `mapstructure:"interfaces"`
+	Tags              []string          `mapstructure:"tags"`
+	Meta              map[string]string `mapstructure:"meta"`
+	ConsulExtras      *ConsulExtras     `mapstructure:"consul"`
 	serviceDefinition *discovery.ServiceDefinition
+	keepOnReload      bool
 
 	// health checking
 	Health            *HealthConfig `mapstructure:"health"`
 	healthCheckExec   *commands.Command
+	healthChecks      []*subCheck
+	warningRetries    int
+	checkResolver     *commands.Command
 	heartbeatInterval time.Duration
 	ttl               int
 
 	// timeouts and restarts
-	ExecTimeout     string      `mapstructure:"timeout"`
-	Restarts        interface{} `mapstructure:"restarts"`
-	StopTimeout     string      `mapstructure:"stopTimeout"`
+	ExecTimeout     string         `mapstructure:"timeout"`
+	CPUTimeout      string         `mapstructure:"cpuTimeout"`
+	Nice            int            `mapstructure:"nice"`
+	TTY             bool           `mapstructure:"tty"`
+	ProcessTitle    string         `mapstructure:"processTitle"`
+	Restarts        interface{}    `mapstructure:"restarts"`
+	StopTimeout     string         `mapstructure:"stopTimeout"`
+	StopWaitOnExit  bool           `mapstructure:"stopWaitOnExit"`
+	ExitOnStartFail bool           `mapstructure:"exitOnStartFailure"`
+	Primary         bool           `mapstructure:"primary"`
+	PostStop        *HookConfig    `mapstructure:"postStop"`
+	Steps           []HookConfig   `mapstructure:"steps"`
+	Success         *SuccessConfig `mapstructure:"success"`
 	execTimeout     time.Duration
 	exec            *commands.Command
+	execArgs        *argTemplates
 	stoppingTimeout time.Duration
 	restartLimit    int
 	freqInterval    time.Duration
 
+	// flap protection
+	Flapping       *FlappingConfig `mapstructure:"flapping"`
+	flapMinRuntime time.Duration
+	flapBackoff    time.Duration
+	flapMaxBackoff time.Duration
+	flapLimit      int
+
+	// file rendered from the watch that starts the job
+	Render   *RenderConfig `mapstructure:"render"`
+	renderer *renderer
+
+	// lists the instances found by the watch that starts the job, for
+	// its exec templates and render
+	upstream discovery.InstanceLister
+
+	// retries of transient errors starting the exec
+	ExecRetry *ExecRetryConfig `mapstructure:"execRetry"`
+
+	// command that must pass before the process is stopped
+	DrainGate *DrainGateConfig `mapstructure:"drainGate"`
+	drainGate *drainGate
+
 	// related jobs and frequency
 	When              *WhenConfig `mapstructure:"when"`
 	whenEvent         events.Event
@@ -53,6 +102,32 @@ type Config struct {
 	whenStartsLimit   int
 	stoppingWaitEvent events.Event
 
+	// readiness gate for registration
+	ReadyFile         *ReadyFileConfig `mapstructure:"readyFile"`
+	readyFilePath     string
+	readyFileInterval time.Duration
+	readyFileTimeout  time.Duration
+
+	// dependency gate for registration
+	RegisterWhen   *RegisterWhenConfig `mapstructure:"registerWhen"`
+	registerSource string
+
+	// dependency the process only runs while it's healthy
+	Requires       *RequiresConfig `mapstructure:"requires"`
+	requiresSource string
+
+	// environment
+	Env             map[string]string `mapstructure:"env"`
+	env             *envTemplates
+	EnvFiles        *EnvFilesConfig `mapstructure:"envFiles"`
+	envFilePaths    []string
+	envFileInterval time.Duration
+	EnvPrecedence   []string `mapstructure:"envPrecedence"`
+	envPrecedence   []string
+
+	// process hardening
+	Security *SecurityConfig `mapstructure:"security"`
+
 	// logging
 	Logging *LoggingConfig `mapstructure:"logging"`
 }
@@ -69,38 +144,183 @@ type WhenConfig struct {
 
 // HealthConfig configures the Job's health checks
 type HealthConfig struct {
-	CheckExec    interface{}    `mapstructure:"exec"`
-	CheckTimeout string         `mapstructure:"timeout"`
-	Heartbeat    int            `mapstructure:"interval"` // time in seconds
-	TTL          int            `mapstructure:"ttl"`      // time in seconds
-	Logging      *LoggingConfig `mapstructure:"logging"`
+	CheckExec    interface{}       `mapstructure:"exec"`
+	Checks       []*SubCheckConfig `mapstructure:"checks"`
+	CheckTimeout string            `mapstructure:"timeout"`
+	Heartbeat    int               `mapstructure:"interval"` // time in seconds
+	TTL          int               `mapstructure:"ttl"`      // time in seconds
+	Logging      *LoggingConfig    `mapstructure:"logging"`
+	FailOnExit   bool              `mapstructure:"failOnExit"`
+	Namespaces   []string          `mapstructure:"namespaces"`
+	Success      *SuccessConfig    `mapstructure:"success"`
+
+	WarningRetries int         `mapstructure:"warningRetries"`
+	Resolver       *HookConfig `mapstructure:"resolver"`
+}
+
+// SuccessConfig configures which non-zero exits of a command are treated
+// as successful
+type SuccessConfig struct {
+	Codes  []int  `mapstructure:"codes"`
+	Output string `mapstructure:"output"`
+}
+
+// HookConfig configures a command that runs at a point in the lifecycle
+// of a Job's process
+type HookConfig struct {
+	Exec    interface{} `mapstructure:"exec"`
+	Timeout string      `mapstructure:"timeout"`
+}
+
+// SubCheckConfig configures one of several health checks whose results
+// are combined into the Job's health. Checks are critical by default.
+type SubCheckConfig struct {
+	Name     string      `mapstructure:"name"`
+	Exec     interface{} `mapstructure:"exec"`
+	Critical *bool       `mapstructure:"critical"`
+}
+
+// FlappingConfig configures how the Job restarts a process that keeps
+// exiting right after it starts
+type FlappingConfig struct {
+	MinRuntime string `mapstructure:"minRuntime"`
+	Backoff    string `mapstructure:"backoff"`
+	MaxBackoff string `mapstructure:"maxBackoff"`
+	Limit      int    `mapstructure:"limit"`
+}
+
+// ExecRetryConfig configures the retries of transient errors starting
+// the Job's exec
+type ExecRetryConfig struct {
+	Attempts int    `mapstructure:"attempts"`
+	Delay    string `mapstructure:"delay"`
+}
+
+// ReadyFileConfig configures a file whose existence gates the Job's
+// health and service registration
+type ReadyFileConfig struct {
+	Path     string `mapstructure:"path"`
+	Interval string `mapstructure:"interval"`
+	Timeout  string `mapstructure:"timeout"`
+}
+
+// RegisterWhenConfig configures another Job or Watch whose health gates
+// the Job's service registration, without gating its start
+type RegisterWhenConfig struct {
+	Source string `mapstructure:"source"`
+}
+
+// RequiresConfig configures another Job or Watch that must be healthy
+// for the Job's process to run
+type RequiresConfig struct {
+	Source string `mapstructure:"source"`
+}
+
+// EnvFilesConfig configures files of KEY=VALUE lines that are added to
+// the environment of the Job's process
+type EnvFilesConfig struct {
+	Paths    interface{} `mapstructure:"paths"`
+	Interval string      `mapstructure:"interval"`
+}
+
+// SecurityConfig configures restrictions applied to the Job's process
+type SecurityConfig struct {
+	NoNewPrivs     bool   `mapstructure:"noNewPrivs"`
+	SeccompProfile string `mapstructure:"seccompProfile"`
 }
 
 // ConsulExtras handles additional Consul configuration.
 type ConsulExtras struct {
 	EnableTagOverride              bool   `mapstructure:"enableTagOverride"`
 	DeregisterCriticalServiceAfter string `mapstructure:"deregisterCriticalServiceAfter"`
+	OnReload                       string `mapstructure:"onReload"`
+
+	Limits *RegistrationLimitsConfig `mapstructure:"limits"`
+}
+
+// RegistrationLimitsConfig caps the tags and meta of the Job's service,
+// below what Consul itself allows, so that a registration that's too big
+// is caught when the configuration is loaded. Zero is no limit.
+type RegistrationLimitsConfig struct {
+	MaxTags            int    `mapstructure:"maxTags"`
+	MaxTagLength       int    `mapstructure:"maxTagLength"`
+	MaxMeta            int    `mapstructure:"maxMeta"`
+	MaxMetaValueLength int    `mapstructure:"maxMetaValueLength"`
+	OnExceed           string `mapstructure:"onExceed"` // "error" or "warn"
 }
 
 // LoggingConfig handles job-specific logging fields
 type LoggingConfig struct {
-	Raw bool `mapstructure:"raw"`
+	Raw      bool          `mapstructure:"raw"`
+	Output   string        `mapstructure:"output"`
+	Rotate   *RotateConfig `mapstructure:"rotate"`
+	Buffer   int           `mapstructure:"buffer"`   // number of lines
+	Overflow string        `mapstructure:"overflow"` // "block" or "drop"
+	Binary   string        `mapstructure:"binary"`   // "hex" or "base64"
+}
+
+// RotateConfig configures rotation of a job's output file
+type RotateConfig struct {
+	MaxSize  int    `mapstructure:"maxSize"` // size in megabytes
+	MaxAge   string `mapstructure:"maxAge"`
+	Compress bool   `mapstructure:"compress"`
+	Keep     int    `mapstructure:"keep"`
 }
 
 // NewConfigs parses json config into a validated slice of Configs
 func NewConfigs(raw []interface{}, disc discovery.Backend) ([]*Config, error) {
-	var jobs []*Config
+	jobs, _, err := newConfigs(raw, disc, false, nil)
+	return jobs, err
+}
+
+// NewConfigsBestEffort parses json config into a validated slice of
+// Configs like NewConfigs, except that a job that fails to validate
+// doesn't fail the others. It's replaced by the Config of the same name
+// from prev, or left out if there isn't one, and its error is returned
+// by its name.
+func NewConfigsBestEffort(raw []interface{}, disc discovery.Backend,
+	prev []*Config) ([]*Config, map[string]error, error) {
+	return newConfigs(raw, disc, true, prev)
+}
+
+func newConfigs(raw []interface{}, disc discovery.Backend,
+	bestEffort bool, prev []*Config) ([]*Config, map[string]error, error) {
+	var decoded []*Config
 	if raw == nil {
-		return jobs, nil
+		return decoded, nil, nil
+	}
+	if err := decode.ToStruct(raw, &decoded); err != nil {
+		return nil, nil, fmt.Errorf("job configuration error: %v", err)
+	}
+	prevByName := map[string]*Config{}
+	for _, job := range prev {
+		prevByName[job.Name] = job
 	}
-	if err := decode.ToStruct(raw, &jobs); err != nil {
-		return nil, fmt.Errorf("job configuration error: %v", err)
+	jobs := make([]*Config, 0, len(decoded))
+	var failed map[string]error
+	for _, job := range decoded {
+		err := job.Validate(disc)
+		switch {
+		case err == nil:
+			jobs = append(jobs, job)
+		case !bestEffort:
+			return nil, nil, err
+		default:
+			if failed == nil {
+				failed = map[string]error{}
+			}
+			failed[job.Name] = err
+			if prevJob, ok := prevByName[job.Name]; ok {
+				jobs = append(jobs, prevJob)
+			}
+		}
+	}
+	jobs, failed, err := validateEnvJobs(jobs, bestEffort, prevByName, failed)
+	if err != nil {
+		return nil, nil, err
 	}
 	stopDependencies := make(map[string]string)
 	for _, job := range jobs {
-		if err := job.Validate(disc); err != nil {
-			return nil, err
-		}
 		if job.whenEvent.Code == events.Stopping {
 			stopDependencies[job.whenEvent.Source] = job.Name
 		}
@@ -111,7 +331,50 @@ func NewConfigs(raw []interface{}, disc discovery.Backend) ([]*Config, error) {
 			job.setStopping(dependent)
 		}
 	}
-	return jobs, nil
+	return jobs, failed, nil
+}
+
+// validateEnvJobs checks that the jobs referred to by each job's env
+// exist. Under bestEffort, a job that refers to one that doesn't is
+// failed like any other invalid job.
+func validateEnvJobs(jobs []*Config, bestEffort bool, prevByName map[string]*Config,
+	failed map[string]error) ([]*Config, map[string]error, error) {
+	names := map[string]bool{}
+	for _, job := range jobs {
+		names[job.Name] = true
+	}
+	valid := make([]*Config, 0, len(jobs))
+	for _, job := range jobs {
+		err := job.validateEnvJobs(names)
+		switch {
+		case err == nil:
+			valid = append(valid, job)
+		case !bestEffort:
+			return nil, nil, err
+		default:
+			if failed == nil {
+				failed = map[string]error{}
+			}
+			failed[job.Name] = err
+			if prevJob, ok := prevByName[job.Name]; ok {
+				valid = append(valid, prevJob)
+			}
+		}
+	}
+	return valid, failed, nil
+}
+
+func (cfg *Config) validateEnvJobs(names map[string]bool) error {
+	if cfg.env == nil {
+		return nil
+	}
+	for _, name := range cfg.env.jobNames() {
+		if !names[name] {
+			return fmt.Errorf("job[%s].env refers to unknown job '%s'",
+				cfg.Name, name)
+		}
+	}
+	return nil
 }
 
 // Validate ensures that a Config meets all constraints
@@ -128,8 +391,47 @@ func (cfg *Config) Validate(disc discovery.Backend) error {
 	if err := cfg.validateRestarts(); err != nil {
 		return err
 	}
+	if err := cfg.validateFlapping(); err != nil {
+		return err
+	}
+	if err := cfg.validateReadyFile(); err != nil {
+		return err
+	}
+	if err := cfg.validateRegisterWhen(); err != nil {
+		return err
+	}
+	if err := cfg.validateRequires(); err != nil {
+		return err
+	}
+	if err := cfg.validateEnvFiles(); err != nil {
+		return err
+	}
+	env, err := newEnvTemplates(cfg.Env)
+	if err != nil {
+		return fmt.Errorf("unable to parse job[%s].env: %v", cfg.Name, err)
+	}
+	cfg.env = env
+	if err := cfg.validateEnvPrecedence(); err != nil {
+		return err
+	}
+	if cfg.Primary && cfg.Health == nil {
+		// without a health check the job would never be ready
+		return fmt.Errorf("job[%s].health must be set for a primary job", cfg.Name)
+	}
 
-	return cfg.validateExec()
+	if err := cfg.validateExec(disc); err != nil {
+		return err
+	}
+	if err := cfg.validateRender(disc); err != nil {
+		return err
+	}
+	if err := cfg.validateDrainGate(); err != nil {
+		return err
+	}
+	if err := cfg.validateCheckNamespaces(); err != nil {
+		return err
+	}
+	return cfg.validateProcessTitle()
 }
 
 func (cfg *Config) setStopping(name string) {
@@ -141,6 +443,10 @@ func (cfg *Config) validateDiscovery(disc discovery.Backend) error {
 	if err := cfg.validateHealthCheck(); err != nil {
 		return err
 	}
+	if cfg.AdvertisePort != 0 && cfg.Port == 0 {
+		return fmt.Errorf("job[%s].advertisePort requires 'port' to be set",
+			cfg.Name)
+	}
 	// if port isn't set then we won't do any discovery for this job
 	if (cfg.Port == 0 || disc == nil) && cfg.Name != "" {
 		return nil
@@ -217,8 +523,8 @@ func (cfg *Config) validateFrequency() error {
 func (cfg *Config) validateWhenEvent() error {
 	whenTimeout, err := timing.GetTimeout(cfg.When.Timeout)
 	if err != nil {
-		return fmt.Errorf("unable to parse job[%s].when.timeout: %v",
-			cfg.Name, err)
+		return fmt.Errorf("unable to parse job[%s].when.timeout '%s': %v",
+			cfg.Name, cfg.When.Timeout, err)
 	}
 	cfg.whenTimeout = whenTimeout
 
@@ -256,7 +562,7 @@ func (cfg *Config) validateStoppingTimeout() error {
 	return nil
 }
 
-func (cfg *Config) validateExec() error {
+func (cfg *Config) validateExec(disc discovery.Backend) error {
 
 	if cfg.ExecTimeout == "" && cfg.freqInterval != 0 {
 		// periodic tasks require a timeout
@@ -289,7 +595,325 @@ func (cfg *Config) validateExec() error {
 			cfg.Name = cmd.Exec
 		}
 		cmd.Name = cfg.Name
+		if err := cfg.validateCPUTimeout(cmd); err != nil {
+			return err
+		}
+		if err := cfg.validateExecRetry(cmd); err != nil {
+			return err
+		}
+		if cfg.Nice < -20 || cfg.Nice > 19 {
+			return fmt.Errorf("job[%s].nice must be between -20 and 19", cfg.Name)
+		}
+		cmd.Nice = cfg.Nice
+		cmd.TTY = cfg.TTY
+		cmd.Title = cfg.ProcessTitle
+		if cfg.Security != nil {
+			hardening, err := commands.NewHardening(
+				cfg.Security.NoNewPrivs, cfg.Security.SeccompProfile)
+			if err != nil {
+				return fmt.Errorf("unable to load job[%s].security.seccompProfile: %v",
+					cfg.Name, err)
+			}
+			cmd.Hardening = hardening
+		}
+		if err := cfg.validateOutput(cmd); err != nil {
+			return err
+		}
+		if err := cfg.validateLogBuffer(cmd); err != nil {
+			return err
+		}
+		if err := cfg.validateBinaryLogging(cmd); err != nil {
+			return err
+		}
+		if err := cfg.validatePostStop(cmd); err != nil {
+			return err
+		}
+		if err := cfg.validateSteps(cmd); err != nil {
+			return err
+		}
+		success, err := newSuccess(fmt.Sprintf("job[%s].success", cfg.Name),
+			cfg.Success)
+		if err != nil {
+			return err
+		}
+		cmd.Success = success
 		cfg.exec = cmd
+		if err := cfg.validateExecArgs(disc); err != nil {
+			return err
+		}
+	} else if cfg.PostStop != nil {
+		return fmt.Errorf("job[%s].exec must be set to use postStop", cfg.Name)
+	} else if len(cfg.Steps) > 0 {
+		return fmt.Errorf("job[%s].exec must be set to use steps", cfg.Name)
+	}
+	return nil
+}
+
+// validateExecArgs parses any templates in the exec's arguments. The
+// templates are rendered against the result of the watch that starts the
+// job, so they're only used for jobs started by a watch.
+func (cfg *Config) validateExecArgs(disc discovery.Backend) error {
+	if !strings.HasPrefix(cfg.whenEvent.Source, "watch.") {
+		return nil
+	}
+	service := strings.TrimPrefix(cfg.whenEvent.Source, "watch.")
+	execArgs, err := newArgTemplates(cfg.exec.Args, service)
+	if err != nil {
+		return fmt.Errorf("unable to parse job[%s].exec template: %v", cfg.Name, err)
+	}
+	if execArgs == nil {
+		return nil
+	}
+	lister, ok := disc.(discovery.InstanceLister)
+	if !ok {
+		return fmt.Errorf("job[%s].exec templates require a discovery backend that lists instances",
+			cfg.Name)
+	}
+	cfg.execArgs = execArgs
+	cfg.upstream = lister
+	return nil
+}
+
+func (cfg *Config) validateRender(disc discovery.Backend) error {
+	if cfg.Render == nil {
+		return nil
+	}
+	if !strings.HasPrefix(cfg.whenEvent.Source, "watch.") {
+		return fmt.Errorf("job[%s].render requires the job to be started by a watch",
+			cfg.Name)
+	}
+	if cfg.Render.Template == "" || cfg.Render.Path == "" {
+		return fmt.Errorf("job[%s].render.template and render.path must be set",
+			cfg.Name)
+	}
+	lister, ok := disc.(discovery.InstanceLister)
+	if !ok {
+		return fmt.Errorf("job[%s].render requires a discovery backend that lists instances",
+			cfg.Name)
+	}
+	text, err := ioutil.ReadFile(cfg.Render.Template)
+	if err != nil {
+		return fmt.Errorf("unable to read job[%s].render.template: %v", cfg.Name, err)
+	}
+	tmpl, err := template.New(cfg.Render.Template).Parse(string(text))
+	if err != nil {
+		return fmt.Errorf("unable to parse job[%s].render.template: %v", cfg.Name, err)
+	}
+	cfg.renderer = &renderer{
+		tmpl:          tmpl,
+		path:          cfg.Render.Path,
+		skipUnchanged: cfg.Render.SkipUnchanged,
+		service:       strings.TrimPrefix(cfg.whenEvent.Source, "watch."),
+	}
+	cfg.upstream = lister
+	return nil
+}
+
+// UseWatchUpstreams points the exec templates and render of each job
+// started by a watch at the upstream of that watch, by the watch's name.
+// Until then they use the discovery backend, which doesn't know about
+// the instances found by a watch with another upstream, such as DNS.
+func UseWatchUpstreams(cfgs []*Config, upstreams map[string]discovery.Upstream) error {
+	for _, cfg := range cfgs {
+		if cfg.upstream == nil {
+			continue
+		}
+		lister, err := watchLister(cfg.Name, cfg.whenEvent.Source, upstreams)
+		if err != nil {
+			return err
+		}
+		if lister != nil {
+			cfg.upstream = lister
+		}
+	}
+	return nil
+}
+
+// watchLister returns the upstream of the named watch as an
+// InstanceLister, or nil if there's no such watch
+func watchLister(job, watch string, upstreams map[string]discovery.Upstream) (discovery.InstanceLister, error) {
+	upstream, ok := upstreams[watch]
+	if !ok {
+		return nil, nil
+	}
+	lister, ok := upstream.(discovery.InstanceLister)
+	if !ok {
+		return nil, fmt.Errorf("job[%s] templates require %s to list its instances",
+			job, watch)
+	}
+	return lister, nil
+}
+
+func (cfg *Config) validateCPUTimeout(cmd *commands.Command) error {
+	if cfg.CPUTimeout == "" {
+		return nil
+	}
+	cpuTimeout, err := timing.GetTimeout(cfg.CPUTimeout)
+	if err != nil {
+		return fmt.Errorf("unable to parse job[%s].cpuTimeout '%s': %v",
+			cfg.Name, cfg.CPUTimeout, err)
+	}
+	if cpuTimeout < time.Second {
+		// RLIMIT_CPU has a resolution of seconds
+		return fmt.Errorf("job[%s].cpuTimeout '%v' cannot be less than 1s",
+			cfg.Name, cfg.CPUTimeout)
+	}
+	cmd.CPUTimeout = cpuTimeout
+	return nil
+}
+
+func (cfg *Config) validateExecRetry(cmd *commands.Command) error {
+	if cfg.ExecRetry == nil {
+		return nil
+	}
+	if cfg.ExecRetry.Attempts < 0 {
+		return fmt.Errorf("job[%s].execRetry.attempts must be >= 0", cfg.Name)
+	}
+	retry := &commands.ExecRetry{
+		Attempts: cfg.ExecRetry.Attempts,
+		Delay:    commands.DefaultExecRetry.Delay,
+	}
+	if cfg.ExecRetry.Delay != "" {
+		delay, err := timing.GetTimeout(cfg.ExecRetry.Delay)
+		if err != nil {
+			return fmt.Errorf("unable to parse job[%s].execRetry.delay '%s': %v",
+				cfg.Name, cfg.ExecRetry.Delay, err)
+		}
+		if delay <= 0 {
+			return fmt.Errorf("job[%s].execRetry.delay must be > 0", cfg.Name)
+		}
+		retry.Delay = delay
+	}
+	cmd.ExecRetry = retry
+	return nil
+}
+
+func (cfg *Config) validatePostStop(cmd *commands.Command) error {
+	if cfg.PostStop == nil {
+		return nil
+	}
+	timeout := defaultPostStopTimeout
+	if cfg.PostStop.Timeout != "" {
+		parsedTimeout, err := timing.GetTimeout(cfg.PostStop.Timeout)
+		if err != nil {
+			return fmt.Errorf("unable to parse job[%s].postStop.timeout '%s': %v",
+				cfg.Name, cfg.PostStop.Timeout, err)
+		}
+		timeout = parsedTimeout
+	}
+	fields := log.Fields{"job": cfg.Name + ".postStop"}
+	if cfg.Logging != nil && cfg.Logging.Raw {
+		fields = nil
+	}
+	hook, err := commands.NewCommand(cfg.PostStop.Exec, timeout, fields)
+	if err != nil {
+		return fmt.Errorf("unable to create job[%s].postStop.exec: %v",
+			cfg.Name, err)
+	}
+	hook.Name = cfg.Name + ".postStop"
+	cmd.PostStop = hook
+	return nil
+}
+
+// validateSteps creates the commands run in order before the job's exec.
+// Steps are named <job>.step<N>, counting from 1, and have no timeout
+// unless one is set.
+func (cfg *Config) validateSteps(cmd *commands.Command) error {
+	for i, step := range cfg.Steps {
+		var timeout time.Duration
+		if step.Timeout != "" {
+			parsedTimeout, err := timing.GetTimeout(step.Timeout)
+			if err != nil {
+				return fmt.Errorf("unable to parse job[%s].steps[%d].timeout '%s': %v",
+					cfg.Name, i, step.Timeout, err)
+			}
+			timeout = parsedTimeout
+		}
+		name := fmt.Sprintf("%s.step%d", cfg.Name, i+1)
+		fields := log.Fields{"job": name}
+		if cfg.Logging != nil && cfg.Logging.Raw {
+			fields = nil
+		}
+		stepCmd, err := commands.NewCommand(step.Exec, timeout, fields)
+		if err != nil {
+			return fmt.Errorf("unable to create job[%s].steps[%d].exec: %v",
+				cfg.Name, i, err)
+		}
+		stepCmd.Name = name
+		cmd.Steps = append(cmd.Steps, stepCmd)
+	}
+	return nil
+}
+
+func (cfg *Config) validateOutput(cmd *commands.Command) error {
+	if cfg.Logging == nil || cfg.Logging.Output == "" {
+		if cfg.Logging != nil && cfg.Logging.Rotate != nil {
+			return fmt.Errorf("job[%s].logging.output must be set to use rotate",
+				cfg.Name)
+		}
+		return nil
+	}
+	out := &commands.OutputFile{Path: cfg.Logging.Output}
+	if rotate := cfg.Logging.Rotate; rotate != nil {
+		if rotate.MaxSize < 0 {
+			return fmt.Errorf("job[%s].logging.rotate.maxSize must be >= 0",
+				cfg.Name)
+		}
+		if rotate.Keep < 0 {
+			return fmt.Errorf("job[%s].logging.rotate.keep must be >= 0",
+				cfg.Name)
+		}
+		maxAge, err := timing.GetTimeout(rotate.MaxAge)
+		if err != nil {
+			return fmt.Errorf("unable to parse job[%s].logging.rotate.maxAge '%s': %v",
+				cfg.Name, rotate.MaxAge, err)
+		}
+		out.MaxSize = int64(rotate.MaxSize) * 1024 * 1024
+		out.MaxAge = maxAge
+		out.Compress = rotate.Compress
+		out.Keep = rotate.Keep
+	}
+	cmd.Output = out
+	return nil
+}
+
+func (cfg *Config) validateBinaryLogging(cmd *commands.Command) error {
+	if cfg.Logging == nil {
+		return nil
+	}
+	switch cfg.Logging.Binary {
+	case "", commands.BinaryHex, commands.BinaryBase64:
+		cmd.BinaryEncoding = cfg.Logging.Binary
+		return nil
+	}
+	return fmt.Errorf("job[%s].logging.binary must be one of 'hex' or 'base64'",
+		cfg.Name)
+}
+
+func (cfg *Config) validateLogBuffer(cmd *commands.Command) error {
+	if cfg.Logging == nil {
+		return nil
+	}
+	if cfg.Logging.Buffer < 0 {
+		return fmt.Errorf("job[%s].logging.buffer must be >= 0", cfg.Name)
+	}
+	switch cfg.Logging.Overflow {
+	case "", "block", "drop":
+	default:
+		return fmt.Errorf(
+			"job[%s].logging.overflow must be one of 'block' or 'drop'",
+			cfg.Name)
+	}
+	if cfg.Logging.Buffer == 0 {
+		if cfg.Logging.Overflow != "" {
+			return fmt.Errorf("job[%s].logging.buffer must be set to use overflow",
+				cfg.Name)
+		}
+		return nil
+	}
+	cmd.LogBuffer = &commands.LogBuffer{
+		Size: cfg.Logging.Buffer,
+		Drop: cfg.Logging.Overflow == "drop",
 	}
 	return nil
 }
@@ -311,6 +935,12 @@ func (cfg *Config) validateHealthCheck() error {
 	cfg.ttl = cfg.Health.TTL
 	cfg.heartbeatInterval = time.Duration(cfg.Health.Heartbeat) * time.Second
 
+	success, err := newSuccess(
+		fmt.Sprintf("job[%s].health.success", cfg.Name), cfg.Health.Success)
+	if err != nil {
+		return err
+	}
+
 	var checkTimeout time.Duration
 	if cfg.Health.CheckTimeout != "" {
 		parsedTimeout, err := timing.GetTimeout(cfg.Health.CheckTimeout)
@@ -330,6 +960,10 @@ func (cfg *Config) validateHealthCheck() error {
 		if cfg.Health.Logging != nil && cfg.Health.Logging.Raw {
 			fields = nil
 		}
+		if cfg.Health.Logging != nil && cfg.Health.Logging.Output != "" {
+			return fmt.Errorf("job[%s].health.logging.output is not supported",
+				cfg.Name)
+		}
 
 		log.Debugf("job[%s].health.exec fields: %v", cfg.Name, fields)
 		cmd, err := commands.NewCommand(cfg.Health.CheckExec, checkTimeout, fields)
@@ -338,8 +972,173 @@ func (cfg *Config) validateHealthCheck() error {
 				cfg.Name, err)
 		}
 		cmd.Name = checkName
+		cmd.Success = success
 		cfg.healthCheckExec = cmd
 	}
+	if err := cfg.validateSubChecks(checkTimeout, success); err != nil {
+		return err
+	}
+	if err := cfg.validateWarningRetries(); err != nil {
+		return err
+	}
+	return cfg.validateResolver()
+}
+
+// validateResolver creates the command that produces the target of the
+// health checks, which is run once before the checks begin
+func (cfg *Config) validateResolver() error {
+	resolver := cfg.Health.Resolver
+	if resolver == nil {
+		return nil
+	}
+	if cfg.healthCheckExec == nil && len(cfg.healthChecks) == 0 {
+		return fmt.Errorf("job[%s].health.exec or health.checks must be set to use resolver",
+			cfg.Name)
+	}
+	var timeout time.Duration
+	if resolver.Timeout != "" {
+		parsedTimeout, err := timing.GetTimeout(resolver.Timeout)
+		if err != nil {
+			return fmt.Errorf("unable to parse job[%s].health.resolver.timeout '%s': %v",
+				cfg.Name, resolver.Timeout, err)
+		}
+		timeout = parsedTimeout
+	} else {
+		timeout = cfg.heartbeatInterval
+	}
+	name := fmt.Sprintf("%s.resolver", cfg.Name)
+	cmd, err := commands.NewCommand(resolver.Exec, timeout, log.Fields{"check": name})
+	if err != nil {
+		return fmt.Errorf("unable to create job[%s].health.resolver.exec: %v",
+			cfg.Name, err)
+	}
+	cmd.Name = name
+	cfg.checkResolver = cmd
+	return nil
+}
+
+// validateWarningRetries checks the number of times we re-run failed
+// non-critical checks before reporting a warning. Only jobs with several
+// health checks can be in a warning state.
+func (cfg *Config) validateWarningRetries() error {
+	if cfg.Health.WarningRetries < 0 {
+		return fmt.Errorf("job[%s].health.warningRetries must be >= 0", cfg.Name)
+	}
+	if cfg.Health.WarningRetries > 0 && len(cfg.healthChecks) == 0 {
+		return fmt.Errorf("job[%s].health.checks must be set to use warningRetries",
+			cfg.Name)
+	}
+	cfg.warningRetries = cfg.Health.WarningRetries
+	return nil
+}
+
+// newSuccess validates the SuccessConfig at the given config field
+func newSuccess(field string, cfg *SuccessConfig) (*commands.Success, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	success := &commands.Success{}
+	for _, code := range cfg.Codes {
+		if code < 1 || code > 255 {
+			return nil, fmt.Errorf("%s.codes '%d' must be between 1 and 255",
+				field, code)
+		}
+		success.Codes = append(success.Codes, code)
+	}
+	if cfg.Output != "" {
+		re, err := regexp.Compile(cfg.Output)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse %s.output '%s': %v",
+				field, cfg.Output, err)
+		}
+		success.Output = re
+	}
+	return success, nil
+}
+
+func (cfg *Config) validateSubChecks(checkTimeout time.Duration, success *commands.Success) error {
+	if len(cfg.Health.Checks) == 0 {
+		return nil
+	}
+	if cfg.Health.CheckExec != nil {
+		return fmt.Errorf("job[%s].health can have only one of 'exec' or 'checks'",
+			cfg.Name)
+	}
+	names := make(map[string]bool)
+	for i, check := range cfg.Health.Checks {
+		name := check.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		if names[name] {
+			return fmt.Errorf("job[%s].health.checks has more than one check named '%s'",
+				cfg.Name, name)
+		}
+		names[name] = true
+		checkName := fmt.Sprintf("check.%s.%s", cfg.Name, name)
+		fields := log.Fields{"check": checkName}
+		if cfg.Health.Logging != nil && cfg.Health.Logging.Raw {
+			fields = nil
+		}
+		cmd, err := commands.NewCommand(check.Exec, checkTimeout, fields)
+		if err != nil {
+			return fmt.Errorf("unable to create job[%s].health.checks[%s].exec: %v",
+				cfg.Name, name, err)
+		}
+		cmd.Name = checkName
+		cmd.Success = success
+		cfg.healthChecks = append(cfg.healthChecks, &subCheck{
+			exec:     cmd,
+			critical: check.Critical == nil || *check.Critical,
+		})
+	}
+	return nil
+}
+
+// validateCheckNamespaces configures the health checks to run in the
+// namespaces of the job's process. This has to happen after the exec
+// is validated.
+func (cfg *Config) validateCheckNamespaces() error {
+	if cfg.Health == nil || len(cfg.Health.Namespaces) == 0 {
+		return nil
+	}
+	if cfg.exec == nil {
+		return fmt.Errorf("job[%s].exec must be set to use health.namespaces",
+			cfg.Name)
+	}
+	for _, nsType := range cfg.Health.Namespaces {
+		if !commands.ValidNamespaceTypes[nsType] {
+			return fmt.Errorf("job[%s].health.namespaces '%s' is not a valid namespace",
+				cfg.Name, nsType)
+		}
+	}
+	namespaces := &commands.Namespaces{
+		Target: cfg.exec,
+		Types:  cfg.Health.Namespaces,
+	}
+	if cfg.healthCheckExec != nil {
+		cfg.healthCheckExec.Namespaces = namespaces
+	}
+	for _, check := range cfg.healthChecks {
+		check.exec.Namespaces = namespaces
+	}
+	return nil
+}
+
+// validateProcessTitle rejects a process title on any of the job's
+// commands that run in another process' namespaces. nsenter execs those
+// commands itself, so the title would be silently dropped.
+func (cfg *Config) validateProcessTitle() error {
+	cmds := []*commands.Command{cfg.exec, cfg.healthCheckExec}
+	for _, check := range cfg.healthChecks {
+		cmds = append(cmds, check.exec)
+	}
+	for _, cmd := range cmds {
+		if cmd != nil && cmd.Title != "" && cmd.Namespaces != nil {
+			return fmt.Errorf("job[%s].processTitle can't be used with namespaces",
+				cfg.Name)
+		}
+	}
 	return nil
 }
 
@@ -395,6 +1194,174 @@ func (cfg *Config) validateRestarts() error {
 	return nil
 }
 
+const defaultReadyFileInterval = time.Second
+
+// defaults for restarting a process that exits too quickly
+const (
+	defaultFlapBackoff    = time.Second
+	defaultFlapMaxBackoff = 30 * time.Second
+	defaultFlapLimit      = 5
+)
+
+// validateFlapping checks the thresholds for processes that exit too
+// quickly after they start. Only jobs that restart their process on exit
+// can flap.
+func (cfg *Config) validateFlapping() error {
+	if cfg.Flapping == nil {
+		return nil
+	}
+	if cfg.freqInterval > 0 {
+		return fmt.Errorf("job[%s].flapping cannot be used with when.interval",
+			cfg.Name)
+	}
+	if cfg.restartLimit == 0 {
+		return fmt.Errorf("job[%s].restarts must be set to use flapping", cfg.Name)
+	}
+	if cfg.Flapping.MinRuntime == "" {
+		return fmt.Errorf("job[%s].flapping.minRuntime must be set", cfg.Name)
+	}
+	minRuntime, err := timing.GetTimeout(cfg.Flapping.MinRuntime)
+	if err != nil {
+		return fmt.Errorf("unable to parse job[%s].flapping.minRuntime '%s': %v",
+			cfg.Name, cfg.Flapping.MinRuntime, err)
+	}
+	if minRuntime < taskMinDuration {
+		return fmt.Errorf("job[%s].flapping.minRuntime '%s' cannot be less than %v",
+			cfg.Name, cfg.Flapping.MinRuntime, taskMinDuration)
+	}
+	cfg.flapMinRuntime = minRuntime
+	cfg.flapBackoff = defaultFlapBackoff
+	if cfg.Flapping.Backoff != "" {
+		backoff, err := timing.GetTimeout(cfg.Flapping.Backoff)
+		if err != nil {
+			return fmt.Errorf("unable to parse job[%s].flapping.backoff '%s': %v",
+				cfg.Name, cfg.Flapping.Backoff, err)
+		}
+		cfg.flapBackoff = backoff
+	}
+	cfg.flapMaxBackoff = defaultFlapMaxBackoff
+	if cfg.Flapping.MaxBackoff != "" {
+		maxBackoff, err := timing.GetTimeout(cfg.Flapping.MaxBackoff)
+		if err != nil {
+			return fmt.Errorf("unable to parse job[%s].flapping.maxBackoff '%s': %v",
+				cfg.Name, cfg.Flapping.MaxBackoff, err)
+		}
+		cfg.flapMaxBackoff = maxBackoff
+	}
+	if cfg.flapMaxBackoff < cfg.flapBackoff {
+		return fmt.Errorf("job[%s].flapping.maxBackoff cannot be less than flapping.backoff",
+			cfg.Name)
+	}
+	switch {
+	case cfg.Flapping.Limit < 0:
+		return fmt.Errorf("job[%s].flapping.limit must be > 0", cfg.Name)
+	case cfg.Flapping.Limit == 0:
+		cfg.flapLimit = defaultFlapLimit
+	default:
+		cfg.flapLimit = cfg.Flapping.Limit
+	}
+	return nil
+}
+
+func (cfg *Config) validateReadyFile() error {
+	if cfg.ReadyFile == nil {
+		return nil
+	}
+	if cfg.ReadyFile.Path == "" {
+		return fmt.Errorf("job[%s].readyFile.path must be set", cfg.Name)
+	}
+	cfg.readyFilePath = cfg.ReadyFile.Path
+	cfg.readyFileInterval = defaultReadyFileInterval
+	if cfg.ReadyFile.Interval != "" {
+		interval, err := timing.GetTimeout(cfg.ReadyFile.Interval)
+		if err != nil {
+			return fmt.Errorf("unable to parse job[%s].readyFile.interval '%s': %v",
+				cfg.Name, cfg.ReadyFile.Interval, err)
+		}
+		if interval < taskMinDuration {
+			return fmt.Errorf("job[%s].readyFile.interval '%s' cannot be less than %v",
+				cfg.Name, cfg.ReadyFile.Interval, taskMinDuration)
+		}
+		cfg.readyFileInterval = interval
+	}
+	timeout, err := timing.GetTimeout(cfg.ReadyFile.Timeout)
+	if err != nil {
+		return fmt.Errorf("unable to parse job[%s].readyFile.timeout '%s': %v",
+			cfg.Name, cfg.ReadyFile.Timeout, err)
+	}
+	cfg.readyFileTimeout = timeout
+	return nil
+}
+
+func (cfg *Config) validateRegisterWhen() error {
+	if cfg.RegisterWhen == nil {
+		return nil
+	}
+	if cfg.RegisterWhen.Source == "" {
+		return fmt.Errorf("job[%s].registerWhen.source must be set", cfg.Name)
+	}
+	if cfg.RegisterWhen.Source == cfg.Name {
+		return fmt.Errorf("job[%s].registerWhen.source cannot be the job itself",
+			cfg.Name)
+	}
+	if cfg.serviceDefinition == nil {
+		return fmt.Errorf("job[%s].port must be set to use registerWhen", cfg.Name)
+	}
+	cfg.registerSource = cfg.RegisterWhen.Source
+	return nil
+}
+
+func (cfg *Config) validateRequires() error {
+	if cfg.Requires == nil {
+		return nil
+	}
+	if cfg.Requires.Source == "" {
+		return fmt.Errorf("job[%s].requires.source must be set", cfg.Name)
+	}
+	if cfg.Requires.Source == cfg.Name {
+		return fmt.Errorf("job[%s].requires.source cannot be the job itself",
+			cfg.Name)
+	}
+	if cfg.Exec == nil {
+		return fmt.Errorf("job[%s].exec must be set to use requires", cfg.Name)
+	}
+	cfg.requiresSource = cfg.Requires.Source
+	return nil
+}
+
+// defaultEnvFileInterval is how often env files are re-read if the job
+// doesn't set its own interval
+const defaultEnvFileInterval = 30 * time.Second
+
+func (cfg *Config) validateEnvFiles() error {
+	if cfg.EnvFiles == nil {
+		return nil
+	}
+	paths, err := decode.ToStrings(cfg.EnvFiles.Paths)
+	if err != nil {
+		return fmt.Errorf("unable to parse job[%s].envFiles.paths: %v",
+			cfg.Name, err)
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("job[%s].envFiles.paths must be set", cfg.Name)
+	}
+	cfg.envFilePaths = paths
+	cfg.envFileInterval = defaultEnvFileInterval
+	if cfg.EnvFiles.Interval != "" {
+		interval, err := timing.GetTimeout(cfg.EnvFiles.Interval)
+		if err != nil {
+			return fmt.Errorf("unable to parse job[%s].envFiles.interval '%s': %v",
+				cfg.Name, cfg.EnvFiles.Interval, err)
+		}
+		if interval < taskMinDuration {
+			return fmt.Errorf("job[%s].envFiles.interval '%s' cannot be less than %v",
+				cfg.Name, cfg.EnvFiles.Interval, taskMinDuration)
+		}
+		cfg.envFileInterval = interval
+	}
+	return nil
+}
+
 // addDiscoveryConfig validates the configuration for service discovery
 // and attaches the discovery.ServiceDefinition to the Config
 func (cfg *Config) addDiscoveryConfig(disc discovery.Backend) error {
@@ -406,30 +1373,59 @@ func (cfg *Config) addDiscoveryConfig(disc discovery.Backend) error {
 	if err != nil {
 		return err
 	}
-	hostname, _ := os.Hostname()
-	id := fmt.Sprintf("%s-%s", cfg.Name, hostname)
+	id, err := cfg.serviceID()
+	if err != nil {
+		return err
+	}
+	if err := cfg.validateMeta(); err != nil {
+		return err
+	}
+	if err := cfg.validateRegistrationLimits(); err != nil {
+		return err
+	}
+	port := cfg.Port
+	if cfg.AdvertisePort != 0 {
+		if cfg.AdvertisePort < 0 || cfg.AdvertisePort > 65535 {
+			return fmt.Errorf("job[%s].advertisePort must be between 1 and 65535",
+				cfg.Name)
+		}
+		port = cfg.AdvertisePort
+	}
 
 	var (
 		enableTagOverride bool
 		deregAfter        string
 	)
 
+	cfg.keepOnReload = true
 	if cfg.ConsulExtras != nil {
 		deregAfter = cfg.ConsulExtras.DeregisterCriticalServiceAfter
-		_, err := time.ParseDuration(deregAfter)
-		if err != nil {
-			return fmt.Errorf(
-				"unable to parse job[%s].consul.deregisterCriticalServiceAfter: %s",
-				cfg.Name, err)
+		if deregAfter != "" {
+			_, err := time.ParseDuration(deregAfter)
+			if err != nil {
+				return fmt.Errorf(
+					"unable to parse job[%s].consul.deregisterCriticalServiceAfter: %s",
+					cfg.Name, err)
+			}
 		}
 		enableTagOverride = cfg.ConsulExtras.EnableTagOverride
+		switch cfg.ConsulExtras.OnReload {
+		case "", "update":
+		case "deregister":
+			cfg.keepOnReload = false
+		default:
+			return fmt.Errorf("job[%s].consul.onReload must be one of 'update' or 'deregister'",
+				cfg.Name)
+		}
 	}
 	cfg.serviceDefinition = &discovery.ServiceDefinition{
 		ID:                             id,
 		Name:                           cfg.Name,
-		Port:                           cfg.Port,
+		Port:                           port,
+		ListenPort:                     cfg.Port,
 		TTL:                            cfg.ttl,
 		Tags:                           cfg.Tags,
+		Meta:                           cfg.Meta,
 		InitialStatus:                  cfg.InitialStatus,
 		IPAddress:                      ipAddress,
 		DeregisterCriticalServiceAfter: deregAfter,
@@ -439,6 +1435,129 @@ func (cfg *Config) addDiscoveryConfig(disc discovery.Backend) error {
 	return nil
 }
 
+// these are the same limits the Consul agent enforces on service meta
+const (
+	metaMaxPairs       = 64
+	metaMaxKeyLength   = 128
+	metaMaxValueLength = 512
+)
+
+var metaKeyRegex = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+
+func (cfg *Config) validateMeta() error {
+	if len(cfg.Meta) > metaMaxPairs {
+		return fmt.Errorf("job[%s].meta cannot have more than %d keys",
+			cfg.Name, metaMaxPairs)
+	}
+	for key, value := range cfg.Meta {
+		if !metaKeyRegex.MatchString(key) || len(key) > metaMaxKeyLength {
+			return fmt.Errorf("job[%s].meta key '%s' must be at most %d "+
+				"letters, numbers, '_', or '-'", cfg.Name, key, metaMaxKeyLength)
+		}
+		if strings.HasPrefix(key, "consul-") {
+			return fmt.Errorf("job[%s].meta key '%s' cannot use the reserved "+
+				"'consul-' prefix", cfg.Name, key)
+		}
+		if len(value) > metaMaxValueLength {
+			return fmt.Errorf("job[%s].meta value for '%s' cannot be longer than %d",
+				cfg.Name, key, metaMaxValueLength)
+		}
+	}
+	return nil
+}
+
+// validateRegistrationLimits checks the tags and meta against the limits
+// in the consul block, returning an error or only logging a warning for
+// each that's exceeded
+func (cfg *Config) validateRegistrationLimits() error {
+	if cfg.ConsulExtras == nil || cfg.ConsulExtras.Limits == nil {
+		return nil
+	}
+	limits := cfg.ConsulExtras.Limits
+	if limits.MaxTags < 0 || limits.MaxTagLength < 0 ||
+		limits.MaxMeta < 0 || limits.MaxMetaValueLength < 0 {
+		return fmt.Errorf("job[%s].consul.limits must be >= 0", cfg.Name)
+	}
+	if limits.MaxMeta > metaMaxPairs {
+		return fmt.Errorf("job[%s].consul.limits.maxMeta cannot be more than %d",
+			cfg.Name, metaMaxPairs)
+	}
+	if limits.MaxMetaValueLength > metaMaxValueLength {
+		return fmt.Errorf("job[%s].consul.limits.maxMetaValueLength cannot be more than %d",
+			cfg.Name, metaMaxValueLength)
+	}
+	exceeded := func(format string, args ...interface{}) error {
+		return fmt.Errorf(format, args...)
+	}
+	switch limits.OnExceed {
+	case "", "error":
+	case "warn":
+		exceeded = func(format string, args ...interface{}) error {
+			log.Warnf(format, args...)
+			return nil
+		}
+	default:
+		return fmt.Errorf("job[%s].consul.limits.onExceed must be one of 'error' or 'warn'",
+			cfg.Name)
+	}
+
+	if limits.MaxTags > 0 && len(cfg.Tags) > limits.MaxTags {
+		if err := exceeded("job[%s].tags has %d tags, more than the limit of %d",
+			cfg.Name, len(cfg.Tags), limits.MaxTags); err != nil {
+			return err
+		}
+	}
+	if limits.MaxTagLength > 0 {
+		for _, tag := range cfg.Tags {
+			if len(tag) > limits.MaxTagLength {
+				if err := exceeded("job[%s].tags '%s' is longer than the limit of %d",
+					cfg.Name, tag, limits.MaxTagLength); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if limits.MaxMeta > 0 && len(cfg.Meta) > limits.MaxMeta {
+		if err := exceeded("job[%s].meta has %d keys, more than the limit of %d",
+			cfg.Name, len(cfg.Meta), limits.MaxMeta); err != nil {
+			return err
+		}
+	}
+	if limits.MaxMetaValueLength > 0 {
+		// sorted so that the same key is reported each time
+		keys := make([]string, 0, len(cfg.Meta))
+		for key := range cfg.Meta {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if len(cfg.Meta[key]) > limits.MaxMetaValueLength {
+				if err := exceeded("job[%s].meta value for '%s' is longer than the limit of %d",
+					cfg.Name, key, limits.MaxMetaValueLength); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+var serviceIDRegex = regexp.MustCompile("^[a-zA-Z0-9_.:-]+$")
+
+// serviceID returns the configured ID for the job's service, or
+// generates one from the job name and hostname
+func (cfg *Config) serviceID() (string, error) {
+	if cfg.ID == "" {
+		hostname, _ := os.Hostname()
+		return fmt.Sprintf("%s-%s", cfg.Name, hostname), nil
+	}
+	if !serviceIDRegex.MatchString(cfg.ID) {
+		return "", fmt.Errorf("job[%s].id '%s' must contain only letters, "+
+			"numbers, '_', '.', ':', or '-'", cfg.Name, cfg.ID)
+	}
+	return cfg.ID, nil
+}
+
 // String implements the stdlib fmt.Stringer interface for pretty-printing
 func (cfg *Config) String() string {
 	return "jobs.Config[" + cfg.Name + "]"
@@ -3,11 +3,15 @@ package jobs
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/joyent/containerpilot/commands"
+	"github.com/joyent/containerpilot/config/template"
 	"github.com/joyent/containerpilot/events"
 	"github.com/joyent/containerpilot/tests"
 	"github.com/joyent/containerpilot/tests/mocks"
@@ -164,6 +168,94 @@ func TestJobConfigConsulExtras(t *testing.T) {
 	assert.Equal(job.restartLimit, 0, "config.for job.restartLimit")
 }
 
+func TestJobConfigMeta(t *testing.T) {
+	os.Setenv("TEST_BUILD_SHA", "abc123")
+	defer os.Unsetenv("TEST_BUILD_SHA")
+	rendered, err := template.Apply([]byte(`[{
+		name: "myjob",
+		port: 80,
+		interfaces: ["inet", "lo0"],
+		health: {exec: "true", interval: 1, ttl: 5},
+		meta: {build_sha: "{{ .TEST_BUILD_SHA }}", team: "platform"}
+	}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := &mocks.RegistryDiscoveryBackend{}
+	cfgs, err := NewConfigs(tests.DecodeRawToSlice(string(rendered)), registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := cfgs[0].serviceDefinition
+	service.SendHeartbeat() // force registration
+	registration := registry.Registration(service.ID)
+	if registration == nil {
+		t.Fatal("expected service to be registered")
+	}
+	assert.Equal(t, map[string]string{"build_sha": "abc123", "team": "platform"},
+		registration.Meta)
+}
+
+func TestJobConfigAdvertisePort(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[{
+		name: "myjob",
+		port: 8080,
+		advertisePort: 32080,
+		interfaces: ["inet", "lo0"],
+		health: {exec: "true", interval: 1, ttl: 5}
+	}]`)
+	registry := &mocks.RegistryDiscoveryBackend{}
+	cfgs, err := NewConfigs(testCfg, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := cfgs[0].serviceDefinition
+	assert.Equal(t, 8080, service.ListenPort)
+	service.SendHeartbeat() // force registration
+	registration := registry.Registration(service.ID)
+	if registration == nil {
+		t.Fatal("expected service to be registered")
+	}
+	assert.Equal(t, 32080, registration.Port)
+}
+
+func TestJobConfigServiceID(t *testing.T) {
+	os.Setenv("TEST_INSTANCE", "az1-3")
+	defer os.Unsetenv("TEST_INSTANCE")
+	rendered, err := template.Apply([]byte(`[{
+		name: "myjob",
+		id: "myjob-{{ .TEST_INSTANCE }}",
+		port: 80,
+		interfaces: ["inet", "lo0"],
+		health: {exec: "true", interval: 1, ttl: 5}
+	}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	register := func() string {
+		registry := &mocks.RegistryDiscoveryBackend{}
+		cfgs, err := NewConfigs(tests.DecodeRawToSlice(string(rendered)), registry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		service := cfgs[0].serviceDefinition
+		service.SendHeartbeat() // force registration
+		if !registry.IsRegistered("myjob-az1-3") {
+			t.Fatalf("expected service to be registered with configured ID")
+		}
+		return service.ID
+	}
+	first := register()
+	assert.Equal(t, first, register(), "expected ID to be stable across restarts")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(`[{
+		name: "myjob", id: "my/job", port: 80,
+		interfaces: ["inet", "lo0"],
+		health: {exec: "true", interval: 1, ttl: 5}}]`), noop)
+	assert.EqualError(t, err, "job[myjob].id 'my/job' must contain only "+
+		"letters, numbers, '_', '.', ':', or '-'")
+}
+
 func TestJobConfigSmokeTest(t *testing.T) {
 	data, _ := ioutil.ReadFile(fmt.Sprintf("./testdata/%s.json5", t.Name()))
 	testCfg := tests.DecodeRawToSlice(string(data))
@@ -284,6 +376,278 @@ func TestJobConfigValidateDiscovery(t *testing.T) {
 	}
 }
 
+func TestErrJobConfigMeta(t *testing.T) {
+	expectErr := func(meta, errMsg string) {
+		testCfg := tests.DecodeRawToSlice(fmt.Sprintf(`[{
+			name: "myjob", port: 80, interfaces: ["inet", "lo0"],
+			health: {exec: "true", interval: 1, ttl: 5},
+			meta: %s}]`, meta))
+		_, err := NewConfigs(testCfg, noop)
+		assert.EqualError(t, err, errMsg)
+	}
+	expectErr(`{"bad key": "x"}`,
+		"job[myjob].meta key 'bad key' must be at most 128 letters, numbers, '_', or '-'")
+	expectErr(`{"consul-version": "x"}`,
+		"job[myjob].meta key 'consul-version' cannot use the reserved 'consul-' prefix")
+}
+
+func TestJobConfigRegistrationLimits(t *testing.T) {
+	newCfg := func(limits string) (*Config, error) {
+		testCfg := tests.DecodeRawToSlice(fmt.Sprintf(`[{
+			name: "myjob", port: 80, interfaces: ["inet", "lo0"],
+			health: {exec: "true", interval: 1, ttl: 5},
+			tags: ["a", "b", "c", "a-much-longer-tag"],
+			meta: {short: "x", long: "xxxxxxxxxxxx"},
+			consul: {limits: %s}}]`, limits))
+		cfgs, err := NewConfigs(testCfg, noop)
+		if err != nil {
+			return nil, err
+		}
+		return cfgs[0], nil
+	}
+	expectErr := func(limits, errMsg string) {
+		_, err := newCfg(limits)
+		assert.EqualError(t, err, errMsg)
+	}
+
+	expectErr(`{maxTags: 3}`,
+		"job[myjob].tags has 4 tags, more than the limit of 3")
+	expectErr(`{maxTagLength: 8}`,
+		"job[myjob].tags 'a-much-longer-tag' is longer than the limit of 8")
+	expectErr(`{maxMeta: 1}`,
+		"job[myjob].meta has 2 keys, more than the limit of 1")
+	expectErr(`{maxMetaValueLength: 10}`,
+		"job[myjob].meta value for 'long' is longer than the limit of 10")
+	expectErr(`{maxMeta: 100}`,
+		"job[myjob].consul.limits.maxMeta cannot be more than 64")
+	expectErr(`{maxTags: -1}`,
+		"job[myjob].consul.limits must be >= 0")
+	expectErr(`{maxTags: 3, onExceed: "ignore"}`,
+		"job[myjob].consul.limits.onExceed must be one of 'error' or 'warn'")
+
+	// within the limits, or only warning, the service is still defined
+	cfg, err := newCfg(`{maxTags: 4, maxTagLength: 17, maxMeta: 2, maxMetaValueLength: 12}`)
+	assert.NoError(t, err)
+	assert.NotNil(t, cfg.serviceDefinition)
+	cfg, err = newCfg(`{maxTags: 3, onExceed: "warn"}`)
+	assert.NoError(t, err)
+	assert.Len(t, cfg.serviceDefinition.Tags, 4)
+}
+
+func TestErrJobConfigAdvertisePort(t *testing.T) {
+	expectErr := func(test, errMsg string) {
+		_, err := NewConfigs(tests.DecodeRawToSlice(test), noop)
+		assert.EqualError(t, err, errMsg)
+	}
+	expectErr(`[{name: "myjob", advertisePort: 32080, exec: "true"}]`,
+		"job[myjob].advertisePort requires 'port' to be set")
+	expectErr(`[{
+		name: "myjob", port: 80, advertisePort: 70000,
+		interfaces: ["inet", "lo0"],
+		health: {exec: "true", interval: 1, ttl: 5}}]`,
+		"job[myjob].advertisePort must be between 1 and 65535")
+}
+
+func TestJobConfigLogBuffer(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[{
+		name: "myjob", exec: "true",
+		logging: {buffer: 100, overflow: "drop"}}]`)
+	cfgs, err := NewConfigs(testCfg, noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, &commands.LogBuffer{Size: 100, Drop: true},
+		cfgs[0].exec.LogBuffer)
+
+	expectErr := func(logging, errMsg string) {
+		testCfg := tests.DecodeRawToSlice(fmt.Sprintf(
+			`[{name: "myjob", exec: "true", logging: %s}]`, logging))
+		_, err := NewConfigs(testCfg, noop)
+		assert.EqualError(t, err, errMsg)
+	}
+	expectErr(`{buffer: -1}`, "job[myjob].logging.buffer must be >= 0")
+	expectErr(`{buffer: 10, overflow: "wait"}`,
+		"job[myjob].logging.overflow must be one of 'block' or 'drop'")
+	expectErr(`{overflow: "drop"}`,
+		"job[myjob].logging.buffer must be set to use overflow")
+}
+
+func TestJobConfigBinaryLogging(t *testing.T) {
+	cfgs, err := NewConfigs(tests.DecodeRawToSlice(
+		`[{name: "myjob", exec: "true", logging: {binary: "hex"}}]`), noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, commands.BinaryHex, cfgs[0].exec.BinaryEncoding)
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{name: "myjob", exec: "true", logging: {binary: "escape"}}]`), noop)
+	assert.EqualError(t, err,
+		"job[myjob].logging.binary must be one of 'hex' or 'base64'")
+}
+
+func TestJobConfigCPUTimeout(t *testing.T) {
+	cfgs, err := NewConfigs(tests.DecodeRawToSlice(
+		`[{name: "myjob", exec: "true", cpuTimeout: "90s"}]`), noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 90*time.Second, cfgs[0].exec.CPUTimeout)
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{name: "myjob", exec: "true", cpuTimeout: "500ms"}]`), noop)
+	assert.EqualError(t, err, "job[myjob].cpuTimeout '500ms' cannot be less than 1s")
+}
+
+func TestJobConfigNice(t *testing.T) {
+	cfgs, err := NewConfigs(tests.DecodeRawToSlice(
+		`[{name: "myjob", exec: "true", nice: 10}]`), noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 10, cfgs[0].exec.Nice)
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{name: "myjob", exec: "true", nice: 20}]`), noop)
+	assert.EqualError(t, err, "job[myjob].nice must be between -20 and 19")
+}
+
+func TestJobConfigTTY(t *testing.T) {
+	cfgs, err := NewConfigs(tests.DecodeRawToSlice(
+		`[{name: "myjob", exec: "true", tty: true}, {name: "other", exec: "true"}]`), noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, cfgs[0].exec.TTY)
+	assert.False(t, cfgs[1].exec.TTY)
+}
+
+func TestJobConfigPrimary(t *testing.T) {
+	cfgs, err := NewConfigs(tests.DecodeRawToSlice(`[{name: "myjob",
+		exec: "true", primary: true, health: {exec: "true", interval: 1, ttl: 5}}]`), noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, NewJob(cfgs[0]).Primary)
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{name: "myjob", exec: "true", primary: true}]`), noop)
+	assert.EqualError(t, err, "job[myjob].health must be set for a primary job")
+}
+
+func TestJobConfigCheckNamespaces(t *testing.T) {
+	cfgs, err := NewConfigs(tests.DecodeRawToSlice(`[{
+		name: "myjob", exec: "/bin/app",
+		health: {exec: "true", interval: 1, ttl: 5, namespaces: ["mount"]}}]`),
+		noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	namespaces := cfgs[0].healthCheckExec.Namespaces
+	if namespaces == nil {
+		t.Fatal("expected health check to enter the job's namespaces")
+	}
+	assert.Equal(t, cfgs[0].exec, namespaces.Target)
+	assert.Equal(t, []string{"mount"}, namespaces.Types)
+
+	expectErr := func(test, errMsg string) {
+		_, err := NewConfigs(tests.DecodeRawToSlice(test), noop)
+		assert.EqualError(t, err, errMsg)
+	}
+	expectErr(`[{name: "myjob", exec: "/bin/app",
+		health: {exec: "true", interval: 1, ttl: 5, namespaces: ["mnt"]}}]`,
+		"job[myjob].health.namespaces 'mnt' is not a valid namespace")
+	expectErr(`[{name: "myjob", when: {interval: "1s"},
+		health: {exec: "true", interval: 1, ttl: 5, namespaces: ["mount"]}}]`,
+		"job[myjob].exec must be set to use health.namespaces")
+}
+
+func TestErrJobConfigPostStop(t *testing.T) {
+	expectErr := func(test, errMsg string) {
+		_, err := NewConfigs(tests.DecodeRawToSlice(test), noop)
+		assert.EqualError(t, err, errMsg)
+	}
+	expectErr(`[{name: "myjob", when: {interval: "1s"}, postStop: {exec: "true"}}]`,
+		"job[myjob].exec must be set to use postStop")
+	expectErr(`[{name: "myjob", exec: "true", postStop: {exec: ""}}]`,
+		"unable to create job[myjob].postStop.exec: received zero-length argument")
+}
+
+func TestJobConfigSteps(t *testing.T) {
+	cfgs, err := NewConfigs(tests.DecodeRawToSlice(`[{
+		name: "myjob",
+		exec: "/bin/server",
+		steps: [{exec: "/bin/migrate", timeout: "30s"}, {exec: "/bin/seed"}]
+	}]`), noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := cfgs[0].exec.Steps
+	if len(steps) != 2 {
+		t.Fatalf("expected 2 steps but got %d", len(steps))
+	}
+	assert.Equal(t, "myjob.step1", steps[0].Name)
+	assert.Equal(t, "/bin/migrate", steps[0].Exec)
+	assert.Equal(t, 30*time.Second, steps[0].Timeout)
+	assert.Equal(t, "myjob.step2", steps[1].Name)
+	assert.Equal(t, time.Duration(0), steps[1].Timeout)
+
+	expectErr := func(test, errMsg string) {
+		_, err := NewConfigs(tests.DecodeRawToSlice(test), noop)
+		assert.EqualError(t, err, errMsg)
+	}
+	expectErr(`[{name: "myjob", when: {interval: "1s"}, steps: [{exec: "true"}]}]`,
+		"job[myjob].exec must be set to use steps")
+	expectErr(`[{name: "myjob", exec: "true", steps: [{exec: "true"}, {exec: ""}]}]`,
+		"unable to create job[myjob].steps[1].exec: received zero-length argument")
+	expectErr(`[{name: "myjob", exec: "true", steps: [{exec: "true", timeout: "x"}]}]`,
+		"unable to parse job[myjob].steps[0].timeout 'x': "+
+			`must be a number of seconds or a duration with units, such as "30s", "5m" or "1h"`)
+}
+
+func TestErrJobConfigRegisterWhen(t *testing.T) {
+	expectErr := func(test, errMsg string) {
+		_, err := NewConfigs(tests.DecodeRawToSlice(test), noop)
+		assert.EqualError(t, err, errMsg)
+	}
+	expectErr(`[{name: "myjob", exec: "true", port: 80, interfaces: ["inet", "lo0"],
+		health: {exec: "true", interval: 1, ttl: 5}, registerWhen: {}}]`,
+		"job[myjob].registerWhen.source must be set")
+	expectErr(`[{name: "myjob", exec: "true", port: 80, interfaces: ["inet", "lo0"],
+		health: {exec: "true", interval: 1, ttl: 5}, registerWhen: {source: "myjob"}}]`,
+		"job[myjob].registerWhen.source cannot be the job itself")
+	expectErr(`[{name: "myjob", exec: "true", registerWhen: {source: "db"}}]`,
+		"job[myjob].port must be set to use registerWhen")
+}
+
+func TestJobConfigSuccess(t *testing.T) {
+	cfgs, err := NewConfigs(tests.DecodeRawToSlice(`[{
+		name: "myjob",
+		exec: "/bin/app",
+		success: {codes: [1, 2], output: "^no changes$"},
+		health: {exec: "true", interval: 1, ttl: 5, success: {codes: [3]}}
+	}]`), noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []int{1, 2}, cfgs[0].exec.Success.Codes)
+	assert.Equal(t, "^no changes$", cfgs[0].exec.Success.Output.String())
+	assert.Equal(t, []int{3}, cfgs[0].healthCheckExec.Success.Codes)
+
+	expectErr := func(test, errMsg string) {
+		_, err := NewConfigs(tests.DecodeRawToSlice(test), noop)
+		assert.EqualError(t, err, errMsg)
+	}
+	expectErr(`[{name: "myjob", exec: "true", success: {codes: [0]}}]`,
+		"job[myjob].success.codes '0' must be between 1 and 255")
+	expectErr(`[{name: "myjob", exec: "true", success: {output: "("}}]`,
+		"unable to parse job[myjob].success.output '(': "+
+			"error parsing regexp: missing closing ): `(`")
+	expectErr(`[{name: "myjob", exec: "true",
+		health: {exec: "true", interval: 1, ttl: 5, success: {codes: [256]}}}]`,
+		"job[myjob].health.success.codes '256' must be between 1 and 255")
+}
+
 func TestErrJobConfigConsulEnableTagOverride(t *testing.T) {
 	testCfg, _ := ioutil.ReadFile(fmt.Sprintf("./testdata/%s.json5", t.Name()))
 	_, err := NewConfigs(tests.DecodeRawToSlice(string(testCfg)), noop)
@@ -324,7 +688,7 @@ func TestJobConfigValidateFrequency(t *testing.T) {
 
 	expectErr(
 		`[{name: "E", exec: "/bin/taskE", timeout: "1ns", when: {interval: "xx"}}]`,
-		"unable to parse job[E].when.interval 'xx': time: invalid duration xx")
+		"unable to parse job[E].when.interval 'xx': must be a number of seconds or a duration with units, such as \"30s\", \"5m\" or \"1h\"")
 
 	testCfg := tests.DecodeRawToSlice(
 		`[{name: "F", exec: "/bin/taskF", when: {interval: "1ms"}}]`)
@@ -335,6 +699,14 @@ func TestJobConfigValidateFrequency(t *testing.T) {
 		"expected job[0].restartLimit to be 'unlimited'")
 }
 
+func TestErrJobConfigWhenTimeout(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[{name: "myName", exec: "/bin/true",
+		when: {source: "setup", once: "exitSuccess", timeout: "30 sec"}}]`)
+	_, err := NewConfigs(testCfg, nil)
+	assert.EqualError(t, err,
+		"unable to parse job[myName].when.timeout '30 sec': must be a number of seconds or a duration with units, such as \"30s\", \"5m\" or \"1h\"")
+}
+
 func TestJobConfigValidateExec(t *testing.T) {
 	assert := assert.New(t)
 
@@ -379,7 +751,7 @@ func TestJobConfigValidateExec(t *testing.T) {
 		timeout: "xx"
 	}]`)
 	_, err = NewConfigs(testCfg, noop)
-	expected := "unable to parse job[serviceC].timeout 'xx': time: invalid duration xx"
+	expected := "unable to parse job[serviceC].timeout 'xx': must be a number of seconds or a duration with units, such as \"30s\", \"5m\" or \"1h\""
 	if err == nil || err.Error() != expected {
 		t.Fatalf("expected '%s', got '%v'", expected, err)
 	}
@@ -397,6 +769,238 @@ func TestJobConfigValidateExec(t *testing.T) {
 
 }
 
+func TestJobConfigValidateExecArgs(t *testing.T) {
+	disc := &mocks.CountingDiscoveryBackend{}
+	testCfg := tests.DecodeRawToSlice(`[
+	{
+		name: "serviceA",
+		exec: ["/bin/serviceA", "--peers", "{{range .Instances}}{{.Address}} {{end}}"],
+		when: { source: "watch.upstream", each: "changed" }
+	}]`)
+	cfg, err := NewConfigs(testCfg, disc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotNil(t, cfg[0].execArgs)
+
+	// args aren't templates unless the job is started by a watch
+	testCfg = tests.DecodeRawToSlice(`[
+	{
+		name: "serviceB",
+		exec: ["/bin/serviceB", "{{.Service}}"]
+	}]`)
+	cfg, err = NewConfigs(testCfg, disc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, cfg[0].execArgs)
+	assert.Equal(t, []string{"{{.Service}}"}, cfg[0].exec.Args)
+
+	testCfg = tests.DecodeRawToSlice(`[
+	{
+		name: "serviceC",
+		exec: ["/bin/serviceC", "{{.Hosts}}"],
+		when: { source: "watch.upstream", each: "changed" }
+	}]`)
+	_, err = NewConfigs(testCfg, disc)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to parse job[serviceC].exec template")
+
+	testCfg = tests.DecodeRawToSlice(`[
+	{
+		name: "serviceD",
+		exec: ["/bin/serviceD", "{{.Service}}"],
+		when: { source: "watch.upstream", each: "changed" }
+	}]`)
+	_, err = NewConfigs(testCfg, noop)
+	assert.Equal(t, "job[serviceD].exec templates require a discovery backend that lists instances",
+		fmt.Sprint(err))
+}
+
+func TestJobConfigValidateExecRetry(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[
+	{
+		name: "serviceA",
+		exec: "/bin/serviceA",
+		execRetry: { attempts: 5, delay: "50ms" }
+	},
+	{
+		name: "serviceB",
+		exec: "/bin/serviceB",
+		execRetry: { attempts: 0 }
+	},
+	{
+		name: "serviceC",
+		exec: "/bin/serviceC"
+	}]`)
+	cfgs, err := NewConfigs(testCfg, noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, &commands.ExecRetry{Attempts: 5, Delay: 50 * time.Millisecond},
+		cfgs[0].exec.ExecRetry)
+	assert.Equal(t, &commands.ExecRetry{Attempts: 0, Delay: 100 * time.Millisecond},
+		cfgs[1].exec.ExecRetry)
+	assert.Nil(t, cfgs[2].exec.ExecRetry, "expected the default to be used")
+
+	testCfg = tests.DecodeRawToSlice(`[
+	{
+		name: "serviceD",
+		exec: "/bin/serviceD",
+		execRetry: { attempts: -1 }
+	}]`)
+	_, err = NewConfigs(testCfg, noop)
+	assert.EqualError(t, err, "job[serviceD].execRetry.attempts must be >= 0")
+
+	testCfg = tests.DecodeRawToSlice(`[
+	{
+		name: "serviceE",
+		exec: "/bin/serviceE",
+		execRetry: { delay: "0s" }
+	}]`)
+	_, err = NewConfigs(testCfg, noop)
+	assert.EqualError(t, err, "job[serviceE].execRetry.delay must be > 0")
+}
+
+func TestJobConfigValidateEnv(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[
+	{
+		name: "serviceA",
+		exec: "/bin/serviceA",
+		env: { PORT: "{{ jobPort \"db\" }}", MODE: "prod" }
+	},
+	{
+		name: "db",
+		exec: "/bin/db"
+	}]`)
+	cfgs, err := NewConfigs(testCfg, noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"MODE", "PORT"}, cfgs[0].env.names)
+
+	testCfg = tests.DecodeRawToSlice(`[
+	{
+		name: "serviceD",
+		exec: "/bin/serviceD",
+		env: { CACHE: "{{ if true }}{{ jobIP \"cache\" | printf \"%s\" }}{{ end }}" }
+	}]`)
+	_, err = NewConfigs(testCfg, noop)
+	assert.EqualError(t, err, "job[serviceD].env refers to unknown job 'cache'")
+
+	testCfg = tests.DecodeRawToSlice(`[
+	{
+		name: "serviceB",
+		exec: "/bin/serviceB",
+		env: { PORT: "{{ jobPort \"db\" " }
+	}]`)
+	_, err = NewConfigs(testCfg, noop)
+	assert.EqualError(t, err, "unable to parse job[serviceB].env: template: PORT:1: unclosed action")
+
+	testCfg = tests.DecodeRawToSlice(`[
+	{
+		name: "serviceC",
+		exec: "/bin/serviceC",
+		env: { PORT: "{{ jobPorts \"db\" }}" }
+	}]`)
+	_, err = NewConfigs(testCfg, noop)
+	assert.EqualError(t, err, "unable to parse job[serviceC].env: template: PORT:1: function \"jobPorts\" not defined")
+}
+
+func TestJobConfigValidateEnvPrecedence(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[{name: "myjob", exec: "true"}]`)
+	cfgs, _ := NewConfigs(testCfg, noop)
+	assert.Equal(t, []string{"containerpilot", "env", "envFiles", "inherited"},
+		cfgs[0].envPrecedence)
+
+	testCfg = tests.DecodeRawToSlice(`[{name: "myjob", exec: "true",
+		envPrecedence: ["inherited", "env", "secrets", "containerpilot"]}]`)
+	_, err := NewConfigs(testCfg, noop)
+	assert.EqualError(t, err, "job[myjob].envPrecedence has unknown source 'secrets'")
+
+	testCfg = tests.DecodeRawToSlice(`[{name: "myjob", exec: "true",
+		envPrecedence: ["inherited", "env", "env", "containerpilot"]}]`)
+	_, err = NewConfigs(testCfg, noop)
+	assert.EqualError(t, err, "job[myjob].envPrecedence must list each of 'containerpilot', 'env', 'envFiles', and 'inherited' once")
+}
+
+func TestJobConfigValidateRequires(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[{name: "worker", exec: "true", requires: {}}]`)
+	_, err := NewConfigs(testCfg, noop)
+	assert.EqualError(t, err, "job[worker].requires.source must be set")
+
+	testCfg = tests.DecodeRawToSlice(`[{name: "worker", exec: "true", requires: {source: "worker"}}]`)
+	_, err = NewConfigs(testCfg, noop)
+	assert.EqualError(t, err, "job[worker].requires.source cannot be the job itself")
+
+	testCfg = tests.DecodeRawToSlice(`[{name: "worker", requires: {source: "watch.queue"}}]`)
+	_, err = NewConfigs(testCfg, noop)
+	assert.EqualError(t, err, "job[worker].exec must be set to use requires")
+
+	testCfg = tests.DecodeRawToSlice(`[{name: "worker", exec: "true", requires: {source: "watch.queue"}}]`)
+	cfgs, err := NewConfigs(testCfg, noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "watch.queue", cfgs[0].requiresSource)
+}
+
+func TestJobConfigValidateDrainGate(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[{name: "app", drainGate: {exec: "true"}}]`)
+	_, err := NewConfigs(testCfg, noop)
+	assert.EqualError(t, err, "job[app].exec must be set to use drainGate")
+
+	testCfg = tests.DecodeRawToSlice(`[{name: "app", exec: "true", drainGate: {exec: "true", interval: "0s"}}]`)
+	_, err = NewConfigs(testCfg, noop)
+	assert.EqualError(t, err, "job[app].drainGate.interval must be > 0")
+
+	testCfg = tests.DecodeRawToSlice(`[{name: "app", exec: "true", drainGate: {exec: "true"}}]`)
+	cfgs, err := NewConfigs(testCfg, noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gate := cfgs[0].drainGate
+	assert.Equal(t, "app.drainGate", gate.exec.Name)
+	assert.Equal(t, time.Second, gate.interval)
+	assert.Equal(t, time.Second, gate.exec.Timeout)
+	assert.Equal(t, 30*time.Second, gate.timeout)
+}
+
+func TestJobConfigValidateRender(t *testing.T) {
+	disc := &mocks.CountingDiscoveryBackend{}
+	testCfg := tests.DecodeRawToSlice(`[
+	{
+		name: "serviceA",
+		exec: "/bin/reload",
+		render: { template: "/no/such/file", path: "/etc/app.conf" }
+	}]`)
+	_, err := NewConfigs(testCfg, disc)
+	assert.EqualError(t, err, "job[serviceA].render requires the job to be started by a watch")
+
+	testCfg = tests.DecodeRawToSlice(`[
+	{
+		name: "serviceB",
+		exec: "/bin/reload",
+		when: { source: "watch.upstream", each: "changed" },
+		render: { path: "/etc/app.conf" }
+	}]`)
+	_, err = NewConfigs(testCfg, disc)
+	assert.EqualError(t, err, "job[serviceB].render.template and render.path must be set")
+
+	testCfg = tests.DecodeRawToSlice(`[
+	{
+		name: "serviceC",
+		exec: "/bin/reload",
+		when: { source: "watch.upstream", each: "changed" },
+		render: { template: "/no/such/file", path: "/etc/app.conf" }
+	}]`)
+	_, err = NewConfigs(testCfg, disc)
+	assert.EqualError(t, err, "unable to read job[serviceC].render.template: open /no/such/file: no such file or directory")
+
+	_, err = NewConfigs(testCfg, noop)
+	assert.EqualError(t, err, "job[serviceC].render requires a discovery backend that lists instances")
+}
+
 func TestJobConfigValidateRestarts(t *testing.T) {
 
 	expectErr := func(test, name, val, msg string) {
@@ -460,7 +1064,115 @@ func TestHealthChecksConfigError(t *testing.T) {
 		"unable to create job[myName].health.exec: received zero-length argument")
 	expectErr(
 		`[{name: "myName", health: {exec: "/bin/true", interval: 1, ttl: 5, timeout: "xx"}}]`,
-		"could not parse job[myName].health.timeout 'xx': time: invalid duration xx")
+		"could not parse job[myName].health.timeout 'xx': must be a number of seconds or a duration with units, such as \"30s\", \"5m\" or \"1h\"")
+}
+
+func TestJobConfigValidateSubChecks(t *testing.T) {
+	expectErr := func(health, errMsg string) {
+		testCfg := tests.DecodeRawToSlice(fmt.Sprintf(
+			`[{name: "myjob", exec: "/bin/A", health: %s}]`, health))
+		_, err := NewConfigs(testCfg, nil)
+		assert.EqualError(t, err, errMsg)
+	}
+	expectErr(`{interval: 1, ttl: 5, exec: "true", checks: [{exec: "true"}]}`,
+		"job[myjob].health can have only one of 'exec' or 'checks'")
+	expectErr(`{interval: 1, ttl: 5, checks: [{name: "a", exec: "true"}, {name: "a", exec: "true"}]}`,
+		"job[myjob].health.checks has more than one check named 'a'")
+	expectErr(`{interval: 1, ttl: 5, checks: [{exec: ""}]}`,
+		"unable to create job[myjob].health.checks[0].exec: received zero-length argument")
+}
+
+func TestJobConfigValidateReadyFile(t *testing.T) {
+	expectErr := func(test, errMsg string) {
+		testCfg := tests.DecodeRawToSlice(test)
+		_, err := NewConfigs(testCfg, nil)
+		if err == nil || !strings.HasPrefix(err.Error(), errMsg) {
+			t.Fatalf("expected '%s', got '%v'", errMsg, err)
+		}
+	}
+	expectErr(
+		`[{name: "A", exec: "/bin/A", readyFile: {timeout: "1s"}}]`,
+		"job[A].readyFile.path must be set")
+	expectErr(
+		`[{name: "B", exec: "/bin/B", readyFile: {path: "/tmp/ready", interval: "xx"}}]`,
+		"unable to parse job[B].readyFile.interval 'xx': ")
+	expectErr(
+		`[{name: "C", exec: "/bin/C", readyFile: {path: "/tmp/ready", timeout: "xx"}}]`,
+		"unable to parse job[C].readyFile.timeout 'xx': ")
+
+	testCfg := tests.DecodeRawToSlice(
+		`[{name: "D", exec: "/bin/D", readyFile: {path: "/tmp/ready", timeout: "10s"}}]`)
+	cfg, err := NewConfigs(testCfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "/tmp/ready", cfg[0].readyFilePath)
+	assert.Equal(t, defaultReadyFileInterval, cfg[0].readyFileInterval)
+	assert.Equal(t, 10*time.Second, cfg[0].readyFileTimeout)
+}
+
+func TestJobConfigValidateFlapping(t *testing.T) {
+	expectErr := func(test, errMsg string) {
+		testCfg := tests.DecodeRawToSlice(test)
+		_, err := NewConfigs(testCfg, nil)
+		if err == nil || !strings.HasPrefix(err.Error(), errMsg) {
+			t.Fatalf("expected '%s', got '%v'", errMsg, err)
+		}
+	}
+	expectErr(
+		`[{name: "A", exec: "/bin/A", flapping: {minRuntime: "1s"}}]`,
+		"job[A].restarts must be set to use flapping")
+	expectErr(
+		`[{name: "B", exec: "/bin/B", restarts: "unlimited", flapping: {}}]`,
+		"job[B].flapping.minRuntime must be set")
+	expectErr(
+		`[{name: "C", exec: "/bin/C", restarts: "unlimited",
+		  flapping: {minRuntime: "1s", backoff: "xx"}}]`,
+		"unable to parse job[C].flapping.backoff 'xx': ")
+	expectErr(
+		`[{name: "D", exec: "/bin/D", restarts: "unlimited",
+		  flapping: {minRuntime: "1s", backoff: "10s", maxBackoff: "5s"}}]`,
+		"job[D].flapping.maxBackoff cannot be less than flapping.backoff")
+	expectErr(
+		`[{name: "E", exec: "/bin/E", when: {interval: "1s"},
+		  flapping: {minRuntime: "1s"}}]`,
+		"job[E].flapping cannot be used with when.interval")
+
+	testCfg := tests.DecodeRawToSlice(
+		`[{name: "F", exec: "/bin/F", restarts: 3, flapping: {minRuntime: "2s"}}]`)
+	cfg, err := NewConfigs(testCfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2*time.Second, cfg[0].flapMinRuntime)
+	assert.Equal(t, defaultFlapBackoff, cfg[0].flapBackoff)
+	assert.Equal(t, defaultFlapMaxBackoff, cfg[0].flapMaxBackoff)
+	assert.Equal(t, defaultFlapLimit, cfg[0].flapLimit)
+}
+
+func TestJobConfigValidateEnvFiles(t *testing.T) {
+	expectErr := func(test, errMsg string) {
+		testCfg := tests.DecodeRawToSlice(test)
+		_, err := NewConfigs(testCfg, nil)
+		if err == nil || !strings.HasPrefix(err.Error(), errMsg) {
+			t.Fatalf("expected '%s', got '%v'", errMsg, err)
+		}
+	}
+	expectErr(
+		`[{name: "A", exec: "/bin/A", envFiles: {interval: "1s"}}]`,
+		"job[A].envFiles.paths must be set")
+	expectErr(
+		`[{name: "B", exec: "/bin/B", envFiles: {paths: "/tmp/.env", interval: "xx"}}]`,
+		"unable to parse job[B].envFiles.interval 'xx': ")
+
+	testCfg := tests.DecodeRawToSlice(
+		`[{name: "C", exec: "/bin/C", envFiles: {paths: ["/tmp/a.env", "/tmp/b.env"]}}]`)
+	cfg, err := NewConfigs(testCfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"/tmp/a.env", "/tmp/b.env"}, cfg[0].envFilePaths)
+	assert.Equal(t, defaultEnvFileInterval, cfg[0].envFileInterval)
 }
 
 // ---------------------------------------------------------------------
@@ -0,0 +1,128 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/joyent/containerpilot/commands"
+	"github.com/joyent/containerpilot/config/timing"
+	"github.com/joyent/containerpilot/events"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaults for how often the drain gate runs and how long we wait for it
+// to pass before stopping the process anyway
+const (
+	defaultDrainGateInterval = time.Second
+	defaultDrainGateTimeout  = 30 * time.Second
+)
+
+// DrainGateConfig configures a command that must pass before a Job's
+// process is stopped, so that it can finish draining
+type DrainGateConfig struct {
+	Exec     interface{} `mapstructure:"exec"`
+	Interval string      `mapstructure:"interval"`
+	Timeout  string      `mapstructure:"timeout"`
+}
+
+// drainGate is run every interval while the Job is stopping, until it
+// exits 0 or the timeout expires
+type drainGate struct {
+	exec     *commands.Command
+	interval time.Duration
+	timeout  time.Duration
+}
+
+func (cfg *Config) validateDrainGate() error {
+	if cfg.DrainGate == nil {
+		return nil
+	}
+	if cfg.Exec == nil {
+		return fmt.Errorf("job[%s].exec must be set to use drainGate", cfg.Name)
+	}
+	gate := &drainGate{
+		interval: defaultDrainGateInterval,
+		timeout:  defaultDrainGateTimeout,
+	}
+	if cfg.DrainGate.Interval != "" {
+		interval, err := timing.GetTimeout(cfg.DrainGate.Interval)
+		if err != nil {
+			return fmt.Errorf("unable to parse job[%s].drainGate.interval '%s': %v",
+				cfg.Name, cfg.DrainGate.Interval, err)
+		}
+		if interval <= 0 {
+			return fmt.Errorf("job[%s].drainGate.interval must be > 0", cfg.Name)
+		}
+		gate.interval = interval
+	}
+	if cfg.DrainGate.Timeout != "" {
+		timeout, err := timing.GetTimeout(cfg.DrainGate.Timeout)
+		if err != nil {
+			return fmt.Errorf("unable to parse job[%s].drainGate.timeout '%s': %v",
+				cfg.Name, cfg.DrainGate.Timeout, err)
+		}
+		if timeout <= 0 {
+			return fmt.Errorf("job[%s].drainGate.timeout must be > 0", cfg.Name)
+		}
+		gate.timeout = timeout
+	}
+	fields := log.Fields{"job": cfg.Name + ".drainGate"}
+	if cfg.Logging != nil && cfg.Logging.Raw {
+		fields = nil
+	}
+	// each run of the gate gets no longer than the interval, so that a
+	// hung gate doesn't hold up the next one
+	exec, err := commands.NewCommand(cfg.DrainGate.Exec, gate.interval, fields)
+	if err != nil {
+		return fmt.Errorf("unable to create job[%s].drainGate.exec: %v",
+			cfg.Name, err)
+	}
+	exec.Name = cfg.Name + ".drainGate"
+	gate.exec = exec
+	cfg.drainGate = gate
+	return nil
+}
+
+// waitForDrainGate runs the drain gate every interval until it exits 0,
+// so that the Job's process isn't stopped while it's still draining. It
+// gives up once the gate's timeout expires, and doesn't wait at all if
+// the process isn't running or exits while we wait. The Job's context
+// may already be cancelled when we're stopping, so the gate gets its own.
+func (job *Job) waitForDrainGate() {
+	gate := job.drainGate
+	if gate == nil || job.exec == nil || job.exec.Pid() == 0 {
+		return
+	}
+	pollName := fmt.Sprintf("%s.drain-poll", job.Name)
+	gateCtx, cancel := context.WithTimeout(context.Background(), gate.timeout)
+	defer cancel()
+	gate.exec.Run(gateCtx, job.Publisher.Bus)
+	for {
+		select {
+		case event := <-job.Rx:
+			switch event {
+			case events.Event{events.ExitSuccess, gate.exec.Name}:
+				log.Debugf("job[%s] drain gate passed", job.Name)
+				return
+			case events.Event{events.ExitFailed, gate.exec.Name}:
+				events.NewEventTimeout(gateCtx, job.Rx, gate.interval, pollName)
+			case events.Event{events.TimerExpired, pollName}:
+				// we may have missed the exit event of the process
+				// while we were stopping
+				if job.exec.Pid() == 0 {
+					return
+				}
+				gate.exec.Run(gateCtx, job.Publisher.Bus)
+			case events.Event{events.ExitSuccess, job.Name},
+				events.Event{events.ExitFailed, job.Name}:
+				log.Debugf("job[%s] exited while draining", job.Name)
+				return
+			}
+		case <-gateCtx.Done():
+			log.Warnf("job[%s] drain gate didn't pass within %v, stopping anyway",
+				job.Name, gate.timeout)
+			return
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package jobs
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+// envRetryInterval is how long a Job whose env can't be rendered waits
+// before trying again to start
+const envRetryInterval = time.Second
+
+// envTemplates renders the values of a Job's env, which may refer to the
+// state of other Jobs (ex. {{ jobPort "db" }})
+type envTemplates struct {
+	names     []string // sorted, so that the env is in a stable order
+	templates map[string]*template.Template
+
+	// the Jobs that the templates can refer to, by name; set once all
+	// the Jobs have been created
+	peers map[string]*Job
+}
+
+// newEnvTemplates parses the templates in the values of the env, and
+// returns nil if there's no env
+func newEnvTemplates(env map[string]string) (*envTemplates, error) {
+	if len(env) == 0 {
+		return nil, nil
+	}
+	t := &envTemplates{templates: map[string]*template.Template{}}
+	funcs := template.FuncMap{
+		"jobPort": t.jobPort,
+		"jobIP":   t.jobIP,
+		"jobPid":  t.jobPid,
+	}
+	for name, value := range env {
+		if name == "" || strings.Contains(name, "=") {
+			return nil, fmt.Errorf("'%s' is not a valid environment variable name", name)
+		}
+		tmpl, err := template.New(name).Funcs(funcs).Parse(value)
+		if err != nil {
+			return nil, err
+		}
+		t.names = append(t.names, name)
+		t.templates[name] = tmpl
+	}
+	sort.Strings(t.names)
+	return t, nil
+}
+
+// jobNames returns the names of the Jobs the templates refer to, so that
+// references to Jobs that don't exist can be caught when the config is
+// loaded. Only names given as string literals can be found.
+func (t *envTemplates) jobNames() []string {
+	names := []string{}
+	for _, name := range t.names {
+		names = append(names, templateJobNames(t.templates[name].Tree.Root)...)
+	}
+	return names
+}
+
+func templateJobNames(node parse.Node) []string {
+	names := []string{}
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return names
+		}
+		for _, n := range node.Nodes {
+			names = append(names, templateJobNames(n)...)
+		}
+	case *parse.ActionNode:
+		names = templateJobNames(node.Pipe)
+	case *parse.IfNode:
+		names = branchJobNames(&node.BranchNode)
+	case *parse.RangeNode:
+		names = branchJobNames(&node.BranchNode)
+	case *parse.WithNode:
+		names = branchJobNames(&node.BranchNode)
+	case *parse.PipeNode:
+		if node == nil {
+			return names
+		}
+		for _, cmd := range node.Cmds {
+			for i, arg := range cmd.Args {
+				switch arg := arg.(type) {
+				case *parse.IdentifierNode:
+					if !isJobFunc(arg.Ident) || i+1 >= len(cmd.Args) {
+						continue
+					}
+					if str, ok := cmd.Args[i+1].(*parse.StringNode); ok {
+						names = append(names, str.Text)
+					}
+				case *parse.PipeNode:
+					names = append(names, templateJobNames(arg)...)
+				}
+			}
+		}
+	}
+	return names
+}
+
+func branchJobNames(node *parse.BranchNode) []string {
+	names := templateJobNames(node.Pipe)
+	names = append(names, templateJobNames(node.List)...)
+	return append(names, templateJobNames(node.ElseList)...)
+}
+
+func isJobFunc(name string) bool {
+	switch name {
+	case "jobPort", "jobIP", "jobPid":
+		return true
+	}
+	return false
+}
+
+// render returns the env as KEY=value pairs, or an error if any of the
+// Jobs it refers to isn't running yet
+func (t *envTemplates) render() ([]string, error) {
+	env := []string{}
+	for _, name := range t.names {
+		var buf bytes.Buffer
+		if err := t.templates[name].Execute(&buf, nil); err != nil {
+			return nil, fmt.Errorf("unable to render %s: %v", name, err)
+		}
+		env = append(env, name+"="+buf.String())
+	}
+	return env, nil
+}
+
+// peer returns the named Job, which must be running so that we don't
+// hand out the state of a Job that hasn't started yet
+func (t *envTemplates) peer(name string) (*Job, error) {
+	job, ok := t.peers[name]
+	if !ok {
+		return nil, fmt.Errorf("no job named '%s'", name)
+	}
+	if job.exec == nil || job.exec.Pid() == 0 {
+		return nil, fmt.Errorf("job[%s] is not running", name)
+	}
+	return job, nil
+}
+
+func (t *envTemplates) jobPort(name string) (int, error) {
+	job, err := t.peer(name)
+	if err != nil {
+		return 0, err
+	}
+	if job.Service == nil {
+		return 0, fmt.Errorf("job[%s] has no port", name)
+	}
+	return job.Service.ListenPort, nil
+}
+
+func (t *envTemplates) jobIP(name string) (string, error) {
+	job, err := t.peer(name)
+	if err != nil {
+		return "", err
+	}
+	if job.Service == nil {
+		return "", fmt.Errorf("job[%s] has no port", name)
+	}
+	return job.Service.IPAddress, nil
+}
+
+func (t *envTemplates) jobPid(name string) (int, error) {
+	job, err := t.peer(name)
+	if err != nil {
+		return 0, err
+	}
+	return job.exec.Pid(), nil
+}
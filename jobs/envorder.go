@@ -0,0 +1,130 @@
+package jobs
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joyent/containerpilot/commands"
+)
+
+// the sources of a job's environment, as named in envPrecedence
+const (
+	envSourceContainerPilot = "containerpilot" // CONTAINERPILOT_JOB, _EVENT_*
+	envSourceEnv            = "env"
+	envSourceEnvFiles       = "envFiles"
+	envSourceInherited      = "inherited" // ContainerPilot's own environment
+)
+
+// defaultEnvPrecedence is the order in which a job's environment sources
+// override each other, highest first
+var defaultEnvPrecedence = []string{
+	envSourceContainerPilot, envSourceEnv, envSourceEnvFiles, envSourceInherited,
+}
+
+// EnvValue is the value of one variable in a job's environment and the
+// source that it came from
+type EnvValue struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+func (cfg *Config) validateEnvPrecedence() error {
+	if cfg.EnvPrecedence == nil {
+		cfg.envPrecedence = defaultEnvPrecedence
+		return nil
+	}
+	seen := map[string]bool{}
+	for _, source := range cfg.EnvPrecedence {
+		switch source {
+		case envSourceContainerPilot, envSourceEnv, envSourceEnvFiles, envSourceInherited:
+		default:
+			return fmt.Errorf("job[%s].envPrecedence has unknown source '%s'",
+				cfg.Name, source)
+		}
+		seen[source] = true
+	}
+	if len(seen) != len(defaultEnvPrecedence) || len(cfg.EnvPrecedence) != len(seen) {
+		return fmt.Errorf("job[%s].envPrecedence must list each of "+
+			"'containerpilot', 'env', 'envFiles', and 'inherited' once", cfg.Name)
+	}
+	cfg.envPrecedence = cfg.EnvPrecedence
+	return nil
+}
+
+// execEnv is the environment added to that of the Job's exec: the values
+// of its env files and env, its name, and the event that started it,
+// merged in the order of its envPrecedence. It also records the merged
+// values so that they can be inspected with Env.
+func (job *Job) execEnv() []string {
+	ownEnv := []string{commands.EnvVar("JOB") + "=" + job.Name}
+	if job.triggerSource != "" {
+		ownEnv = append(ownEnv,
+			commands.EnvVar("EVENT_SOURCE")+"="+job.triggerSource,
+			commands.EnvVar("EVENT_TIME")+"="+job.triggerTime.Format(time.RFC3339))
+	}
+	sources := map[string][]string{
+		envSourceContainerPilot: ownEnv,
+		envSourceEnvFiles:       job.envFileValues,
+		envSourceEnv:            job.envValues,
+	}
+	merged := map[string]EnvValue{}
+	names := []string{}
+	for i := len(job.envPrecedence) - 1; i >= 0; i-- {
+		source := job.envPrecedence[i]
+		if source == envSourceInherited {
+			// only inherited values that override another source's
+			// are of interest; the rest are the exec's environment
+			// regardless
+			for name := range merged {
+				if value, ok := os.LookupEnv(name); ok {
+					merged[name] = EnvValue{value, source}
+				}
+			}
+			continue
+		}
+		for _, kv := range sources[source] {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			if _, ok := merged[parts[0]]; !ok {
+				names = append(names, parts[0])
+			}
+			merged[parts[0]] = EnvValue{parts[1], source}
+		}
+	}
+
+	env := []string{}
+	for _, name := range names {
+		// the exec already has ContainerPilot's environment, so the
+		// values it should keep don't have to be added again
+		if value := merged[name]; value.Source != envSourceInherited {
+			env = append(env, name+"="+value.Value)
+		}
+	}
+	job.statusLock.Lock()
+	job.mergedEnv = merged
+	job.statusLock.Unlock()
+	return env
+}
+
+// Env returns the environment that the Job's exec was last started with
+// from its env files, env, and event, along with any of ContainerPilot's
+// own environment that overrides them. The values of variables with
+// sensitive names are redacted, as they are in the dead letter log.
+// Returns nil if the exec hasn't been started.
+func (job *Job) Env() map[string]EnvValue {
+	job.statusLock.RLock()
+	defer job.statusLock.RUnlock()
+	if job.mergedEnv == nil {
+		return nil
+	}
+	env := make(map[string]EnvValue, len(job.mergedEnv))
+	for name, value := range job.mergedEnv {
+		value.Value = commands.RedactValue(name, value.Value)
+		env[name] = value
+	}
+	return env
+}
@@ -2,8 +2,12 @@
 package jobs
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"os"
+	"reflect"
+	"strings"
 	"sync"
 	"time"
 
@@ -25,8 +29,16 @@ const (
 
 // Job manages the state of a job and its start/stop conditions
 type Job struct {
-	Name string
-	exec *commands.Command
+	Name     string
+	exec     *commands.Command
+	execArgs *argTemplates
+	renderer *renderer
+	Primary  bool // gates the readiness of ContainerPilot
+
+	// the instances found by the watch that starts the Job, for its
+	// exec templates and render; the upstream is guarded by statusLock
+	upstreamWatch string
+	upstream      discovery.InstanceLister
 
 	// service health and discovery
 	Status          JobStatus
@@ -34,6 +46,18 @@ type Job struct {
 	Service         *discovery.ServiceDefinition
 	healthCheckExec *commands.Command
 	healthCheckName string
+	healthChecks    []*subCheck
+	checkResults    map[string]bool
+	warningRetries  int
+	warningsRemain  int
+	failOnExit      bool
+	keepOnReload    bool
+
+	// resolving the target of the health checks
+	checkResolver  *commands.Command
+	resolverOutput *bytes.Buffer
+	resolving      bool
+	checkTarget    string
 
 	// starting events
 	startEvent        events.Event
@@ -44,6 +68,8 @@ type Job struct {
 	// stopping events
 	stoppingWaitEvent events.Event
 	stoppingTimeout   time.Duration
+	stopWaitOnExit    bool
+	drainGate         *drainGate
 
 	// timing and restarts
 	heartbeat      time.Duration
@@ -51,6 +77,60 @@ type Job struct {
 	restartsRemain int
 	frequency      time.Duration
 
+	// flap protection
+	flapMinRuntime time.Duration
+	flapBackoff    time.Duration
+	flapMaxBackoff time.Duration
+	flapLimit      int
+	quickExits     int
+
+	// first start failures
+	exitOnStartFail  bool
+	execStarts       int
+	startFailureCode int
+
+	// readiness gate for registration
+	readyFilePath     string
+	readyFileInterval time.Duration
+	readyFileTimeout  time.Duration
+	isReady           bool
+	readyCancel       context.CancelFunc
+
+	// dependency gate for registration
+	registerSource     string
+	awaitingDependency bool
+
+	// dependency the process only runs while it's healthy
+	requiresSource    string
+	dependencyLost    bool
+	dependencyStopped bool // the exec is owed a start once it recovers
+
+	// environment files
+	envFilePaths    []string
+	envFileInterval time.Duration
+	envFileValues   []string
+	envRestart      bool
+	isRunning       bool
+
+	// env from the state of other jobs; the exec's start is held while
+	// it can't be rendered
+	env       *envTemplates
+	envValues []string
+	envHeld   bool
+
+	// precedence of the env sources, and the env they were last merged
+	// into, guarded by statusLock
+	envPrecedence []string
+	mergedEnv     map[string]EnvValue
+
+	// the event that last started the exec
+	triggerSource string
+	triggerTime   time.Time
+
+	// process lifecycle, guarded by statusLock
+	lastStart time.Time
+	lastStop  time.Time
+
 	// completed
 	IsComplete   bool
 	completeLock *sync.RWMutex
@@ -64,21 +144,58 @@ func NewJob(cfg *Config) *Job {
 	job := &Job{
 		Name:              cfg.Name,
 		exec:              cfg.exec,
+		execArgs:          cfg.execArgs,
+		renderer:          cfg.renderer,
+		upstreamWatch:     cfg.whenEvent.Source,
+		upstream:          cfg.upstream,
+		env:               cfg.env,
+		envPrecedence:     cfg.envPrecedence,
+		Primary:           cfg.Primary,
 		heartbeat:         cfg.heartbeatInterval,
 		Service:           cfg.serviceDefinition,
 		healthCheckExec:   cfg.healthCheckExec,
+		healthChecks:      cfg.healthChecks,
+		warningRetries:    cfg.warningRetries,
+		warningsRemain:    cfg.warningRetries,
+		checkResolver:     cfg.checkResolver,
+		keepOnReload:      cfg.keepOnReload,
 		startEvent:        cfg.whenEvent,
 		startTimeout:      cfg.whenTimeout,
 		startsRemain:      cfg.whenStartsLimit,
 		stoppingWaitEvent: cfg.stoppingWaitEvent,
 		stoppingTimeout:   cfg.stoppingTimeout,
+		stopWaitOnExit:    cfg.StopWaitOnExit,
+		drainGate:         cfg.drainGate,
+		exitOnStartFail:   cfg.ExitOnStartFail,
 		restartLimit:      cfg.restartLimit,
 		restartsRemain:    cfg.restartLimit,
 		frequency:         cfg.freqInterval,
+		flapMinRuntime:    cfg.flapMinRuntime,
+		flapBackoff:       cfg.flapBackoff,
+		flapMaxBackoff:    cfg.flapMaxBackoff,
+		flapLimit:         cfg.flapLimit,
+		readyFilePath:     cfg.readyFilePath,
+		readyFileInterval: cfg.readyFileInterval,
+		readyFileTimeout:  cfg.readyFileTimeout,
+		isReady:           cfg.readyFilePath == "",
+		registerSource:    cfg.registerSource,
+		requiresSource:    cfg.requiresSource,
+		envFilePaths:      cfg.envFilePaths,
+		envFileInterval:   cfg.envFileInterval,
 	}
+	if cfg.Health != nil {
+		job.failOnExit = cfg.Health.FailOnExit
+	}
+	if job.checkResolver != nil {
+		job.resolverOutput = &bytes.Buffer{}
+		job.checkResolver.Output = job.resolverOutput
+	}
+	// the registration gate starts closed until the dependency is healthy
+	job.awaitingDependency = job.registerSource != ""
 	job.statusLock = &sync.RWMutex{}
 	job.completeLock = &sync.RWMutex{}
 	job.Rx = make(chan events.Event, eventBufferSize)
+	job.Subscriber.Name = "job." + job.Name
 	if job.Name == "containerpilot" {
 		// right now this hardcodes the telemetry service to
 		// be always "healthy", but maybe we want to have it verify itself
@@ -91,27 +208,40 @@ func NewJob(cfg *Config) *Job {
 // FromConfigs creates Jobs from a slice of validated Configs
 func FromConfigs(cfgs []*Config) []*Job {
 	jobs := []*Job{}
+	byName := map[string]*Job{}
 	for _, cfg := range cfgs {
 		job := NewJob(cfg)
 		jobs = append(jobs, job)
+		byName[job.Name] = job
+	}
+	for _, job := range jobs {
+		if job.env != nil {
+			job.env.peers = byName
+		}
 	}
 	return jobs
 }
 
 // SendHeartbeat sends a heartbeat for this Job's service
 func (job *Job) SendHeartbeat() {
-	if job.Service != nil {
+	if job.Service != nil && job.canRegister() {
 		job.Service.SendHeartbeat()
 	}
 }
 
 // checkRegistration registers this Job's service if it isn't already registered.
 func (job *Job) checkRegistration() {
-	if job.Service != nil && job.Service.InitialStatus != "" {
+	if job.Service != nil && job.Service.InitialStatus != "" && job.canRegister() {
 		job.Service.RegisterWithInitialStatus()
 	}
 }
 
+// canRegister returns true if neither the ready file nor the registerWhen
+// dependency is holding back the Job's service registration
+func (job *Job) canRegister() bool {
+	return job.isReady && !job.awaitingDependency
+}
+
 // GetStatus returns the current health status of the Job
 func (job *Job) GetStatus() JobStatus {
 	job.statusLock.RLock()
@@ -119,6 +249,12 @@ func (job *Job) GetStatus() JobStatus {
 	return job.Status
 }
 
+// IsHealthy returns true if the Job's health checks are passing
+func (job *Job) IsHealthy() bool {
+	status := job.GetStatus()
+	return status == statusHealthy || status == statusAlwaysHealthy
+}
+
 func (job *Job) setStatus(status JobStatus) {
 	job.statusLock.Lock()
 	defer job.statusLock.Unlock()
@@ -127,6 +263,35 @@ func (job *Job) setStatus(status JobStatus) {
 	}
 }
 
+// GetLifecycle returns when the Job's process was last started and last
+// stopped, and how long it's been running if it's running now. Times are
+// zero if the process hasn't been started or stopped yet.
+func (job *Job) GetLifecycle() (lastStart, lastStop time.Time, uptime time.Duration) {
+	job.statusLock.RLock()
+	defer job.statusLock.RUnlock()
+	if !job.lastStart.IsZero() && job.lastStop.Before(job.lastStart) {
+		uptime = time.Since(job.lastStart)
+	}
+	return job.lastStart, job.lastStop, uptime
+}
+
+func (job *Job) setLifecycle(start, stop time.Time) {
+	job.statusLock.Lock()
+	defer job.statusLock.Unlock()
+	if !start.IsZero() {
+		job.lastStart = start
+	}
+	if !stop.IsZero() {
+		job.lastStop = stop
+	}
+}
+
+// KeepsRegistration returns true if the Job leaves its service registered
+// when it's stopped for a reload of the configuration
+func (job *Job) KeepsRegistration() bool {
+	return job.Service != nil && job.keepOnReload
+}
+
 func (job *Job) setComplete() {
 	job.completeLock.Lock()
 	defer job.completeLock.Unlock()
@@ -152,6 +317,10 @@ func (job *Job) Run(pctx context.Context, completedCh chan struct{}) {
 		events.NewEventTimer(ctx, job.Rx, job.heartbeat,
 			fmt.Sprintf("%s.heartbeat", job.Name))
 	}
+	if job.envFileInterval > 0 {
+		events.NewEventTimer(ctx, job.Rx, job.envFileInterval,
+			fmt.Sprintf("%s.env-poll", job.Name))
+	}
 	if job.startTimeout > 0 {
 		timeoutName := fmt.Sprintf("%s.wait-timeout", job.Name)
 		events.NewEventTimeout(ctx, job.Rx, job.startTimeout, timeoutName)
@@ -187,11 +356,34 @@ func (job *Job) Run(pctx context.Context, completedCh chan struct{}) {
 func (job *Job) processEvent(ctx context.Context, event events.Event) processEventStatus {
 	runEverySource := fmt.Sprintf("%s.run-every", job.Name)
 	heartbeatSource := fmt.Sprintf("%s.heartbeat", job.Name)
+	readyPollSource := fmt.Sprintf("%s.ready-poll", job.Name)
+	readyTimeoutSource := fmt.Sprintf("%s.ready-timeout", job.Name)
+	envPollSource := fmt.Sprintf("%s.env-poll", job.Name)
+	flapBackoffSource := fmt.Sprintf("%s.flap-backoff", job.Name)
+	envRetrySource := fmt.Sprintf("%s.env-retry", job.Name)
 	healthCheckName := fmt.Sprintf("check.%s", job.Name)
 	if job.healthCheckExec != nil {
 		healthCheckName = job.healthCheckExec.Name
 	}
 
+	if job.registerSource != "" && event.Source == job.registerSource {
+		// not exclusive of the events below, because the same
+		// dependency might also gate the start of the job
+		job.onRegisterDependency(event)
+	}
+	if job.requiresSource != "" && event.Source == job.requiresSource {
+		job.onRequiredDependency(ctx, event)
+	}
+
+	if event.Code == events.ExitSuccess || event.Code == events.ExitFailed {
+		if job.checkResolver != nil && event.Source == job.checkResolver.Name {
+			return job.onResolverExit(ctx, event.Code == events.ExitSuccess)
+		}
+		if check := job.subCheckFor(event.Source); check != nil {
+			return job.onSubCheckExit(ctx, check, event.Code == events.ExitSuccess)
+		}
+	}
+
 	switch event {
 
 	case events.Event{Code: events.TimerExpired, Source: heartbeatSource}:
@@ -203,6 +395,24 @@ func (job *Job) processEvent(ctx context.Context, event events.Event) processEve
 	case events.Event{Code: events.TimerExpired, Source: runEverySource}:
 		return job.onRunEveryTimerExpired(ctx)
 
+	case events.Event{Code: events.TimerExpired, Source: readyPollSource}:
+		return job.onReadyFilePoll(ctx)
+
+	case events.Event{Code: events.TimerExpired, Source: readyTimeoutSource}:
+		return job.onReadyFileTimeout(ctx)
+
+	case events.Event{Code: events.TimerExpired, Source: envPollSource}:
+		return job.onEnvFilePoll(ctx)
+
+	case events.Event{Code: events.TimerExpired, Source: flapBackoffSource}:
+		job.startJobExec(ctx)
+		return jobContinue
+
+	case events.Event{Code: events.TimerExpired, Source: envRetrySource}:
+		job.envHeld = false
+		job.startJobExec(ctx)
+		return jobContinue
+
 	case events.Event{Code: events.ExitFailed, Source: healthCheckName}:
 		return job.onHealthCheckFailed(ctx)
 
@@ -223,6 +433,12 @@ func (job *Job) processEvent(ctx context.Context, event events.Event) processEve
 		events.Event{Code: events.ExitFailed, Source: job.Name}:
 		return job.onExecExit(ctx)
 
+	case events.Event{Code: events.Deregister, Source: job.Name}:
+		return job.onDeregister(ctx)
+
+	case events.Event{Code: events.Register, Source: job.Name}:
+		return job.onRegister(ctx)
+
 	case events.Event{Code: events.Signal, Source: "SIGHUP"},
 		events.Event{Code: events.Signal, Source: "SIGUSR2"}:
 		return job.onSignalEvent(ctx, event.Source)
@@ -235,17 +451,194 @@ func (job *Job) processEvent(ctx context.Context, event events.Event) processEve
 
 // startJobExec runs the Job's executable and returns without waiting
 func (job *Job) startJobExec(ctx context.Context) {
+	job.startExec(ctx, false)
+}
+
+// startJobExecOnWatch runs the Job's executable for a change found by the
+// watch that starts it. Unlike any other start, such as a retry or a
+// restart, it's skipped if the Job is configured to skip unchanged
+// renders and its rendered file is unchanged.
+func (job *Job) startJobExecOnWatch(ctx context.Context) {
+	job.startExec(ctx, true)
+}
+
+func (job *Job) startExec(ctx context.Context, skippable bool) {
 	job.startTimeoutEvent = events.NonEvent
+	if job.dependencyLost {
+		log.Infof("job[%s] not started while %s is unhealthy",
+			job.Name, job.requiresSource)
+		job.dependencyStopped = true
+		return
+	}
+	// the env is rendered first so that a start held for it doesn't
+	// write the file, which would leave it unchanged for the retry
+	if job.exec != nil && !job.renderEnv(ctx) {
+		return
+	}
+	if job.renderer != nil && !job.renderFile(skippable) {
+		return
+	}
 	job.setStatus(statusUnknown)
 	if job.exec != nil {
+		job.loadEnvFiles()
+		job.exec.Env = job.execEnv()
+		job.renderExecArgs()
+		job.setLifecycle(time.Now(), time.Time{})
 		job.exec.Run(ctx, job.Publisher.Bus)
+		job.isRunning = true
+		job.execStarts++
+	}
+	job.watchReadyFile(ctx)
+}
+
+// loadEnvFiles reads the Job's env files for its exec's environment. If
+// the files can't be read we keep whatever values we last read.
+func (job *Job) loadEnvFiles() {
+	if len(job.envFilePaths) == 0 {
+		return
+	}
+	env, err := job.readEnvFiles()
+	if err != nil {
+		log.Errorf("job[%s] unable to read env files: %v", job.Name, err)
+		return
+	}
+	job.envFileValues = env
+}
+
+// renderFile renders the Job's file from the instances found by the
+// watch that started it. Returns false if the exec shouldn't run, either
+// because the file couldn't be rendered or because it's unchanged, the
+// start is skippable, and we're configured to skip the exec when it is.
+func (job *Job) renderFile(skippable bool) bool {
+	changed, err := job.renderer.write(job.getUpstream())
+	if err != nil {
+		log.Errorf("job[%s] unable to render %s: %v", job.Name, job.renderer.path, err)
+		return false
+	}
+	if !changed && skippable && job.renderer.skipUnchanged {
+		log.Debugf("job[%s] skipped: %s is unchanged", job.Name, job.renderer.path)
+		renderSkipped.WithLabelValues(job.Name).Inc()
+		return false
+	}
+	return true
+}
+
+// renderEnv fills in the Job's env from the state of the jobs it refers
+// to. If it can't be rendered, such as when one of them hasn't started
+// yet, we hold the start and try again after envRetryInterval rather
+// than start the exec without the values. Returns false if the start is
+// held.
+func (job *Job) renderEnv(ctx context.Context) bool {
+	if job.env == nil {
+		return true
+	}
+	if job.envHeld {
+		return false // already waiting to try again
+	}
+	env, err := job.env.render()
+	if err != nil {
+		log.Warnf("job[%s] waiting to start, unable to render env: %v",
+			job.Name, err)
+		job.envHeld = true
+		events.NewEventTimeout(ctx, job.Rx, envRetryInterval,
+			fmt.Sprintf("%s.env-retry", job.Name))
+		return false
+	}
+	job.envValues = env
+	return true
+}
+
+// renderExecArgs fills in the Job's exec arguments from the instances
+// last found by the watch that starts it. If they can't be rendered we
+// keep whatever arguments we last had.
+func (job *Job) renderExecArgs() {
+	if job.execArgs == nil {
+		return
+	}
+	args, err := job.execArgs.render(job.getUpstream())
+	if err != nil {
+		log.Errorf("job[%s] unable to render exec arguments: %v", job.Name, err)
+		return
+	}
+	job.exec.Args = args
+}
+
+// UseWatchUpstreams points the Job's exec templates and render at the
+// upstream of the watch that starts it, if it's one of the upstreams by
+// watch name. A soft reload replaces the watches, and the upstreams of
+// the old ones stop finding instances.
+func (job *Job) UseWatchUpstreams(upstreams map[string]discovery.Upstream) error {
+	if job.getUpstream() == nil {
+		return nil
+	}
+	lister, err := watchLister(job.Name, job.upstreamWatch, upstreams)
+	if err != nil || lister == nil {
+		return err
+	}
+	job.statusLock.Lock()
+	defer job.statusLock.Unlock()
+	job.upstream = lister
+	return nil
+}
+
+func (job *Job) getUpstream() discovery.InstanceLister {
+	job.statusLock.RLock()
+	defer job.statusLock.RUnlock()
+	return job.upstream
+}
+
+// setTrigger records the event that's starting the Job's exec, so that
+// the process knows what started it and when. Restarts keep the event.
+func (job *Job) setTrigger(event events.Event) {
+	job.triggerSource = event.Source
+	job.triggerTime = time.Now()
+}
+
+func (job *Job) readEnvFiles() ([]string, error) {
+	env := []string{}
+	for _, path := range job.envFilePaths {
+		values, err := commands.ParseEnvFile(path)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, values...)
+	}
+	return env, nil
+}
+
+// watchReadyFile closes the readiness gate and starts polling for the
+// ready file, which reopens it once the file appears
+func (job *Job) watchReadyFile(ctx context.Context) {
+	if job.readyFilePath == "" {
+		return
+	}
+	if job.readyCancel != nil {
+		job.readyCancel()
+	}
+	job.isReady = false
+	readyCtx, cancel := context.WithCancel(ctx)
+	job.readyCancel = cancel
+	events.NewEventTimer(readyCtx, job.Rx, job.readyFileInterval,
+		fmt.Sprintf("%s.ready-poll", job.Name))
+	if job.readyFileTimeout > 0 {
+		events.NewEventTimeout(readyCtx, job.Rx, job.readyFileTimeout,
+			fmt.Sprintf("%s.ready-timeout", job.Name))
 	}
 }
 
 func (job *Job) onHeartbeatTimerExpired(ctx context.Context) processEventStatus {
 	status := job.GetStatus()
 	if status != statusMaintenance && status != statusIdle {
-		if job.healthCheckExec != nil {
+		if job.checkResolver != nil && job.checkTarget == "" {
+			job.runResolver(ctx)
+		} else if len(job.healthChecks) > 0 {
+			// start a new round of results
+			job.checkResults = make(map[string]bool)
+			job.warningsRemain = job.warningRetries
+			for _, check := range job.healthChecks {
+				check.exec.Run(ctx, job.Publisher.Bus)
+			}
+		} else if job.healthCheckExec != nil {
 			job.healthCheckExec.Run(ctx, job.Publisher.Bus)
 		} else if job.Service != nil {
 			// this is the case for non-checked but advertised
@@ -256,6 +649,46 @@ func (job *Job) onHeartbeatTimerExpired(ctx context.Context) processEventStatus
 	return jobContinue
 }
 
+// runResolver runs the command that produces the target of the health
+// checks, unless it's already running
+func (job *Job) runResolver(ctx context.Context) {
+	if job.resolving {
+		return
+	}
+	job.resolving = true
+	job.resolverOutput.Reset()
+	job.checkResolver.Run(ctx, job.Publisher.Bus)
+}
+
+// onResolverExit passes the last line the resolver printed to the health
+// checks as their target, and starts checking. If the resolver failed,
+// we try again when the next check is due.
+func (job *Job) onResolverExit(ctx context.Context, passed bool) processEventStatus {
+	job.resolving = false
+	if !passed {
+		log.Warnf("job[%s] health check resolver failed, retrying at next interval",
+			job.Name)
+		return jobContinue
+	}
+	lines := strings.Split(strings.TrimSpace(job.resolverOutput.String()), "\n")
+	target := strings.TrimSpace(lines[len(lines)-1])
+	if target == "" {
+		log.Warnf("job[%s] health check resolver printed no target, retrying at next interval",
+			job.Name)
+		return jobContinue
+	}
+	log.Infof("job[%s] health checks target %s", job.Name, target)
+	job.checkTarget = target
+	env := commands.EnvVar("CHECK_TARGET") + "=" + target
+	if job.healthCheckExec != nil {
+		job.healthCheckExec.Env = append(job.healthCheckExec.Env, env)
+	}
+	for _, check := range job.healthChecks {
+		check.exec.Env = append(check.exec.Env, env)
+	}
+	return job.onHeartbeatTimerExpired(ctx)
+}
+
 func (job *Job) onStartTimeoutExpired(ctx context.Context) processEventStatus {
 	job.Publish(events.Event{
 		Code: events.TimerExpired, Source: job.Name})
@@ -271,10 +704,126 @@ func (job *Job) onRunEveryTimerExpired(ctx context.Context) processEventStatus {
 		return jobHalt
 	}
 	job.restartsRemain--
+	job.setTrigger(events.Event{
+		Code: events.TimerExpired, Source: job.Name + ".run-every"})
 	job.startJobExec(ctx)
 	return jobContinue
 }
 
+func (job *Job) onReadyFilePoll(ctx context.Context) processEventStatus {
+	if job.isReady {
+		return jobContinue
+	}
+	if _, err := os.Stat(job.readyFilePath); err != nil {
+		return jobContinue
+	}
+	log.Debugf("job[%s] ready file found: %s", job.Name, job.readyFilePath)
+	job.isReady = true
+	job.readyCancel()
+	if job.healthCheckExec == nil && len(job.healthChecks) == 0 {
+		// without a health check we'd otherwise have to wait for
+		// the next heartbeat before registering
+		job.SendHeartbeat()
+	}
+	return jobContinue
+}
+
+func (job *Job) onReadyFileTimeout(ctx context.Context) processEventStatus {
+	if job.isReady {
+		return jobContinue
+	}
+	log.Errorf("job[%s] timed out waiting for ready file: %s",
+		job.Name, job.readyFilePath)
+	job.readyCancel()
+	job.setStatus(statusUnhealthy)
+	job.Publish(events.Event{events.StatusUnhealthy, job.Name})
+	return jobContinue
+}
+
+// onRegisterDependency opens the registration gate when the registerWhen
+// dependency becomes healthy and closes it again, deregistering the
+// service, when the dependency becomes unhealthy
+func (job *Job) onRegisterDependency(event events.Event) {
+	switch event.Code {
+	case events.StatusHealthy:
+		if !job.awaitingDependency {
+			return
+		}
+		log.Debugf("job[%s] registration dependency %s is healthy",
+			job.Name, job.registerSource)
+		job.awaitingDependency = false
+		if job.healthCheckExec == nil && len(job.healthChecks) == 0 {
+			// without a health check we'd otherwise have to wait for
+			// the next heartbeat before registering
+			job.SendHeartbeat()
+		}
+	case events.StatusUnhealthy, events.Stopped:
+		if job.awaitingDependency {
+			return
+		}
+		log.Infof("job[%s] registration dependency %s is unhealthy, deregistering",
+			job.Name, job.registerSource)
+		job.awaitingDependency = true
+		// clears the registration so that we register again once the
+		// dependency recovers, without suppressing it after that
+		job.Service.ForceDeregister()
+		job.Service.Reregister()
+	}
+}
+
+// onRequiredDependency stops the Job's process when the dependency it
+// requires becomes unhealthy, and starts it again when the dependency
+// recovers
+func (job *Job) onRequiredDependency(ctx context.Context, event events.Event) {
+	switch event.Code {
+	case events.StatusHealthy:
+		if !job.dependencyLost {
+			return
+		}
+		log.Infof("job[%s] required dependency %s is healthy",
+			job.Name, job.requiresSource)
+		job.dependencyLost = false
+		if job.dependencyStopped && !job.isRunning {
+			job.dependencyStopped = false
+			job.startJobExec(ctx)
+		}
+		// otherwise we'll start it again once we see it exit
+	case events.StatusUnhealthy, events.Stopped:
+		if job.dependencyLost {
+			return
+		}
+		job.dependencyLost = true
+		if job.isRunning {
+			log.Infof("job[%s] required dependency %s is unhealthy, stopping",
+				job.Name, job.requiresSource)
+			job.dependencyStopped = true
+			job.exec.Term()
+		}
+	}
+}
+
+func (job *Job) onEnvFilePoll(ctx context.Context) processEventStatus {
+	if job.exec == nil || job.envRestart {
+		return jobContinue
+	}
+	env, err := job.readEnvFiles()
+	if err != nil {
+		log.Warnf("job[%s] unable to read env files: %v", job.Name, err)
+		return jobContinue
+	}
+	if reflect.DeepEqual(env, job.envFileValues) {
+		return jobContinue
+	}
+	job.envFileValues = env
+	if job.isRunning {
+		// we'll start the exec again once we see it exit
+		log.Infof("job[%s] env files changed, restarting", job.Name)
+		job.envRestart = true
+		job.exec.Term()
+	}
+	return jobContinue
+}
+
 func (job *Job) onHealthCheckFailed(ctx context.Context) processEventStatus {
 	if job.GetStatus() != statusMaintenance {
 		job.setStatus(statusUnhealthy)
@@ -283,8 +832,20 @@ func (job *Job) onHealthCheckFailed(ctx context.Context) processEventStatus {
 	return jobContinue
 }
 
+// failHealthCheck marks the job unhealthy and its service critical right
+// away, rather than waiting for the next health check or for the TTL to
+// expire, because we know the process backing the service has exited
+func (job *Job) failHealthCheck() {
+	if job.Service == nil || job.GetStatus() == statusMaintenance {
+		return
+	}
+	job.setStatus(statusUnhealthy)
+	job.Publish(events.Event{events.StatusUnhealthy, job.Name})
+	job.Service.SendCritical("process exited")
+}
+
 func (job *Job) onHealthCheckPassed(ctx context.Context) processEventStatus {
-	if job.GetStatus() != statusMaintenance {
+	if job.GetStatus() != statusMaintenance && job.isReady {
 		job.setStatus(statusHealthy)
 		job.Publish(events.Event{events.StatusHealthy, job.Name})
 		job.SendHeartbeat()
@@ -292,6 +853,67 @@ func (job *Job) onHealthCheckPassed(ctx context.Context) processEventStatus {
 	return jobContinue
 }
 
+func (job *Job) onHealthCheckWarning(ctx context.Context) processEventStatus {
+	if job.GetStatus() != statusMaintenance && job.isReady {
+		// a warning is still healthy for the purposes of other jobs,
+		// but we report it to Consul
+		job.setStatus(statusHealthy)
+		job.Publish(events.Event{events.StatusHealthy, job.Name})
+		if job.Service != nil && job.canRegister() {
+			job.Service.SendWarning()
+		}
+	}
+	return jobContinue
+}
+
+// subCheckFor returns the sub-check whose exec is named source, if any
+func (job *Job) subCheckFor(source string) *subCheck {
+	for _, check := range job.healthChecks {
+		if check.exec.Name == source {
+			return check
+		}
+	}
+	return nil
+}
+
+func (job *Job) onSubCheckExit(ctx context.Context, check *subCheck, passed bool) processEventStatus {
+	if job.checkResults == nil {
+		return jobContinue // result from a round we've already scored
+	}
+	job.checkResults[check.exec.Name] = passed
+	if len(job.checkResults) < len(job.healthChecks) {
+		return jobContinue
+	}
+	state := scoreSubChecks(job.healthChecks, job.checkResults)
+	if state == checkWarning && job.warningsRemain > 0 {
+		job.warningsRemain--
+		job.retryFailedChecks(ctx)
+		return jobContinue
+	}
+	job.checkResults = nil
+	job.warningsRemain = job.warningRetries
+	switch state {
+	case checkWarning:
+		return job.onHealthCheckWarning(ctx)
+	case checkCritical:
+		return job.onHealthCheckFailed(ctx)
+	}
+	return job.onHealthCheckPassed(ctx)
+}
+
+// retryFailedChecks re-runs the sub-checks that failed in the current
+// round, keeping the results of those that passed, so that a transient
+// failure of a non-critical check isn't reported as a warning
+func (job *Job) retryFailedChecks(ctx context.Context) {
+	log.Debugf("job[%s] health checks in warning state, retrying", job.Name)
+	for _, check := range job.healthChecks {
+		if !job.checkResults[check.exec.Name] {
+			delete(job.checkResults, check.exec.Name)
+			check.exec.Run(ctx, job.Publisher.Bus)
+		}
+	}
+}
+
 func (job *Job) onQuit(ctx context.Context) processEventStatus {
 	job.restartsRemain = 0 // no more restarts
 	if (job.startEvent.Code == events.Stopping ||
@@ -330,12 +952,77 @@ func (job *Job) onExitMaintenance(ctx context.Context) processEventStatus {
 	return jobContinue
 }
 
+func (job *Job) onDeregister(ctx context.Context) processEventStatus {
+	if job.Service != nil {
+		job.Service.ForceDeregister()
+	}
+	return jobContinue
+}
+
+func (job *Job) onRegister(ctx context.Context) processEventStatus {
+	if job.Service != nil {
+		// we'll be registered again on the next passing health check
+		job.Service.Reregister()
+	}
+	return jobContinue
+}
+
 func (job *Job) onExecExit(ctx context.Context) processEventStatus {
+	job.isRunning = false
+	lastStart, _, _ := job.GetLifecycle()
+	job.setLifecycle(time.Time{}, time.Now())
+	if job.startFailed() {
+		// restarting won't fix a process that can't run at all, so
+		// we exit rather than hide the problem in a restart loop
+		log.Errorf("job[%s] failed on first start with exit code %d, shutting down",
+			job.Name, job.startFailureCode)
+		job.Publisher.Bus.Shutdown()
+		job.startEvent = events.NonEvent
+		job.setStatus(statusUnknown)
+		return jobHalt
+	}
+	if job.envRestart {
+		// restarts for changed env files don't count against the limit
+		job.envRestart = false
+		job.startJobExec(ctx)
+		return jobContinue
+	}
+	if job.dependencyStopped {
+		// stops for a lost dependency don't count against the limit,
+		// and we start again once it recovers
+		if !job.dependencyLost {
+			job.dependencyStopped = false
+			job.startJobExec(ctx)
+		}
+		return jobContinue
+	}
 	if job.frequency > 0 {
 		return jobContinue // periodic jobs ignore previous events
 	}
+	if job.failOnExit {
+		job.failHealthCheck()
+	}
+	if job.flapped(time.Since(lastStart)) {
+		log.Errorf("job[%s] exited within %v of starting %d times in a row, not restarting",
+			job.Name, job.flapMinRuntime, job.quickExits)
+		job.startEvent = events.NonEvent
+		job.setStatus(statusFailed)
+		job.Publish(events.Event{Code: events.StatusUnhealthy, Source: job.Name})
+		return jobHalt
+	}
 	if job.restartPermitted() {
 		job.restartsRemain--
+		if job.exec != nil {
+			job.Publish(events.Event{Code: events.Restarting, Source: job.Name})
+		}
+		if job.quickExits > 0 {
+			delay := job.flapDelay()
+			log.Warnf("job[%s] exited within %v of starting, restarting in %v",
+				job.Name, job.flapMinRuntime, delay)
+			events.NewEventTimeout(ctx, job.Rx, delay,
+				fmt.Sprintf("%s.flap-backoff", job.Name))
+			return jobContinue
+		}
 		job.startJobExec(ctx)
 		return jobContinue
 	}
@@ -351,6 +1038,7 @@ func (job *Job) onExecExit(ctx context.Context) processEventStatus {
 func (job *Job) onSignalEvent(ctx context.Context, sig string) processEventStatus {
 	if job.startEvent.Code == events.Signal &&
 		job.startEvent.Source == sig {
+		job.setTrigger(job.startEvent)
 		job.startJobExec(ctx)
 	}
 	return jobContinue
@@ -361,6 +1049,8 @@ func (job *Job) onStartEvent(ctx context.Context) processEventStatus {
 		job.startEvent = events.NonEvent
 		return jobHalt
 	}
+	job.setTrigger(job.startEvent)
+	fromWatch := job.startEvent.Code == events.StatusChanged
 	if job.startsRemain != unlimited {
 		// if we have unlimited restarts we want to make sure we don't
 		// decrement forever and then wrap-around
@@ -371,10 +1061,64 @@ func (job *Job) onStartEvent(ctx context.Context) processEventStatus {
 			job.startEvent = events.NonEvent
 		}
 	}
-	job.startJobExec(ctx)
+	if fromWatch {
+		job.startJobExecOnWatch(ctx)
+	} else {
+		job.startJobExec(ctx)
+	}
 	return jobContinue
 }
 
+// startFailed returns true if the job is configured to exit on a start
+// failure and its exec couldn't be run the first time it was started
+// (exit code 126 or 127, by shell convention), and records the exit code
+func (job *Job) startFailed() bool {
+	if !job.exitOnStartFail || job.exec == nil || job.execStarts != 1 {
+		return false
+	}
+	code := job.exec.ExitCode()
+	if code != 126 && code != 127 {
+		return false
+	}
+	job.startFailureCode = code
+	return true
+}
+
+// StartFailureCode returns the exit code of the Job's exec if it failed
+// on its first start and the Job is configured to exit for that, or zero
+// otherwise
+func (job *Job) StartFailureCode() int {
+	return job.startFailureCode
+}
+
+// flapped counts the process' exit as a quick exit if it ran for less
+// than the flapping minRuntime, and returns true once there have been
+// too many quick exits in a row
+func (job *Job) flapped(runtime time.Duration) bool {
+	if job.flapMinRuntime == 0 || job.exec == nil {
+		return false
+	}
+	if runtime >= job.flapMinRuntime {
+		job.quickExits = 0
+		return false
+	}
+	job.quickExits++
+	return job.quickExits >= job.flapLimit
+}
+
+// flapDelay is how long we wait to restart after a quick exit. It
+// doubles with each quick exit in a row, up to the flapping maxBackoff.
+func (job *Job) flapDelay() time.Duration {
+	delay := job.flapBackoff
+	for i := 1; i < job.quickExits && delay < job.flapMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > job.flapMaxBackoff {
+		delay = job.flapMaxBackoff
+	}
+	return delay
+}
+
 func (job *Job) restartPermitted() bool {
 	if job.restartLimit == unlimited || job.restartsRemain > 0 {
 		return true
@@ -383,8 +1127,11 @@ func (job *Job) restartPermitted() bool {
 }
 
 // cleanup fires the Stopping event and will wait to receive a stoppingWaitEvent
-// if one is configured. cleans up registration to event bus and closes all
-// channels and contexts when done.
+// if one is configured. If the job's process exits on its own while we're
+// waiting there's nothing left for the stopping job to act on, so we stop
+// waiting unless stopWaitOnExit is set. It then waits for the drain gate,
+// if any, before stopping the process. cleans up registration to event bus
+// and closes all channels and contexts when done.
 func (job *Job) cleanup(ctx context.Context, cancel context.CancelFunc) {
 	stoppingTimeout := fmt.Sprintf("%s.stopping-timeout", job.Name)
 	job.Publish(events.Event{Code: events.Stopping, Source: job.Name})
@@ -400,13 +1147,29 @@ func (job *Job) cleanup(ctx context.Context, cancel context.CancelFunc) {
 			switch event {
 			case job.stoppingWaitEvent:
 				break loop
-			case events.Event{events.Stopping, stoppingTimeout}:
+			case events.Event{events.TimerExpired, stoppingTimeout}:
 				break loop
+			case events.Event{events.ExitSuccess, job.Name},
+				events.Event{events.ExitFailed, job.Name}:
+				if !job.stopWaitOnExit {
+					log.Debugf("job[%s] exited while stopping, not waiting for %v",
+						job.Name, job.stoppingWaitEvent.Source)
+					break loop
+				}
 			}
 		}
 	}
+	job.waitForDrainGate()
 	cancel()
-	if job.Service != nil {
+	if job.KeepsRegistration() && job.Publisher.Bus.Reloading() {
+		// the job from the new config updates the registration in
+		// place, so we don't leave a gap where the service is missing.
+		// But nothing is serving it until then, so we fail its check
+		// rather than let it pass until the TTL expires.
+		log.Debugf("job[%s] keeping service %s registered for reload",
+			job.Name, job.Service.ID)
+		job.Service.SendCritical("stopped for reload")
+	} else if job.Service != nil {
 		job.Service.Deregister() // deregister from Consul
 	}
 	job.Unsubscribe() // deregister from events
@@ -2,14 +2,24 @@ package jobs
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/joyent/containerpilot/discovery"
 	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/tests"
+	"github.com/joyent/containerpilot/tests/mocks"
+	"github.com/joyent/containerpilot/watches"
 )
 
 func TestJobRunSafeClose(t *testing.T) {
@@ -163,6 +173,488 @@ func TestJobRunRestarts(t *testing.T) {
 	runRestartsTest(nil, 1)
 }
 
+func TestJobEventEnv(t *testing.T) {
+	dir, _ := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	bus := events.NewEventBus()
+	cfg := &Config{
+		Name: "myjob",
+		Exec: []interface{}{"sh", "-c", fmt.Sprintf(
+			"echo $CONTAINERPILOT_EVENT_SOURCE $CONTAINERPILOT_EVENT_TIME >> %s", out)},
+		When:     &WhenConfig{Frequency: "100ms"},
+		Restarts: "1",
+	}
+	if err := cfg.Validate(noop); err != nil {
+		t.Fatal(err)
+	}
+	job := NewJob(cfg)
+	job.Subscribe(bus)
+	job.Register(bus)
+	ctx, cancel := context.WithCancel(context.Background())
+	job.Run(ctx, make(chan struct{}, 1))
+	started := time.Now()
+	job.Publish(events.GlobalStartup)
+	time.Sleep(500 * time.Millisecond)
+	cancel()
+	bus.Wait()
+
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if !assert.Len(t, lines, 2) {
+		return
+	}
+	assert.True(t, strings.HasPrefix(lines[0], "global "),
+		"expected the first run to be started by the startup event: %s", lines[0])
+	fields := strings.Fields(lines[1])
+	if !assert.Len(t, fields, 2) {
+		return
+	}
+	assert.Equal(t, "myjob.run-every", fields[0])
+	eventTime, err := time.Parse(time.RFC3339, fields[1])
+	if assert.NoError(t, err) {
+		assert.False(t, eventTime.Before(started.Truncate(time.Second)),
+			"event time %v is before the job started", eventTime)
+		assert.False(t, eventTime.After(time.Now()),
+			"event time %v is in the future", eventTime)
+	}
+}
+
+func TestJobExecArgsFromWatch(t *testing.T) {
+	dir, _ := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	disc := &mocks.CountingDiscoveryBackend{}
+	disc.SetInstances([]discovery.Instance{
+		{ID: "svc-a-1", Address: "192.168.1.1", Port: 8080},
+		{ID: "svc-a-2", Address: "192.168.1.2", Port: 8080},
+	})
+	disc.CheckForUpstreamChanges("svc-a", "", "")
+
+	bus := events.NewEventBus()
+	cfg := &Config{
+		Name: "myjob",
+		Exec: []interface{}{"sh", "-c", fmt.Sprintf("echo $# $@ > %s", out), "--",
+			"{{range .Instances}}{{.Address}}:{{.Port}} {{end}}"},
+		When: &WhenConfig{Source: "watch.svc-a", Each: "changed"},
+	}
+	if err := cfg.Validate(disc); err != nil {
+		t.Fatal(err)
+	}
+	job := NewJob(cfg)
+	job.Subscribe(bus)
+	job.Register(bus)
+	ctx, cancel := context.WithCancel(context.Background())
+	job.Run(ctx, make(chan struct{}, 1))
+	job.Publish(events.GlobalStartup)
+	job.Publish(events.Event{events.StatusChanged, "watch.svc-a"})
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	bus.Wait()
+
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "2 192.168.1.1:8080 192.168.1.2:8080",
+		strings.TrimSpace(string(data)))
+}
+
+func TestJobExecArgsFromWatchUpstream(t *testing.T) {
+	dir, _ := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+
+	// the discovery backend doesn't know about the watch's instances,
+	// like Consul for a watch that resolves DNS
+	disc := &mocks.CountingDiscoveryBackend{}
+	newUpstream := func(address string) *mocks.CountingDiscoveryBackend {
+		upstream := &mocks.CountingDiscoveryBackend{}
+		upstream.SetInstances([]discovery.Instance{
+			{ID: address, Address: address, Port: 8080}})
+		upstream.CheckForUpstreamChanges("svc-a", "", "")
+		return upstream
+	}
+	cfgs, err := NewConfigs(tests.DecodeRawToSlice(fmt.Sprintf(`[
+	{
+		name: "myjob",
+		exec: ["sh", "-c", "echo $@ >> %s", "--",
+			"{{range .Instances}}{{.Address}}:{{.Port}}{{end}}"],
+		when: {source: "watch.svc-a", each: "changed"}
+	}]`, out)), disc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = UseWatchUpstreams(cfgs, map[string]discovery.Upstream{
+		"watch.svc-a": newUpstream("192.168.1.1"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bus := events.NewEventBus()
+	job := NewJob(cfgs[0])
+	job.Subscribe(bus)
+	job.Register(bus)
+	ctx, cancel := context.WithCancel(context.Background())
+	job.Run(ctx, make(chan struct{}, 1))
+	job.Publish(events.GlobalStartup)
+	job.Publish(events.Event{events.StatusChanged, "watch.svc-a"})
+	time.Sleep(200 * time.Millisecond)
+
+	// a soft reload replaces the watch and its upstream
+	err = job.UseWatchUpstreams(map[string]discovery.Upstream{
+		"watch.svc-a": newUpstream("192.168.1.2"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.Publish(events.Event{events.StatusChanged, "watch.svc-a"})
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	bus.Wait()
+
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "192.168.1.1:8080\n192.168.1.2:8080\n", string(data))
+
+	err = UseWatchUpstreams(cfgs, map[string]discovery.Upstream{
+		"watch.svc-a": &mocks.NoopDiscoveryBackend{},
+	})
+	assert.EqualError(t, err, "job[myjob] templates require watch.svc-a to list its instances")
+}
+
+func TestJobEnvFromOtherJobs(t *testing.T) {
+	dir, _ := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	cfgs, err := NewConfigs(tests.DecodeRawToSlice(fmt.Sprintf(`[
+	{
+		name: "svc-a",
+		exec: "sleep 2",
+		port: 8080,
+		interfaces: ["inet", "lo0"],
+		health: {exec: "true", interval: 10, ttl: 30}
+	},
+	{
+		name: "svc-b",
+		exec: ["sh", "-c", "echo $A_PORT $A_PID > %s"],
+		env: {
+			A_PORT: "{{ jobPort \"svc-a\" }}",
+			A_PID: "{{ jobPid \"svc-a\" }}"
+		},
+		when: {source: "go", once: "changed"}
+	}]`, out)), noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs := FromConfigs(cfgs)
+	jobA, jobB := jobs[0], jobs[1]
+
+	// svc-a hasn't started yet
+	_, err = jobB.env.render()
+	assert.EqualError(t, err,
+		"unable to render A_PID: template: A_PID:1:3: executing \"A_PID\" at <jobPid \"svc-a\">: error calling jobPid: job[svc-a] is not running")
+
+	bus := events.NewEventBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	for _, job := range jobs {
+		job.Subscribe(bus)
+		job.Register(bus)
+	}
+	for _, job := range jobs {
+		job.Run(ctx, make(chan struct{}, 1))
+	}
+	bus.Publish(events.GlobalStartup)
+	time.Sleep(200 * time.Millisecond)
+	pid := jobA.exec.Pid()
+	bus.Publish(events.Event{events.StatusChanged, "go"})
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	bus.Wait()
+
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotZero(t, pid)
+	assert.Equal(t, fmt.Sprintf("8080 %d", pid), strings.TrimSpace(string(data)))
+	assert.Contains(t, jobB.envValues, "A_PORT=8080")
+}
+
+// A job whose env refers to a job that isn't running yet waits for it
+// rather than starting without the values
+func TestJobEnvHeldUntilPeerRuns(t *testing.T) {
+	dir, _ := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	cfgs, err := NewConfigs(tests.DecodeRawToSlice(fmt.Sprintf(`[
+	{
+		name: "svc-a",
+		exec: "sleep 5",
+		when: {source: "go-a", once: "changed"}
+	},
+	{
+		name: "svc-b",
+		exec: ["sh", "-c", "echo $A_PID > %s"],
+		env: {A_PID: "{{ jobPid \"svc-a\" }}"},
+		when: {source: "go-b", once: "changed"}
+	}]`, out)), noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs := FromConfigs(cfgs)
+	bus := events.NewEventBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	for _, job := range jobs {
+		job.Subscribe(bus)
+		job.Register(bus)
+	}
+	for _, job := range jobs {
+		job.Run(ctx, make(chan struct{}, 1))
+	}
+	defer func() {
+		cancel()
+		bus.Wait()
+	}()
+	bus.Publish(events.GlobalStartup)
+	bus.Publish(events.Event{events.StatusChanged, "go-b"})
+	time.Sleep(200 * time.Millisecond)
+	_, err = os.Stat(out)
+	assert.True(t, os.IsNotExist(err), "expected svc-b to wait for svc-a")
+
+	bus.Publish(events.Event{events.StatusChanged, "go-a"})
+	var data []byte
+	for i := 0; i < 30; i++ {
+		if data, _ = ioutil.ReadFile(out); len(data) > 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	pid := jobs[0].exec.Pid()
+	assert.NotZero(t, pid)
+	assert.Equal(t, fmt.Sprintf("%d", pid), strings.TrimSpace(string(data)))
+}
+
+func TestJobEnvPrecedence(t *testing.T) {
+	dir, _ := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(dir)
+	envFile := filepath.Join(dir, "app.env")
+	ioutil.WriteFile(envFile, []byte(
+		"SHARED=envFiles\nFROM_FILE=envFiles\nDB_PASSWORD=hunter2\n"), 0644)
+	os.Setenv("SHARED", "inherited")
+	defer os.Unsetenv("SHARED")
+
+	runJob := func(precedence string) (string, map[string]EnvValue) {
+		out := filepath.Join(dir, "out")
+		cfgs, err := NewConfigs(tests.DecodeRawToSlice(fmt.Sprintf(`[
+		{
+			name: "app",
+			exec: ["sh", "-c", "echo $SHARED $FROM_FILE > %s"],
+			env: {SHARED: "env"},
+			envFiles: {paths: [%q]},
+			%s
+		}]`, out, envFile, precedence)), noop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		job := NewJob(cfgs[0])
+		bus := events.NewEventBus()
+		ctx, cancel := context.WithCancel(context.Background())
+		job.Subscribe(bus)
+		job.Register(bus)
+		job.Run(ctx, make(chan struct{}, 1))
+		job.Publish(events.GlobalStartup)
+		time.Sleep(200 * time.Millisecond)
+		cancel()
+		bus.Wait()
+		data, _ := ioutil.ReadFile(out)
+		return strings.TrimSpace(string(data)), job.Env()
+	}
+
+	got, env := runJob("")
+	assert.Equal(t, "env envFiles", got, "expected env to override envFiles and inherited by default")
+	assert.Equal(t, EnvValue{"env", "env"}, env["SHARED"])
+	assert.Equal(t, EnvValue{"envFiles", "envFiles"}, env["FROM_FILE"])
+	assert.Equal(t, "global", env["CONTAINERPILOT_EVENT_SOURCE"].Value)
+	assert.Equal(t, EnvValue{"app", "containerpilot"}, env["CONTAINERPILOT_JOB"])
+	assert.Equal(t, EnvValue{"[REDACTED]", "envFiles"}, env["DB_PASSWORD"],
+		"expected sensitive values to be redacted")
+
+	got, env = runJob(`envPrecedence: ["inherited", "envFiles", "env", "containerpilot"]`)
+	assert.Equal(t, "inherited envFiles", got)
+	assert.Equal(t, EnvValue{"inherited", "inherited"}, env["SHARED"])
+
+	got, env = runJob(`envPrecedence: ["containerpilot", "envFiles", "env", "inherited"]`)
+	assert.Equal(t, "envFiles envFiles", got)
+	assert.Equal(t, EnvValue{"envFiles", "envFiles"}, env["SHARED"])
+}
+
+func TestJobRenderSkipUnchanged(t *testing.T) {
+	dir, _ := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(dir)
+	tmpl := filepath.Join(dir, "upstreams.tmpl")
+	dest := filepath.Join(dir, "upstreams")
+	out := filepath.Join(dir, "out")
+	ioutil.WriteFile(tmpl,
+		[]byte("{{range .Instances}}server {{.Address}}:{{.Port}}\n{{end}}"), 0644)
+	disc := &mocks.CountingDiscoveryBackend{}
+
+	bus := events.NewEventBus()
+	cfg := &Config{
+		Name:   "reload-app",
+		Exec:   []interface{}{"sh", "-c", fmt.Sprintf("echo reload >> %s", out)},
+		When:   &WhenConfig{Source: "watch.svc-a", Each: "changed"},
+		Render: &RenderConfig{Template: tmpl, Path: dest, SkipUnchanged: true},
+	}
+	if err := cfg.Validate(disc); err != nil {
+		t.Fatal(err)
+	}
+	job := NewJob(cfg)
+	job.Subscribe(bus)
+	job.Register(bus)
+	ctx, cancel := context.WithCancel(context.Background())
+	job.Run(ctx, make(chan struct{}, 1))
+	job.Publish(events.GlobalStartup)
+	metric := &dto.Metric{}
+	renderSkipped.WithLabelValues("reload-app").Write(metric)
+	skipped := metric.GetCounter().GetValue()
+
+	for _, instances := range [][]discovery.Instance{
+		{{ID: "a1", Address: "192.168.1.1", Port: 80}},
+		{{ID: "a1", Address: "192.168.1.1", Port: 80}}, // renders the same
+		{{ID: "a2", Address: "192.168.1.2", Port: 80}},
+	} {
+		disc.SetInstances(instances)
+		disc.CheckForUpstreamChanges("svc-a", "", "")
+		job.Publish(events.Event{events.StatusChanged, "watch.svc-a"})
+		time.Sleep(200 * time.Millisecond)
+	}
+	cancel()
+	bus.Wait()
+
+	rendered, _ := ioutil.ReadFile(dest)
+	assert.Equal(t, "server 192.168.1.2:80\n", string(rendered))
+	runs, _ := ioutil.ReadFile(out)
+	assert.Equal(t, "reload\nreload\n", string(runs),
+		"expected the exec to be skipped when the file was unchanged")
+	renderSkipped.WithLabelValues("reload-app").Write(metric)
+	assert.Equal(t, skipped+1, metric.GetCounter().GetValue())
+}
+
+// only the starts for a change found by the watch are skipped when the
+// render is unchanged, not a start held until its env can be rendered
+func TestJobRenderSkipUnchangedEnvRetry(t *testing.T) {
+	dir, _ := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(dir)
+	tmpl := filepath.Join(dir, "upstreams.tmpl")
+	dest := filepath.Join(dir, "upstreams")
+	out := filepath.Join(dir, "out")
+	ioutil.WriteFile(tmpl,
+		[]byte("{{range .Instances}}server {{.Address}}:{{.Port}}\n{{end}}"), 0644)
+	disc := &mocks.CountingDiscoveryBackend{}
+	disc.SetInstances([]discovery.Instance{{ID: "a1", Address: "192.168.1.1", Port: 80}})
+	disc.CheckForUpstreamChanges("svc-a", "", "")
+
+	cfgs, err := NewConfigs(tests.DecodeRawToSlice(fmt.Sprintf(`[
+	{
+		name: "db",
+		exec: "sleep 5",
+		when: {source: "go-db", once: "changed"}
+	},
+	{
+		name: "app",
+		exec: ["sh", "-c", "echo $DB_PID >> %s"],
+		env: {DB_PID: "{{ jobPid \"db\" }}"},
+		when: {source: "watch.svc-a", each: "changed"},
+		render: {template: %q, path: %q, skipUnchanged: true}
+	}]`, out, tmpl, dest)), disc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs := FromConfigs(cfgs)
+	bus := events.NewEventBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	for _, job := range jobs {
+		job.Subscribe(bus)
+		job.Register(bus)
+	}
+	for _, job := range jobs {
+		job.Run(ctx, make(chan struct{}, 1))
+	}
+	defer func() {
+		cancel()
+		bus.Wait()
+	}()
+	bus.Publish(events.GlobalStartup)
+	bus.Publish(events.Event{events.StatusChanged, "watch.svc-a"})
+	time.Sleep(200 * time.Millisecond)
+	bus.Publish(events.Event{events.StatusChanged, "go-db"})
+
+	var runs []byte
+	for i := 0; i < 30; i++ {
+		if runs, _ = ioutil.ReadFile(out); len(runs) > 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(t, fmt.Sprintf("%d\n", jobs[0].exec.Pid()), string(runs),
+		"expected the held start to run once the env could be rendered")
+	rendered, _ := ioutil.ReadFile(dest)
+	assert.Equal(t, "server 192.168.1.1:80\n", string(rendered))
+}
+
+func TestJobRunFlapping(t *testing.T) {
+	bus := events.NewEventBus()
+	cfg := &Config{
+		Name:            "myjob",
+		whenEvent:       events.GlobalStartup,
+		whenStartsLimit: 1,
+		Exec:            "true",
+		Restarts:        "unlimited",
+		Flapping: &FlappingConfig{
+			MinRuntime: "1s", Backoff: "50ms", MaxBackoff: "100ms", Limit: 3},
+	}
+	if err := cfg.Validate(noop); err != nil {
+		t.Fatal(err)
+	}
+	job := NewJob(cfg)
+	job.Subscribe(bus)
+	job.Register(bus)
+	job.Run(context.Background(), make(chan struct{}, 1))
+	started := time.Now()
+	job.Publish(events.GlobalStartup)
+	bus.Wait()
+
+	// the first two quick exits are restarted after 50ms and 100ms,
+	// and the third marks the job failed
+	assert.True(t, time.Since(started) >= 150*time.Millisecond,
+		"expected restarts to be delayed")
+	assert.Equal(t, statusFailed, job.GetStatus())
+	got := map[events.Event]int{}
+	for _, event := range bus.DebugEvents() {
+		got[event]++
+	}
+	assert.Equal(t, 3, got[events.Event{events.ExitSuccess, "myjob"}])
+	assert.Equal(t, 2, got[events.Event{events.Restarting, "myjob"}])
+	assert.Equal(t, 1, got[events.Event{events.StatusUnhealthy, "myjob"}])
+}
+
+func TestJobFlapDelay(t *testing.T) {
+	job := &Job{flapBackoff: time.Second, flapMaxBackoff: 5 * time.Second}
+	for i, expected := range []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second,
+	} {
+		job.quickExits = i + 1
+		assert.Equal(t, expected, job.flapDelay())
+	}
+}
+
 func TestJobRunPeriodic(t *testing.T) {
 	bus := events.NewEventBus()
 	stopCh := make(chan struct{}, 1)
@@ -468,3 +960,801 @@ func TestJobProcessEvent(t *testing.T) {
 	})
 
 }
+
+func TestJobKeepsRegistrationOnReload(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[
+		{name: "svc-a", id: "a-id", exec: "sleep 5", port: 80,
+		 interfaces: ["inet", "lo0"], health: {exec: "true", interval: 10, ttl: 30}},
+		{name: "svc-b", id: "b-id", exec: "sleep 5", port: 80,
+		 interfaces: ["inet", "lo0"], health: {exec: "true", interval: 10, ttl: 30},
+		 consul: {onReload: "deregister"}}
+	]`)
+	registry := &mocks.RegistryDiscoveryBackend{}
+	cfgs, err := NewConfigs(testCfg, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := events.NewEventBus()
+	jobs := FromConfigs(cfgs)
+	for _, job := range jobs {
+		job.Subscribe(bus)
+		job.Register(bus)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, job := range jobs {
+		job.Run(ctx, make(chan struct{}, 1))
+		job.SendHeartbeat()
+	}
+	assert.True(t, registry.IsRegistered("a-id"))
+	assert.True(t, registry.IsRegistered("b-id"))
+
+	bus.SetReloadFlag()
+	bus.Shutdown()
+	bus.Wait()
+	for _, job := range jobs {
+		job.Kill()
+	}
+	assert.Equal(t, []string{"b-id"}, registry.Deregistrations(),
+		"expected only the job configured to deregister on reload to do so")
+	assert.True(t, registry.IsRegistered("a-id"))
+	assert.Equal(t, "fail", registry.CheckStatus("service:a-id"),
+		"expected the kept service to stop passing its check")
+	assert.True(t, jobs[0].KeepsRegistration())
+	assert.False(t, jobs[1].KeepsRegistration())
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(`[{name: "svc-c", exec: "true", port: 80,
+		interfaces: ["inet", "lo0"], health: {exec: "true", interval: 10, ttl: 30},
+		consul: {onReload: "never"}}]`), registry)
+	assert.EqualError(t, err,
+		"job[svc-c].consul.onReload must be one of 'update' or 'deregister'")
+}
+
+func TestJobForceDeregister(t *testing.T) {
+	bus := events.NewEventBus()
+	registry := &mocks.RegistryDiscoveryBackend{}
+	newJob := func(name string) *Job {
+		job := &Job{
+			Name: name,
+			Service: &discovery.ServiceDefinition{
+				ID: name + "-id", Name: name, Port: 80, TTL: 5,
+				Consul: registry,
+			},
+			isReady:    true,
+			statusLock: &sync.RWMutex{},
+		}
+		job.Publisher.Bus = bus
+		return job
+	}
+	jobA := newJob("serviceA")
+	jobB := newJob("serviceB")
+	jobA.SendHeartbeat()
+	jobB.SendHeartbeat()
+	assert.True(t, registry.IsRegistered("serviceA-id"))
+	assert.True(t, registry.IsRegistered("serviceB-id"))
+
+	deregister := events.Event{events.Deregister, "serviceA"}
+	jobA.processEvent(nil, deregister)
+	jobB.processEvent(nil, deregister)
+	assert.False(t, registry.IsRegistered("serviceA-id"),
+		"expected targeted service to be deregistered")
+	assert.True(t, registry.IsRegistered("serviceB-id"),
+		"expected other service to remain registered")
+
+	jobA.processEvent(nil, events.Event{events.ExitSuccess, "check.serviceA"})
+	assert.False(t, registry.IsRegistered("serviceA-id"),
+		"expected heartbeat not to re-register deregistered service")
+
+	jobA.processEvent(nil, events.Event{events.Register, "serviceA"})
+	jobA.processEvent(nil, events.Event{events.ExitSuccess, "check.serviceA"})
+	assert.True(t, registry.IsRegistered("serviceA-id"),
+		"expected service to be registered again after explicit register")
+}
+
+func TestJobReadyFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(dir)
+	readyFile := filepath.Join(dir, "ready")
+
+	registry := &mocks.RegistryDiscoveryBackend{}
+	testCfg := tests.DecodeRawToSlice(fmt.Sprintf(`[{
+		name: "myjob",
+		exec: "sleep 5",
+		port: 80,
+		interfaces: ["inet", "lo0"],
+		health: {interval: 10, ttl: 30},
+		readyFile: {path: %q, interval: "10ms", timeout: "5s"}
+	}]`, readyFile))
+	cfgs, err := NewConfigs(testCfg, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := events.NewEventBus()
+	stopCh := make(chan struct{}, 1)
+	job := NewJob(cfgs[0])
+	job.Subscribe(bus)
+	job.Register(bus)
+	ctx, cancel := context.WithCancel(context.Background())
+	job.Run(ctx, stopCh)
+	job.Publish(events.GlobalStartup)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, registry.IsRegistered(job.Service.ID),
+		"expected no registration before ready file exists")
+
+	ioutil.WriteFile(readyFile, []byte{}, 0644)
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, registry.IsRegistered(job.Service.ID),
+		"expected registration after ready file exists")
+
+	cancel()
+	bus.Wait()
+}
+
+// A Job with registerWhen starts right away but isn't registered until
+// its dependency is healthy, and is deregistered if it becomes unhealthy
+func TestJobRegisterWhen(t *testing.T) {
+	registry := &mocks.RegistryDiscoveryBackend{}
+	testCfg := tests.DecodeRawToSlice(`[{
+		name: "myjob",
+		exec: "sleep 5",
+		port: 80,
+		interfaces: ["inet", "lo0"],
+		health: {interval: 10, ttl: 30},
+		registerWhen: {source: "upstream"}
+	}]`)
+	cfgs, err := NewConfigs(testCfg, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := events.NewEventBus()
+	stopCh := make(chan struct{}, 1)
+	job := NewJob(cfgs[0])
+	job.Subscribe(bus)
+	job.Register(bus)
+	ctx, cancel := context.WithCancel(context.Background())
+	job.Run(ctx, stopCh)
+	job.Publish(events.GlobalStartup)
+	job.Publish(events.Event{events.StatusUnhealthy, "upstream"})
+
+	time.Sleep(100 * time.Millisecond)
+	assert.NotEqual(t, 0, job.exec.Pid(), "expected process to be running")
+	job.SendHeartbeat()
+	assert.False(t, registry.IsRegistered(job.Service.ID),
+		"expected no registration while dependency is unhealthy")
+
+	job.Publish(events.Event{events.StatusHealthy, "upstream"})
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, registry.IsRegistered(job.Service.ID),
+		"expected registration after dependency is healthy")
+
+	job.Publish(events.Event{events.StatusUnhealthy, "upstream"})
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, registry.IsRegistered(job.Service.ID),
+		"expected deregistration after dependency is unhealthy")
+
+	job.Publish(events.Event{events.StatusHealthy, "upstream"})
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, registry.IsRegistered(job.Service.ID),
+		"expected registration again after dependency recovers")
+
+	cancel()
+	bus.Wait()
+}
+
+// A health check warning doesn't register a Job that's waiting on its
+// ready file or its registerWhen dependency
+func TestJobWarningWaitsToRegister(t *testing.T) {
+	for _, gate := range []string{
+		`readyFile: {path: "/tmp/doesNotExist", interval: "1s", timeout: "5s"}`,
+		`registerWhen: {source: "upstream"}`,
+	} {
+		registry := &mocks.RegistryDiscoveryBackend{}
+		cfgs, err := NewConfigs(tests.DecodeRawToSlice(fmt.Sprintf(`[{
+			name: "myjob",
+			exec: "sleep 5",
+			port: 80,
+			interfaces: ["inet", "lo0"],
+			health: {interval: 10, ttl: 30},
+			%s
+		}]`, gate)), registry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		job := NewJob(cfgs[0])
+		job.Register(events.NewEventBus())
+		job.onHealthCheckWarning(context.Background())
+		assert.False(t, registry.IsRegistered(job.Service.ID),
+			"expected no registration from a warning with %s", gate)
+		job.Unregister()
+	}
+}
+
+// A Job that requires a watch is stopped when the watched service has
+// no instances left and started again when it has some
+func TestJobRequires(t *testing.T) {
+	disc := &mocks.CountingDiscoveryBackend{}
+	disc.SetCount(1)
+	watchCfgs, err := watches.NewConfigs(tests.DecodeRawToSlice(
+		`[{name: "queue", interval: 1}]`), disc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfgs, err := NewConfigs(tests.DecodeRawToSlice(`[{
+		name: "worker",
+		exec: "sleep 10",
+		requires: {source: "watch.queue"}
+	}]`), disc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := events.NewEventBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	job := NewJob(cfgs[0])
+	job.Subscribe(bus)
+	job.Register(bus)
+	job.Run(ctx, make(chan struct{}, 1))
+	watches.NewWatch(watchCfgs[0]).Run(ctx, bus)
+	job.Publish(events.GlobalStartup)
+
+	time.Sleep(1500 * time.Millisecond)
+	pid := job.exec.Pid()
+	assert.NotZero(t, pid, "expected process to be running")
+
+	disc.SetCount(0)
+	time.Sleep(time.Second)
+	assert.Zero(t, job.exec.Pid(),
+		"expected process to be stopped after the queue has no instances")
+
+	disc.SetCount(1)
+	time.Sleep(time.Second)
+	assert.NotZero(t, job.exec.Pid(),
+		"expected process to be started again after the queue recovers")
+	// the job has no restarts, so it's only running again because it was
+	// stopped for the dependency rather than exiting on its own
+	assert.NotEqual(t, pid, job.exec.Pid())
+
+	cancel()
+	bus.Wait()
+}
+
+// A Job whose process exits while it's waiting on a pre-stop job should
+// stop waiting immediately unless configured otherwise
+func TestJobStoppingProcessExit(t *testing.T) {
+	testFunc := func(t *testing.T, waitOnExit bool) time.Duration {
+		bus := events.NewEventBus()
+		stopCh := make(chan struct{}, 1)
+		cfg := &Config{
+			Name:           "myjob",
+			Exec:           []string{"./testdata/test.sh", "sleepStuff"},
+			StopTimeout:    "1s",
+			StopWaitOnExit: waitOnExit,
+		}
+		cfg.Validate(noop)
+		cfg.setStopping("preStop") // never arrives
+		job := NewJob(cfg)
+		job.Subscribe(bus)
+		job.Register(bus)
+		job.Run(context.Background(), stopCh)
+		job.Publish(events.GlobalStartup)
+		time.Sleep(100 * time.Millisecond) // let the process start
+
+		start := time.Now()
+		bus.Publish(events.GlobalShutdown)
+		time.Sleep(100 * time.Millisecond) // let the job start stopping
+		job.Kill()
+		bus.Wait()
+		return time.Since(start)
+	}
+
+	t.Run("proceed on exit", func(t *testing.T) {
+		elapsed := testFunc(t, false)
+		assert.True(t, elapsed < time.Second,
+			"expected shutdown without waiting for stop timeout, took %v", elapsed)
+	})
+	t.Run("wait on exit", func(t *testing.T) {
+		elapsed := testFunc(t, true)
+		assert.True(t, elapsed >= time.Second,
+			"expected shutdown to wait for stop timeout, took %v", elapsed)
+	})
+}
+
+// A Job watching its env files should restart its process with the new
+// values when they change
+func TestJobEnvFileRotation(t *testing.T) {
+	dir, _ := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(dir)
+	envFile := filepath.Join(dir, "secrets.env")
+	outFile := filepath.Join(dir, "out")
+	ioutil.WriteFile(envFile, []byte("SECRET=first\n"), 0644)
+
+	testCfg := tests.DecodeRawToSlice(fmt.Sprintf(`[{
+		name: "myjob",
+		exec: ["sh", "-c", "echo $SECRET >> %s; sleep 5"],
+		envFiles: {paths: %q, interval: "10ms"}
+	}]`, outFile, envFile))
+	cfgs, err := NewConfigs(testCfg, noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := events.NewEventBus()
+	stopCh := make(chan struct{}, 1)
+	job := NewJob(cfgs[0])
+	job.Subscribe(bus)
+	job.Register(bus)
+	ctx, cancel := context.WithCancel(context.Background())
+	job.Run(ctx, stopCh)
+	job.Publish(events.GlobalStartup)
+
+	waitForOutput := func(expected string) string {
+		var out []byte
+		for i := 0; i < 100; i++ {
+			if out, _ = ioutil.ReadFile(outFile); string(out) == expected {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return string(out)
+	}
+	assert.Equal(t, "first\n", waitForOutput("first\n"))
+	ioutil.WriteFile(envFile, []byte("SECRET=second\n"), 0644)
+	assert.Equal(t, "first\nsecond\n", waitForOutput("first\nsecond\n"),
+		"expected job to restart with rotated env value")
+
+	cancel()
+	bus.Wait()
+}
+
+// A Job's postStop hook runs once after each exit of its process
+func TestJobPostStop(t *testing.T) {
+	dir, _ := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(dir)
+	outFile := filepath.Join(dir, "out")
+
+	testCfg := tests.DecodeRawToSlice(fmt.Sprintf(`[{
+		name: "myjob",
+		exec: "sleep 5",
+		postStop: {exec: ["sh", "-c", "echo ran >> %s"], timeout: "1s"}
+	}]`, outFile))
+	cfgs, err := NewConfigs(testCfg, noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := events.NewEventBus()
+	job := NewJob(cfgs[0])
+	job.Subscribe(bus)
+	job.Register(bus)
+	job.Run(context.Background(), make(chan struct{}, 1))
+	job.Publish(events.GlobalStartup)
+	time.Sleep(100 * time.Millisecond)
+	out, _ := ioutil.ReadFile(outFile)
+	assert.Equal(t, "", string(out), "expected postStop not to run yet")
+
+	job.Kill()
+	bus.Wait()
+	out, _ = ioutil.ReadFile(outFile)
+	assert.Equal(t, "ran\n", string(out), "expected postStop to run once")
+}
+
+// A Job with a drain gate doesn't stop its process until the gate passes,
+// or until the gate's timeout expires
+func TestJobDrainGate(t *testing.T) {
+	testFunc := func(t *testing.T, passOn int, timeout string) ([]string, time.Duration) {
+		dir, _ := ioutil.TempDir("", "TestJobDrainGate")
+		defer os.RemoveAll(dir)
+		outFile := filepath.Join(dir, "out")
+		countFile := filepath.Join(dir, "count")
+
+		testCfg := tests.DecodeRawToSlice(fmt.Sprintf(`[{
+			name: "myjob",
+			exec: ["sh", "-c", "trap 'echo term >> %[1]s; exit 0' TERM; while true; do sleep 0.05; done"],
+			drainGate: {
+				exec: ["sh", "-c", "n=$(($(cat %[2]s 2>/dev/null || echo 0) + 1)); echo $n > %[2]s; echo gate >> %[1]s; [ $n -ge %[3]d ]"],
+				interval: "100ms",
+				timeout: "%[4]s"
+			}
+		}]`, outFile, countFile, passOn, timeout))
+		cfgs, err := NewConfigs(testCfg, noop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bus := events.NewEventBus()
+		job := NewJob(cfgs[0])
+		job.Subscribe(bus)
+		job.Register(bus)
+		job.Run(context.Background(), make(chan struct{}, 1))
+		job.Publish(events.GlobalStartup)
+		time.Sleep(100 * time.Millisecond) // let the process start
+
+		start := time.Now()
+		bus.Publish(events.GlobalShutdown)
+		bus.Wait()
+		elapsed := time.Since(start)
+		time.Sleep(100 * time.Millisecond) // let the trap write
+		out, _ := ioutil.ReadFile(outFile)
+		return strings.Split(strings.TrimSpace(string(out)), "\n"), elapsed
+	}
+
+	t.Run("gate passes", func(t *testing.T) {
+		lines, _ := testFunc(t, 3, "5s")
+		assert.Equal(t, []string{"gate", "gate", "gate", "term"}, lines,
+			"expected the process to be stopped once the gate passed")
+	})
+	t.Run("timeout expires", func(t *testing.T) {
+		lines, elapsed := testFunc(t, 1000, "350ms")
+		assert.True(t, elapsed >= 350*time.Millisecond,
+			"expected shutdown to wait for the drain gate, took %v", elapsed)
+		assert.True(t, len(lines) >= 3, "expected the gate to be retried: %v", lines)
+		assert.Equal(t, "term", lines[len(lines)-1],
+			"expected the process to be stopped after the gate gave up")
+		assert.NotContains(t, lines[:len(lines)-1], "term")
+	})
+	t.Run("job cancelled", func(t *testing.T) {
+		// the Job's context is already done when it stops, and its
+		// process ignores SIGTERM so it doesn't exit on its own
+		testCfg := tests.DecodeRawToSlice(`[{
+			name: "myjob",
+			exec: ["sh", "-c", "trap '' TERM; while true; do sleep 0.05; done"],
+			drainGate: {exec: "false", interval: "100ms", timeout: "350ms"}
+		}]`)
+		cfgs, err := NewConfigs(testCfg, noop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bus := events.NewEventBus()
+		job := NewJob(cfgs[0])
+		job.Subscribe(bus)
+		job.Register(bus)
+		ctx, cancel := context.WithCancel(context.Background())
+		job.Run(ctx, make(chan struct{}, 1))
+		job.Publish(events.GlobalStartup)
+		time.Sleep(100 * time.Millisecond) // let the process start
+		defer job.Kill()
+
+		done := make(chan struct{})
+		go func() {
+			cancel()
+			bus.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected the drain gate to give up after its timeout")
+		}
+	})
+}
+
+// A Job's steps run in order before its exec, and the first failing step
+// stops the rest of the sequence and fails the Job
+func TestJobSteps(t *testing.T) {
+	dir, _ := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(dir)
+	outFile := filepath.Join(dir, "out")
+
+	testCfg := tests.DecodeRawToSlice(fmt.Sprintf(`[{
+		name: "myjob",
+		exec: ["sh", "-c", "echo server >> %[1]s"],
+		steps: [
+			{exec: ["sh", "-c", "echo migrate >> %[1]s"], timeout: "1s"},
+			{exec: ["sh", "-c", "echo seed >> %[1]s; exit 1"]},
+			{exec: ["sh", "-c", "echo never >> %[1]s"]}
+		]
+	}]`, outFile))
+	cfgs, err := NewConfigs(testCfg, noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := events.NewEventBus()
+	job := NewJob(cfgs[0])
+	job.Subscribe(bus)
+	job.Register(bus)
+	job.Run(context.Background(), make(chan struct{}, 1))
+	job.Publish(events.GlobalStartup)
+	bus.Wait()
+
+	out, _ := ioutil.ReadFile(outFile)
+	assert.Equal(t, "migrate\nseed\n", string(out),
+		"expected steps to stop at the first failure")
+	assert.Contains(t, bus.DebugEvents(),
+		events.Event{events.ExitFailed, "myjob"}, "expected job to fail")
+}
+
+// A Job with exitOnStartFailure shuts down ContainerPilot if its process
+// can't run on the first start, but not if it crashes later
+func TestJobExitOnStartFailure(t *testing.T) {
+	runJob := func(exec string) *Job {
+		testCfg := tests.DecodeRawToSlice(fmt.Sprintf(`[{
+			name: "myjob",
+			exec: %s,
+			restarts: 2,
+			exitOnStartFailure: true
+		}]`, exec))
+		cfgs, err := NewConfigs(testCfg, noop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bus := events.NewEventBus()
+		job := NewJob(cfgs[0])
+		job.Subscribe(bus)
+		job.Register(bus)
+		job.Run(context.Background(), make(chan struct{}, 1))
+		job.Publish(events.GlobalStartup)
+		done := make(chan struct{})
+		go func() {
+			bus.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("expected %s to exit promptly", exec)
+		}
+		return job
+	}
+
+	job := runJob(`"/bin/nonexistent-binary"`)
+	assert.Equal(t, 127, job.StartFailureCode())
+
+	dir, _ := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "marker")
+	job = runJob(fmt.Sprintf(
+		`["sh", "-c", "[ -f %s ] && exit 127; touch %s; exit 1"]`,
+		marker, marker))
+	assert.Equal(t, 0, job.StartFailureCode(),
+		"expected a later crash not to count as a start failure")
+	assert.Equal(t, 3, job.execStarts)
+}
+
+// A Job with health.failOnExit marks its service critical as soon as its
+// process exits, well before the next scheduled health check
+func TestJobFailOnExit(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[{
+		name: "myjob",
+		exec: ["sh", "-c", "sleep 0.1"],
+		port: 80,
+		interfaces: ["inet", "lo0"],
+		health: {exec: "true", interval: 10, ttl: 30, failOnExit: true}
+	}]`)
+	registry := &mocks.RegistryDiscoveryBackend{}
+	cfgs, err := NewConfigs(testCfg, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := events.NewEventBus()
+	job := NewJob(cfgs[0])
+	job.Service.SendHeartbeat() // register as passing
+	job.setStatus(statusHealthy)
+	checkID := "service:" + job.Service.ID
+	assert.Equal(t, "pass", registry.CheckStatus(checkID))
+
+	job.Subscribe(bus)
+	job.Register(bus)
+	ctx, cancel := context.WithCancel(context.Background())
+	job.Run(ctx, make(chan struct{}, 1))
+	job.Publish(events.GlobalStartup)
+	time.Sleep(500 * time.Millisecond)
+
+	assert.Equal(t, "fail", registry.CheckStatus(checkID),
+		"expected service to be critical after its process exited")
+	cancel()
+	bus.Wait()
+}
+
+// A Job whose health check exits with a code configured as success is
+// treated as healthy
+func TestJobHealthCheckSuccessCodes(t *testing.T) {
+	runCheck := func(success string) *Job {
+		testCfg := tests.DecodeRawToSlice(fmt.Sprintf(`[{
+			name: "myjob",
+			exec: "sleep 5",
+			health: {exec: ["sh", "-c", "exit 1"], interval: 10, ttl: 30 %s}
+		}]`, success))
+		cfgs, err := NewConfigs(testCfg, noop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bus := events.NewEventBus()
+		job := NewJob(cfgs[0])
+		job.Subscribe(bus)
+		job.Register(bus)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer bus.Wait()
+		defer cancel()
+		job.Run(ctx, make(chan struct{}, 1))
+		job.Publish(events.GlobalStartup)
+		time.Sleep(50 * time.Millisecond)
+		job.Publish(events.Event{events.TimerExpired, "myjob.heartbeat"})
+		time.Sleep(200 * time.Millisecond)
+		return job
+	}
+	assert.False(t, runCheck("").IsHealthy(),
+		"expected failed check to be unhealthy")
+	assert.True(t, runCheck(", success: {codes: [1]}").IsHealthy(),
+		"expected exit code 1 to be treated as healthy")
+}
+
+// A Job with several health checks is healthy only if all its critical
+// checks pass, and warning if only non-critical checks fail
+func TestJobSubChecks(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[{
+		name: "myjob",
+		exec: "sleep 5",
+		port: 80,
+		interfaces: ["inet", "lo0"],
+		health: {
+			interval: 10,
+			ttl: 30,
+			checks: [
+				{name: "db", exec: "true"},
+				{name: "cache", exec: "true", critical: false}
+			]
+		}
+	}]`)
+	cfgs, err := NewConfigs(testCfg, noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := cfgs[0].healthChecks
+	assert.Equal(t, 2, len(checks))
+	assert.Equal(t, "check.myjob.db", checks[0].exec.Name)
+	assert.True(t, checks[0].critical)
+	assert.Equal(t, "check.myjob.cache", checks[1].exec.Name)
+	assert.False(t, checks[1].critical)
+
+	testCases := []struct {
+		dbPassed    bool
+		cachePassed bool
+		expected    checkState
+		status      JobStatus
+	}{
+		{true, true, checkPassing, statusHealthy},
+		{true, false, checkWarning, statusHealthy},
+		{false, true, checkCritical, statusUnhealthy},
+		{false, false, checkCritical, statusUnhealthy},
+	}
+	exitEvent := func(name string, passed bool) events.Event {
+		if passed {
+			return events.Event{events.ExitSuccess, name}
+		}
+		return events.Event{events.ExitFailed, name}
+	}
+	for _, tc := range testCases {
+		passed := map[string]bool{
+			"check.myjob.db":    tc.dbPassed,
+			"check.myjob.cache": tc.cachePassed,
+		}
+		assert.Equal(t, tc.expected, scoreSubChecks(checks, passed),
+			"score for db=%v cache=%v", tc.dbPassed, tc.cachePassed)
+
+		bus := events.NewEventBus()
+		job := NewJob(cfgs[0])
+		job.Register(bus)
+		job.checkResults = make(map[string]bool) // a round is in flight
+		job.processEvent(nil, exitEvent("check.myjob.db", tc.dbPassed))
+		assert.Equal(t, statusIdle, job.GetStatus(),
+			"status should not change until all checks report")
+		job.processEvent(nil, exitEvent("check.myjob.cache", tc.cachePassed))
+		assert.Equal(t, tc.status, job.GetStatus(),
+			"status for db=%v cache=%v", tc.dbPassed, tc.cachePassed)
+		job.Unregister()
+	}
+}
+
+// A Job with health.warningRetries re-runs a failed non-critical check
+// before reporting a warning, so a transient failure never reaches Consul
+func TestJobWarningRetries(t *testing.T) {
+	dir, _ := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "marker")
+	testCfg := tests.DecodeRawToSlice(fmt.Sprintf(`[{
+		name: "myjob",
+		exec: "sleep 5",
+		port: 80,
+		interfaces: ["inet", "lo0"],
+		health: {
+			interval: 10,
+			ttl: 30,
+			warningRetries: 2,
+			checks: [
+				{name: "db", exec: "true"},
+				{name: "cache", critical: false,
+				 exec: ["sh", "-c", "[ -f %s ] && exit 0; touch %s; exit 1"]}
+			]
+		}
+	}]`, marker, marker))
+	registry := &mocks.RegistryDiscoveryBackend{}
+	cfgs, err := NewConfigs(testCfg, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := events.NewEventBus()
+	job := NewJob(cfgs[0])
+	job.Subscribe(bus)
+	job.Register(bus)
+	ctx, cancel := context.WithCancel(context.Background())
+	job.Run(ctx, make(chan struct{}, 1))
+	job.Publish(events.GlobalStartup)
+	time.Sleep(50 * time.Millisecond)
+	job.Publish(events.Event{events.TimerExpired, "myjob.heartbeat"})
+	time.Sleep(500 * time.Millisecond)
+	cancel()
+	bus.Wait()
+
+	checkID := "service:" + job.Service.ID
+	assert.Equal(t, []string{"pass"}, registry.CheckHistory(checkID),
+		"expected the retried check to pass without a warning")
+	assert.Equal(t, 2, job.warningsRemain,
+		"expected retries to be reset after the round was reported")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(`[{name: "myjob", exec: "sleep 5",
+		health: {exec: "true", interval: 10, ttl: 30, warningRetries: 1}}]`), noop)
+	assert.EqualError(t, err,
+		"job[myjob].health.checks must be set to use warningRetries")
+}
+
+func TestJobHealthResolver(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[{
+		name: "myjob",
+		exec: "sleep 5",
+		port: 80,
+		interfaces: ["inet", "lo0"],
+		health: {
+			exec: ["sh", "-c", "test \"$CONTAINERPILOT_CHECK_TARGET\" = 8080"],
+			interval: 10,
+			ttl: 30,
+			resolver: {exec: ["sh", "-c", "echo starting; echo 8080"]}
+		}
+	}]`)
+	registry := &mocks.RegistryDiscoveryBackend{}
+	cfgs, err := NewConfigs(testCfg, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := events.NewEventBus()
+	job := NewJob(cfgs[0])
+	job.Subscribe(bus)
+	job.Register(bus)
+	ctx, cancel := context.WithCancel(context.Background())
+	job.Run(ctx, make(chan struct{}, 1))
+	job.Publish(events.GlobalStartup)
+	time.Sleep(50 * time.Millisecond)
+	job.Publish(events.Event{events.TimerExpired, "myjob.heartbeat"})
+	time.Sleep(500 * time.Millisecond)
+	cancel()
+	bus.Wait()
+
+	assert.Equal(t, "8080", job.checkTarget)
+	checkID := "service:" + job.Service.ID
+	assert.Equal(t, []string{"pass"}, registry.CheckHistory(checkID),
+		"expected the check to pass against the resolved target")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(`[{name: "myjob", exec: "sleep 5",
+		health: {interval: 10, ttl: 30, resolver: {exec: "echo 8080"}}}]`), noop)
+	assert.EqualError(t, err,
+		"job[myjob].health.exec or health.checks must be set to use resolver")
+}
+
+// All the health checks across jobs share one limit
+func TestLimitHealthChecks(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[
+		{name: "a", exec: "sleep 5", health: {exec: "true", interval: 1, ttl: 5}},
+		{name: "b", exec: "sleep 5", health: {interval: 1, ttl: 5,
+			checks: [{exec: "true"}, {exec: "true"}]}},
+		{name: "c", exec: "sleep 5"}
+	]`)
+	cfgs, err := NewConfigs(testCfg, noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	LimitHealthChecks(cfgs, 0)
+	assert.Nil(t, cfgs[0].healthCheckExec.Limit, "expected no limit by default")
+
+	LimitHealthChecks(cfgs, 2)
+	limit := cfgs[0].healthCheckExec.Limit
+	assert.NotNil(t, limit)
+	assert.Equal(t, limit, cfgs[1].healthChecks[0].exec.Limit)
+	assert.Equal(t, limit, cfgs[1].healthChecks[1].exec.Limit)
+	assert.Nil(t, cfgs[2].exec.Limit, "expected jobs' own exec not to be limited")
+}
@@ -0,0 +1,75 @@
+package jobs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/joyent/containerpilot/discovery"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var renderSkipped *prometheus.CounterVec
+
+func init() {
+	renderSkipped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "containerpilot_render_skipped",
+		Help: "count of job runs skipped because the rendered file was unchanged, partitioned by job",
+	}, []string{"job"})
+	prometheus.MustRegister(renderSkipped)
+}
+
+// RenderConfig configures a file that's rendered from a template each
+// time a Job started by a watch runs, before its exec
+type RenderConfig struct {
+	Template      string `mapstructure:"template"`
+	Path          string `mapstructure:"path"`
+	SkipUnchanged bool   `mapstructure:"skipUnchanged"`
+}
+
+// renderer renders a template against the instances found by a watch
+type renderer struct {
+	tmpl          *template.Template
+	path          string
+	skipUnchanged bool
+	service       string
+}
+
+// write renders the template against the instances the watch's upstream
+// last found and replaces the file with the output, unless the output is
+// the same as what's already in the file. Returns true if the file was
+// changed.
+func (r *renderer) write(lister discovery.InstanceLister) (bool, error) {
+	var buf bytes.Buffer
+	err := r.tmpl.Execute(&buf, watchResult{
+		Service:   r.service,
+		Instances: lister.Instances(r.service),
+	})
+	if err != nil {
+		return false, err
+	}
+	current, err := ioutil.ReadFile(r.path)
+	if err == nil && bytes.Equal(current, buf.Bytes()) {
+		return false, nil
+	}
+	// write a temporary file and rename it into place, so that nothing
+	// reading the file sees it half-written
+	tmp, err := ioutil.TempFile(filepath.Dir(r.path), "."+filepath.Base(r.path))
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return false, err
+	}
+	return true, os.Rename(tmp.Name(), r.path)
+}
@@ -12,6 +12,7 @@ const (
 	statusMaintenance
 	statusAlwaysHealthy
 	statusCompleted
+	statusFailed
 )
 
 func (i JobStatus) String() string {
@@ -27,6 +28,8 @@ func (i JobStatus) String() string {
 		return "healthy"
 	case 6:
 		return "completed"
+	case 7:
+		return "failed"
 	default:
 		// both idle and unknown return unknown for purposes of serialization
 		return "unknown"
@@ -7,13 +7,21 @@ import (
 	"os"
 	"runtime"
 
+	"github.com/joyent/containerpilot/commands"
 	"github.com/joyent/containerpilot/core"
+	"github.com/joyent/containerpilot/subcommands"
 	"github.com/joyent/containerpilot/sup"
 	log "github.com/sirupsen/logrus"
 )
 
 // Main executes the containerpilot CLI
 func main() {
+	// When we're re-executed to harden a job's process we exec it as soon
+	// as we can, without starting anything of our own.
+	if len(os.Args) > 1 && os.Args[1] == commands.HardeningShimArg {
+		os.Exit(commands.RunHardeningShim(os.Args[2:]))
+	}
+
 	// make sure we use only a single CPU so as not to cause
 	// contention on the main application
 	runtime.GOMAXPROCS(1)
@@ -31,7 +39,8 @@ func main() {
 	if subcommand != nil {
 		err := subcommand(params)
 		if err != nil {
-			log.Fatal(err)
+			log.Error(err)
+			os.Exit(subcommands.ExitCode(err))
 		}
 		return
 	}
@@ -40,5 +49,15 @@ func main() {
 	if configErr != nil {
 		log.Fatal(configErr)
 	}
-	app.Run() // blocks forever
+	app.Run() // blocks until shutdown
+	if code := app.StartFailureCode(); code != 0 {
+		// pass along the failed process' exit code so that the reason
+		// the container exited is obvious
+		log.Errorf("exiting after job failed to start")
+		os.Exit(code)
+	}
+	if app.Breaker.IsTripped() {
+		// exit non-zero so that the orchestrator reschedules us
+		log.Fatal("exiting after too many job restarts")
+	}
 }
@@ -5,9 +5,12 @@ package subcommands
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 
 	"github.com/joyent/containerpilot/client"
+	"github.com/joyent/containerpilot/commands"
 	"github.com/joyent/containerpilot/config"
+	"github.com/joyent/containerpilot/jobs"
 )
 
 // Params ...
@@ -18,6 +21,7 @@ type Params struct {
 	ConfigPath      string
 	RenderFlag      string
 	MaintenanceFlag string
+	CheckName       string
 
 	Metrics map[string]string
 	Env     map[string]string
@@ -26,6 +30,30 @@ type Params struct {
 // Handler functions implement a subcommand
 type Handler func(Params) error
 
+// ExitError is returned by a Handler that should exit with a code
+// other than 1
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+// ExitCode returns the code ContainerPilot exits with after a Handler
+// returns the error: 0 if there's no error, the code of an ExitError, or
+// 1 for any other error
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*ExitError); ok {
+		return exitErr.Code
+	}
+	return 1
+}
+
 // VersionHandler prints the version info only
 func VersionHandler(params Params) error {
 	fmt.Printf("Version: %s\nGitHash: %s\n", params.Version, params.GitHash)
@@ -50,6 +78,18 @@ func ReloadHandler(params Params) error {
 	return nil
 }
 
+// SoftReloadHandler fires a SoftReload request through the HTTPClient.
+func SoftReloadHandler(params Params) error {
+	client, err := initClient(params.ConfigPath)
+	if err != nil {
+		return err
+	}
+	if err := client.SoftReload(); err != nil {
+		return fmt.Errorf("-softreload: failed to run subcommand: %v", err)
+	}
+	return nil
+}
+
 // MaintenanceHandler fires either an enable or disable SetMaintenance
 // request through the HTTPClient.
 func MaintenanceHandler(params Params) error {
@@ -84,8 +124,14 @@ func PutEnvHandler(params Params) error {
 }
 
 // PutMetricsHandler fires a PutMetric request through the HTTPClient.
+// When it's run by a job's process, the metrics are sent on behalf of
+// that job.
 func PutMetricsHandler(params Params) error {
-	client, err := initClient(params.ConfigPath)
+	cfg, err := config.LoadConfig(params.ConfigPath)
+	if err != nil {
+		return err
+	}
+	client, err := client.NewHTTPClient(cfg.Control.SocketPath)
 	if err != nil {
 		return err
 	}
@@ -93,7 +139,9 @@ func PutMetricsHandler(params Params) error {
 	if err != nil {
 		return err
 	}
-	if err = client.PutMetric(string(metricsJSON)); err != nil {
+	commands.SetEnvPrefix(cfg.EnvPrefix)
+	job := os.Getenv(commands.EnvVar("JOB"))
+	if err = client.PutMetric(string(metricsJSON), job); err != nil {
 		return fmt.Errorf("-reload: failed to run subcommand: %v", err)
 	}
 	return nil
@@ -112,6 +160,20 @@ func GetPingHandler(params Params) error {
 	return nil
 }
 
+// CheckHandler runs the health check of a job once and returns an error
+// if it fails, so that ContainerPilot exits with the check's exit code.
+func CheckHandler(params Params) error {
+	cfg, err := config.LoadConfig(params.ConfigPath)
+	if err != nil {
+		return err
+	}
+	code, err := jobs.RunHealthCheck(cfg.Jobs, params.CheckName)
+	if err != nil {
+		return &ExitError{Code: code, Err: fmt.Errorf("-check: %v", err)}
+	}
+	return nil
+}
+
 // loads the configuration so we can get the control socket and
 // initializes the HTTPClient which callers will use for sending
 // it commands
@@ -11,7 +11,8 @@ import (
 
 // Run forks the ContainerPilot process and then starts signal handlers
 // that will reap child processes and pass-thru SIGINT and SIGKILL to
-// the ContainerPilot worker process.
+// the ContainerPilot worker process. We exit with the worker's exit code
+// so that the container does too.
 func Run() {
 	self, err := exec.LookPath(os.Args[0])
 	if err != nil {
@@ -24,7 +25,21 @@ func Run() {
 	}
 	passThroughSignals(proc.Pid)
 	handleReaping(proc.Pid)
-	proc.Wait()
+	state, err := proc.Wait()
+	if err != nil {
+		// the reaper got to the worker first and exits for us
+		select {}
+	}
+	os.Exit(exitStatus(state.Sys().(syscall.WaitStatus)))
+}
+
+// exitStatus follows the shell convention of 128+N for a process that was
+// killed by signal N
+func exitStatus(wstatus syscall.WaitStatus) int {
+	if wstatus.Signaled() {
+		return 128 + int(wstatus.Signal())
+	}
+	return wstatus.ExitStatus()
 }
 
 // passThroughSignals listens for signals used to gracefully shutdown and
@@ -64,19 +79,23 @@ func handleReaping(pid int) {
 	go func() {
 		for {
 			<-sigRecv
-			reap()
+			reap(pid)
 		}
 	}()
 }
 
-// reaps child processes that have been reparented to PID1
-func reap() {
+// reaps child processes that have been reparented to PID1, and exits
+// with the worker's exit code if it's the worker that we reaped
+func reap(worker int) {
 	for {
 	POLL:
 		var wstatus syscall.WaitStatus
 		pid, err := syscall.Wait4(-1, &wstatus, 0, nil)
 		switch err {
 		case nil:
+			if pid == worker {
+				os.Exit(exitStatus(wstatus))
+			}
 			if pid > 0 {
 				goto POLL
 			}
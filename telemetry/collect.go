@@ -0,0 +1,69 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// collection runs the sensors of the Metrics on each scrape. Scrapes that
+// arrive while the sensors are already running wait for them and share
+// their results, rather than running them again at the same time.
+type collection struct {
+	metrics []*Metric
+
+	lock    sync.Mutex
+	running *pass // the pass that's running, or nil
+}
+
+// pass is one run of the sensors
+type pass struct {
+	done   chan struct{} // closed when the pass is done
+	values map[string]float64
+}
+
+// do runs the sensors once, or waits for the pass that's running, and
+// returns the values the sensors recorded by the name of their Metric.
+// Sensors that failed are left out.
+func (c *collection) do() map[string]float64 {
+	c.lock.Lock()
+	if running := c.running; running != nil {
+		c.lock.Unlock()
+		<-running.done
+		return running.values
+	}
+	p := &pass{done: make(chan struct{}), values: map[string]float64{}}
+	c.running = p
+	c.lock.Unlock()
+
+	// the pass is shared, so one scrape giving up mustn't cancel it for
+	// the others; each sensor has its own timeout
+	var wg sync.WaitGroup
+	var valuesLock sync.Mutex
+	for _, metric := range c.metrics {
+		wg.Add(1)
+		go func(metric *Metric) {
+			defer wg.Done()
+			if val, ok := metric.collect(context.Background()); ok {
+				valuesLock.Lock()
+				p.values[metric.Name] = val
+				valuesLock.Unlock()
+			}
+		}(metric)
+	}
+	wg.Wait()
+
+	c.lock.Lock()
+	c.running = nil
+	c.lock.Unlock()
+	close(p.done)
+	return p.values
+}
+
+// collectingHandler runs the sensors before each request is served
+func (c *collection) collectingHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.do()
+		next.ServeHTTP(w, r)
+	})
+}
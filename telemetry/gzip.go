@@ -0,0 +1,77 @@
+package telemetry
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// GzipHandler wraps an http.Handler and compresses its responses for
+// clients that send an 'Accept-Encoding: gzip' header. Responses are
+// passed through unmodified for all other clients.
+type GzipHandler struct {
+	handler http.Handler
+}
+
+// NewGzipHandler constructs a GzipHandler around the given handler
+func NewGzipHandler(handler http.Handler) GzipHandler {
+	return GzipHandler{handler: handler}
+}
+
+// ServeHTTP implements http.Handler for GzipHandler
+func (gh GzipHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !acceptsGzip(r) {
+		gh.handler.ServeHTTP(w, r)
+		return
+	}
+	// the wrapped handler may know how to compress its own responses,
+	// so hide the header from it to avoid compressing twice
+	req := new(http.Request)
+	*req = *r
+	req.Header = http.Header{}
+	for k, v := range r.Header {
+		req.Header[k] = v
+	}
+	req.Header.Del("Accept-Encoding")
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Add("Vary", "Accept-Encoding")
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	gh.handler.ServeHTTP(gzipResponseWriter{Writer: gz, ResponseWriter: w}, req)
+}
+
+// gzipResponseWriter sends the body through the gzip.Writer while
+// passing headers thru to the underlying http.ResponseWriter
+type gzipResponseWriter struct {
+	io.Writer
+	http.ResponseWriter
+}
+
+func (w gzipResponseWriter) WriteHeader(code int) {
+	// the wrapped handler's Content-Length is for the uncompressed body
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w gzipResponseWriter) Write(b []byte) (int, error) {
+	w.Header().Del("Content-Length")
+	return w.Writer.Write(b)
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, header := range r.Header["Accept-Encoding"] {
+		for _, encoding := range strings.Split(header, ",") {
+			parts := strings.Split(encoding, ";")
+			if strings.TrimSpace(parts[0]) != "gzip" {
+				continue
+			}
+			if len(parts) > 1 && strings.Replace(parts[1], " ", "", -1) == "q=0" {
+				return false
+			}
+			return true
+		}
+	}
+	return false
+}
@@ -5,6 +5,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/joyent/containerpilot/commands"
 	"github.com/joyent/containerpilot/events"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
@@ -29,7 +30,11 @@ const (
 type Metric struct {
 	Name      string
 	Type      MetricType
+	eventName string // the name values are sent to the metric with
+	job       string // the only job whose values are recorded, if set
 	collector prometheus.Collector
+	labeled   prometheus.Collector // labeled by job, if set
+	sensor    *commands.Command
 
 	events.Subscriber
 }
@@ -39,12 +44,20 @@ func NewMetric(cfg *MetricConfig) *Metric {
 	metric := &Metric{
 		Name:      cfg.fullName,
 		Type:      cfg.metricType,
+		eventName: cfg.eventName,
+		job:       cfg.Job,
 		collector: cfg.collector,
+		labeled:   cfg.labeled,
+		sensor:    cfg.sensor,
 	}
 	metric.Rx = make(chan events.Event, eventBufferSize)
+	metric.Subscriber.Name = "metric." + metric.Name
 	return metric
 }
 
+// processMetric records a measurement published as "name|value", or as
+// "name|value|job" when it was sent by a job's process. A Metric for a
+// job only records the measurements sent by that job.
 func (metric *Metric) processMetric(event string) {
 	measurement := strings.Split(event, "|")
 	if len(measurement) < 2 {
@@ -53,30 +66,72 @@ func (metric *Metric) processMetric(event string) {
 	}
 	metricKey := measurement[0]
 	metricVal := measurement[1]
-	if metric.Name == metricKey {
-		metric.record(metricVal)
+	job := ""
+	if len(measurement) > 2 {
+		job = measurement[2]
+	}
+	if metric.eventName == metricKey && (metric.job == "" || metric.job == job) {
+		metric.record(metricVal, job)
 	}
 }
 
-func (metric *Metric) record(metricValue string) {
-	if val, err := strconv.ParseFloat(
-		strings.TrimSpace(metricValue), 64); err != nil {
+// record records the value. If the Metric is labeled by job, the value
+// is also recorded in the labeled series for the job, or in the series
+// with an empty job label if the job is empty.
+func (metric *Metric) record(metricValue, job string) {
+	val, err := strconv.ParseFloat(strings.TrimSpace(metricValue), 64)
+	if err != nil {
 		log.Errorf("metric produced non-numeric value: %v: %v", metricValue, err)
-	} else {
-		// we should use a type switch here but the prometheus collector
-		// implementations are themselves interfaces and not structs,
-		// so that doesn't work.
-		switch metric.Type {
-		case Counter:
-			metric.collector.(prometheus.Counter).Add(val)
-		case Gauge:
-			metric.collector.(prometheus.Gauge).Set(val)
-		case Histogram:
-			metric.collector.(prometheus.Histogram).Observe(val)
-		case Summary:
-			metric.collector.(prometheus.Summary).Observe(val)
-		}
+		return
+	}
+	metric.observe(val, job)
+}
+
+func (metric *Metric) observe(val float64, job string) {
+	// the flat collector implementations are unexported structs behind
+	// interfaces, so we can't switch on their types and use the
+	// configured type instead
+	switch metric.Type {
+	case Counter:
+		metric.collector.(prometheus.Counter).Add(val)
+	case Gauge:
+		metric.collector.(prometheus.Gauge).Set(val)
+	case Histogram:
+		metric.collector.(prometheus.Histogram).Observe(val)
+	case Summary:
+		metric.collector.(prometheus.Summary).Observe(val)
+	}
+	switch collector := metric.labeled.(type) {
+	case *prometheus.CounterVec:
+		collector.WithLabelValues(job).Add(val)
+	case *prometheus.GaugeVec:
+		collector.WithLabelValues(job).Set(val)
+	case *prometheus.HistogramVec:
+		collector.WithLabelValues(job).Observe(val)
+	case *prometheus.SummaryVec:
+		collector.WithLabelValues(job).Observe(val)
+	}
+}
+
+// collect runs the Metric's sensor, if it has one, and records the value
+// it writes to stdout. Returns the value, or false if there's no sensor
+// or it failed.
+func (metric *Metric) collect(ctx context.Context) (float64, bool) {
+	if metric.sensor == nil {
+		return 0, false
+	}
+	out, err := metric.sensor.RunAndCapture(ctx)
+	if err != nil {
+		log.Errorf("metric: sensor for %s failed: %v", metric.Name, err)
+		return 0, false
+	}
+	val, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		log.Errorf("metric produced non-numeric value: %s: %v", out, err)
+		return 0, false
 	}
+	metric.observe(val, metric.job)
+	return val, true
 }
 
 // Run executes the event loop for the Metric
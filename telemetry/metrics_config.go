@@ -2,10 +2,15 @@ package telemetry
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
+	"time"
 
+	"github.com/joyent/containerpilot/commands"
 	"github.com/joyent/containerpilot/config/decode"
+	"github.com/joyent/containerpilot/config/timing"
 	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
 )
 
 // A MetricConfig is a single measurement of the application.
@@ -15,18 +20,70 @@ type MetricConfig struct {
 	Name      string `mapstructure:"name"`
 	Help      string `mapstructure:"help"` // help string returned by API
 	Type      string `mapstructure:"type"`
+	JobLabel  bool   `mapstructure:"jobLabel"`
 
-	fullName   string // combined name
+	// the job whose values are recorded, and whether the job's name is
+	// prefixed to the metric's
+	Job       string `mapstructure:"job"`
+	prefixJob bool
+
+	// sensor run to collect the value on each scrape
+	Exec    interface{} `mapstructure:"exec"`
+	Timeout string      `mapstructure:"timeout"`
+	sensor  *commands.Command
+
+	fullName   string // combined name, as registered
+	eventName  string // combined name, as sent to the metric
 	metricType MetricType
 	collector  prometheus.Collector
+	labeled    prometheus.Collector // labeled by job, if JobLabel is set
 }
 
+// how long a sensor may run, unless its timeout is set
+const defaultSensorTimeout = 5 * time.Second
+
+// the ways metrics are named, as set by the telemetry metricNames field
+const (
+	metricNamesExact     = "exact"     // as configured
+	metricNamesPrefixJob = "prefixJob" // prefixed by the metric's job
+)
+
+// jobLabelSuffix is appended to the name of a metric's series labeled by
+// job, to keep them apart from its flat series
+const jobLabelSuffix = "_by_job"
+
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
 // NewMetricConfigs creates new metrics from a raw config
 func NewMetricConfigs(raw []interface{}) ([]*MetricConfig, error) {
+	return newMetricConfigs(raw, false)
+}
+
+// newMetricConfigs creates new metrics from a raw config, prefixing the
+// names of those with a job if prefixJob is set. Metrics that would be
+// registered under the same name are an error, because registering one
+// would silently replace the other.
+func newMetricConfigs(raw []interface{}, prefixJob bool) ([]*MetricConfig, error) {
 	var metrics []*MetricConfig
 	if err := decode.ToStruct(raw, &metrics); err != nil {
 		return nil, fmt.Errorf("MetricConfig configuration error: %v", err)
 	}
+	seen := map[string]*MetricConfig{}
+	for _, metric := range metrics {
+		metric.prefixJob = prefixJob
+		name := prometheus.BuildFQName(
+			metric.namespace(), metric.Subsystem, metric.Name)
+		names := []string{name}
+		if metric.JobLabel {
+			names = append(names, name+jobLabelSuffix)
+		}
+		for _, name := range names {
+			if other, ok := seen[name]; ok {
+				return nil, metricCollisionError(name, other, metric)
+			}
+			seen[name] = metric
+		}
+	}
 	for _, metric := range metrics {
 		if err := metric.Validate(); err != nil {
 			return metrics, err
@@ -35,52 +92,139 @@ func NewMetricConfigs(raw []interface{}) ([]*MetricConfig, error) {
 	return metrics, nil
 }
 
+func metricCollisionError(name string, a, b *MetricConfig) error {
+	if a.Job != "" && b.Job != "" && a.Job != b.Job && !a.prefixJob {
+		return fmt.Errorf("metric[%s] is defined more than once, for jobs "+
+			"'%s' and '%s'; set telemetry.metricNames to '%s' to keep "+
+			"them apart", name, a.Job, b.Job, metricNamesPrefixJob)
+	}
+	return fmt.Errorf("metric[%s] is defined more than once", name)
+}
+
+// namespace is the Prometheus namespace the metric is registered under:
+// its own, after the name of its job if that's prefixed
+func (cfg *MetricConfig) namespace() string {
+	if !cfg.prefixJob || cfg.Job == "" {
+		return cfg.Namespace
+	}
+	prefix := invalidNameChars.ReplaceAllString(cfg.Job, "_")
+	if prefix[0] >= '0' && prefix[0] <= '9' {
+		prefix = "_" + prefix
+	}
+	if cfg.Namespace == "" {
+		return prefix
+	}
+	return prefix + "_" + cfg.Namespace
+}
+
 // Validate ensures Metric meets all requirements
 func (cfg *MetricConfig) Validate() error {
 
-	cfg.fullName = strings.Join([]string{cfg.Namespace, cfg.Subsystem, cfg.Name}, "_")
+	cfg.eventName = strings.Join([]string{cfg.Namespace, cfg.Subsystem, cfg.Name}, "_")
+	cfg.fullName = strings.Join([]string{cfg.namespace(), cfg.Subsystem, cfg.Name}, "_")
+	if err := cfg.validateSensor(); err != nil {
+		return err
+	}
 
 	// the prometheus client lib's API here is baffling... they don't expose
 	// an interface or embed their Opts type in each of the Opts "subtypes",
-	// so we can't share the initialization.
+	// so we can't share the initialization. A metric with a job label
+	// keeps its flat collector for compatibility, and records each job's
+	// values under its own name with jobLabelSuffix, because Prometheus
+	// won't mix labeled and unlabeled series under one name.
+	labels := []string{"job"}
+	labeledName := cfg.Name + jobLabelSuffix
 	switch cfg.Type {
 	case "counter":
 		cfg.metricType = Counter
-		cfg.collector = prometheus.NewCounter(prometheus.CounterOpts{
-			Namespace: cfg.Namespace,
+		opts := prometheus.CounterOpts{
+			Namespace: cfg.namespace(),
 			Subsystem: cfg.Subsystem,
 			Name:      cfg.Name,
 			Help:      cfg.Help,
-		})
+		}
+		cfg.collector = prometheus.NewCounter(opts)
+		if cfg.JobLabel {
+			opts.Name = labeledName
+			cfg.labeled = prometheus.NewCounterVec(opts, labels)
+		}
 	case "gauge":
 		cfg.metricType = Gauge
-		cfg.collector = prometheus.NewGauge(prometheus.GaugeOpts{
-			Namespace: cfg.Namespace,
+		opts := prometheus.GaugeOpts{
+			Namespace: cfg.namespace(),
 			Subsystem: cfg.Subsystem,
 			Name:      cfg.Name,
 			Help:      cfg.Help,
-		})
+		}
+		cfg.collector = prometheus.NewGauge(opts)
+		if cfg.JobLabel {
+			opts.Name = labeledName
+			cfg.labeled = prometheus.NewGaugeVec(opts, labels)
+		}
 	case "histogram":
 		cfg.metricType = Histogram
-		cfg.collector = prometheus.NewHistogram(prometheus.HistogramOpts{
-			Namespace: cfg.Namespace,
+		opts := prometheus.HistogramOpts{
+			Namespace: cfg.namespace(),
 			Subsystem: cfg.Subsystem,
 			Name:      cfg.Name,
 			Help:      cfg.Help,
-		})
+		}
+		cfg.collector = prometheus.NewHistogram(opts)
+		if cfg.JobLabel {
+			opts.Name = labeledName
+			cfg.labeled = prometheus.NewHistogramVec(opts, labels)
+		}
 	case "summary":
 		cfg.metricType = Summary
-		cfg.collector = prometheus.NewSummary(prometheus.SummaryOpts{
-			Namespace: cfg.Namespace,
+		opts := prometheus.SummaryOpts{
+			Namespace: cfg.namespace(),
 			Subsystem: cfg.Subsystem,
 			Name:      cfg.Name,
 			Help:      cfg.Help,
-		})
+		}
+		cfg.collector = prometheus.NewSummary(opts)
+		if cfg.JobLabel {
+			opts.Name = labeledName
+			cfg.labeled = prometheus.NewSummaryVec(opts, labels)
+		}
 	default:
 		return fmt.Errorf("invalid metric type: %s", cfg.Type)
 	}
 	// we're going to unregister before every attempt to register
 	// so that we can reload config
 	prometheus.Unregister(cfg.collector)
-	return prometheus.Register(cfg.collector)
+	if err := prometheus.Register(cfg.collector); err != nil {
+		return err
+	}
+	if cfg.labeled != nil {
+		prometheus.Unregister(cfg.labeled)
+		return prometheus.Register(cfg.labeled)
+	}
+	return nil
+}
+
+func (cfg *MetricConfig) validateSensor() error {
+	if cfg.Exec == nil {
+		return nil
+	}
+	timeout := defaultSensorTimeout
+	if cfg.Timeout != "" {
+		var err error
+		timeout, err = timing.GetTimeout(cfg.Timeout)
+		if err != nil {
+			return fmt.Errorf("unable to parse metric[%s].timeout '%s': %v",
+				cfg.fullName, cfg.Timeout, err)
+		}
+		if timeout <= 0 {
+			return fmt.Errorf("metric[%s].timeout must be > 0", cfg.fullName)
+		}
+	}
+	sensor, err := commands.NewCommand(cfg.Exec, timeout,
+		log.Fields{"metric": cfg.fullName})
+	if err != nil {
+		return fmt.Errorf("unable to create metric[%s].exec: %v", cfg.fullName, err)
+	}
+	sensor.Name = "sensor." + cfg.fullName
+	cfg.sensor = sensor
+	return nil
 }
@@ -15,6 +15,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/tests"
 )
 
 /*
@@ -58,6 +59,117 @@ func TestMetricRun(t *testing.T) {
 		"failed to get match for metric in response")
 }
 
+func TestMetricJobLabel(t *testing.T) {
+	testServer := httptest.NewServer(prometheus.UninstrumentedHandler())
+	defer testServer.Close()
+	cfg := &MetricConfig{
+		Namespace: "telemetry",
+		Subsystem: "metrics",
+		Name:      "TestMetricJobLabel",
+		Help:      "help",
+		Type:      "gauge",
+		JobLabel:  true,
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	metric := NewMetric(cfg)
+
+	// the same sensor sent by two jobs, and once outside of any job
+	metric.processMetric(metric.Name + "|12|worker-a")
+	metric.processMetric(metric.Name + "|3|worker-b")
+	metric.processMetric(metric.Name + "|7")
+	resp := getFromTestServer(t, testServer)
+	assert.Equal(t, 1, strings.Count(resp,
+		`telemetry_metrics_TestMetricJobLabel_by_job{job="worker-a"} 12`))
+	assert.Equal(t, 1, strings.Count(resp,
+		`telemetry_metrics_TestMetricJobLabel_by_job{job="worker-b"} 3`))
+	assert.Equal(t, 1, strings.Count(resp,
+		`telemetry_metrics_TestMetricJobLabel_by_job{job=""} 7`))
+
+	// the flat series is kept alongside the labeled ones
+	assert.Equal(t, 1, strings.Count(resp, "telemetry_metrics_TestMetricJobLabel 7"))
+
+	// without the label the job is ignored
+	cfg = &MetricConfig{
+		Namespace: "telemetry",
+		Subsystem: "metrics",
+		Name:      "TestMetricJobLabelFlat",
+		Help:      "help",
+		Type:      "counter",
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	metric = NewMetric(cfg)
+	metric.processMetric(metric.Name + "|12|worker-a")
+	metric.processMetric(metric.Name + "|3|worker-b")
+	resp = getFromTestServer(t, testServer)
+	assert.Equal(t, 1, strings.Count(resp, "telemetry_metrics_TestMetricJobLabelFlat 15"))
+}
+
+func TestMetricJobNamespacing(t *testing.T) {
+	testServer := httptest.NewServer(prometheus.UninstrumentedHandler())
+	defer testServer.Close()
+	fragment := `[{
+	namespace: "telemetry",
+	subsystem: "metrics",
+	name: "%[1]s",
+	help: "help",
+	type: "gauge",
+	job: "worker-a"
+}, {
+	namespace: "telemetry",
+	subsystem: "metrics",
+	name: "%[1]s",
+	help: "help",
+	type: "gauge",
+	job: "worker-b"
+}]`
+
+	// the same sensor name on two jobs collides unless it's prefixed
+	_, err := newMetricConfigs(tests.DecodeRawToSlice(
+		fmt.Sprintf(fragment, "TestMetricJobNamespacingExact")), false)
+	assert.EqualError(t, err, "metric[telemetry_metrics_TestMetricJobNamespacingExact] "+
+		"is defined more than once, for jobs 'worker-a' and 'worker-b'; "+
+		"set telemetry.metricNames to 'prefixJob' to keep them apart")
+
+	// a metric labeled by job also takes the name of its labeled series
+	_, err = newMetricConfigs(tests.DecodeRawToSlice(`[{
+	namespace: "telemetry",
+	subsystem: "metrics",
+	name: "TestMetricJobNamespacing",
+	help: "help",
+	type: "gauge",
+	jobLabel: true
+}, {
+	namespace: "telemetry",
+	subsystem: "metrics",
+	name: "TestMetricJobNamespacing_by_job",
+	help: "help",
+	type: "gauge"
+}]`), false)
+	assert.EqualError(t, err, "metric[telemetry_metrics_TestMetricJobNamespacing_by_job] "+
+		"is defined more than once")
+
+	cfgs, err := newMetricConfigs(tests.DecodeRawToSlice(
+		fmt.Sprintf(fragment, "TestMetricJobNamespacing")), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := []*Metric{NewMetric(cfgs[0]), NewMetric(cfgs[1])}
+	for _, metric := range metrics {
+		metric.processMetric("telemetry_metrics_TestMetricJobNamespacing|12|worker-a")
+		metric.processMetric("telemetry_metrics_TestMetricJobNamespacing|3|worker-b")
+		metric.processMetric("telemetry_metrics_TestMetricJobNamespacing|7")
+	}
+	resp := getFromTestServer(t, testServer)
+	assert.Equal(t, 1, strings.Count(resp,
+		"worker_a_telemetry_metrics_TestMetricJobNamespacing 12"))
+	assert.Equal(t, 1, strings.Count(resp,
+		"worker_b_telemetry_metrics_TestMetricJobNamespacing 3"))
+}
+
 // TestMetricProcessMetric covers the same ground as the 4 collector-
 // specific tests below, but checks the unhappy path.
 func TestMetricProcessMetric(t *testing.T) {
@@ -118,7 +230,7 @@ func TestMetricRecordCounter(t *testing.T) {
 		})}
 	prometheus.MustRegister(metric.collector)
 	testFunc := func(input, expected string) bool {
-		metric.record(input)
+		metric.record(input, "")
 		resp := getFromTestServer(t, testServer)
 		return strings.Count(resp, expected) == 1
 	}
@@ -148,7 +260,7 @@ func TestMetricRecordGauge(t *testing.T) {
 	prometheus.MustRegister(metric.collector)
 
 	testFunc := func(input, expected string) bool {
-		metric.record(input)
+		metric.record(input, "")
 		resp := getFromTestServer(t, testServer)
 		return strings.Count(resp, expected) == 1
 	}
@@ -180,7 +292,7 @@ func TestMetricRecordHistogram(t *testing.T) {
 	patt := `telemetry_metrics_TestMetricRecordHistogram_bucket{le="([\.0-9|\+Inf]*)"} ([1-9])`
 
 	testFunc := func(input string, expected [][]string) bool {
-		metric.record(input)
+		metric.record(input, "")
 		resp := getFromTestServer(t, testServer)
 		return checkBuckets(resp, patt, expected)
 	}
@@ -218,7 +330,7 @@ func TestMetricRecordSummary(t *testing.T) {
 	t.Run("record ok", func(t *testing.T) {
 		// need a bunch of metrics to make quantiles make any sense
 		for i := 1; i <= 10; i++ {
-			metric.record(fmt.Sprintf("%v", i))
+			metric.record(fmt.Sprintf("%v", i), "")
 		}
 		resp := getFromTestServer(t, testServer)
 		expected := [][]string{{"0.5", "5"}, {"0.9", "9"}, {"0.99", "10"}}
@@ -228,7 +340,7 @@ func TestMetricRecordSummary(t *testing.T) {
 	t.Run("record update", func(t *testing.T) {
 		for i := 1; i <= 5; i++ {
 			// add a new record for each one in the bottom half
-			metric.record(fmt.Sprintf("%v", i))
+			metric.record(fmt.Sprintf("%v", i), "")
 		}
 		resp := getFromTestServer(t, testServer)
 		expected := [][]string{{"0.5", "4"}, {"0.9", "9"}, {"0.99", "10"}}
@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/joyent/containerpilot/commands"
 	"github.com/joyent/containerpilot/jobs"
 	"github.com/joyent/containerpilot/watches"
 )
@@ -18,11 +21,19 @@ type Status struct {
 	Jobs     []*jobStatusResponse
 	Services []*serviceStatusResponse
 	Watches  []string
+
+	// the streak of each command that has run, by name
+	Commands map[string]commands.Streak
+
+	// guards the fields above, which requests update and a soft reload
+	// replaces while other requests read them
+	lock sync.Mutex
 }
 
 type jobStatusResponse struct {
 	Name   string
 	Status string
+	lifecycleResponse
 }
 
 type serviceStatusResponse struct {
@@ -30,6 +41,27 @@ type serviceStatusResponse struct {
 	Address string
 	Port    int
 	Status  string
+	lifecycleResponse
+}
+
+// lifecycleResponse reports when a job's process last started and
+// stopped, and how many seconds it's been running if it's running now
+type lifecycleResponse struct {
+	LastStart *time.Time `json:",omitempty"`
+	LastStop  *time.Time `json:",omitempty"`
+	Uptime    float64
+}
+
+func (resp *lifecycleResponse) update(job *jobs.Job) {
+	lastStart, lastStop, uptime := job.GetLifecycle()
+	resp.LastStart, resp.LastStop = nil, nil
+	if !lastStart.IsZero() {
+		resp.LastStart = &lastStart
+	}
+	if !lastStop.IsZero() {
+		resp.LastStop = &lastStop
+	}
+	resp.Uptime = uptime.Seconds()
 }
 
 // StatusHandler implements http.Handler
@@ -50,19 +82,24 @@ func (sh StatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, http.StatusText(failedStatus), failedStatus)
 		return
 	}
+	sh.telem.Status.lock.Lock()
+	defer sh.telem.Status.lock.Unlock()
 	for _, job := range sh.telem.Status.jobs {
 		status := fmt.Sprintf("%s", job.GetStatus())
 		for _, service := range sh.telem.Status.Services {
 			if service.Name == job.Name {
 				service.Status = status
+				service.update(job)
 			}
 		}
 		for _, jobStatus := range sh.telem.Status.Jobs {
 			if jobStatus.Name == job.Name {
 				jobStatus.Status = status
+				jobStatus.update(job)
 			}
 		}
 	}
+	sh.telem.Status.Commands = commands.Streaks()
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(sh.telem.Status)
@@ -71,6 +108,8 @@ func (sh StatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // MonitorJobs adds a list of Jobs for the /status handler to monitor
 func (t *Telemetry) MonitorJobs(jobs []*jobs.Job) {
 	if t != nil {
+		t.Status.lock.Lock()
+		defer t.Status.lock.Unlock()
 		for _, job := range jobs {
 			t.Status.jobs = append(t.Status.jobs, job)
 			if job.Service != nil && job.Service.Port != 0 {
@@ -92,15 +131,19 @@ func (t *Telemetry) MonitorJobs(jobs []*jobs.Job) {
 	}
 }
 
-// MonitorWatches adds a list of Watches for the /status handler to monitor
+// MonitorWatches sets the list of Watches for the /status handler to
+// monitor, replacing any from before a soft reload
 func (t *Telemetry) MonitorWatches(watches []*watches.Watch) {
 
-	// these watch names are cached forever because they don't change
-	// unless we reload ContainerPilot itself (and this server)
+	// these watch names are cached because they don't change unless we
+	// reload ContainerPilot or its watches
 	if t != nil {
+		t.Status.lock.Lock()
+		defer t.Status.lock.Unlock()
+		names := make([]string, 0, len(watches))
 		for _, watch := range watches {
-			name := strings.TrimPrefix(watch.Name, "watch.")
-			t.Status.Watches = append(t.Status.Watches, name)
+			names = append(names, strings.TrimPrefix(watch.Name, "watch."))
 		}
+		t.Status.Watches = names
 	}
 }
@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/joyent/containerpilot/events"
 	"github.com/joyent/containerpilot/jobs"
 	"github.com/joyent/containerpilot/tests"
 	"github.com/joyent/containerpilot/tests/mocks"
@@ -121,3 +123,87 @@ func TestStatusServerGet(t *testing.T) {
 	assert.Equal(t, "myjob3", out.Jobs[1].Name)
 	assert.Equal(t, "unknown", out.Jobs[1].Status, "unexpected job status")
 }
+
+func TestStatusServerJobLifecycle(t *testing.T) {
+	jobCfgs, err := jobs.NewConfigs(
+		tests.DecodeRawToSlice(`[{name: "myjob", exec: "sleep 10"}]`),
+		&mocks.NoopDiscoveryBackend{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := jobs.FromConfigs(jobCfgs)[0]
+	cfg := &Config{Port: 9094, Interfaces: []interface{}{"lo", "lo0", "inet"}}
+	cfg.Validate(&mocks.NoopDiscoveryBackend{})
+	telem := NewTelemetry(cfg)
+	telem.MonitorJobs([]*jobs.Job{job})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer telem.Stop(ctx)
+	telem.Run(ctx)
+
+	bus := events.NewEventBus()
+	job.Subscribe(bus)
+	job.Register(bus)
+	job.Run(ctx, make(chan struct{}, 1))
+	defer func() {
+		// cancelling the context stops the job, which unregisters
+		// from the bus once its process has exited
+		cancel()
+		bus.Wait()
+	}()
+	bus.Publish(events.GlobalStartup)
+	time.Sleep(100 * time.Millisecond)
+
+	url := fmt.Sprintf("http://%v:%v/status", telem.addr.IP, telem.addr.Port)
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("could not connect to status endpoint: %v", err)
+	}
+	defer resp.Body.Close()
+	var out Status
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !assert.Len(t, out.Jobs, 1) {
+		return
+	}
+	status := out.Jobs[0]
+	if assert.NotNil(t, status.LastStart, "expected a start time") {
+		assert.WithinDuration(t, time.Now(), *status.LastStart, 5*time.Second)
+	}
+	assert.Nil(t, status.LastStop, "job should not have stopped")
+	assert.True(t, status.Uptime > 0, "expected nonzero uptime")
+}
+
+// a soft reload replaces the watches while requests are being served
+func TestStatusServerMonitorWatchesConcurrent(t *testing.T) {
+	cfg := &Config{Port: 9095, Interfaces: []interface{}{"lo", "lo0", "inet"}}
+	cfg.Validate(&mocks.NoopDiscoveryBackend{})
+	telem := NewTelemetry(cfg)
+	ctx := context.Background()
+	defer telem.Stop(ctx)
+	telem.Run(ctx)
+
+	watchCfgs, err := watches.NewConfigs(
+		tests.DecodeRawToSlice(`[{name: "watch1", interval: 1}]`),
+		&mocks.NoopDiscoveryBackend{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			telem.MonitorWatches(watches.FromConfigs(watchCfgs))
+		}
+	}()
+	url := fmt.Sprintf("http://%v:%v/status", telem.addr.IP, telem.addr.Port)
+	for i := 0; i < 20; i++ {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatalf("could not connect to status endpoint: %v", err)
+		}
+		resp.Body.Close()
+	}
+	<-done
+}
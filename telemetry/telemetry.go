@@ -4,25 +4,41 @@ package telemetry
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
 	"net/http"
+	"runtime"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/joyent/containerpilot/commands"
 	"github.com/joyent/containerpilot/version"
 )
 
+var buildInfo *prometheus.GaugeVec
+
+func init() {
+	buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "containerpilot_build_info",
+		Help: "always 1, labeled with the version, commit, and Go version ContainerPilot was built with",
+	}, []string{"version", "commit", "go"})
+	prometheus.MustRegister(buildInfo)
+}
+
 // Telemetry represents the service to advertise for finding the metrics
 // endpoint, and the collection of Metrics.
 type Telemetry struct {
-	Metrics []*Metric // supports '/metrics' endpoint fields
+	Metrics []*Metric // supports the metrics endpoint fields
 	Status  *Status   // supports '/status' endpoint fields
 
+	sensors *collection // the Metrics that run a sensor
+
 	// server
 	router *http.ServeMux
 	addr   net.TCPAddr
+	certs  *certLoader // serves HTTPS if set
 
 	http.Server
 }
@@ -37,20 +53,43 @@ func NewTelemetry(cfg *Config) *Telemetry {
 		Status:  &Status{Version: version.Version},
 	}
 	t.addr = cfg.addr
+	t.certs = cfg.certs
 
-	router := http.NewServeMux()
-	router.Handle("/metrics", prometheus.Handler())
-	router.Handle("/status", NewStatusHandler(t))
-	t.Handler = router
+	// the version is set at build time, so we set this here rather than
+	// at init so that it reflects the values we report elsewhere
+	buildInfo.Reset()
+	buildInfo.WithLabelValues(
+		version.Version, version.GitHash, runtime.Version()).Set(1)
+	commands.EnableMetrics(cfg.EnvLabels)
 
+	t.sensors = &collection{}
 	for _, sensorCfg := range cfg.MetricConfigs {
 		sensor := NewMetric(sensorCfg)
 		t.Metrics = append(t.Metrics, sensor)
+		if sensor.sensor != nil {
+			t.sensors.metrics = append(t.sensors.metrics, sensor)
+		}
 	}
 
+	var metricsHandler http.Handler = prometheus.Handler()
+	if len(t.sensors.metrics) > 0 {
+		metricsHandler = t.sensors.collectingHandler(metricsHandler)
+	}
+	router := http.NewServeMux()
+	router.Handle(cfg.Path, NewGzipHandler(metricsHandler))
+	router.Handle("/status", NewStatusHandler(t))
+	t.Handler = router
+
 	return t
 }
 
+// Collect runs the sensors of the Metrics now, or waits for the run
+// that's already in progress for a scrape, and returns the values they
+// recorded by metric name. Sensors that failed are left out.
+func (t *Telemetry) Collect() map[string]float64 {
+	return t.sensors.do()
+}
+
 // Run executes the event loop for the telemetry server
 func (t *Telemetry) Run(ctx context.Context) {
 	t.Start()
@@ -64,6 +103,9 @@ func (t *Telemetry) Run(ctx context.Context) {
 // Start starts serving the telemetry service
 func (t *Telemetry) Start() {
 	ln := t.listenWithRetry()
+	if t.certs != nil {
+		ln = tls.NewListener(ln, t.certs.tlsConfig())
+	}
 	go func() {
 		log.Infof("telemetry: serving at %s", t.addr.String())
 		t.Serve(ln)
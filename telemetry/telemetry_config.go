@@ -3,6 +3,8 @@ package telemetry
 import (
 	"fmt"
 	"net"
+	"regexp"
+	"strings"
 
 	"github.com/joyent/containerpilot/config/decode"
 	"github.com/joyent/containerpilot/config/services"
@@ -18,11 +20,18 @@ type Config struct {
 	Interfaces []interface{} `mapstructure:"interfaces"` // optional override
 	Tags       []string      `mapstructure:"tags"`
 	Metrics    []interface{} `mapstructure:"metrics"`
+	EnvLabels  []string      `mapstructure:"envLabels"`
+	Path       string        `mapstructure:"path"`
+	TLS        *TLSConfig    `mapstructure:"tls"`
+
+	// how the metrics for jobs are named
+	MetricNames string `mapstructure:"metricNames"`
 
 	// derived in Validate
 	MetricConfigs []*MetricConfig
 	JobConfig     *jobs.Config
 	addr          net.TCPAddr
+	certs         *certLoader
 }
 
 // NewConfig parses json config into a validated Config
@@ -42,7 +51,8 @@ func NewConfig(raw interface{}, disc discovery.Backend) (*Config, error) {
 		// note that we don't return an error if there are no metrics
 		// because the prometheus handler will still pick up metrics
 		// internal to ContainerPilot (i.e. the golang runtime)
-		metrics, err := NewMetricConfigs(cfg.Metrics)
+		metrics, err := newMetricConfigs(cfg.Metrics,
+			cfg.MetricNames == metricNamesPrefixJob)
 		if err != nil {
 			return nil, err
 		}
@@ -51,8 +61,31 @@ func NewConfig(raw interface{}, disc discovery.Backend) (*Config, error) {
 	return cfg, nil
 }
 
+// defaultMetricsPath is where the Prometheus metrics are served unless
+// the telemetry path is configured
+const defaultMetricsPath = "/metrics"
+
+var validEnvLabel = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
 // Validate ...
 func (cfg *Config) Validate(disc discovery.Backend) error {
+	if err := cfg.validateEnvLabels(); err != nil {
+		return err
+	}
+	if err := cfg.validatePath(); err != nil {
+		return err
+	}
+	if err := cfg.validateTLS(); err != nil {
+		return err
+	}
+	switch cfg.MetricNames {
+	case "":
+		cfg.MetricNames = metricNamesExact
+	case metricNamesExact, metricNamesPrefixJob:
+	default:
+		return fmt.Errorf("metricNames must be one of '%s' or '%s'",
+			metricNamesExact, metricNamesPrefixJob)
+	}
 	ipAddress, err := services.IPFromInterfaces(cfg.Interfaces)
 	if err != nil {
 		return err
@@ -67,6 +100,44 @@ func (cfg *Config) Validate(disc discovery.Backend) error {
 	return nil
 }
 
+// validateEnvLabels ensures that each of the environment variables we
+// label the command metrics with makes a valid and unique label name
+func (cfg *Config) validateEnvLabels() error {
+	seen := map[string]bool{"command": true, "status": true}
+	for _, name := range cfg.EnvLabels {
+		if !validEnvLabel.MatchString(name) {
+			return fmt.Errorf("envLabels '%s' is not a valid label name", name)
+		}
+		if strings.HasPrefix(name, "__") {
+			// reserved by Prometheus for its own use
+			return fmt.Errorf("envLabels '%s' can't start with '__'", name)
+		}
+		label := strings.ToLower(name)
+		if seen[label] {
+			return fmt.Errorf("envLabels '%s' duplicates the '%s' label", name, label)
+		}
+		seen[label] = true
+	}
+	return nil
+}
+
+// validatePath ensures that the metrics are served at a single path of
+// their own, so that requests to any other path get a 404
+func (cfg *Config) validatePath() error {
+	if cfg.Path == "" {
+		cfg.Path = defaultMetricsPath
+	}
+	switch {
+	case !strings.HasPrefix(cfg.Path, "/"):
+		return fmt.Errorf("path '%s' must start with '/'", cfg.Path)
+	case strings.HasSuffix(cfg.Path, "/"):
+		return fmt.Errorf("path '%s' must not end with '/'", cfg.Path)
+	case cfg.Path == "/status":
+		return fmt.Errorf("path '%s' is reserved for the status endpoint", cfg.Path)
+	}
+	return nil
+}
+
 // ToJobConfig ...
 func (cfg *Config) ToJobConfig() *jobs.Config {
 	if version.Version != "" {
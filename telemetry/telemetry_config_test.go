@@ -45,3 +45,51 @@ func TestTelemetryConfigBadInterface(t *testing.T) {
 		t.Fatalf("expected '%v' in error from bad metric type but got %v", expected, err)
 	}
 }
+
+func TestTelemetryConfigEnvLabels(t *testing.T) {
+	testCfg := tests.DecodeRaw(`{"interfaces": ["inet", "lo0"],
+		"envLabels": ["DEPLOY_ENV", "REGION"]}`)
+	telem, err := NewConfig(testCfg, &mocks.NoopDiscoveryBackend{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(telem.EnvLabels) != 2 {
+		t.Fatalf("expected 2 envLabels but got %v", telem.EnvLabels)
+	}
+
+	testErr := func(labels, expected string) {
+		testCfg := tests.DecodeRaw(fmt.Sprintf(
+			`{"interfaces": ["inet", "lo0"], "envLabels": %s}`, labels))
+		_, err := NewConfig(testCfg, &mocks.NoopDiscoveryBackend{})
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("expected '%v' in error but got %v", expected, err)
+		}
+	}
+	testErr(`["DEPLOY-ENV"]`, "envLabels 'DEPLOY-ENV' is not a valid label name")
+	testErr(`["__FOO"]`, "envLabels '__FOO' can't start with '__'")
+	testErr(`["STATUS"]`, "envLabels 'STATUS' duplicates the 'status' label")
+	testErr(`["REGION", "region"]`, "envLabels 'region' duplicates the 'region' label")
+}
+
+func TestTelemetryConfigPath(t *testing.T) {
+	testCfg := tests.DecodeRaw(`{"interfaces": ["inet", "lo0"]}`)
+	telem, err := NewConfig(testCfg, &mocks.NoopDiscoveryBackend{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if telem.Path != "/metrics" {
+		t.Fatalf("expected default path '/metrics' but got %v", telem.Path)
+	}
+
+	testErr := func(path, expected string) {
+		testCfg := tests.DecodeRaw(fmt.Sprintf(
+			`{"interfaces": ["inet", "lo0"], "path": %q}`, path))
+		_, err := NewConfig(testCfg, &mocks.NoopDiscoveryBackend{})
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("expected '%v' in error but got %v", expected, err)
+		}
+	}
+	testErr("metrics", "path 'metrics' must start with '/'")
+	testErr("/", "path '/' must not end with '/'")
+	testErr("/status", "path '/status' is reserved for the status endpoint")
+}
@@ -1,12 +1,23 @@
 package telemetry
 
 import (
+	"compress/gzip"
 	"context"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joyent/containerpilot/tests"
 	"github.com/joyent/containerpilot/tests/mocks"
+	"github.com/joyent/containerpilot/version"
 )
 
 func TestTelemetryServerRestart(t *testing.T) {
@@ -27,6 +38,114 @@ func TestTelemetryServerRestart(t *testing.T) {
 	checkServerIsListening(t, telem)
 }
 
+func TestTelemetryCustomPath(t *testing.T) {
+	testCfg := tests.DecodeRaw(`{"port": 9093, "interfaces": ["lo", "lo0", "inet"],
+		"path": "/internal/metrics"}`)
+	cfg, err := NewConfig(testCfg, &mocks.NoopDiscoveryBackend{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	telem := NewTelemetry(cfg)
+	ctx := context.Background()
+	telem.Run(ctx)
+	defer telem.Stop(ctx)
+
+	get := func(path string) int {
+		url := fmt.Sprintf("http://%v:%v%s", telem.addr.IP, telem.addr.Port, path)
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatalf("could not connect to telemetry server: %v", err)
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusOK, get("/internal/metrics"))
+	assert.Equal(t, http.StatusNotFound, get("/metrics"))
+	assert.Equal(t, http.StatusNotFound, get("/internal"))
+	assert.Equal(t, http.StatusOK, get("/status"))
+}
+
+func TestTelemetrySensorScrapesCoalesced(t *testing.T) {
+	runs, _ := ioutil.TempFile("", "sensor-runs")
+	runs.Close()
+	defer os.Remove(runs.Name())
+
+	// the sensor is slow enough that both scrapes arrive while it runs
+	testCfg := tests.DecodeRaw(fmt.Sprintf(`{"port": 9094,
+		"interfaces": ["lo", "lo0", "inet"],
+		"metrics": [{"namespace": "telemetry", "subsystem": "sensor",
+			"name": "TestTelemetrySensorScrapesCoalesced", "help": "help",
+			"type": "gauge", "timeout": "2s",
+			"exec": ["sh", "-c", "sleep 0.5; echo run >> %s; echo 42"]}]}`,
+		runs.Name()))
+	cfg, err := NewConfig(testCfg, &mocks.NoopDiscoveryBackend{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prometheus.Unregister(cfg.MetricConfigs[0].collector)
+	telem := NewTelemetry(cfg)
+	testServer := httptest.NewServer(telem.Handler)
+	defer testServer.Close()
+
+	bodies := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			resp, err := http.Get(testServer.URL + "/metrics")
+			if err != nil {
+				bodies <- err.Error()
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			bodies <- string(body)
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		assert.Contains(t, <-bodies,
+			"telemetry_sensor_TestTelemetrySensorScrapesCoalesced 42")
+	}
+	out, _ := ioutil.ReadFile(runs.Name())
+	assert.Equal(t, "run\n", string(out), "expected the sensor to run once")
+}
+
+func TestTelemetryCollect(t *testing.T) {
+	source, _ := ioutil.TempFile("", "sensor-source")
+	source.WriteString("1")
+	source.Close()
+	defer os.Remove(source.Name())
+
+	testCfg := tests.DecodeRaw(fmt.Sprintf(`{"port": 9094,
+		"interfaces": ["lo", "lo0", "inet"],
+		"metrics": [{"namespace": "telemetry", "subsystem": "sensor",
+			"name": "TestTelemetryCollect", "help": "help",
+			"type": "gauge", "exec": ["cat", "%s"]}]}`, source.Name()))
+	cfg, err := NewConfig(testCfg, &mocks.NoopDiscoveryBackend{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prometheus.Unregister(cfg.MetricConfigs[0].collector)
+	telem := NewTelemetry(cfg)
+	// this server doesn't run the sensors, so it only reports the values
+	// from the collections we ask for
+	testServer := httptest.NewServer(prometheus.UninstrumentedHandler())
+	defer testServer.Close()
+
+	assert.Equal(t, map[string]float64{"telemetry_sensor_TestTelemetryCollect": 1},
+		telem.Collect())
+	assert.Contains(t, getFromTestServer(t, testServer),
+		"telemetry_sensor_TestTelemetryCollect 1")
+
+	ioutil.WriteFile(source.Name(), []byte("2"), 0644)
+	assert.Equal(t, map[string]float64{"telemetry_sensor_TestTelemetryCollect": 2},
+		telem.Collect())
+	assert.Contains(t, getFromTestServer(t, testServer),
+		"telemetry_sensor_TestTelemetryCollect 2")
+
+	// a failed sensor is left out
+	os.Remove(source.Name())
+	assert.Equal(t, map[string]float64{}, telem.Collect())
+}
+
 func checkServerIsListening(t *testing.T, telem *Telemetry) {
 	url := fmt.Sprintf("http://%v:%v/metrics", telem.addr.IP, telem.addr.Port)
 	resp, err := http.Get(url)
@@ -38,3 +157,89 @@ func checkServerIsListening(t *testing.T, telem *Telemetry) {
 		t.Fatalf("got %v status from telemetry server", resp.StatusCode)
 	}
 }
+
+func TestTelemetryBuildInfo(t *testing.T) {
+	defer func(v, hash string) {
+		version.Version, version.GitHash = v, hash
+	}(version.Version, version.GitHash)
+	version.Version, version.GitHash = "3.9.9", "abc1234"
+
+	cfg := &Config{Port: 9090, Interfaces: []interface{}{"lo", "lo0", "inet"}}
+	cfg.Validate(&mocks.NoopDiscoveryBackend{})
+	NewTelemetry(cfg)
+
+	testServer := httptest.NewServer(prometheus.UninstrumentedHandler())
+	defer testServer.Close()
+	resp, err := http.Get(testServer.URL)
+	if err != nil {
+		t.Fatalf("failed to scrape metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	expected := fmt.Sprintf(
+		`containerpilot_build_info{commit="abc1234",go="%s",version="3.9.9"} 1`,
+		runtime.Version())
+	assert.Contains(t, string(body), expected)
+}
+
+func TestTelemetryGzipMetrics(t *testing.T) {
+	counter := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "telemetry",
+		Subsystem: "gzip",
+		Name:      "TestTelemetryGzipMetrics",
+		Help:      "help",
+	})
+	prometheus.MustRegister(counter)
+	defer prometheus.Unregister(counter)
+	counter.Add(42)
+
+	testServer := httptest.NewServer(
+		NewGzipHandler(prometheus.UninstrumentedHandler()))
+	defer testServer.Close()
+
+	// the default transport transparently decompresses, so we need
+	// to turn that off in order to see what's on the wire
+	client := &http.Client{
+		Transport: &http.Transport{DisableCompression: true},
+	}
+	scrape := func(encoding string) (string, string) {
+		req, _ := http.NewRequest("GET", testServer.URL, nil)
+		if encoding != "" {
+			req.Header.Set("Accept-Encoding", encoding)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("failed to scrape metrics: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.Header.Get("Content-Encoding"), string(body)
+	}
+	// the golang runtime metrics change between scrapes, so only
+	// compare the metric we own
+	ownMetrics := func(body string) []string {
+		lines := []string{}
+		for _, line := range strings.Split(body, "\n") {
+			if strings.Contains(line, "telemetry_gzip_TestTelemetryGzipMetrics") {
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	}
+
+	plainEncoding, plainBody := scrape("")
+	assert.Equal(t, "", plainEncoding, "expected no Content-Encoding")
+
+	gzEncoding, gzBody := scrape("gzip, deflate")
+	assert.Equal(t, "gzip", gzEncoding, "expected gzip Content-Encoding")
+	reader, err := gzip.NewReader(strings.NewReader(gzBody))
+	if err != nil {
+		t.Fatalf("expected gzip response body: %v", err)
+	}
+	decompressed, _ := ioutil.ReadAll(reader)
+
+	expected := ownMetrics(plainBody)
+	assert.NotEmpty(t, expected, "expected metric in plain response")
+	assert.Equal(t, expected, ownMetrics(string(decompressed)),
+		"expected decompressed metrics to match plain metrics")
+}
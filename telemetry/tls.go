@@ -0,0 +1,108 @@
+package telemetry
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TLSConfig configures the telemetry server to serve HTTPS with the
+// certificate and key in the files
+type TLSConfig struct {
+	Cert string `mapstructure:"cert"`
+	Key  string `mapstructure:"key"`
+}
+
+func (cfg *Config) validateTLS() error {
+	if cfg.TLS == nil {
+		return nil
+	}
+	if cfg.TLS.Cert == "" || cfg.TLS.Key == "" {
+		return fmt.Errorf("tls.cert and tls.key must both be set")
+	}
+	certs, err := newCertLoader(cfg.TLS.Cert, cfg.TLS.Key)
+	if err != nil {
+		return fmt.Errorf("unable to load tls certificate: %v", err)
+	}
+	cfg.certs = certs
+	return nil
+}
+
+// certLoader serves a certificate and key loaded from files, and loads
+// them again when either file changes so that a rotated certificate is
+// used for new connections without restarting the server
+type certLoader struct {
+	certPath string
+	keyPath  string
+
+	lock    sync.Mutex
+	cert    *tls.Certificate
+	certMod fileVersion
+	keyMod  fileVersion
+}
+
+// fileVersion is what we compare to tell whether a file has changed
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+func statVersion(path string) (fileVersion, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}, err
+	}
+	return fileVersion{info.ModTime(), info.Size()}, nil
+}
+
+func newCertLoader(certPath, keyPath string) (*certLoader, error) {
+	l := &certLoader{certPath: certPath, keyPath: keyPath}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// load reads the certificate and key, if they've changed since they were
+// last read. The lock must be held, or the certLoader not yet shared.
+func (l *certLoader) load() error {
+	certMod, err := statVersion(l.certPath)
+	if err != nil {
+		return err
+	}
+	keyMod, err := statVersion(l.keyPath)
+	if err != nil {
+		return err
+	}
+	if l.cert != nil && certMod == l.certMod && keyMod == l.keyMod {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(l.certPath, l.keyPath)
+	if err != nil {
+		return err
+	}
+	l.cert, l.certMod, l.keyMod = &cert, certMod, keyMod
+	return nil
+}
+
+// GetCertificate implements the tls.Config callback of the same name. A
+// certificate that can't be loaded, such as one caught halfway through
+// being rotated, is logged and the last good one is served instead.
+func (l *certLoader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if err := l.load(); err != nil {
+		log.Errorf("telemetry: unable to reload tls certificate, "+
+			"serving the previous one: %v", err)
+	}
+	return l.cert, nil
+}
+
+// tlsConfig returns the tls.Config the server's listener is wrapped with
+func (l *certLoader) tlsConfig() *tls.Config {
+	return &tls.Config{GetCertificate: l.GetCertificate}
+}
@@ -0,0 +1,108 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joyent/containerpilot/tests"
+	"github.com/joyent/containerpilot/tests/mocks"
+)
+
+func TestTelemetryTLSCertRotation(t *testing.T) {
+	dir, _ := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(dir)
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	writeTestCert(t, certPath, keyPath, "first")
+
+	testCfg := tests.DecodeRaw(fmt.Sprintf(`{"port": 9095,
+		"interfaces": ["lo", "lo0", "inet"],
+		"tls": {"cert": %q, "key": %q}}`, certPath, keyPath))
+	cfg, err := NewConfig(testCfg, &mocks.NoopDiscoveryBackend{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	telem := NewTelemetry(cfg)
+	ctx := context.Background()
+	telem.Run(ctx)
+	defer telem.Stop(ctx)
+
+	handshake := func() string {
+		conn, err := tls.Dial("tcp", telem.addr.String(),
+			&tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("tls handshake failed: %v", err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+	assert.Equal(t, "first", handshake())
+
+	// the files are swapped while the server keeps listening
+	writeTestCert(t, certPath, keyPath, "second")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certPath, later, later)
+	os.Chtimes(keyPath, later, later)
+	assert.Equal(t, "second", handshake(), "expected the rotated certificate")
+
+	// a broken rotation keeps serving the last good certificate
+	ioutil.WriteFile(keyPath, []byte("garbage"), 0600)
+	assert.Equal(t, "second", handshake())
+}
+
+func TestTelemetryTLSConfig(t *testing.T) {
+	testCfg := tests.DecodeRaw(`{"interfaces": ["lo", "lo0", "inet"],
+		"tls": {"cert": "/no/such/cert.pem"}}`)
+	_, err := NewConfig(testCfg, &mocks.NoopDiscoveryBackend{})
+	assert.EqualError(t, err,
+		"telemetry validation error: tls.cert and tls.key must both be set")
+
+	testCfg = tests.DecodeRaw(`{"interfaces": ["lo", "lo0", "inet"],
+		"tls": {"cert": "/no/such/cert.pem", "key": "/no/such/key.pem"}}`)
+	_, err = NewConfig(testCfg, &mocks.NoopDiscoveryBackend{})
+	assert.EqualError(t, err, "telemetry validation error: unable to load "+
+		"tls certificate: stat /no/such/cert.pem: no such file or directory")
+}
+
+// writeTestCert writes a self-signed certificate for the common name,
+// and its key
+func writeTestCert(t *testing.T, certPath, keyPath, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template,
+		&key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(certPath,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyPath,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+}
@@ -1,23 +1,45 @@
 package mocks
 
-import "github.com/hashicorp/consul/api"
+import (
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/joyent/containerpilot/discovery"
+)
 
 // NoopDiscoveryBackend is a mock discovery.Backend
 type NoopDiscoveryBackend struct {
 	Val     bool
 	lastVal bool
+	valLock sync.Mutex
+}
+
+// SetVal sets the Val field while the backend may be in use by a watch
+func (noop *NoopDiscoveryBackend) SetVal(val bool) {
+	noop.valLock.Lock()
+	defer noop.valLock.Unlock()
+	noop.Val = val
 }
 
 // CheckForUpstreamChanges will return the public Val field to mock
 // whether a change has occurred. Will not report a change on the second
-// check unless the Val has been updated externally by the test rig
-func (noop *NoopDiscoveryBackend) CheckForUpstreamChanges(_, _, _ string) (didChange, isHealthy bool) {
+// check unless the Val has been updated externally by the test rig.
+// A change to true is reported as added instances and a change to false
+// as removed instances.
+func (noop *NoopDiscoveryBackend) CheckForUpstreamChanges(_, _, _ string) (change discovery.UpstreamChange, isHealthy bool) {
+	noop.valLock.Lock()
+	defer noop.valLock.Unlock()
+	change = discovery.NoChange
 	if noop.lastVal != noop.Val {
-		didChange = true
+		change = discovery.InstancesRemoved
+		if noop.Val {
+			change = discovery.InstancesAdded
+		}
 	}
 	noop.lastVal = noop.Val
 	isHealthy = noop.Val
-	return didChange, isHealthy
+	return change, isHealthy
 }
 
 // CheckRegister (required for mock interface)
@@ -39,3 +61,165 @@ func (noop *NoopDiscoveryBackend) ServiceDeregister(serviceID string) error {
 func (noop *NoopDiscoveryBackend) ServiceRegister(service *api.AgentServiceRegistration) error {
 	return nil
 }
+
+// RegistryDiscoveryBackend is a mock discovery.Backend that keeps track
+// of which services are currently registered with the local agent
+type RegistryDiscoveryBackend struct {
+	NoopDiscoveryBackend
+	lock     sync.RWMutex
+	services map[string]*api.AgentServiceRegistration
+	checks   map[string]string
+	history  map[string][]string
+
+	deregistrations []string
+}
+
+// ServiceRegister records the service as registered
+func (reg *RegistryDiscoveryBackend) ServiceRegister(service *api.AgentServiceRegistration) error {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+	if reg.services == nil {
+		reg.services = make(map[string]*api.AgentServiceRegistration)
+	}
+	reg.services[service.ID] = service
+	return nil
+}
+
+// ServiceDeregister removes the service from the registry
+func (reg *RegistryDiscoveryBackend) ServiceDeregister(serviceID string) error {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+	delete(reg.services, serviceID)
+	reg.deregistrations = append(reg.deregistrations, serviceID)
+	return nil
+}
+
+// Deregistrations returns the IDs of every service deregistered, in order
+func (reg *RegistryDiscoveryBackend) Deregistrations() []string {
+	reg.lock.RLock()
+	defer reg.lock.RUnlock()
+	return append([]string{}, reg.deregistrations...)
+}
+
+// IsRegistered returns true if the service is currently registered
+func (reg *RegistryDiscoveryBackend) IsRegistered(serviceID string) bool {
+	reg.lock.RLock()
+	defer reg.lock.RUnlock()
+	_, ok := reg.services[serviceID]
+	return ok
+}
+
+// Registration returns the registration for the service, if it's
+// currently registered
+func (reg *RegistryDiscoveryBackend) Registration(serviceID string) *api.AgentServiceRegistration {
+	reg.lock.RLock()
+	defer reg.lock.RUnlock()
+	return reg.services[serviceID]
+}
+
+// UpdateTTL records the latest status of the check
+func (reg *RegistryDiscoveryBackend) UpdateTTL(checkID, output, status string) error {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+	if reg.checks == nil {
+		reg.checks = make(map[string]string)
+	}
+	reg.checks[checkID] = status
+	if reg.history == nil {
+		reg.history = make(map[string][]string)
+	}
+	reg.history[checkID] = append(reg.history[checkID], status)
+	return nil
+}
+
+// CheckStatus returns the latest status sent for the check, if any
+func (reg *RegistryDiscoveryBackend) CheckStatus(checkID string) string {
+	reg.lock.RLock()
+	defer reg.lock.RUnlock()
+	return reg.checks[checkID]
+}
+
+// CheckHistory returns every status sent for the check, in order
+func (reg *RegistryDiscoveryBackend) CheckHistory(checkID string) []string {
+	reg.lock.RLock()
+	defer reg.lock.RUnlock()
+	return append([]string{}, reg.history[checkID]...)
+}
+
+// CountingDiscoveryBackend is a mock discovery.Backend that reports a
+// number of healthy instances set by the test rig
+type CountingDiscoveryBackend struct {
+	NoopDiscoveryBackend
+	lock      sync.RWMutex
+	count     int
+	lastCount int
+	instances []discovery.Instance
+	lastFound []discovery.Instance
+}
+
+// SetCount sets the number of healthy instances found on the next check
+func (counting *CountingDiscoveryBackend) SetCount(count int) {
+	counting.lock.Lock()
+	defer counting.lock.Unlock()
+	counting.count = count
+}
+
+// SetInstances sets the healthy instances found on the next check
+func (counting *CountingDiscoveryBackend) SetInstances(instances []discovery.Instance) {
+	counting.lock.Lock()
+	defer counting.lock.Unlock()
+	counting.count = len(instances)
+	counting.instances = instances
+}
+
+// CheckForUpstreamChanges reports instances added or removed whenever the
+// count has changed since the last check
+func (counting *CountingDiscoveryBackend) CheckForUpstreamChanges(_, _, _ string) (change discovery.UpstreamChange, isHealthy bool) {
+	counting.lock.Lock()
+	defer counting.lock.Unlock()
+	switch {
+	case counting.count > counting.lastCount:
+		change = discovery.InstancesAdded
+	case counting.count < counting.lastCount:
+		change = discovery.InstancesRemoved
+	}
+	counting.lastCount = counting.count
+	counting.lastFound = counting.instances
+	return change, counting.count > 0
+}
+
+// InstanceCount returns the count found on the last check
+func (counting *CountingDiscoveryBackend) InstanceCount(_ string) int {
+	counting.lock.RLock()
+	defer counting.lock.RUnlock()
+	return counting.lastCount
+}
+
+// Instances returns the instances set by SetInstances as of the last check
+func (counting *CountingDiscoveryBackend) Instances(_ string) []discovery.Instance {
+	counting.lock.RLock()
+	defer counting.lock.RUnlock()
+	return counting.lastFound
+}
+
+// PingingDiscoveryBackend is a mock discovery.Backend whose Ping fails
+// with an error set by the test rig
+type PingingDiscoveryBackend struct {
+	NoopDiscoveryBackend
+	lock sync.RWMutex
+	err  error
+}
+
+// SetPingError sets the error returned by Ping, or nil for it to succeed
+func (pinging *PingingDiscoveryBackend) SetPingError(err error) {
+	pinging.lock.Lock()
+	defer pinging.lock.Unlock()
+	pinging.err = err
+}
+
+// Ping returns the error set by SetPingError
+func (pinging *PingingDiscoveryBackend) Ping() error {
+	pinging.lock.RLock()
+	defer pinging.lock.RUnlock()
+	return pinging.err
+}
@@ -2,9 +2,12 @@ package watches
 
 import (
 	"fmt"
+	"net"
+	"time"
 
 	"github.com/joyent/containerpilot/config/decode"
 	"github.com/joyent/containerpilot/config/services"
+	"github.com/joyent/containerpilot/config/timing"
 	"github.com/joyent/containerpilot/discovery"
 )
 
@@ -15,7 +18,18 @@ type Config struct {
 	Poll             int    `mapstructure:"interval"` // time in seconds
 	Tag              string `mapstructure:"tag"`
 	DC               string `mapstructure:"dc"` // Consul datacenter
-	discoveryService discovery.Backend
+	Stabilize        string `mapstructure:"stabilize"`
+	stabilize        time.Duration
+	MinInstances     int        `mapstructure:"minInstances"`
+	DNS              *DNSConfig `mapstructure:"dns"`
+	discoveryService discovery.Upstream
+}
+
+// DNSConfig configures a watch that resolves the DNS SRV records of a
+// name rather than asking the discovery backend
+type DNSConfig struct {
+	Name     string `mapstructure:"name"`
+	Resolver string `mapstructure:"resolver"` // address of the DNS server
 }
 
 // NewConfigs parses json config into a validated slice of Configs
@@ -47,10 +61,65 @@ func (cfg *Config) Validate(disc discovery.Backend) error {
 	if cfg.Poll < 1 {
 		return fmt.Errorf("watch[%s].interval must be > 0", cfg.serviceName)
 	}
-	cfg.discoveryService = disc
+	stabilize, err := timing.GetTimeout(cfg.Stabilize)
+	if err != nil {
+		return fmt.Errorf("unable to parse watch[%s].stabilize '%s': %v",
+			cfg.serviceName, cfg.Stabilize, err)
+	}
+	if stabilize < 0 {
+		return fmt.Errorf("watch[%s].stabilize must be >= 0", cfg.serviceName)
+	}
+	cfg.stabilize = stabilize
+	if cfg.MinInstances < 0 {
+		return fmt.Errorf("watch[%s].minInstances must be >= 0", cfg.serviceName)
+	}
+	var upstream discovery.Upstream = disc
+	if cfg.DNS != nil {
+		dns, err := cfg.validateDNS()
+		if err != nil {
+			return err
+		}
+		upstream = dns
+	}
+	if _, ok := upstream.(discovery.InstanceCounter); cfg.MinInstances > 0 && !ok {
+		return fmt.Errorf("watch[%s].minInstances is not supported by the discovery backend",
+			cfg.serviceName)
+	}
+	cfg.discoveryService = upstream
 	return nil
 }
 
+func (cfg *Config) validateDNS() (*discovery.DNS, error) {
+	if cfg.DNS.Name == "" {
+		return nil, fmt.Errorf("watch[%s].dns.name must be set", cfg.serviceName)
+	}
+	if cfg.Tag != "" || cfg.DC != "" {
+		return nil, fmt.Errorf("watch[%s].dns cannot be used with tag or dc",
+			cfg.serviceName)
+	}
+	if cfg.DNS.Resolver != "" {
+		if _, _, err := net.SplitHostPort(cfg.DNS.Resolver); err != nil {
+			return nil, fmt.Errorf("unable to parse watch[%s].dns.resolver '%s': %v",
+				cfg.serviceName, cfg.DNS.Resolver, err)
+		}
+	}
+	// a lookup that takes longer than the interval is stale by the time
+	// it's done
+	timeout := time.Duration(cfg.Poll) * time.Second
+	return discovery.NewDNS(cfg.DNS.Name,
+		discovery.NewResolver(cfg.DNS.Resolver), timeout), nil
+}
+
+// Upstreams returns the upstream each watch checks, by the name of the
+// watch as it's used in the source of its events
+func Upstreams(cfgs []*Config) map[string]discovery.Upstream {
+	upstreams := make(map[string]discovery.Upstream, len(cfgs))
+	for _, cfg := range cfgs {
+		upstreams[cfg.Name] = cfg.discoveryService
+	}
+	return upstreams
+}
+
 // String implements the stdlib fmt.Stringer interface for pretty-printing
 func (cfg *Config) String() string {
 	return "watches.Config[" + cfg.Name + "]"
@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/joyent/containerpilot/discovery"
 	"github.com/joyent/containerpilot/tests"
 )
 
@@ -28,6 +30,7 @@ func TestWatchesParse(t *testing.T) {
 	assert.Equal(watches[1].Name, "watch.upstreamB", "config for Name")
 	assert.Equal(watches[1].Poll, 79, "config for Poll")
 	assert.Equal(watches[1].DC, "us-east-1", "config for DC")
+	assert.Equal(watches[1].stabilize, 5*time.Second, "config for stabilize")
 }
 
 func TestWatchesConfigError(t *testing.T) {
@@ -38,4 +41,30 @@ func TestWatchesConfigError(t *testing.T) {
 	_, err = NewConfigs(tests.DecodeRawToSlice(
 		`[{"name": "myName"}]`), nil)
 	assert.Error(t, err, "watch[myName].interval must be > 0")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "myName", "interval": 1, "stabilize": "-1s"}]`), nil)
+	assert.EqualError(t, err, "watch[myName].stabilize must be >= 0")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "myName", "interval": 1, "minInstances": -1}]`), nil)
+	assert.EqualError(t, err, "watch[myName].minInstances must be >= 0")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "myName", "interval": 1, "dns": {}}]`), nil)
+	assert.EqualError(t, err, "watch[myName].dns.name must be set")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "myName", "interval": 1, "tag": "prod", "dns": {"name": "_http._tcp.app"}}]`), nil)
+	assert.EqualError(t, err, "watch[myName].dns cannot be used with tag or dc")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "myName", "interval": 1, "dns": {"name": "_http._tcp.app", "resolver": "10.0.0.10"}}]`), nil)
+	assert.EqualError(t, err, "unable to parse watch[myName].dns.resolver '10.0.0.10': address 10.0.0.10: missing port in address")
+
+	cfgs, err := NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "myName", "interval": 1, "minInstances": 2, "dns": {"name": "_http._tcp.app", "resolver": "10.0.0.10:53"}}]`), nil)
+	if assert.NoError(t, err) {
+		assert.IsType(t, &discovery.DNS{}, cfgs[0].discoveryService)
+	}
 }
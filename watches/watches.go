@@ -4,10 +4,14 @@ package watches
 
 import (
 	"context"
+	"os"
+	"strings"
 	"time"
 
+	"github.com/joyent/containerpilot/commands"
 	"github.com/joyent/containerpilot/discovery"
 	"github.com/joyent/containerpilot/events"
+	log "github.com/sirupsen/logrus"
 )
 
 // Watch represents an event to signal when something changes
@@ -17,9 +21,23 @@ type Watch struct {
 	tag              string
 	dc               string
 	poll             int
-	discoveryService discovery.Backend
+	stabilize        time.Duration
+	minInstances     int
+	discoveryService discovery.Upstream
 	rx               chan events.Event
 
+	// state of a change waiting out the stabilization window
+	pending        bool
+	pendingChange  discovery.UpstreamChange
+	pendingHealthy bool
+	droppedOut     bool
+	lastHealthy    bool
+	published      bool
+
+	// the instances when we last published a change, if the upstream can
+	// list them
+	publishedInstances []discovery.Instance
+
 	events.Publisher
 }
 
@@ -33,6 +51,8 @@ func NewWatch(cfg *Config) *Watch {
 		tag:              cfg.Tag,
 		dc:               cfg.DC,
 		poll:             cfg.Poll,
+		stabilize:        cfg.stabilize,
+		minInstances:     cfg.MinInstances,
 		discoveryService: cfg.discoveryService,
 	}
 	// watch.InitRx()
@@ -50,10 +70,25 @@ func FromConfigs(cfgs []*Config) []*Watch {
 	return watches
 }
 
+// UsesDiscovery returns true if the Watch checks the service discovery
+// backend, rather than another source like DNS
+func (watch *Watch) UsesDiscovery() bool {
+	_, ok := watch.discoveryService.(discovery.Backend)
+	return ok
+}
+
 // CheckForUpstreamChanges checks the service discovery endpoint for any changes
-// in a dependent backend. Returns true when there has been a change.
-func (watch *Watch) CheckForUpstreamChanges() (bool, bool) {
-	return watch.discoveryService.CheckForUpstreamChanges(watch.serviceName, watch.tag, watch.dc)
+// in a dependent backend. Returns how the backend changed, if at all, and
+// whether it has enough healthy instances to be considered available.
+func (watch *Watch) CheckForUpstreamChanges() (discovery.UpstreamChange, bool) {
+	change, isHealthy := watch.discoveryService.CheckForUpstreamChanges(
+		watch.serviceName, watch.tag, watch.dc)
+	if watch.minInstances > 0 {
+		// validated in the Config
+		counter := watch.discoveryService.(discovery.InstanceCounter)
+		isHealthy = counter.InstanceCount(watch.serviceName) >= watch.minInstances
+	}
+	return change, isHealthy
 }
 
 // Tick returns the watcher's ticker time duration.
@@ -61,17 +96,28 @@ func (watch *Watch) Tick() time.Duration {
 	return time.Duration(watch.poll) * time.Second
 }
 
+// EnvName is the name of the environment variable that reports how the
+// watched service last changed (ex. CONTAINERPILOT_APP_EVENT)
+func (watch *Watch) EnvName() string {
+	name := strings.ToUpper(watch.serviceName)
+	name = strings.Replace(name, "-", "_", -1)
+	return commands.EnvVar(name + "_EVENT")
+}
+
 // Run executes the event loop for the Watch
 func (watch *Watch) Run(pctx context.Context, bus *events.EventBus) {
 	watch.Register(bus)
 	ctx, cancel := context.WithCancel(pctx)
 	timerSource := watch.Name + ".poll"
+	stabilizeSource := watch.Name + ".stabilize"
+	stabilizeCancel := func() {}
 
 	// TODO(justinwr@): this could be replaced by a simple Ticker
 	events.NewEventTimer(ctx, watch.rx, watch.Tick(), timerSource)
 
 	go func() {
 		defer func() {
+			stabilizeCancel()
 			cancel()
 			watch.Unregister()
 			watch.Wait()
@@ -82,18 +128,25 @@ func (watch *Watch) Run(pctx context.Context, bus *events.EventBus) {
 				if !ok || event == events.QuitByTest {
 					return
 				}
-				if event == (events.Event{events.TimerExpired, timerSource}) {
-					didChange, isHealthy := watch.CheckForUpstreamChanges()
-					if didChange {
-						watch.Publish(events.Event{events.StatusChanged, watch.Name})
-						// we only send the StatusHealthy and StatusUnhealthy
-						// events if there was a change
-						if isHealthy {
-							watch.Publish(events.Event{events.StatusHealthy, watch.Name})
-						} else {
-							watch.Publish(events.Event{events.StatusUnhealthy, watch.Name})
-						}
+				switch event {
+				case events.Event{events.TimerExpired, timerSource}:
+					change, isHealthy := watch.CheckForUpstreamChanges()
+					if change == discovery.NoChange {
+						continue
 					}
+					if watch.stabilize == 0 {
+						watch.publishChange(change, isHealthy)
+						continue
+					}
+					// every change restarts the stabilization window
+					stabilizeCancel()
+					var stabilizeCtx context.Context
+					stabilizeCtx, stabilizeCancel = context.WithCancel(ctx)
+					events.NewEventTimeout(stabilizeCtx, watch.rx,
+						watch.stabilize, stabilizeSource)
+					watch.holdChange(change, isHealthy)
+				case events.Event{events.TimerExpired, stabilizeSource}:
+					watch.releaseChange()
 				}
 			case <-ctx.Done():
 				return
@@ -102,6 +155,83 @@ func (watch *Watch) Run(pctx context.Context, bus *events.EventBus) {
 	}()
 }
 
+// holdChange records a change until the service has been stable for the
+// stabilization window
+func (watch *Watch) holdChange(change discovery.UpstreamChange, isHealthy bool) {
+	watch.pending = true
+	watch.pendingChange = change
+	watch.pendingHealthy = isHealthy
+	if !isHealthy {
+		watch.droppedOut = true
+	}
+}
+
+// releaseChange publishes the change held over the stabilization window,
+// unless the service only dropped out briefly and is healthy again with
+// the same instances as when we last published
+func (watch *Watch) releaseChange() {
+	if !watch.pending {
+		return
+	}
+	transient := watch.droppedOut && watch.pendingHealthy && watch.lastHealthy &&
+		watch.instancesUnchanged()
+	watch.pending = false
+	watch.droppedOut = false
+	if transient {
+		log.Debugf("%s: ignoring transient loss of instances", watch.Name)
+		return
+	}
+	watch.publishChange(watch.pendingChange, watch.pendingHealthy)
+}
+
+// instancesUnchanged returns true if the upstream has the same instances
+// as when we last published a change. If it can't list its instances we
+// can't tell, so we assume they've changed rather than miss a change.
+func (watch *Watch) instancesUnchanged() bool {
+	lister, ok := watch.discoveryService.(discovery.InstanceLister)
+	if !ok {
+		return false
+	}
+	current := lister.Instances(watch.serviceName)
+	if len(current) != len(watch.publishedInstances) {
+		return false
+	}
+	seen := make(map[discovery.Instance]bool, len(current))
+	for _, instance := range watch.publishedInstances {
+		seen[instance] = true
+	}
+	for _, instance := range current {
+		if !seen[instance] {
+			return false
+		}
+	}
+	return true
+}
+
+func (watch *Watch) publishChange(change discovery.UpstreamChange, isHealthy bool) {
+	crossed := !watch.published || isHealthy != watch.lastHealthy
+	watch.lastHealthy = isHealthy
+	watch.published = true
+	if lister, ok := watch.discoveryService.(discovery.InstanceLister); ok {
+		watch.publishedInstances = lister.Instances(watch.serviceName)
+	}
+	// set before publishing so that any job started
+	// by these events can see why the watch fired
+	os.Setenv(watch.EnvName(), string(change))
+	watch.Publish(events.Event{events.StatusChanged, watch.Name})
+	// we only send the StatusHealthy and StatusUnhealthy
+	// events if there was a change. With minInstances, changes that
+	// don't cross the threshold aren't a change in availability.
+	if watch.minInstances > 0 && !crossed {
+		return
+	}
+	if isHealthy {
+		watch.Publish(events.Event{events.StatusHealthy, watch.Name})
+	} else {
+		watch.Publish(events.Event{events.StatusUnhealthy, watch.Name})
+	}
+}
+
 // Receive receives an event into the internal control channel.
 func (watch *Watch) Receive(event events.Event) {
 	watch.rx <- event
@@ -3,7 +3,13 @@ package watches
 import (
 	"context"
 	"fmt"
+	"net"
+	"os"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 
 	"github.com/joyent/containerpilot/discovery"
 	"github.com/joyent/containerpilot/events"
@@ -38,6 +44,222 @@ func TestWatchPollFail(t *testing.T) {
 	}
 }
 
+func TestWatchEventEnv(t *testing.T) {
+	cfg := &Config{
+		Name: "my-watch",
+		Poll: 1,
+	}
+	disc := &mocks.NoopDiscoveryBackend{Val: true}
+	cfg.Validate(disc)
+	watch := NewWatch(cfg)
+	assert.Equal(t, "CONTAINERPILOT_MY_WATCH_EVENT", watch.EnvName())
+	defer os.Unsetenv(watch.EnvName())
+
+	bus := events.NewEventBus()
+	watch.Run(context.Background(), bus)
+	poll := events.Event{events.TimerExpired, "watch.my-watch.poll"}
+	watch.Receive(poll)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, "added", os.Getenv(watch.EnvName()))
+
+	disc.SetVal(false) // instance removed
+	watch.Receive(poll)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, "removed", os.Getenv(watch.EnvName()))
+
+	watch.Receive(events.QuitByTest)
+	bus.Wait()
+}
+
+// A watch with a stabilization window doesn't fire when the service
+// briefly drops to zero instances and recovers within the window, but
+// does when it recovers with different instances
+func TestWatchStabilize(t *testing.T) {
+	original := []discovery.Instance{
+		{ID: "a", Address: "192.168.1.1", Port: 8080},
+		{ID: "b", Address: "192.168.1.2", Port: 8080},
+	}
+	replaced := []discovery.Instance{
+		{ID: "c", Address: "192.168.1.3", Port: 8080},
+		{ID: "d", Address: "192.168.1.4", Port: 8080},
+	}
+	tests := []struct {
+		name     string
+		restored []discovery.Instance
+		changes  int
+	}{
+		{"Transient", original, 1},
+		{"Replaced", replaced, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := runStabilizeTest(t, original, tt.restored)
+			changed := events.Event{events.StatusChanged, "watch.mywatchStable"}
+			healthy := events.Event{events.StatusHealthy, "watch.mywatchStable"}
+			unhealthy := events.Event{events.StatusUnhealthy, "watch.mywatchStable"}
+			assert.Equal(t, tt.changes, got[changed])
+			assert.Equal(t, tt.changes, got[healthy])
+			assert.Equal(t, 0, got[unhealthy], "expected the drop to be ignored")
+		})
+	}
+}
+
+// runStabilizeTest drops the service from its original instances to none
+// and then to the restored instances within the stabilization window
+func runStabilizeTest(t *testing.T, original, restored []discovery.Instance) map[events.Event]int {
+	cfg := &Config{
+		Name:      "mywatchStable",
+		Poll:      1,
+		Stabilize: "200ms",
+	}
+	disc := &mocks.CountingDiscoveryBackend{}
+	if err := cfg.Validate(disc); err != nil {
+		t.Fatal(err)
+	}
+	watch := NewWatch(cfg)
+	defer os.Unsetenv(watch.EnvName())
+	bus := events.NewEventBus()
+	watch.Run(context.Background(), bus)
+	poll := events.Event{events.TimerExpired, "watch.mywatchStable.poll"}
+
+	disc.SetInstances(original) // instances first seen
+	watch.Receive(poll)
+	time.Sleep(300 * time.Millisecond)
+
+	disc.SetInstances(nil) // transient drop to zero instances...
+	watch.Receive(poll)
+	time.Sleep(50 * time.Millisecond)
+	disc.SetInstances(restored) // ...restored within the window
+	watch.Receive(poll)
+	time.Sleep(300 * time.Millisecond)
+
+	watch.Receive(events.QuitByTest)
+	bus.Wait()
+	got := map[events.Event]int{}
+	for _, result := range bus.DebugEvents() {
+		got[result]++
+	}
+	return got
+}
+
+// A watch with minInstances is only healthy while it has at least that
+// many instances, and only reports crossing the threshold
+func TestWatchMinInstances(t *testing.T) {
+	cfg := &Config{
+		Name:         "mywatchQuorum",
+		Poll:         1,
+		MinInstances: 3,
+	}
+	disc := &mocks.CountingDiscoveryBackend{}
+	if err := cfg.Validate(disc); err != nil {
+		t.Fatal(err)
+	}
+	watch := NewWatch(cfg)
+	defer os.Unsetenv(watch.EnvName())
+	bus := events.NewEventBus()
+	watch.Run(context.Background(), bus)
+	poll := events.Event{events.TimerExpired, "watch.mywatchQuorum.poll"}
+	for _, count := range []int{1, 2, 3, 4, 2, 3} {
+		disc.SetCount(count)
+		watch.Receive(poll)
+		time.Sleep(50 * time.Millisecond)
+	}
+	watch.Receive(events.QuitByTest)
+	bus.Wait()
+
+	healthy := events.Event{events.StatusHealthy, "watch.mywatchQuorum"}
+	unhealthy := events.Event{events.StatusUnhealthy, "watch.mywatchQuorum"}
+	changed := events.Event{events.StatusChanged, "watch.mywatchQuorum"}
+	got := []events.Event{}
+	changes := 0
+	for _, event := range bus.DebugEvents() {
+		switch event {
+		case healthy, unhealthy:
+			got = append(got, event)
+		case changed:
+			changes++
+		}
+	}
+	assert.Equal(t, []events.Event{unhealthy, healthy, unhealthy, healthy}, got)
+	assert.Equal(t, 6, changes, "expected every change in instances to be reported")
+
+	cfg = &Config{Name: "mywatchQuorum", Poll: 1, MinInstances: 3}
+	assert.EqualError(t, cfg.Validate(&mocks.NoopDiscoveryBackend{}),
+		"watch[mywatchQuorum].minInstances is not supported by the discovery backend")
+}
+
+// stubResolver returns the SRV records it's been given
+type stubResolver struct {
+	lock    sync.Mutex
+	records []*net.SRV
+}
+
+func (r *stubResolver) set(records ...*net.SRV) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.records = records
+}
+
+func (r *stubResolver) LookupSRV(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.records) == 0 {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return name, r.records, nil
+}
+
+// A DNS watch reports changes to the SRV records of its name
+func TestWatchDNS(t *testing.T) {
+	cfg := &Config{
+		Name: "mywatchDNS",
+		Poll: 1,
+		DNS:  &DNSConfig{Name: "_http._tcp.app.default.svc.cluster.local"},
+	}
+	if err := cfg.Validate(nil); err != nil {
+		t.Fatal(err)
+	}
+	resolver := &stubResolver{}
+	dns := discovery.NewDNS(cfg.DNS.Name, resolver, time.Second)
+	cfg.discoveryService = dns
+	watch := NewWatch(cfg)
+	defer os.Unsetenv(watch.EnvName())
+	bus := events.NewEventBus()
+	watch.Run(context.Background(), bus)
+	poll := events.Event{events.TimerExpired, "watch.mywatchDNS.poll"}
+
+	envs := []string{}
+	for _, records := range [][]*net.SRV{
+		{{Target: "10-0-0-1.app.default.svc.cluster.local.", Port: 80}},
+		{{Target: "10-0-0-1.app.default.svc.cluster.local.", Port: 80}}, // no change
+		{{Target: "10-0-0-1.app.default.svc.cluster.local.", Port: 80},
+			{Target: "10-0-0-2.app.default.svc.cluster.local.", Port: 80}},
+		{{Target: "10-0-0-1.app.default.svc.cluster.local.", Port: 80},
+			{Target: "10-0-0-3.app.default.svc.cluster.local.", Port: 80}},
+		{}, // name no longer resolves
+	} {
+		resolver.set(records...)
+		watch.Receive(poll)
+		time.Sleep(50 * time.Millisecond)
+		envs = append(envs, os.Getenv(watch.EnvName()))
+	}
+	assert.Equal(t, []string{"initial", "initial", "added", "changed", "removed"}, envs)
+	watch.Receive(events.QuitByTest)
+	bus.Wait()
+
+	healthy := events.Event{events.StatusHealthy, "watch.mywatchDNS"}
+	unhealthy := events.Event{events.StatusUnhealthy, "watch.mywatchDNS"}
+	changed := events.Event{events.StatusChanged, "watch.mywatchDNS"}
+	got := map[events.Event]int{}
+	for _, event := range bus.DebugEvents() {
+		got[event]++
+	}
+	assert.Equal(t, 4, got[changed], "expected every change in records to be reported")
+	assert.Equal(t, 3, got[healthy])
+	assert.Equal(t, 1, got[unhealthy])
+	assert.Equal(t, []discovery.Instance{}, dns.Instances("mywatchDNS"))
+}
+
 func runWatchTest(cfg *Config, count int, disc discovery.Backend) map[events.Event]int {
 	bus := events.NewEventBus()
 	cfg.Validate(disc)
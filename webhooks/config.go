@@ -0,0 +1,150 @@
+package webhooks
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/joyent/containerpilot/config/decode"
+	"github.com/joyent/containerpilot/config/services"
+	"github.com/joyent/containerpilot/config/timing"
+	"github.com/joyent/containerpilot/events"
+)
+
+const (
+	defaultTimeout    = 5 * time.Second
+	defaultAttempts   = 3
+	defaultBackoff    = time.Second
+	defaultMaxBackoff = 30 * time.Second
+)
+
+// Config configures a webhook
+type Config struct {
+	Name    string       `mapstructure:"name"`
+	URL     string       `mapstructure:"url"`
+	Events  []string     `mapstructure:"events"`
+	Source  string       `mapstructure:"source"`
+	Timeout string       `mapstructure:"timeout"`
+	Retry   *RetryConfig `mapstructure:"retry"`
+
+	codes       []events.EventCode
+	timeout     time.Duration
+	quietPeriod time.Duration
+}
+
+// RetryConfig configures how a webhook retries a failed delivery
+type RetryConfig struct {
+	Attempts   int    `mapstructure:"attempts"`
+	Backoff    string `mapstructure:"backoff"`
+	MaxBackoff string `mapstructure:"maxBackoff"`
+
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// NewConfigs parses json config into a validated slice of Configs
+func NewConfigs(raw []interface{}) ([]*Config, error) {
+	var webhooks []*Config
+	if raw == nil {
+		return webhooks, nil
+	}
+	if err := decode.ToStruct(raw, &webhooks); err != nil {
+		return webhooks, fmt.Errorf("webhook configuration error: %v", err)
+	}
+	for _, webhook := range webhooks {
+		if err := webhook.Validate(); err != nil {
+			return webhooks, err
+		}
+	}
+	return webhooks, nil
+}
+
+// Validate ensures Config meets all requirements
+func (cfg *Config) Validate() error {
+	if err := services.ValidateName(cfg.Name); err != nil {
+		return err
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook[%s].url must be an http or https URL", cfg.Name)
+	}
+	if len(cfg.Events) == 0 {
+		return fmt.Errorf("webhook[%s].events must not be empty", cfg.Name)
+	}
+	for _, name := range cfg.Events {
+		code, err := events.FromString(name)
+		if err != nil {
+			return fmt.Errorf("unable to parse webhook[%s].events: %v", cfg.Name, err)
+		}
+		cfg.codes = append(cfg.codes, code)
+	}
+
+	if cfg.Timeout == "" {
+		cfg.timeout = defaultTimeout
+	} else {
+		timeout, err := timing.GetTimeout(cfg.Timeout)
+		if err != nil {
+			return fmt.Errorf("unable to parse webhook[%s].timeout '%s': %v",
+				cfg.Name, cfg.Timeout, err)
+		}
+		if timeout <= 0 {
+			return fmt.Errorf("webhook[%s].timeout must be > 0", cfg.Name)
+		}
+		cfg.timeout = timeout
+	}
+	return cfg.validateRetry()
+}
+
+func (cfg *Config) validateRetry() error {
+	if cfg.Retry == nil {
+		cfg.Retry = &RetryConfig{}
+	}
+	retry := cfg.Retry
+	if retry.Attempts == 0 {
+		retry.Attempts = defaultAttempts
+	}
+	if retry.Attempts < 1 {
+		return fmt.Errorf("webhook[%s].retry.attempts must be > 0", cfg.Name)
+	}
+
+	retry.backoff = defaultBackoff
+	if retry.Backoff != "" {
+		backoff, err := timing.GetTimeout(retry.Backoff)
+		if err != nil {
+			return fmt.Errorf("unable to parse webhook[%s].retry.backoff '%s': %v",
+				cfg.Name, retry.Backoff, err)
+		}
+		if backoff <= 0 {
+			return fmt.Errorf("webhook[%s].retry.backoff must be > 0", cfg.Name)
+		}
+		retry.backoff = backoff
+	}
+
+	retry.maxBackoff = defaultMaxBackoff
+	if retry.MaxBackoff != "" {
+		maxBackoff, err := timing.GetTimeout(retry.MaxBackoff)
+		if err != nil {
+			return fmt.Errorf("unable to parse webhook[%s].retry.maxBackoff '%s': %v",
+				cfg.Name, retry.MaxBackoff, err)
+		}
+		retry.maxBackoff = maxBackoff
+	}
+	if retry.maxBackoff < retry.backoff {
+		return fmt.Errorf("webhook[%s].retry.maxBackoff must be >= backoff", cfg.Name)
+	}
+	return nil
+}
+
+// SetQuietPeriod sets the period after ContainerPilot starts during which
+// the webhooks don't deliver failure events, because failures are
+// expected while the jobs come up
+func SetQuietPeriod(cfgs []*Config, period time.Duration) {
+	for _, cfg := range cfgs {
+		cfg.quietPeriod = period
+	}
+}
+
+// String implements the stdlib fmt.Stringer interface for pretty-printing
+func (cfg *Config) String() string {
+	return "webhooks.Config[" + cfg.Name + "]"
+}
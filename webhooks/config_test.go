@@ -0,0 +1,64 @@
+package webhooks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/tests"
+)
+
+func TestWebhooksParse(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[
+	{
+		name: "alerts",
+		url: "http://example.com/hook",
+		events: ["exitFailed", "unhealthy"],
+		source: "app",
+		timeout: "2s",
+		retry: {attempts: 5, backoff: "500ms", maxBackoff: "10s"}
+	},
+	{
+		name: "audit",
+		url: "https://example.com/audit",
+		events: ["startup"]
+	}]`)
+	webhooks, err := NewConfigs(testCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert := assert.New(t)
+	assert.Equal([]events.EventCode{events.ExitFailed, events.StatusUnhealthy},
+		webhooks[0].codes, "config for events")
+	assert.Equal("app", webhooks[0].Source, "config for source")
+	assert.Equal(2*time.Second, webhooks[0].timeout, "config for timeout")
+	assert.Equal(5, webhooks[0].Retry.Attempts, "config for retry.attempts")
+	assert.Equal(500*time.Millisecond, webhooks[0].Retry.backoff, "config for retry.backoff")
+	assert.Equal(10*time.Second, webhooks[0].Retry.maxBackoff, "config for retry.maxBackoff")
+
+	assert.Equal(defaultTimeout, webhooks[1].timeout, "default timeout")
+	assert.Equal(defaultAttempts, webhooks[1].Retry.Attempts, "default retry.attempts")
+	assert.Equal(defaultBackoff, webhooks[1].Retry.backoff, "default retry.backoff")
+	assert.Equal(defaultMaxBackoff, webhooks[1].Retry.maxBackoff, "default retry.maxBackoff")
+}
+
+func TestWebhooksConfigError(t *testing.T) {
+	testErr := func(raw, expected string) {
+		_, err := NewConfigs(tests.DecodeRawToSlice(raw))
+		assert.EqualError(t, err, expected)
+	}
+	testErr(`[{name: "hook", url: "example.com", events: ["startup"]}]`,
+		"webhook[hook].url must be an http or https URL")
+	testErr(`[{name: "hook", url: "http://example.com"}]`,
+		"webhook[hook].events must not be empty")
+	testErr(`[{name: "hook", url: "http://example.com", events: ["bogus"]}]`,
+		"unable to parse webhook[hook].events: bogus is not a valid event code")
+	testErr(`[{name: "hook", url: "http://example.com", events: ["startup"],
+		retry: {attempts: -1}}]`,
+		"webhook[hook].retry.attempts must be > 0")
+	testErr(`[{name: "hook", url: "http://example.com", events: ["startup"],
+		retry: {backoff: "10s", maxBackoff: "1s"}}]`,
+		"webhook[hook].retry.maxBackoff must be >= backoff")
+}
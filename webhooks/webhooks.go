@@ -0,0 +1,278 @@
+// Package webhooks manages the configuration and running of webhooks that
+// deliver events to an external HTTP receiver
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/joyent/containerpilot/events"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	eventBufferSize    = 1000
+	deliveryBufferSize = 100
+
+	// drainTimeout bounds how long a webhook holds up shutdown while it
+	// delivers the events queued before it
+	drainTimeout = 10 * time.Second
+)
+
+// the events that are suppressed during the quiet period
+var failureCodes = []events.EventCode{events.ExitFailed, events.StatusUnhealthy}
+
+var failures *prometheus.CounterVec
+
+func init() {
+	failures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "containerpilot_webhook_failures",
+		Help: "count of events that could not be delivered, partitioned by webhook",
+	}, []string{"webhook"})
+	prometheus.MustRegister(failures)
+}
+
+// Webhook delivers matching events to an HTTP receiver. Deliveries are
+// made from their own goroutine so that a slow or failing receiver never
+// blocks the event bus.
+type Webhook struct {
+	Name       string
+	url        string
+	codes      []events.EventCode
+	source     string
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	client     *http.Client
+	deliveries chan payload
+	drainAfter time.Duration
+
+	quietUntil time.Time
+
+	events.Subscriber
+	publisher events.Publisher
+}
+
+// payload is the JSON body POSTed to the receiver
+type payload struct {
+	Code      string    `json:"code"`
+	Source    string    `json:"source"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// NewWebhook creates a Webhook from a validated Config. The quiet period
+// is measured from started, the time ContainerPilot started, so that it
+// isn't extended by reloads.
+func NewWebhook(cfg *Config, started time.Time) *Webhook {
+	webhook := &Webhook{
+		Name:       cfg.Name,
+		url:        cfg.URL,
+		codes:      cfg.codes,
+		source:     cfg.Source,
+		attempts:   cfg.Retry.Attempts,
+		backoff:    cfg.Retry.backoff,
+		maxBackoff: cfg.Retry.maxBackoff,
+		client:     &http.Client{Timeout: cfg.timeout},
+		deliveries: make(chan payload, deliveryBufferSize),
+		drainAfter: drainTimeout,
+
+		quietUntil: started.Add(cfg.quietPeriod),
+	}
+	webhook.Rx = make(chan events.Event, eventBufferSize)
+	webhook.Subscriber.Name = "webhook." + webhook.Name
+	return webhook
+}
+
+// FromConfigs creates Webhooks from a slice of validated Configs
+func FromConfigs(cfgs []*Config, started time.Time) []*Webhook {
+	webhooks := []*Webhook{}
+	for _, cfg := range cfgs {
+		webhooks = append(webhooks, NewWebhook(cfg, started))
+	}
+	return webhooks
+}
+
+// Run executes the event loop for the Webhook
+func (webhook *Webhook) Run(pctx context.Context, bus *events.EventBus) {
+	webhook.Subscribe(bus)
+	// registering keeps bus.Wait from returning until we've delivered
+	// the events queued before the shutdown, such as the failure that
+	// caused it
+	webhook.publisher.Register(bus)
+	ctx, cancel := context.WithCancel(pctx)
+
+	// deliveries aren't cancelled along with the event loop so that
+	// retries can continue while we drain the queue
+	deliverCtx, deliverCancel := context.WithCancel(context.Background())
+	drained := make(chan struct{})
+	go func() {
+		webhook.deliver(deliverCtx)
+		close(drained)
+	}()
+	go func() {
+		defer func() {
+			cancel()
+			webhook.Unsubscribe()
+			close(webhook.deliveries)
+			webhook.drain(drained, deliverCancel)
+			webhook.publisher.Unregister()
+			webhook.Wait()
+		}()
+		for {
+			select {
+			case event, ok := <-webhook.Rx:
+				if !ok || event == events.QuitByTest {
+					return
+				}
+				if webhook.matches(event) && !webhook.quiet(event) {
+					webhook.enqueue(event)
+				}
+				if event == events.GlobalShutdown {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (webhook *Webhook) matches(event events.Event) bool {
+	if webhook.source != "" && event.Source != webhook.source {
+		return false
+	}
+	for _, code := range webhook.codes {
+		if event.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// quiet returns true if the event is a failure that arrived during the
+// quiet period, logging it in place of delivering it
+func (webhook *Webhook) quiet(event events.Event) bool {
+	if !time.Now().Before(webhook.quietUntil) {
+		return false
+	}
+	for _, code := range failureCodes {
+		if event.Code == code {
+			log.Infof("webhook[%s]: not delivering %v during the quiet period",
+				webhook.Name, event)
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue hands the event off for delivery without blocking, dropping
+// it if the receiver has fallen too far behind
+func (webhook *Webhook) enqueue(event events.Event) {
+	p := payload{
+		Code:      event.Code.String(),
+		Source:    event.Source,
+		Timestamp: time.Now().UTC(),
+	}
+	select {
+	case webhook.deliveries <- p:
+	default:
+		log.Warnf("webhook[%s]: delivery queue full, dropping event %v",
+			webhook.Name, event)
+		failures.WithLabelValues(webhook.Name).Inc()
+	}
+}
+
+// drain waits for the delivery goroutine to finish sending the queue,
+// cancelling any deliveries still in progress after drainAfter so that
+// an unreachable receiver can't hold up the shutdown indefinitely
+func (webhook *Webhook) drain(drained <-chan struct{}, cancel context.CancelFunc) {
+	defer cancel()
+	timer := time.NewTimer(webhook.drainAfter)
+	defer timer.Stop()
+	select {
+	case <-drained:
+	case <-timer.C:
+		log.Warnf("webhook[%s]: timed out delivering queued events at shutdown",
+			webhook.Name)
+		cancel()
+		<-drained
+	}
+}
+
+func (webhook *Webhook) deliver(ctx context.Context) {
+	for p := range webhook.deliveries {
+		webhook.send(ctx, p)
+	}
+}
+
+// send POSTs the payload to the receiver, retrying with backoff until it
+// succeeds or we run out of attempts
+func (webhook *Webhook) send(ctx context.Context, p payload) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		log.Errorf("webhook[%s]: unable to encode event: %v", webhook.Name, err)
+		return
+	}
+	for attempt := 1; ; attempt++ {
+		err = webhook.post(ctx, body)
+		if err == nil {
+			return
+		}
+		if attempt >= webhook.attempts {
+			break
+		}
+		log.Debugf("webhook[%s]: attempt %d failed: %v", webhook.Name, attempt, err)
+		select {
+		case <-time.After(webhook.delay(attempt)):
+		case <-ctx.Done():
+			return
+		}
+	}
+	log.Errorf("webhook[%s]: giving up on %s event from %s after %d attempts: %v",
+		webhook.Name, p.Code, p.Source, webhook.attempts, err)
+	failures.WithLabelValues(webhook.Name).Inc()
+}
+
+func (webhook *Webhook) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequest("POST", webhook.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := webhook.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	return nil
+}
+
+// delay returns how long to wait after the given failed attempt. The
+// backoff doubles with each attempt up to maxBackoff, and we wait a
+// random duration between half and all of it so that many containers
+// failing at once don't retry in lockstep.
+func (webhook *Webhook) delay(attempt int) time.Duration {
+	backoff := webhook.backoff
+	for i := 1; i < attempt && backoff < webhook.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > webhook.maxBackoff {
+		backoff = webhook.maxBackoff
+	}
+	half := int64(backoff / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// String implements the stdlib fmt.Stringer interface for pretty-printing
+func (webhook *Webhook) String() string {
+	return "webhooks.Webhook[" + webhook.Name + "]"
+}
@@ -0,0 +1,265 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joyent/containerpilot/events"
+)
+
+// flakyReceiver fails the first few requests it receives
+type flakyReceiver struct {
+	failures int
+	times    []time.Time
+	received []payload
+	lock     sync.Mutex
+}
+
+func (r *flakyReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.times = append(r.times, time.Now())
+	if len(r.times) <= r.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var p payload
+	json.NewDecoder(req.Body).Decode(&p)
+	r.received = append(r.received, p)
+}
+
+func (r *flakyReceiver) results() ([]time.Time, []payload) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.times, r.received
+}
+
+func TestWebhookRetriesUntilDelivered(t *testing.T) {
+	receiver := &flakyReceiver{failures: 2}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	cfgs, err := NewConfigs([]interface{}{map[string]interface{}{
+		"name":   "hook",
+		"url":    server.URL,
+		"events": []interface{}{"exitFailed"},
+		"retry": map[string]interface{}{
+			"attempts": 3, "backoff": "200ms", "maxBackoff": "1s"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhook := NewWebhook(cfgs[0], time.Now())
+
+	bus := events.NewEventBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	webhook.Run(ctx, bus)
+	bus.Publish(events.Event{events.ExitSuccess, "app"}) // ignored
+	bus.Publish(events.Event{events.ExitFailed, "app"})
+
+	var times []time.Time
+	var received []payload
+	for i := 0; i < 50; i++ {
+		times, received = receiver.results()
+		if len(received) > 0 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(received) != 1 {
+		t.Fatalf("expected event to be delivered, got %d attempts", len(times))
+	}
+	assert.Equal(t, "ExitFailed", received[0].Code)
+	assert.Equal(t, "app", received[0].Source)
+	assert.Len(t, times, 3)
+
+	first, second := times[1].Sub(times[0]), times[2].Sub(times[1])
+	assert.True(t, first >= 100*time.Millisecond,
+		"expected first retry to wait for backoff, waited %v", first)
+	assert.True(t, second > first,
+		"expected retry delays to grow, waited %v then %v", first, second)
+}
+
+func TestWebhookQuietPeriod(t *testing.T) {
+	receiver := &flakyReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	cfgs, err := NewConfigs([]interface{}{map[string]interface{}{
+		"name":   "hook-quiet",
+		"url":    server.URL,
+		"events": []interface{}{"exitFailed", "exitSuccess"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetQuietPeriod(cfgs, 300*time.Millisecond)
+	webhook := NewWebhook(cfgs[0], time.Now())
+
+	bus := events.NewEventBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	webhook.Run(ctx, bus)
+	bus.Publish(events.Event{events.ExitFailed, "early"})    // suppressed
+	bus.Publish(events.Event{events.ExitSuccess, "healthy"}) // not a failure
+	time.Sleep(400 * time.Millisecond)
+	bus.Publish(events.Event{events.ExitFailed, "late"})
+
+	var received []payload
+	for i := 0; i < 50; i++ {
+		_, received = receiver.results()
+		if len(received) > 1 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if assert.Len(t, received, 2) {
+		assert.Equal(t, "healthy", received[0].Source)
+		assert.Equal(t, "late", received[1].Source)
+	}
+}
+
+func TestWebhookQuietPeriodFromStart(t *testing.T) {
+	receiver := &flakyReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	cfgs, err := NewConfigs([]interface{}{map[string]interface{}{
+		"name":   "hook-quiet-start",
+		"url":    server.URL,
+		"events": []interface{}{"exitFailed"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetQuietPeriod(cfgs, time.Minute)
+	// a reload creates new webhooks long after ContainerPilot started
+	webhook := NewWebhook(cfgs[0], time.Now().Add(-time.Hour))
+
+	bus := events.NewEventBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	webhook.Run(ctx, bus)
+	bus.Publish(events.Event{events.ExitFailed, "app"})
+	bus.Shutdown()
+	bus.Wait()
+
+	_, received := receiver.results()
+	assert.Len(t, received, 1)
+}
+
+func TestWebhookDrainsAtShutdown(t *testing.T) {
+	receiver := &flakyReceiver{failures: 1}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	cfgs, err := NewConfigs([]interface{}{map[string]interface{}{
+		"name":   "hook-drain",
+		"url":    server.URL,
+		"events": []interface{}{"exitFailed"},
+		"retry": map[string]interface{}{
+			"attempts": 3, "backoff": "100ms", "maxBackoff": "100ms"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhook := NewWebhook(cfgs[0], time.Now())
+
+	bus := events.NewEventBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	webhook.Run(ctx, bus)
+	bus.Publish(events.Event{events.ExitFailed, "app"})
+	bus.Shutdown()
+	bus.Wait()
+
+	// the failed first attempt is retried after the shutdown
+	times, received := receiver.results()
+	assert.Len(t, times, 2)
+	if assert.Len(t, received, 1) {
+		assert.Equal(t, "ExitFailed", received[0].Code)
+	}
+}
+
+func TestWebhookDrainTimeout(t *testing.T) {
+	receiver := &flakyReceiver{failures: 100}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	cfgs, err := NewConfigs([]interface{}{map[string]interface{}{
+		"name":   "hook-drain-timeout",
+		"url":    server.URL,
+		"events": []interface{}{"exitFailed"},
+		"retry": map[string]interface{}{
+			"attempts": 10, "backoff": "1s", "maxBackoff": "1s"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhook := NewWebhook(cfgs[0], time.Now())
+	webhook.drainAfter = 100 * time.Millisecond
+
+	bus := events.NewEventBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	webhook.Run(ctx, bus)
+	bus.Publish(events.Event{events.ExitFailed, "app"})
+	start := time.Now()
+	bus.Shutdown()
+	bus.Wait()
+	elapsed := time.Since(start)
+	assert.True(t, elapsed < time.Second,
+		"expected shutdown to give up on the delivery, waited %v", elapsed)
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	receiver := &flakyReceiver{failures: 100}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	cfgs, err := NewConfigs([]interface{}{map[string]interface{}{
+		"name":   "hook-gives-up",
+		"url":    server.URL,
+		"events": []interface{}{"exitFailed"},
+		"retry": map[string]interface{}{
+			"attempts": 2, "backoff": "10ms", "maxBackoff": "10ms"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhook := NewWebhook(cfgs[0], time.Now())
+	metric := &dto.Metric{}
+	failures.WithLabelValues("hook-gives-up").Write(metric)
+	before := metric.GetCounter().GetValue()
+
+	webhook.deliveries <- payload{Code: "ExitFailed", Source: "app"}
+	close(webhook.deliveries)
+	webhook.deliver(context.Background())
+
+	times, received := receiver.results()
+	assert.Len(t, times, 2)
+	assert.Len(t, received, 0)
+	failures.WithLabelValues("hook-gives-up").Write(metric)
+	assert.Equal(t, before+1, metric.GetCounter().GetValue())
+}
+
+func TestWebhookDelay(t *testing.T) {
+	webhook := &Webhook{backoff: time.Second, maxBackoff: 5 * time.Second}
+	for attempt, max := range []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second,
+	} {
+		delay := webhook.delay(attempt + 1)
+		assert.True(t, delay >= max/2 && delay <= max,
+			"expected delay for attempt %d between %v and %v, got %v",
+			attempt+1, max/2, max, delay)
+	}
+}